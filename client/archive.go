@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// downloadArchive saves the files named in args, or matching the glob when
+// it is the only one, as one archive: a zip if outpath ends in .zip, a
+// tar.gz otherwise. "-" writes it to stdout.
+func downloadArchive(client proto.FileServiceClient, args []string, outpath string) {
+	req := &proto.ArchiveRequest{Filenames: args}
+	if len(args) == 1 && strings.ContainsAny(args[0], "*?[") {
+		req = &proto.ArchiveRequest{Pattern: args[0]}
+	}
+	if strings.HasSuffix(strings.ToLower(outpath), ".zip") {
+		req.Format = proto.ArchiveRequest_ZIP
+	}
+	stream, err := client.DownloadArchive(context.Background(), req)
+	if err != nil {
+		log.Fatalf("download error: %v", err)
+	}
+	size := saveStream(stream.Recv, outpath)
+	if outpath != "-" {
+		fmt.Printf("Downloaded archive -> %s (%s)\n", outpath, byteSize(size))
+	}
+}
+
+// saveStream writes the data of the messages recv returns to outpath.part
+// and renames it to outpath once the stream ends, or writes them to stdout
+// for "-". It returns the bytes written.
+func saveStream(recv func() (*proto.DownloadResponse, error), outpath string) int64 {
+	var out io.WriteCloser = os.Stdout
+	part := outpath + ".part"
+	if outpath != "-" {
+		f, err := os.Create(part)
+		if err != nil {
+			log.Fatalf("create error: %v", err)
+		}
+		out = f
+	}
+	fail := func(format string, err error) {
+		if outpath != "-" {
+			out.Close()
+			os.Remove(part)
+		}
+		log.Fatalf(format, err)
+	}
+	var size int64
+	for {
+		chunk, err := recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail("download error: %v", err)
+		}
+		if _, err := out.Write(chunk.Data); err != nil {
+			fail("write error: %v", err)
+		}
+		size += int64(len(chunk.Data))
+	}
+	if outpath == "-" {
+		return size
+	}
+	if err := out.Close(); err != nil {
+		fail("write error: %v", err)
+	}
+	if err := os.Rename(part, outpath); err != nil {
+		fail("rename error: %v", err)
+	}
+	return size
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// backupManifest is <dest>/manifest.json. Files describes every server file
+// at backup time, whether it was copied into this backup or is unchanged
+// since the base; Copied names the ones under <dest>/files.
+type backupManifest struct {
+	CreatedAt string        `json:"created_at"`
+	Prefix    string        `json:"prefix,omitempty"`
+	Base      string        `json:"base,omitempty"` // previous backup of an incremental one
+	Files     []backupEntry `json:"files"`
+	Copied    []string      `json:"copied"`
+	Deleted   []string      `json:"deleted,omitempty"` // in the base, gone from the server
+}
+
+type backupEntry struct {
+	Filename   string `json:"filename"`
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256"`
+	ModifiedAt string `json:"modified_at"`
+}
+
+func loadBackupManifest(dir string) (*backupManifest, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	var m backupManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", dir, err)
+	}
+	return &m, nil
+}
+
+// backup copies the server files under prefix into dest. With a base
+// backup only files that are new or changed since it are copied, and the
+// ones deleted since are listed, so nightly runs move just the difference.
+func backup(client proto.FileServiceClient, dest, prefix, base string, hedge time.Duration) {
+	var prev map[string]backupEntry
+	m := backupManifest{CreatedAt: time.Now().UTC().Format(time.RFC3339), Prefix: prefix}
+	if base != "" {
+		b, err := loadBackupManifest(base)
+		if err != nil {
+			log.Fatalf("base backup: %v", err)
+		}
+		if b.Prefix != prefix {
+			log.Fatalf("base backup covers prefix %q, not %q", b.Prefix, prefix)
+		}
+		prev = make(map[string]backupEntry, len(b.Files))
+		for _, e := range b.Files {
+			prev[e.Filename] = e
+		}
+		m.Base, _ = filepath.Abs(base)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "manifest.json")); err == nil {
+		log.Fatalf("%s already holds a backup", dest)
+	}
+	if err := os.MkdirAll(filepath.Join(dest, "files"), 0o755); err != nil {
+		log.Fatalf("backup error: %v", err)
+	}
+
+	stream, err := client.ExportManifest(context.Background(), &proto.ExportManifestRequest{Prefix: prefix, Algorithm: "sha256"})
+	if err != nil {
+		log.Fatalf("export error: %v", err)
+	}
+	seen := make(map[string]bool)
+	for {
+		e, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("export error: %v", err)
+		}
+		if e.Filename != filepath.Base(e.Filename) || e.Filename == ".." {
+			log.Printf("backup: skipping unsafe name %q", e.Filename)
+			continue
+		}
+		seen[e.Filename] = true
+		entry := backupEntry{Filename: e.Filename, Size: e.SizeBytes, SHA256: e.Hash, ModifiedAt: e.ModifiedAt}
+		if p, ok := prev[e.Filename]; ok && p.SHA256 == e.Hash && p.Size == e.SizeBytes {
+			m.Files = append(m.Files, p)
+			continue
+		}
+		path := filepath.Join(dest, "files", e.Filename)
+		download(client, e.Filename, path, hedge, true)
+		// describe what was copied, even if the file changed since the export
+		sum, err := fileSHA256(path)
+		if err != nil {
+			log.Fatalf("backup error: %v", err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Fatalf("backup error: %v", err)
+		}
+		entry.SHA256, entry.Size = sum, info.Size()
+		m.Files = append(m.Files, entry)
+		m.Copied = append(m.Copied, e.Filename)
+	}
+	for name := range prev {
+		if !seen[name] {
+			m.Deleted = append(m.Deleted, name)
+		}
+	}
+	sort.Strings(m.Deleted)
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		log.Fatalf("backup error: %v", err)
+	}
+	// written last: a backup without a manifest is unfinished
+	if err := os.WriteFile(filepath.Join(dest, "manifest.json"), b, 0o644); err != nil {
+		log.Fatalf("backup error: %v", err)
+	}
+	fmt.Printf("backup: %d files, copied %d, unchanged %d, deleted %d\n",
+		len(m.Files), len(m.Copied), len(m.Files)-len(m.Copied), len(m.Deleted))
+}
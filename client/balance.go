@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+// roundRobin spreads RPCs over every ready address; an address whose
+// connection fails drops out of the rotation until it reconnects.
+const roundRobin = `{"loadBalancingConfig": [{"round_robin": {}}]}`
+
+// pickFirst sends every RPC to the first reachable address, in order.
+const pickFirst = `{"loadBalancingConfig": [{"pick_first": {}}]}`
+
+// dial connects to servers: a comma-separated list of addresses is balanced
+// as-is, a single name goes through the DNS resolver so every A record of
+// it is used, and scheme://... targets use the matching discovery resolver.
+func dial(servers string, opts []grpc.DialOption) (*grpc.ClientConn, error) {
+	return dialWith(servers, roundRobin, opts)
+}
+
+// dialOrdered connects to servers like dial but uses them as a failover
+// list: the first that answers gets every call.
+func dialOrdered(servers string, opts []grpc.DialOption) (*grpc.ClientConn, error) {
+	return dialWith(servers, pickFirst, opts)
+}
+
+// dialWith dials with opts, the connection options of the global flags.
+func dialWith(servers, serviceConfig string, opts []grpc.DialOption) (*grpc.ClientConn, error) {
+	opts = append(opts[:len(opts):len(opts)], grpc.WithDefaultServiceConfig(serviceConfig))
+	if strings.Contains(servers, "://") {
+		return grpc.Dial(servers, opts...)
+	}
+	var addrs []resolver.Address
+	for _, a := range strings.Split(servers, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, resolver.Address{Addr: a})
+		}
+	}
+	if len(addrs) <= 1 {
+		return grpc.Dial("dns:///"+strings.TrimSpace(servers), opts...)
+	}
+	r := manual.NewBuilderWithScheme("fileservice")
+	r.InitialState(resolver.State{Addresses: addrs})
+	opts = append(opts, grpc.WithResolvers(r))
+	return grpc.Dial(r.Scheme()+":///servers", opts...)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// bandwidth changes the server's transfer limits set in req and prints the
+// limits in effect.
+func bandwidth(client proto.FileServiceClient, format string, req *proto.SetBandwidthRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	b, err := client.SetBandwidth(ctx, req)
+	if err != nil {
+		log.Fatalf("bandwidth error: %v", err)
+	}
+	if format == "json" {
+		printJSON(b)
+		return
+	}
+	fmt.Printf("per stream: %s | total: %s\n", rate(b.StreamBytesPerSecond), rate(b.TotalBytesPerSecond))
+}
+
+func rate(n int64) string {
+	if n == 0 {
+		return "unlimited"
+	}
+	return byteSize(n) + "/s"
+}
@@ -2,136 +2,252 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"path/filepath"
+	"strings"
 	"time"
 
+	fileclient "github.com/daniil1412412/grpc-file-service/pkg/client"
 	"github.com/daniil1412412/grpc-file-service/proto"
-	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("usage: client [upload|download|list] args...")
-		return
+	shutdownTracing, err := setupTracing(context.Background(), "grpc-file-service-client")
+	if err != nil {
+		log.Fatalf("tracing: %v", err)
 	}
-
-	conn, err := grpc.Dial("localhost:50051", grpc.WithInsecure())
+	err = newRootCmd().Execute()
+	shutdownTracing(context.Background())
 	if err != nil {
-		log.Fatalf("dial error: %v", err)
+		os.Exit(1)
 	}
-	defer conn.Close()
-	client := proto.NewFileServiceClient(conn)
+}
 
-	switch os.Args[1] {
-	case "upload":
-		if len(os.Args) < 3 {
-			log.Fatalf("usage: client upload <local-file-path>")
-		}
-		upload(client, os.Args[2])
-	case "download":
-		if len(os.Args) < 3 {
-			log.Fatalf("usage: client download <filename-on-server> [out-path]")
-		}
-		out := os.Args[2]
-		if len(os.Args) >= 4 {
-			out = os.Args[3]
-		}
-		download(client, os.Args[2], out)
-	case "list":
-		listFiles(client)
-	default:
-		fmt.Println("unknown command")
-	}
+// transferRetries is how often a transfer broken by a network blip, a
+// timeout or a busy file is carried on before giving up.
+const transferRetries = 5
+
+// newFileClient returns the client library over client, with the CLI's
+// signing key, hedging and retries, reporting to the log.
+func newFileClient(client proto.FileServiceClient, key ed25519.PrivateKey, hedge time.Duration) *fileclient.Client {
+	c := fileclient.New(client)
+	c.Key, c.Hedge, c.Retries, c.Logf = key, hedge, transferRetries, log.Printf
+	return c
 }
 
-func upload(client proto.FileServiceClient, path string) {
+func upload(client proto.FileServiceClient, path, remote string, key ed25519.PrivateKey, quiet bool) {
 	f, err := os.Open(path)
 	if err != nil {
 		log.Fatalf("open error: %v", err)
 	}
 	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		log.Fatalf("open error: %v", err)
+	}
+
+	fc := newFileClient(client, key, 0)
+	bar := newProgressBar(remote, info.Size(), quiet)
+	fc.Progress, fc.Logf = bar.update, bar.logf
+	// no deadline of our own: the server's ends each attempt, and a retry
+	// goes on from there
+	resp, err := fc.Upload(context.Background(), f, remote)
+	bar.finish()
+	if err != nil {
+		log.Fatalf("upload error: %v", err)
+	}
+	fmt.Printf("результатt: ok=%v msg=%s\n", resp.Ok, resp.Message)
+}
+
+func download(client proto.FileServiceClient, filename, outpath string, hedge time.Duration, quiet bool) {
+	fc := newFileClient(client, nil, hedge)
+	bar := newProgressBar(filename, remoteSize(client, filename, quiet), quiet)
+	fc.Progress, fc.Logf = bar.update, bar.logf
+	err := fc.DownloadFile(context.Background(), filename, outpath)
+	bar.finish()
+	if err != nil {
+		log.Fatalf("download error: %v", err)
+	}
+	fmt.Printf("Downloaded %s -> %s\n", filename, outpath)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+func rangedDownload(client proto.FileServiceClient, filename, outpath string, streams int, quiet bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
 	defer cancel()
+	fc := newFileClient(client, nil, 0)
+	bar := newProgressBar(filename, remoteSize(client, filename, quiet), quiet)
+	fc.Progress, fc.Logf = bar.update, bar.logf
+	used, err := fc.DownloadFileRanges(ctx, filename, outpath, streams)
+	bar.finish()
+	if err != nil {
+		log.Fatalf("download error: %v", err)
+	}
+	fmt.Printf("Downloaded %s -> %s over %d streams\n", filename, outpath, used)
+}
 
-	stream, err := client.Upload(ctx)
+// remoteSize returns the size of filename for a progress bar, or -1 when
+// quiet or the server cannot tell.
+func remoteSize(client proto.FileServiceClient, filename string, quiet bool) int64 {
+	if quiet {
+		return -1
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	fi, err := client.StatFile(ctx, &proto.StatRequest{Filename: filename, ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"size_bytes"}}})
 	if err != nil {
-		log.Fatalf("upload start error: %v", err)
+		return -1
 	}
+	return fi.SizeBytes
+}
 
-	// send initial message with filename
-	base := filepath.Base(path)
-	if err := stream.Send(&proto.UploadRequest{Filename: base}); err != nil {
-		log.Fatalf("send filename error: %v", err)
+// fetchList lists the server's files. With fields only those FileInfo
+// fields are filled in, which is much cheaper for names alone.
+func fetchList(client proto.FileServiceClient, fields ...string) []*proto.FileInfo {
+	return fetchPages(client, fileclient.ListOptions{Fields: fields})
+}
+
+func fetchPages(client proto.FileServiceClient, opts fileclient.ListOptions) []*proto.FileInfo {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	files, err := newFileClient(client, nil, 0).List(ctx, opts)
+	if err != nil {
+		log.Fatalf("list error: %v", err)
 	}
+	return files
+}
 
-	buf := make([]byte, 64*1024)
-	for {
-		n, rerr := f.Read(buf)
-		if n > 0 {
-			if err := stream.Send(&proto.UploadRequest{Data: buf[:n]}); err != nil {
-				log.Fatalf("send chunk error: %v", err)
+func listFiles(client proto.FileServiceClient, format string, opts fileclient.ListOptions) {
+	files := fetchPages(client, opts)
+	if format == "json" {
+		printJSON(files)
+		return
+	}
+	if fields := opts.Fields; len(fields) > 0 {
+		// one tab-separated line per file, fields in the order asked for
+		desc := (&proto.FileInfo{}).ProtoReflect().Descriptor().Fields()
+		for _, f := range files {
+			vals := make([]string, len(fields))
+			for i, name := range fields {
+				vals[i] = fmt.Sprint(f.ProtoReflect().Get(desc.ByName(protoreflect.Name(name))).Interface())
 			}
+			fmt.Println(strings.Join(vals, "\t"))
 		}
-		if rerr == io.EOF {
-			break
-		}
-		if rerr != nil {
-			log.Fatalf("read error: %v", rerr)
-		}
+		return
 	}
+	fmt.Println("файлы на сервере:")
+	for _, f := range files {
+		fmt.Printf("- %s | создан: %s | обновлен: %s | %d вес\n", f.Filename, f.CreatedAt, f.ModifiedAt, f.SizeBytes)
+	}
+}
 
-	resp, err := stream.CloseAndRecv()
+func head(client proto.FileServiceClient, filename string, n int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := client.Head(ctx, &proto.HeadRequest{Filename: filename, NBytes: n})
 	if err != nil {
-		log.Fatalf("upload finish error: %v", err)
+		log.Fatalf("head error: %v", err)
 	}
-	fmt.Printf("результатt: ok=%v msg=%s\n", resp.Ok, resp.Message)
+	fmt.Fprintf(os.Stderr, "%s | %s | %d вес\n", resp.Filename, resp.ContentType, resp.SizeBytes)
+	os.Stdout.Write(resp.Data)
 }
 
-func download(client proto.FileServiceClient, filename, outpath string) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+func statFile(client proto.FileServiceClient, format, filename string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
-
-	stream, err := client.Download(ctx, &proto.DownloadRequest{Filename: filename})
+	fi, err := client.StatFile(ctx, &proto.StatRequest{Filename: filename})
 	if err != nil {
-		log.Fatalf("download start error: %v", err)
+		log.Fatalf("stat error: %v", err)
 	}
+	if format == "json" {
+		printJSON(fi)
+		return
+	}
+	fmt.Printf("%s | создан: %s | обновлен: %s | %d вес | %s | %s\n", fi.Filename, fi.CreatedAt, fi.ModifiedAt, fi.SizeBytes, fi.ContentType, fi.Sha256)
+	if fi.OriginalFilename != "" || fi.Uploader != "" {
+		fmt.Printf("загружен как: %s | кем: %s\n", fi.OriginalFilename, fi.Uploader)
+	}
+	if fi.EncryptionKeyId != "" {
+		fmt.Printf("ключ шифрования: %s\n", fi.EncryptionKeyId)
+	}
+}
 
-	out, err := os.Create(outpath)
+func showUsage(client proto.FileServiceClient, format string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	u, err := client.GetUsage(ctx, &proto.UsageRequest{})
 	if err != nil {
-		log.Fatalf("create out file error: %v", err)
+		log.Fatalf("usage error: %v", err)
 	}
-	defer out.Close()
-
-	for {
-		chunk, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Fatalf("recv error: %v", err)
-		}
-		_, werr := out.Write(chunk.Data)
-		if werr != nil {
-			log.Fatalf("write error: %v", werr)
+	if format == "json" {
+		printJSON(u)
+		return
+	}
+	fmt.Printf("total: %s\n", usageLine(u.Bytes, u.Files, u.QuotaBytes))
+	for _, n := range u.Namespaces {
+		name := n.Name
+		if name == "" {
+			name = "(no namespace)"
 		}
+		fmt.Printf("%s: %s\n", name, usageLine(n.Bytes, n.Files, n.QuotaBytes))
 	}
-	fmt.Printf("Downloaded %s -> %s\n", filename, outpath)
 }
 
-func listFiles(client proto.FileServiceClient) {
+func usageLine(bytes, files, quota int64) string {
+	line := fmt.Sprintf("%s in %d files", byteSize(bytes), files)
+	if quota > 0 {
+		line += fmt.Sprintf(", %.0f%% of the %s quota", float64(bytes)*100/float64(quota), byteSize(quota))
+	}
+	return line
+}
+
+func deleteFile(client proto.FileServiceClient, filename string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	resp, err := client.ListFiles(ctx, &proto.ListRequest{})
+	resp, err := client.Delete(ctx, &proto.DeleteRequest{Filename: filename})
 	if err != nil {
-		log.Fatalf("list error: %v", err)
+		log.Fatalf("delete error: %v", err)
 	}
-	fmt.Println("файлы на сервере:")
-	for _, f := range resp.Files {
-		fmt.Printf("- %s | создан: %s | обновлен: %s | %d вес\n", f.Filename, f.CreatedAt, f.ModifiedAt, f.SizeBytes)
+	fmt.Printf("удалён: %s\n", resp.Filename)
+}
+
+func renameFile(client proto.FileServiceClient, from, to string, overwrite bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := client.RenameFile(ctx, &proto.RenameRequest{Filename: from, NewFilename: to, Overwrite: overwrite})
+	if err != nil {
+		log.Fatalf("rename error: %v", err)
+	}
+	fmt.Printf("переименован: %s -> %s\n", from, resp.Filename)
+}
+
+// copyFile and moveFile set no timeout: across shards the server streams
+// the file from one node to the other.
+func copyFile(client proto.FileServiceClient, from, to string, overwrite bool) {
+	resp, err := client.CopyFile(context.Background(), &proto.CopyRequest{Filename: from, NewFilename: to, Overwrite: overwrite})
+	if err != nil {
+		log.Fatalf("copy error: %v", err)
+	}
+	fmt.Printf("скопирован: %s -> %s\n", from, resp.Filename)
+}
+
+func moveFile(client proto.FileServiceClient, from, to string, overwrite bool) {
+	resp, err := client.MoveFile(context.Background(), &proto.MoveRequest{Filename: from, NewFilename: to, Overwrite: overwrite})
+	if err != nil {
+		log.Fatalf("move error: %v", err)
+	}
+	fmt.Printf("перемещён: %s -> %s\n", from, resp.Filename)
+}
+
+// printJSON writes v for scripts, as an indented JSON document.
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalf("output error: %v", err)
 	}
 }
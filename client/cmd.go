@@ -0,0 +1,766 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	fileclient "github.com/daniil1412412/grpc-file-service/pkg/client"
+	"github.com/daniil1412412/grpc-file-service/pkg/compress"
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+)
+
+// cli is the state shared by all commands: global flags, after the profile
+// is applied, and the connection, opened on first use.
+type cli struct {
+	server     string
+	replicas   string
+	profile    string
+	format     string
+	compress   string
+	hedge      time.Duration
+	quiet      bool
+	queue      string
+	sign       string
+	adminToken string
+	token      string
+
+	tls           bool
+	tlsCA         string
+	tlsCert       string
+	tlsKey        string
+	tlsServerName string
+
+	conn  *grpc.ClientConn
+	rconn *grpc.ClientConn // of the replicas, with --replicas
+}
+
+func (c *cli) client() proto.FileServiceClient {
+	if c.replicas != "" {
+		if c.conn == nil {
+			var err error
+			if c.conn, err = dialOrdered(c.server, c.dialOptions()); err != nil {
+				log.Fatalf("dial error: %v", err)
+			}
+			if c.rconn, err = dial(c.replicas, c.dialOptions()); err != nil {
+				log.Fatalf("dial error: %v", err)
+			}
+		}
+		return newRoutedClient(c.conn, c.rconn)
+	}
+	if c.conn == nil {
+		conn, err := dial(c.server, c.dialOptions())
+		if err != nil {
+			log.Fatalf("dial error: %v", err)
+		}
+		c.conn = conn
+	}
+	return proto.NewFileServiceClient(c.conn)
+}
+
+func (c *cli) key() ed25519.PrivateKey {
+	if c.sign == "" {
+		return nil
+	}
+	return loadPrivateKey(c.sign)
+}
+
+// applyProfile fills in the global flags not given on the command line.
+func (c *cli) applyProfile(cmd *cobra.Command) error {
+	p, err := loadProfile(c.profile)
+	if err != nil {
+		return err
+	}
+	flags := cmd.Flags()
+	set := func(name, value string) error {
+		if value == "" || flags.Changed(name) {
+			return nil
+		}
+		return flags.Set(name, value)
+	}
+	for name, value := range map[string]string{
+		"server": p.Server, "replicas": p.Replicas, "hedge": p.Hedge, "queue": p.Queue,
+		"sign": p.Sign, "admin-token": p.AdminToken, "token": p.Token, "format": p.Format, "compress": p.Compress,
+		"tls-ca": p.TLSCA, "tls-cert": p.TLSCert, "tls-key": p.TLSKey, "tls-server-name": p.TLSServerName,
+	} {
+		if err := set(name, value); err != nil {
+			return fmt.Errorf("profile: %w", err)
+		}
+	}
+	if p.TLS {
+		if err := set("tls", "true"); err != nil {
+			return fmt.Errorf("profile: %w", err)
+		}
+	}
+	if c.format != "text" && c.format != "json" {
+		return fmt.Errorf("unknown format %q, want text or json", c.format)
+	}
+	if c.compress != "" && c.compress != "none" && !slices.Contains(compress.Names, c.compress) {
+		return fmt.Errorf("unknown compression %q, want none, %s", c.compress, strings.Join(compress.Names, " or "))
+	}
+	return nil
+}
+
+func newRootCmd() *cobra.Command {
+	c := &cli{}
+	root := &cobra.Command{
+		Use:               "client",
+		Short:             "Client of the gRPC file service",
+		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error { return c.applyProfile(cmd) },
+		PersistentPostRun: func(*cobra.Command, []string) {
+			if c.conn != nil {
+				c.conn.Close()
+			}
+			if c.rconn != nil {
+				c.rconn.Close()
+			}
+		},
+	}
+	pf := root.PersistentFlags()
+	pf.StringVar(&c.server, "server", "localhost:50051", "server address, comma-separated list, DNS name of several replicas or srv:///, consul://, etcd:// discovery target")
+	pf.StringVar(&c.replicas, "replicas", "", "read-only replicas to send downloads and listings to, in the --server format; --server then lists the primary first and its failover targets after")
+	pf.StringVar(&c.profile, "profile", "", "named profile from "+profilesPath()+` (default: the "default" profile if present)`)
+	pf.StringVar(&c.format, "format", "text", "output format of listings: text or json")
+	pf.StringVar(&c.compress, "compress", "none", "compress calls to the server: none, gzip or zstd; pays off for text and logs over slow links")
+	pf.DurationVar(&c.hedge, "hedge", 0, "re-request a download from another replica if it sends no data within this time (0 disables)")
+	pf.BoolVar(&c.quiet, "quiet", false, "no progress bars on upload and download, as when stderr is not a terminal")
+	pf.StringVar(&c.queue, "queue", defaultQueuePath(), "state file of the batch transfer queue used by sync, mirror and resume")
+	pf.StringVar(&c.sign, "sign", "", "ed25519 key file (see keygen) to sign uploads with")
+	pf.StringVar(&c.adminToken, "admin-token", "", "admin token, sent with every call: needed by quarantine, jobs and import, and reaches every user's files as <user>/<name>")
+	pf.StringVar(&c.token, "token", "", "API key or JWT for servers that require authentication")
+	pf.BoolVar(&c.tls, "tls", false, "connect over TLS, verifying the server against the system roots")
+	pf.StringVar(&c.tlsCA, "tls-ca", "", "PEM CA bundle to verify the server against; implies --tls")
+	pf.StringVar(&c.tlsCert, "tls-cert", "", "PEM client certificate for servers that require one (-mtls); implies --tls")
+	pf.StringVar(&c.tlsKey, "tls-key", "", "PEM private key of --tls-cert (default: read from the --tls-cert file)")
+	pf.StringVar(&c.tlsServerName, "tls-server-name", "", "name to verify the server certificate against instead of the dialed host; implies --tls")
+	_ = root.RegisterFlagCompletionFunc("format", cobra.FixedCompletions([]string{"text", "json"}, cobra.ShellCompDirectiveNoFileComp))
+	_ = root.RegisterFlagCompletionFunc("compress", cobra.FixedCompletions(append([]string{"none"}, compress.Names...), cobra.ShellCompDirectiveNoFileComp))
+
+	root.AddCommand(
+		uploadCmd(c), downloadCmd(c), downloadArchiveCmd(c), linkCmd(c), redeemCmd(c), listCmd(c), syncCmd(c), mirrorCmd(c), uploadDirCmd(c), downloadDirCmd(c), resumeCmd(c),
+		tailCmd(c), headCmd(c), statCmd(c), watchCmd(c), usageCmd(c), versionsCmd(c), deleteCmd(c), renameCmd(c), copyCmd(c), moveCmd(c), quarantineCmd(c), jobsCmd(c), bandwidthCmd(c), replicationCmd(c), rotateKeyCmd(c), importCmd(c), keygenCmd(), manifestCmd(c), verifyManifestCmd(), exportManifestCmd(c), backupCmd(c), verifyCmd(c),
+	)
+	return root
+}
+
+// completeRemote completes the first argument with file names on the server.
+func completeRemote(c *cli) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveDefault
+		}
+		if err := c.applyProfile(cmd); err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		var names []string
+		for _, f := range fetchList(c.client(), "filename") {
+			names = append(names, f.Filename)
+		}
+		return names, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func uploadCmd(c *cli) *cobra.Command {
+	var resumable bool
+	cmd := &cobra.Command{
+		Use:   "upload <local-file>",
+		Short: "Upload a file",
+		Long: "Uploads a file. With --resumable a broken upload is retried from the bytes\n" +
+			"the server already has, and running the command again after a crash\n" +
+			"continues the same upload.",
+		Args: cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			if resumable {
+				resumableUpload(c.client(), args[0], filepath.Base(args[0]), c.key(), uploadsPath(c.queue), c.quiet)
+				return
+			}
+			upload(c.client(), args[0], filepath.Base(args[0]), c.key(), c.quiet)
+		},
+	}
+	cmd.Flags().BoolVar(&resumable, "resumable", false, "upload in resumable steps that survive broken connections")
+	return cmd
+}
+
+func downloadCmd(c *cli) *cobra.Command {
+	var streams int
+	cmd := &cobra.Command{
+		Use:   "download <filename-on-server> [out-path]",
+		Short: "Download a file, verifying it piece by piece",
+		Long: "Downloads into <out-path>.part and renames it once verified. A .part left\n" +
+			"by an interrupted download is resumed from its end if the server has the\n" +
+			"file's checksum recorded.",
+		Args:              cobra.RangeArgs(1, 2),
+		ValidArgsFunction: completeRemote(c),
+		Run: func(_ *cobra.Command, args []string) {
+			out := args[0]
+			if len(args) == 2 {
+				out = args[1]
+			}
+			if streams > 1 {
+				rangedDownload(c.client(), args[0], out, streams, c.quiet)
+				return
+			}
+			download(c.client(), args[0], out, c.hedge, c.quiet)
+		},
+	}
+	cmd.Flags().IntVar(&streams, "streams", 1, "fetch large files over this many parallel streams, each for its own byte range")
+	return cmd
+}
+
+func downloadArchiveCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "download-archive <glob|filename...> <out.tar.gz|out.zip|->",
+		Short: "Download several files as one tar.gz or zip archive",
+		Long: "Fetches the files named, or every file matching a single glob, in one\n" +
+			"stream as an archive the server builds on the fly. The output is a zip if\n" +
+			"its name ends in .zip and a tar.gz otherwise; - writes it to stdout.",
+		Example: "  client download-archive '*.csv' reports.tar.gz\n" +
+			"  client download-archive a.txt b.txt c.txt files.zip",
+		Args:              cobra.MinimumNArgs(2),
+		ValidArgsFunction: completeRemote(c),
+		Run: func(_ *cobra.Command, args []string) {
+			downloadArchive(c.client(), args[:len(args)-1], args[len(args)-1])
+		},
+	}
+}
+
+func listCmd(c *cli) *cobra.Command {
+	var fields []string
+	var prefix, pattern, uploader, contentType, order string
+	var desc bool
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List files on the server",
+		Long: "Lists every file, or those matching --prefix and --pattern, sorted by name\n" +
+			"unless --sort says otherwise. --fields asks only for some FileInfo fields\n" +
+			"and prints them tab-separated; filename alone is the cheapest listing,\n" +
+			"sha256 and content_type are only available this way. --uploader and\n" +
+			"--content-type need a server with -meta-index.",
+		Example: "  client list --fields filename\n" +
+			"  client list --fields filename,size_bytes,sha256\n" +
+			"  client list --pattern '*.pdf' --sort size --desc\n" +
+			"  client list --uploader alice --content-type image/ --fields filename,original_filename",
+		Args: cobra.NoArgs,
+		Run: func(*cobra.Command, []string) {
+			by, ok := proto.ListRequest_Order_value[strings.ToUpper(order)]
+			if !ok {
+				log.Fatalf("unknown --sort %q, want name, size or modified", order)
+			}
+			listFiles(c.client(), c.format, fileclient.ListOptions{
+				Prefix:      prefix,
+				Pattern:     pattern,
+				Uploader:    uploader,
+				ContentType: contentType,
+				OrderBy:     proto.ListRequest_Order(by),
+				Descending:  desc,
+				Fields:      fields,
+			})
+		},
+	}
+	cmd.Flags().StringSliceVar(&fields, "fields", nil, "FileInfo fields to fetch: filename, created_at, modified_at, size_bytes, sha256, content_type, original_filename, uploader")
+	cmd.Flags().StringVar(&prefix, "prefix", "", "only files whose name starts with this")
+	cmd.Flags().StringVar(&pattern, "pattern", "", "only files whose name matches this glob (*, ?, [a-z])")
+	cmd.Flags().StringVar(&uploader, "uploader", "", "only files this user uploaded")
+	cmd.Flags().StringVar(&contentType, "content-type", "", "only files whose content type starts with this, e.g. image/")
+	cmd.Flags().StringVar(&order, "sort", "name", "order of the listing: name, size or modified")
+	cmd.Flags().BoolVar(&desc, "desc", false, "list in descending order")
+	return cmd
+}
+
+func syncCmd(c *cli) *cobra.Command {
+	var checksum bool
+	filter := &pathFilter{}
+	cmd := &cobra.Command{
+		Use:   "sync <local-dir>",
+		Short: "Upload files of a directory that are missing or changed on the server",
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			syncDir(c.client(), openQueue(c.queue), args[0], checksum, filter, c.hedge, c.key())
+		},
+	}
+	cmd.Flags().BoolVar(&checksum, "checksum", false, "compare by server-side SHA-256 instead of size+mtime")
+	filter.addFlags(cmd.Flags())
+	return cmd
+}
+
+func mirrorCmd(c *cli) *cobra.Command {
+	opts := mirrorOptions{filter: &pathFilter{}}
+	cmd := &cobra.Command{
+		Use:   "mirror <local-dir> <remote-prefix>",
+		Short: "Make the server files with a name prefix match a directory, or the reverse",
+		Example: "  client mirror photos photos- --delete\n" +
+			"  client mirror photos photos- --pull --delete",
+		Args: cobra.ExactArgs(2),
+		Run: func(_ *cobra.Command, args []string) {
+			mirror(c.client(), openQueue(c.queue), args[0], args[1], opts, c.hedge, c.key())
+		},
+	}
+	f := cmd.Flags()
+	f.BoolVar(&opts.delete, "delete", false, "delete files missing from the source")
+	f.BoolVar(&opts.pull, "pull", false, "make the local dir match the server instead")
+	f.IntVar(&opts.maxDelete, "max-delete", 25, "refuse to delete more than this percent of the target's files")
+	f.BoolVar(&opts.force, "force", false, "ignore --max-delete")
+	opts.filter.addFlags(f)
+	return cmd
+}
+
+func uploadDirCmd(c *cli) *cobra.Command {
+	filter := &pathFilter{}
+	var workers int
+	cmd := &cobra.Command{
+		Use:   "upload-dir <path>",
+		Short: "Upload a directory tree, keeping the paths of its files in their names",
+		Example: "  client upload-dir photos --workers 8\n" +
+			"  client upload-dir photos --exclude '*.tmp'",
+		Args: cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			uploadDir(c.client(), args[0], workers, filter, c.key(), c.quiet)
+		},
+	}
+	cmd.Flags().IntVar(&workers, "workers", 4, "files to transfer at once")
+	filter.addFlags(cmd.Flags())
+	return cmd
+}
+
+func downloadDirCmd(c *cli) *cobra.Command {
+	filter := &pathFilter{}
+	var workers int
+	cmd := &cobra.Command{
+		Use:     "download-dir <prefix> <dest>",
+		Short:   "Download a tree upload-dir stored under a path prefix into a directory",
+		Example: "  client download-dir photos/2024 ./2024",
+		Args:    cobra.ExactArgs(2),
+		Run: func(_ *cobra.Command, args []string) {
+			downloadDir(c.client(), args[0], args[1], workers, filter, c.hedge, c.quiet)
+		},
+	}
+	cmd.Flags().IntVar(&workers, "workers", 4, "files to transfer at once")
+	filter.addFlags(cmd.Flags())
+	return cmd
+}
+
+func resumeCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "resume",
+		Short: "Finish the transfers an interrupted sync or mirror left in the queue",
+		Args:  cobra.NoArgs,
+		Run:   func(*cobra.Command, []string) { resume(c.client(), openQueue(c.queue), c.hedge, c.key()) },
+	}
+}
+
+func tailCmd(c *cli) *cobra.Command {
+	var follow bool
+	var n int64
+	cmd := &cobra.Command{
+		Use:               "tail <filename-on-server>",
+		Short:             "Print the end of a file",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRemote(c),
+		Run:               func(_ *cobra.Command, args []string) { tail(c.client(), args[0], n, follow) },
+	}
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "keep printing data appended to the file")
+	cmd.Flags().Int64VarP(&n, "bytes", "c", 1024, "start this many bytes before the end")
+	return cmd
+}
+
+func headCmd(c *cli) *cobra.Command {
+	var n int64
+	cmd := &cobra.Command{
+		Use:               "head <filename-on-server>",
+		Short:             "Print the start of a file and its content type",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRemote(c),
+		Run:               func(_ *cobra.Command, args []string) { head(c.client(), args[0], n) },
+	}
+	cmd.Flags().Int64VarP(&n, "bytes", "n", 512, "number of bytes to show")
+	return cmd
+}
+
+func statCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:               "stat <filename-on-server>",
+		Short:             "Show a file's size, times, content type and SHA-256",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRemote(c),
+		Run:               func(_ *cobra.Command, args []string) { statFile(c.client(), c.format, args[0]) },
+	}
+}
+
+func watchCmd(c *cli) *cobra.Command {
+	var opts fileclient.WatchOptions
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Print files as they are created, overwritten or deleted",
+		Long: "Prints an event for every file the server creates, overwrites or deletes\n" +
+			"until interrupted. --epoch and --after, from a previous --format json\n" +
+			"event, resume without missing events while the server still has them.",
+		Example: "  client watch --pattern '*.csv'\n" +
+			"  client --format json watch --prefix incoming/",
+		Args: cobra.NoArgs,
+		Run:  func(*cobra.Command, []string) { watch(c.client(), c.format, opts) },
+	}
+	cmd.Flags().StringVar(&opts.Prefix, "prefix", "", "only files whose name starts with this")
+	cmd.Flags().StringVar(&opts.Pattern, "pattern", "", "only files whose name matches this glob (*, ?, [a-z])")
+	cmd.Flags().StringVar(&opts.Epoch, "epoch", "", "resume after an event: its epoch")
+	cmd.Flags().Uint64Var(&opts.AfterSeq, "after", 0, "resume after an event: its seq")
+	return cmd
+}
+
+func usageCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "usage",
+		Short: "Show the bytes and files stored and the quotas, per namespace for admins",
+		Args:  cobra.NoArgs,
+		Run:   func(*cobra.Command, []string) { showUsage(c.client(), c.format) },
+	}
+}
+
+func deleteCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:               "delete <filename-on-server>",
+		Short:             "Delete a file",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRemote(c),
+		Run:               func(_ *cobra.Command, args []string) { deleteFile(c.client(), args[0]) },
+	}
+}
+
+func renameCmd(c *cli) *cobra.Command {
+	var overwrite bool
+	cmd := &cobra.Command{
+		Use:               "rename <filename-on-server> <new-name>",
+		Short:             "Rename a file",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeRemote(c),
+		Run:               func(_ *cobra.Command, args []string) { renameFile(c.client(), args[0], args[1], overwrite) },
+	}
+	cmd.Flags().BoolVar(&overwrite, "overwrite", false, "replace a file already stored under the new name")
+	return cmd
+}
+
+func copyCmd(c *cli) *cobra.Command {
+	var overwrite bool
+	cmd := &cobra.Command{
+		Use:               "copy <filename-on-server> <new-name>",
+		Short:             "Copy a file on the server",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeRemote(c),
+		Run:               func(_ *cobra.Command, args []string) { copyFile(c.client(), args[0], args[1], overwrite) },
+	}
+	cmd.Flags().BoolVar(&overwrite, "overwrite", false, "replace a file already stored under the new name")
+	return cmd
+}
+
+func moveCmd(c *cli) *cobra.Command {
+	var overwrite bool
+	cmd := &cobra.Command{
+		Use:   "move <filename-on-server> <new-name>",
+		Short: "Move a file on the server, across shards too",
+		Long: "Renames the file, or on a sharded server whose new name belongs to another\n" +
+			"node, has the server stream it there and delete the original.",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeRemote(c),
+		Run:               func(_ *cobra.Command, args []string) { moveFile(c.client(), args[0], args[1], overwrite) },
+	}
+	cmd.Flags().BoolVar(&overwrite, "overwrite", false, "replace a file already stored under the new name")
+	return cmd
+}
+
+func linkCmd(c *cli) *cobra.Command {
+	var ttl time.Duration
+	cmd := &cobra.Command{
+		Use:   "link <filename-on-server>",
+		Short: "Create an expiring download link to a file",
+		Long: "Prints a signed link anyone can download the file with until it expires,\n" +
+			"without credentials: a gateway URL if the server has -link-base-url, else\n" +
+			"a token for redeem.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRemote(c),
+		Run:               func(_ *cobra.Command, args []string) { createLink(c.client(), c.format, args[0], ttl) },
+	}
+	cmd.Flags().DurationVar(&ttl, "ttl", 0, "how long the link works (default the server's -link-ttl)")
+	return cmd
+}
+
+func redeemCmd(c *cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "redeem <token> <out-path|->",
+		Short: "Download the file a download link grants",
+		Args:  cobra.ExactArgs(2),
+		Run:   func(_ *cobra.Command, args []string) { redeemLink(c.client(), args[0], args[1]) },
+	}
+}
+
+func quarantineCmd(c *cli) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "quarantine",
+		Short: "Admin: hold files back for review (needs --admin-token)",
+	}
+	sub := func(use, short string, args cobra.PositionalArgs) *cobra.Command {
+		return &cobra.Command{
+			Use:   use,
+			Short: short,
+			Args:  args,
+			Run: func(cmd *cobra.Command, args []string) {
+				quarantine(c.client(), c.format, append([]string{cmd.Name()}, args...))
+			},
+		}
+	}
+	add := sub("add <filename> [reason...]", "Move a file into quarantine", cobra.MinimumNArgs(1))
+	add.ValidArgsFunction = completeRemote(c)
+	cmd.AddCommand(
+		add,
+		sub("list", "List quarantined files", cobra.NoArgs),
+		sub("release <id>", "Put a quarantined file back", cobra.ExactArgs(1)),
+		sub("purge <id>", "Delete a quarantined file for good", cobra.ExactArgs(1)),
+	)
+	return cmd
+}
+
+func versionsCmd(c *cli) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "versions",
+		Short: "List, fetch and restore the versions of a file the server kept (-keep-versions)",
+	}
+	version := func(arg string) int64 {
+		v, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || v < 1 {
+			log.Fatalf("bad version %q", arg)
+		}
+		return v
+	}
+	list := &cobra.Command{
+		Use:               "list <filename-on-server>",
+		Short:             "List the kept versions, newest first",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRemote(c),
+		Run:               func(_ *cobra.Command, args []string) { listVersions(c.client(), c.format, args[0]) },
+	}
+	get := &cobra.Command{
+		Use:               "get <filename-on-server> <version> [output-path]",
+		Short:             "Download a kept version",
+		Args:              cobra.RangeArgs(2, 3),
+		ValidArgsFunction: completeRemote(c),
+		Run: func(_ *cobra.Command, args []string) {
+			out := fmt.Sprintf("%s.v%s", filepath.Base(args[0]), args[1])
+			if len(args) == 3 {
+				out = args[2]
+			}
+			downloadVersion(c.client(), args[0], version(args[1]), out)
+		},
+	}
+	restore := &cobra.Command{
+		Use:               "restore <filename-on-server> <version>",
+		Short:             "Make a kept version current again; the current one is kept",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeRemote(c),
+		Run: func(_ *cobra.Command, args []string) {
+			restoreVersion(c.client(), c.format, args[0], version(args[1]))
+		},
+	}
+	cmd.AddCommand(list, get, restore)
+	return cmd
+}
+
+func jobsCmd(c *cli) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "jobs",
+		Short: "Admin: inspect and start server maintenance jobs (needs --admin-token)",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "Show jobs, their schedules and last results",
+		Args:  cobra.NoArgs,
+		Run:   func(*cobra.Command, []string) { jobs(c.client(), c.format, "") },
+	}, &cobra.Command{
+		Use:   "run <name>",
+		Short: "Start a job now",
+		Args:  cobra.ExactArgs(1),
+		Run:   func(_ *cobra.Command, args []string) { jobs(c.client(), c.format, args[0]) },
+	})
+	return cmd
+}
+
+func bandwidthCmd(c *cli) *cobra.Command {
+	var stream, total int64
+	cmd := &cobra.Command{
+		Use:   "bandwidth",
+		Short: "Admin: show or change the server's transfer bandwidth limits (needs --admin-token)",
+		Long: "Without flags prints the limits in effect. The new limits apply at once,\n" +
+			"also to transfers in progress, until the server restarts.",
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, _ []string) {
+			req := &proto.SetBandwidthRequest{}
+			if cmd.Flags().Changed("stream") {
+				req.StreamBytesPerSecond = &stream
+			}
+			if cmd.Flags().Changed("total") {
+				req.TotalBytesPerSecond = &total
+			}
+			bandwidth(c.client(), c.format, req)
+		},
+	}
+	cmd.Flags().Int64Var(&stream, "stream", 0, "bytes per second each upload and download may transfer, 0 for no limit")
+	cmd.Flags().Int64Var(&total, "total", 0, "bytes per second all transfers together may use, 0 for no limit")
+	return cmd
+}
+
+func replicationCmd(c *cli) *cobra.Command {
+	var verbose bool
+	cmd := &cobra.Command{
+		Use:   "replication",
+		Short: "Admin: show how the server's -mirror targets are keeping up (needs --admin-token)",
+		Args:  cobra.NoArgs,
+		Run:   func(*cobra.Command, []string) { replication(c.client(), c.format, verbose) },
+	}
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "also list the files waiting, oldest first")
+	return cmd
+}
+
+func rotateKeyCmd(c *cli) *cobra.Command {
+	req := &proto.RotateTenantKeyRequest{}
+	cmd := &cobra.Command{
+		Use:   "rotate-key [tenant]",
+		Short: "Admin: start a new encryption key for a namespace of -tenant-keys (needs --admin-token)",
+		Long: "Starts a new key for the tenant, a namespace, or without one for the files\n" +
+			"outside namespaces, and wraps its older keys again with the current master\n" +
+			"key. New files use the new key; --rewrap moves the tenant's stored files to\n" +
+			"it too. Older keys are kept for the files and versions still sealed with them.",
+		Args: cobra.MaximumNArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			if len(args) > 0 {
+				req.Tenant = args[0]
+			}
+			rotateKey(c.client(), c.format, req)
+		},
+	}
+	cmd.Flags().BoolVar(&req.Rewrap, "rewrap", false, "also move the tenant's stored files to the new key")
+	return cmd
+}
+
+func importCmd(c *cli) *cobra.Command {
+	req := &proto.ImportRequest{}
+	cmd := &cobra.Command{
+		Use:   "import <path-on-server>",
+		Short: "Admin: store files already on the server host without uploading them",
+		Long: "Stores a file or directory that is already on the server host, for example a\n" +
+			"mounted archive, and records its checksum. The path must be absolute and\n" +
+			"lie under one of the server's -import-roots.",
+		Args: cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			req.Path = args[0]
+			importFiles(c.client(), c.format, req)
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&req.Prefix, "prefix", "", "prepend this to the stored names")
+	f.BoolVarP(&req.Recursive, "recursive", "r", false, "descend into subdirectories (a/b.txt is stored as a_b.txt)")
+	f.BoolVar(&req.Move, "move", false, "move the files instead of copying them")
+	f.BoolVar(&req.Overwrite, "overwrite", false, "replace stored files of the same name")
+	return cmd
+}
+
+func keygenCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "keygen <name>",
+		Short: "Create an ed25519 key pair <name>.key and <name>.pub for signing uploads",
+		Args:  cobra.ExactArgs(1),
+		Run:   func(_ *cobra.Command, args []string) { keygen(args[0]) },
+	}
+}
+
+func manifestCmd(c *cli) *cobra.Command {
+	var out string
+	cmd := &cobra.Command{
+		Use:   "manifest [filename...]",
+		Short: "Fetch a signed manifest of some or all files",
+		Run:   func(_ *cobra.Command, args []string) { fetchManifest(c.client(), out, args) },
+	}
+	cmd.Flags().StringVarP(&out, "out", "o", "manifest", "write <out>.json and <out>.sig")
+	return cmd
+}
+
+func backupCmd(c *cli) *cobra.Command {
+	var prefix, base string
+	cmd := &cobra.Command{
+		Use:   "backup <dest-dir>",
+		Short: "Copy server files into a backup directory, fully or since a previous backup",
+		Long: "Copies the files into <dest-dir>/files and describes them in\n" +
+			"<dest-dir>/manifest.json. With --base only files that are new or changed since\n" +
+			"that backup are copied, and files deleted since are listed in the manifest.",
+		Example: "  client backup backups/full\n" +
+			"  client backup --base backups/full backups/mon",
+		Args: cobra.ExactArgs(1),
+		Run:  func(_ *cobra.Command, args []string) { backup(c.client(), args[0], prefix, base, c.hedge) },
+	}
+	cmd.Flags().StringVar(&prefix, "prefix", "", "only files whose names start with this")
+	cmd.Flags().StringVar(&base, "base", "", "previous backup directory; makes the backup incremental")
+	return cmd
+}
+
+func verifyCmd(c *cli) *cobra.Command {
+	var prefix, out string
+	cmd := &cobra.Command{
+		Use:   "verify <source> <destination>",
+		Short: "Compare the checksums of two servers or a backup and a server",
+		Long: "Each side is a server address or a backup directory. Files missing from the\n" +
+			"destination, extra on it, unreadable or with different content are reported;\n" +
+			"the exit status is 1 if there are any. Use --format json for a report.",
+		Example: "  client verify old-host:50051 new-host:50051\n" +
+			"  client --format json verify backups/mon localhost:50051 -o report.json",
+		Args: cobra.ExactArgs(2),
+		Run: func(_ *cobra.Command, args []string) {
+			if !verify(c.dialOptions(), args[0], args[1], prefix, c.format, out) {
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&prefix, "prefix", "", "only files whose names start with this")
+	cmd.Flags().StringVarP(&out, "out", "o", "", "write the report to this file instead of stdout")
+	return cmd
+}
+
+func exportManifestCmd(c *cli) *cobra.Command {
+	var prefix, alg, out string
+	cmd := &cobra.Command{
+		Use:   "export-manifest",
+		Short: "Write size, hash and mtime of files for audits and backups",
+		Long: "Writes one line per file. The text format is \"<hash>  <filename>\" as read by\n" +
+			"sha256sum -c and friends; --format json keeps sizes and mtimes too.",
+		Args: cobra.NoArgs,
+		Run:  func(*cobra.Command, []string) { exportManifest(c.client(), prefix, alg, c.format, out) },
+	}
+	f := cmd.Flags()
+	f.StringVar(&prefix, "prefix", "", "only files whose names start with this")
+	f.StringVar(&alg, "algorithm", "sha256", "sha256, sha1, sha512 or md5")
+	f.StringVarP(&out, "out", "o", "", "write to this file instead of stdout")
+	_ = cmd.RegisterFlagCompletionFunc("algorithm", cobra.FixedCompletions([]string{"sha256", "sha1", "sha512", "md5"}, cobra.ShellCompDirectiveNoFileComp))
+	return cmd
+}
+
+func verifyManifestCmd() *cobra.Command {
+	var pub string
+	cmd := &cobra.Command{
+		Use:   "verify-manifest <manifest-name> [dir]",
+		Short: "Check a signed manifest and the files it lists, offline",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(_ *cobra.Command, args []string) {
+			dir := "."
+			if len(args) == 2 {
+				dir = args[1]
+			}
+			verifyManifest(args[0], pub, dir)
+		},
+	}
+	cmd.Flags().StringVar(&pub, "pub", "", "server public key file")
+	_ = cmd.MarkFlagRequired("pub")
+	return cmd
+}
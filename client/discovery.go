@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// discoveryInterval is how often registries are polled for endpoint changes.
+const discoveryInterval = 30 * time.Second
+
+// discoverer looks up the current server addresses in some registry.
+type discoverer interface {
+	Discover(ctx context.Context) ([]string, error)
+}
+
+// discoverers maps a target scheme to the registry it reads, e.g.
+// srv:///_grpc._tcp.files.example.com, consul://127.0.0.1:8500/file-service
+// or etcd://127.0.0.1:2379/services/file-service/. New registries plug in by
+// adding an entry here.
+var discoverers = map[string]func(target *url.URL) discoverer{
+	"srv": func(u *url.URL) discoverer {
+		return srvDiscoverer{name: strings.TrimPrefix(u.Path, "/")}
+	},
+	"consul": func(u *url.URL) discoverer {
+		return consulDiscoverer{addr: u.Host, service: strings.TrimPrefix(u.Path, "/")}
+	},
+	"etcd": func(u *url.URL) discoverer {
+		prefix := u.Path
+		if prefix == "" {
+			prefix = "/"
+		}
+		return etcdDiscoverer{addr: u.Host, prefix: prefix}
+	},
+}
+
+func init() {
+	for scheme, newDiscoverer := range discoverers {
+		resolver.Register(pollBuilder{scheme: scheme, newDiscoverer: newDiscoverer})
+	}
+}
+
+// pollBuilder adapts a discoverer to grpc's resolver API.
+type pollBuilder struct {
+	scheme        string
+	newDiscoverer func(target *url.URL) discoverer
+}
+
+func (b pollBuilder) Scheme() string { return b.scheme }
+
+func (b pollBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &pollResolver{
+		d:      b.newDiscoverer(&target.URL),
+		cc:     cc,
+		now:    make(chan struct{}, 1),
+		cancel: cancel,
+	}
+	go r.watch(ctx)
+	return r, nil
+}
+
+type pollResolver struct {
+	d      discoverer
+	cc     resolver.ClientConn
+	now    chan struct{}
+	cancel context.CancelFunc
+}
+
+func (r *pollResolver) watch(ctx context.Context) {
+	t := time.NewTicker(discoveryInterval)
+	defer t.Stop()
+	for {
+		r.update(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-r.now:
+		}
+	}
+}
+
+func (r *pollResolver) update(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	addrs, err := r.d.Discover(ctx)
+	if err == nil && len(addrs) == 0 {
+		err = errors.New("discovery: no endpoints registered")
+	}
+	if err != nil {
+		r.cc.ReportError(err)
+		return
+	}
+	var state resolver.State
+	for _, a := range addrs {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: a})
+	}
+	_ = r.cc.UpdateState(state)
+}
+
+func (r *pollResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.now <- struct{}{}:
+	default:
+	}
+}
+
+func (r *pollResolver) Close() { r.cancel() }
+
+// ---- DNS SRV ----
+type srvDiscoverer struct {
+	name string
+}
+
+func (d srvDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", d.name)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, rec := range records {
+		host := strings.TrimSuffix(rec.Target, ".")
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(int(rec.Port))))
+	}
+	return addrs, nil
+}
+
+// ---- Consul health API (passing instances only) ----
+type consulDiscoverer struct {
+	addr    string
+	service string
+}
+
+func (d consulDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	u := fmt.Sprintf("http://%s/v1/health/service/%s?passing=true", d.addr, url.PathEscape(d.service))
+	var entries []struct {
+		Node struct {
+			Address string
+		}
+		Service struct {
+			Address string
+			Port    int
+		}
+	}
+	if err := fetchJSON(ctx, http.MethodGet, u, nil, &entries); err != nil {
+		return nil, fmt.Errorf("consul: %w", err)
+	}
+	var addrs []string
+	for _, e := range entries {
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(e.Service.Port)))
+	}
+	return addrs, nil
+}
+
+// ---- etcd v3 JSON gateway: every key under prefix holds one address ----
+type etcdDiscoverer struct {
+	addr   string
+	prefix string
+}
+
+func (d etcdDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	end := []byte(d.prefix)
+	end[len(end)-1]++
+	body, _ := json.Marshal(map[string][]byte{"key": []byte(d.prefix), "range_end": end})
+	var resp struct {
+		Kvs []struct {
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := fetchJSON(ctx, http.MethodPost, "http://"+d.addr+"/v3/kv/range", body, &resp); err != nil {
+		return nil, fmt.Errorf("etcd: %w", err)
+	}
+	var addrs []string
+	for _, kv := range resp.Kvs {
+		addrs = append(addrs, string(kv.Value))
+	}
+	return addrs, nil
+}
+
+func fetchJSON(ctx context.Context, method, u string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// filterRule is one --include or --exclude glob.
+type filterRule struct {
+	pattern string
+	include bool
+}
+
+// pathFilter decides which files recursive commands touch. Every file is
+// included by default; rules are applied in the order given and the last
+// one that matches wins, so `--exclude '*' --include '*.jpg'` keeps only
+// JPEGs.
+type pathFilter struct {
+	rules []filterRule
+}
+
+// addFlags registers --exclude, --include and --exclude-from on fs.
+func (f *pathFilter) addFlags(fs *pflag.FlagSet) {
+	fs.Var(filterFlag{func(p string) error { return f.add(p, false) }}, "exclude", "skip files matching this glob (repeatable)")
+	fs.Var(filterFlag{func(p string) error { return f.add(p, true) }}, "include", "keep files matching this glob even if excluded before (repeatable)")
+	fs.Var(filterFlag{f.load}, "exclude-from", "read .gitignore-style patterns from this file")
+}
+
+// filterFlag appends to a pathFilter, keeping the order of all three flags.
+type filterFlag struct {
+	set func(string) error
+}
+
+func (v filterFlag) Set(s string) error { return v.set(s) }
+func (v filterFlag) String() string     { return "" }
+func (v filterFlag) Type() string       { return "glob" }
+
+func (f *pathFilter) add(pattern string, include bool) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("pattern %q: %w", pattern, err)
+	}
+	f.rules = append(f.rules, filterRule{pattern: pattern, include: include})
+	return nil
+}
+
+// load reads a .gitignore-style file: one glob per line, # comments and
+// !negations. Files here are matched by name only, so leading and trailing
+// slashes are dropped.
+func (f *pathFilter) load(file string) error {
+	fh, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	sc := bufio.NewScanner(fh)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		neg := strings.HasPrefix(line, "!")
+		line = strings.Trim(strings.TrimPrefix(line, "!"), "/")
+		if err := f.add(line, neg); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return sc.Err()
+}
+
+func (f *pathFilter) match(name string) bool {
+	keep := true
+	for _, r := range f.rules {
+		if ok, _ := path.Match(r.pattern, name); ok {
+			keep = r.include
+		}
+	}
+	return keep
+}
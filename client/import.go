@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// importFiles asks the server to store files from its own disk and prints
+// the outcome of each.
+func importFiles(client proto.FileServiceClient, format string, req *proto.ImportRequest) {
+	stream, err := client.ImportFiles(context.Background(), req)
+	if err != nil {
+		log.Fatalf("import error: %v", err)
+	}
+	var results []*proto.ImportResult
+	counts := make(map[string]int)
+	for {
+		r, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("import error: %v", err)
+		}
+		counts[r.Status]++
+		if format == "json" {
+			results = append(results, r)
+			continue
+		}
+		fmt.Printf("%s | %s -> %s | %d вес | %s | %s | %s", r.Status, r.Source, r.Filename, r.SizeBytes, r.ContentType, r.Owner, r.Sha256)
+		if r.Error != "" {
+			fmt.Printf(" | %s", r.Error)
+		}
+		fmt.Println()
+	}
+	if format == "json" {
+		printJSON(results)
+		return
+	}
+	fmt.Printf("import: imported %d, skipped %d, failed %d\n", counts["imported"], counts["skipped"], counts["failed"])
+}
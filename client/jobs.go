@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// jobs lists the server's maintenance jobs, or starts one when name is set.
+func jobs(client proto.FileServiceClient, format, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var list []*proto.JobStatus
+	if name != "" {
+		st, err := client.RunJob(ctx, &proto.RunJobRequest{Name: name})
+		if err != nil {
+			log.Fatalf("run job error: %v", err)
+		}
+		list = append(list, st)
+	} else {
+		resp, err := client.ListJobs(ctx, &proto.ListJobsRequest{})
+		if err != nil {
+			log.Fatalf("list jobs error: %v", err)
+		}
+		list = resp.Jobs
+	}
+	if format == "json" {
+		printJSON(list)
+		return
+	}
+	for _, j := range list {
+		state := "idle"
+		if j.Running {
+			state = "running"
+		}
+		sched := j.Schedule
+		if sched == "" {
+			sched = "on demand"
+		}
+		fmt.Printf("%s | %s | %s | next: %s | last: %s..%s %s", j.Name, sched, state, orDash(j.NextRun), orDash(j.LastStarted), orDash(j.LastFinished), j.LastResult)
+		if j.LastError != "" {
+			fmt.Printf(" | error: %s", j.LastError)
+		}
+		fmt.Println()
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
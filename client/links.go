@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// createLink prints a download link to filename that works for ttl, or
+// the server's default with 0.
+func createLink(client proto.FileServiceClient, format, filename string, ttl time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	link, err := client.CreateDownloadLink(ctx, &proto.DownloadLinkRequest{Filename: filename, TtlSeconds: int64(ttl / time.Second)})
+	if err != nil {
+		log.Fatalf("link error: %v", err)
+	}
+	if format == "json" {
+		printJSON(link)
+		return
+	}
+	if link.Url != "" {
+		fmt.Println(link.Url)
+	} else {
+		fmt.Println(link.Token)
+	}
+	fmt.Printf("expires %s\n", link.ExpiresAt)
+}
+
+// redeemLink downloads the file a link token grants to outpath.
+func redeemLink(client proto.FileServiceClient, token, outpath string) {
+	stream, err := client.RedeemDownload(context.Background(), &proto.RedeemRequest{Token: token})
+	if err != nil {
+		log.Fatalf("download error: %v", err)
+	}
+	size := saveStream(stream.Recv, outpath)
+	if outpath != "-" {
+		fmt.Printf("Downloaded link -> %s (%s)\n", outpath, byteSize(size))
+	}
+}
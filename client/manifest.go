@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// fetchManifest saves a signed manifest of files (all files if empty) as
+// name.json and name.sig.
+func fetchManifest(client proto.FileServiceClient, name string, files []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	resp, err := client.GetSignedManifest(ctx, &proto.ManifestRequest{Filenames: files})
+	if err != nil {
+		log.Fatalf("manifest error: %v", err)
+	}
+	if err := os.WriteFile(name+".json", resp.Manifest, 0o644); err != nil {
+		log.Fatalf("manifest error: %v", err)
+	}
+	if err := os.WriteFile(name+".sig", []byte(base64.StdEncoding.EncodeToString(resp.Signature)+"\n"), 0o644); err != nil {
+		log.Fatalf("manifest error: %v", err)
+	}
+	fmt.Printf("манифест: %s.json, подпись: %s.sig, ключ сервера: %s\n", name, name, base64.StdEncoding.EncodeToString(resp.PublicKey))
+}
+
+// verifyManifest checks the manifest signature with the server's public key
+// file and then every listed file in dir. It needs no server.
+func verifyManifest(name, pubPath, dir string) {
+	body, err := os.ReadFile(name + ".json")
+	if err != nil {
+		log.Fatalf("verify error: %v", err)
+	}
+	sigText, err := os.ReadFile(name + ".sig")
+	if err != nil {
+		log.Fatalf("verify error: %v", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigText)))
+	if err != nil {
+		log.Fatalf("verify error: bad signature file: %v", err)
+	}
+	pubText, err := os.ReadFile(pubPath)
+	if err != nil {
+		log.Fatalf("verify error: %v", err)
+	}
+	pub, err := base64.StdEncoding.DecodeString(strings.Fields(string(pubText) + " ")[0])
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		log.Fatalf("verify error: %s is not an ed25519 public key", pubPath)
+	}
+	if !ed25519.Verify(pub, body, sig) {
+		log.Fatalf("verify error: manifest signature does not match the key")
+	}
+
+	var m struct {
+		Files []struct {
+			Filename string `json:"filename"`
+			Size     int64  `json:"size"`
+			SHA256   string `json:"sha256"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(body, &m); err != nil {
+		log.Fatalf("verify error: %v", err)
+	}
+	bad := 0
+	for _, f := range m.Files {
+		sum, err := fileSHA256(filepath.Join(dir, f.Filename))
+		switch {
+		case err != nil:
+			fmt.Printf("FAIL %s: %v\n", f.Filename, err)
+			bad++
+		case sum != f.SHA256:
+			fmt.Printf("FAIL %s: checksum mismatch\n", f.Filename)
+			bad++
+		default:
+			fmt.Printf("ok   %s\n", f.Filename)
+		}
+	}
+	if bad > 0 {
+		log.Fatalf("verify: %d of %d files failed", bad, len(m.Files))
+	}
+}
+
+// exportManifest writes the server's manifest of files under prefix to out,
+// or stdout if out is empty.
+func exportManifest(client proto.FileServiceClient, prefix, alg, format, out string) {
+	stream, err := client.ExportManifest(context.Background(), &proto.ExportManifestRequest{Prefix: prefix, Algorithm: alg})
+	if err != nil {
+		log.Fatalf("export error: %v", err)
+	}
+	w := os.Stdout
+	if out != "" {
+		if w, err = os.Create(out); err != nil {
+			log.Fatalf("export error: %v", err)
+		}
+		defer w.Close()
+	}
+	var entries []*proto.ManifestEntry
+	for {
+		e, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("export error: %v", err)
+		}
+		if format == "json" {
+			entries = append(entries, e)
+			continue
+		}
+		fmt.Fprintf(w, "%s  %s\n", e.Hash, e.Filename)
+	}
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entries); err != nil {
+			log.Fatalf("export error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// mirrorOptions configure `client mirror`.
+type mirrorOptions struct {
+	pull      bool // make local match remote instead
+	delete    bool // remove what the source does not have
+	maxDelete int  // percent of the target that may be deleted without force
+	force     bool
+	filter    *pathFilter // excluded files are neither copied nor deleted
+}
+
+// mirror makes the server files named prefix+<name> match the regular files
+// of dir, or dir match them with pull. Transfers go through the queue like
+// sync; deletions run after them, so an interrupted mirror never loses data
+// that has not been copied yet.
+func mirror(client proto.FileServiceClient, q *transferQueue, dir, prefix string, opts mirrorOptions, hedge time.Duration, key ed25519.PrivateKey) {
+	if strings.ContainsAny(prefix, `/\`) {
+		log.Fatalf("remote prefix %q: the server has no directories, use a plain name prefix", prefix)
+	}
+	remote := make(map[string]*proto.FileInfo)
+	for _, f := range fetchList(client) {
+		if name, ok := strings.CutPrefix(f.Filename, prefix); ok && name != "" && opts.filter.match(name) {
+			remote[name] = f
+		}
+	}
+	local := make(map[string]bool)
+	entries, err := os.ReadDir(dir)
+	if err != nil && !(opts.pull && os.IsNotExist(err)) {
+		log.Fatalf("read dir error: %v", err)
+	}
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasSuffix(e.Name(), ".part") && opts.filter.match(e.Name()) {
+			local[e.Name()] = true
+		}
+	}
+
+	var todo []transfer
+	var stale []string
+	skipped := 0
+	if opts.pull {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			log.Fatalf("mkdir error: %v", err)
+		}
+		for name, r := range remote {
+			path := filepath.Join(dir, name)
+			if local[name] && !remoteNewer(path, r) {
+				skipped++
+				continue
+			}
+			todo = append(todo, transfer{Op: "download", Local: path, Remote: r.Filename})
+		}
+		for name := range local {
+			if remote[name] == nil {
+				stale = append(stale, name)
+			}
+		}
+	} else {
+		for name := range local {
+			path := filepath.Join(dir, name)
+			if r, ok := remote[name]; ok && !changed(client, path, r, false) {
+				skipped++
+				continue
+			}
+			todo = append(todo, transfer{Op: "upload", Local: path, Remote: prefix + name})
+		}
+		for name := range remote {
+			if !local[name] {
+				stale = append(stale, name)
+			}
+		}
+	}
+
+	target := len(remote)
+	if opts.pull {
+		target = len(local)
+	}
+	if opts.delete && len(stale) > 0 && !opts.force && len(stale)*100 > target*opts.maxDelete {
+		log.Fatalf("mirror would delete %d of %d files, more than -max-delete %d%%; check the paths or pass -force",
+			len(stale), target, opts.maxDelete)
+	}
+
+	if err := q.add(todo...); err != nil {
+		log.Fatalf("queue save error: %v", err)
+	}
+	q.run(client, hedge, key)
+
+	deleted := 0
+	if opts.delete {
+		for _, name := range stale {
+			if opts.pull {
+				if err := os.Remove(filepath.Join(dir, name)); err != nil {
+					log.Fatalf("delete error: %v", err)
+				}
+			} else {
+				deleteRemote(client, prefix+name)
+			}
+			deleted++
+		}
+	}
+	fmt.Printf("mirror: copied %d, unchanged %d, deleted %d\n", len(todo), skipped, deleted)
+}
+
+// remoteNewer reports whether the server copy differs from the local file
+// by size or was modified after it.
+func remoteNewer(path string, remote *proto.FileInfo) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return true
+	}
+	if info.Size() != remote.SizeBytes {
+		return true
+	}
+	mtime, err := time.Parse(time.RFC3339, remote.ModifiedAt)
+	return err != nil || mtime.After(info.ModTime())
+}
+
+func deleteRemote(client proto.FileServiceClient, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := client.Delete(ctx, &proto.DeleteRequest{Filename: name}); err != nil {
+		log.Fatalf("delete error: %v", err)
+	}
+}
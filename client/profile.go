@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// profile holds saved defaults for the global flags, so switching between
+// servers does not mean retyping addresses and keys. Flags given on the
+// command line win.
+type profile struct {
+	Server     string `json:"server"`
+	Replicas   string `json:"replicas"`
+	Hedge      string `json:"hedge"`
+	Queue      string `json:"queue"`
+	Sign       string `json:"sign"`
+	AdminToken string `json:"admin_token"`
+	Token      string `json:"token"`
+	Format     string `json:"format"`
+	Compress   string `json:"compress"`
+
+	TLS           bool   `json:"tls"`
+	TLSCA         string `json:"tls_ca"`
+	TLSCert       string `json:"tls_cert"`
+	TLSKey        string `json:"tls_key"`
+	TLSServerName string `json:"tls_server_name"`
+}
+
+func profilesPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, "grpc-file-service", "profiles.json")
+}
+
+// loadProfile returns the named profile. Without a name the "default"
+// profile is used if there is one.
+func loadProfile(name string) (profile, error) {
+	var profiles map[string]profile
+	b, err := os.ReadFile(profilesPath())
+	if errors.Is(err, fs.ErrNotExist) && name == "" {
+		return profile{}, nil
+	}
+	if err != nil {
+		return profile{}, err
+	}
+	if err := json.Unmarshal(b, &profiles); err != nil {
+		return profile{}, fmt.Errorf("%s: %w", profilesPath(), err)
+	}
+	if name == "" {
+		return profiles["default"], nil
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return profile{}, fmt.Errorf("no profile %q in %s", name, profilesPath())
+	}
+	return p, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressEvery limits how often the bar is redrawn.
+const progressEvery = 200 * time.Millisecond
+
+// progressBar draws a transfer's progress on one line of stderr: percent,
+// bytes, throughput and time left. Without a known total it shows bytes and
+// throughput only.
+type progressBar struct {
+	name  string
+	total int64 // -1 if unknown
+
+	mu    sync.Mutex
+	start time.Time
+	first int64 // done at start, which the throughput leaves out
+	drawn time.Time
+	done  int64
+}
+
+// newProgressBar returns a bar for name, or nil when quiet or stderr is not
+// a terminal; the methods of a nil bar do nothing.
+func newProgressBar(name string, total int64, quiet bool) *progressBar {
+	if quiet {
+		return nil
+	}
+	if fi, err := os.Stderr.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return &progressBar{name: name, total: total, first: -1}
+}
+
+// update is the client library's Progress callback.
+func (p *progressBar) update(done int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if p.first < 0 {
+		p.start, p.first = now, done
+	}
+	p.done = done
+	if now.Sub(p.drawn) >= progressEvery {
+		p.draw(now)
+	}
+}
+
+// logf logs on a line of its own; the bar is drawn again below it.
+func (p *progressBar) logf(format string, v ...any) {
+	if p != nil {
+		p.mu.Lock()
+		fmt.Fprintf(os.Stderr, "\r%100s\r", "")
+		p.drawn = time.Time{}
+		p.mu.Unlock()
+	}
+	log.Printf(format, v...)
+}
+
+// finish draws the final state and ends the line.
+func (p *progressBar) finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.first >= 0 {
+		p.draw(time.Now())
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+func (p *progressBar) draw(now time.Time) {
+	p.drawn = now
+	var rate float64
+	if secs := now.Sub(p.start).Seconds(); secs > 0 {
+		rate = float64(p.done-p.first) / secs
+	}
+	line := fmt.Sprintf("%s %s %s/s", p.name, byteSize(p.done), byteSize(int64(rate)))
+	if p.total > 0 {
+		frac := min(float64(p.done)/float64(p.total), 1)
+		const width = 30
+		fill := int(frac * width)
+		line = fmt.Sprintf("%s %3.0f%% [%s%s] %s / %s %s/s", p.name, frac*100,
+			strings.Repeat("=", fill), strings.Repeat(" ", width-fill), byteSize(p.done), byteSize(p.total), byteSize(int64(rate)))
+		if rate > 0 && p.done < p.total {
+			line += " ETA " + time.Duration(float64(p.total-p.done)/rate*float64(time.Second)).Round(time.Second).String()
+		}
+	}
+	// pad over what a longer previous line left
+	fmt.Fprintf(os.Stderr, "\r%-100s", line)
+}
+
+// byteSize formats n bytes with a binary unit.
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// quarantine runs the admin quarantine subcommands.
+func quarantine(client proto.FileServiceClient, format string, args []string) {
+	const usage = "usage: client --admin-token T quarantine [add <filename> [reason]|list|release <id>|purge <id>]"
+	if len(args) < 1 {
+		log.Fatal(usage)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var entries []*proto.QuarantineEntry
+	switch {
+	case args[0] == "list":
+		resp, err := client.ListQuarantine(ctx, &proto.ListQuarantineRequest{})
+		if err != nil {
+			log.Fatalf("quarantine list error: %v", err)
+		}
+		entries = resp.Entries
+	case args[0] == "add" && len(args) >= 2:
+		e, err := client.QuarantineFile(ctx, &proto.QuarantineRequest{Filename: args[1], Reason: strings.Join(args[2:], " ")})
+		if err != nil {
+			log.Fatalf("quarantine error: %v", err)
+		}
+		entries = append(entries, e)
+	case args[0] == "release" && len(args) >= 2:
+		e, err := client.ReleaseQuarantined(ctx, &proto.QuarantineIDRequest{Id: args[1]})
+		if err != nil {
+			log.Fatalf("release error: %v", err)
+		}
+		entries = append(entries, e)
+	case args[0] == "purge" && len(args) >= 2:
+		e, err := client.PurgeQuarantined(ctx, &proto.QuarantineIDRequest{Id: args[1]})
+		if err != nil {
+			log.Fatalf("purge error: %v", err)
+		}
+		entries = append(entries, e)
+	default:
+		log.Fatal(usage)
+	}
+	if format == "json" {
+		printJSON(entries)
+		return
+	}
+	for _, e := range entries {
+		fmt.Printf("%s | %s | %d вес | %s | %s: %s\n", e.Id, e.Filename, e.SizeBytes, e.QuarantinedAt, e.Source, e.Reason)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// transfer is one queued upload or download of a batch job.
+type transfer struct {
+	Op     string `json:"op"` // "upload" or "download"
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+	State  string `json:"state"` // "pending" or "in-progress"
+}
+
+// transferQueue is the list of unfinished transfers, persisted after every
+// change so `client resume` can finish a batch after a crash or reboot.
+type transferQueue struct {
+	path    string
+	Pending []transfer `json:"pending"`
+}
+
+func defaultQueuePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "grpc-file-service", "queue.json")
+}
+
+func loadQueue(path string) (*transferQueue, error) {
+	q := &transferQueue{path: path}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, q); err != nil {
+		return nil, fmt.Errorf("queue %s: %w", path, err)
+	}
+	return q, nil
+}
+
+func openQueue(path string) *transferQueue {
+	q, err := loadQueue(path)
+	if err != nil {
+		log.Fatalf("queue load error: %v", err)
+	}
+	return q
+}
+
+// save writes the queue atomically so a crash mid-write keeps the old state.
+func (q *transferQueue) save() error {
+	if err := os.MkdirAll(filepath.Dir(q.path), 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(q, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
+
+func (q *transferQueue) add(ts ...transfer) error {
+	for _, t := range ts {
+		t.State = "pending"
+		q.Pending = append(q.Pending, t)
+	}
+	return q.save()
+}
+
+// run performs the queued transfers in order, dropping each one from the
+// queue once it has completed.
+func (q *transferQueue) run(client proto.FileServiceClient, hedge time.Duration, key ed25519.PrivateKey) {
+	for len(q.Pending) > 0 {
+		t := &q.Pending[0]
+		t.State = "in-progress"
+		if err := q.save(); err != nil {
+			log.Fatalf("queue save error: %v", err)
+		}
+		switch t.Op {
+		case "upload":
+			upload(client, t.Local, t.Remote, key, true)
+		case "download":
+			download(client, t.Remote, t.Local, hedge, true)
+		default:
+			log.Printf("queue: skipping unknown op %q", t.Op)
+		}
+		q.Pending = q.Pending[1:]
+		if err := q.save(); err != nil {
+			log.Fatalf("queue save error: %v", err)
+		}
+	}
+}
+
+func resume(client proto.FileServiceClient, q *transferQueue, hedge time.Duration, key ed25519.PrivateKey) {
+	if len(q.Pending) == 0 {
+		fmt.Println("nothing to resume")
+		return
+	}
+	for _, t := range q.Pending {
+		if t.State == "in-progress" {
+			fmt.Printf("restarting interrupted %s of %s\n", t.Op, t.Local)
+		}
+	}
+	q.run(client, hedge, key)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// replicaWait is how long a read waits for a replica connection before it
+// goes to the primary instead.
+const replicaWait = 2 * time.Second
+
+// routedClient sends reads to read-only replicas and everything else to the
+// primary. Reads fall back to the primary while no replica is reachable.
+// Replicas lag behind the primary, so a file just uploaded may not be
+// readable from them yet.
+type routedClient struct {
+	proto.FileServiceClient // the primary
+	replicas                proto.FileServiceClient
+	conn                    *grpc.ClientConn // of the replicas
+}
+
+func newRoutedClient(primary, replicas *grpc.ClientConn) routedClient {
+	return routedClient{
+		FileServiceClient: proto.NewFileServiceClient(primary),
+		replicas:          proto.NewFileServiceClient(replicas),
+		conn:              replicas,
+	}
+}
+
+func (c routedClient) reads(ctx context.Context) proto.FileServiceClient {
+	ctx, cancel := context.WithTimeout(ctx, replicaWait)
+	defer cancel()
+	for {
+		switch state := c.conn.GetState(); state {
+		case connectivity.Ready:
+			return c.replicas
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			return c.FileServiceClient
+		case connectivity.Idle:
+			c.conn.Connect()
+			fallthrough
+		default:
+			if !c.conn.WaitForStateChange(ctx, state) {
+				return c.FileServiceClient
+			}
+		}
+	}
+}
+
+func (c routedClient) Download(ctx context.Context, in *proto.DownloadRequest, opts ...grpc.CallOption) (proto.FileService_DownloadClient, error) {
+	return c.reads(ctx).Download(ctx, in, opts...)
+}
+
+func (c routedClient) ListFiles(ctx context.Context, in *proto.ListRequest, opts ...grpc.CallOption) (*proto.ListResponse, error) {
+	return c.reads(ctx).ListFiles(ctx, in, opts...)
+}
+
+func (c routedClient) HashFile(ctx context.Context, in *proto.HashRequest, opts ...grpc.CallOption) (*proto.HashResponse, error) {
+	return c.reads(ctx).HashFile(ctx, in, opts...)
+}
+
+func (c routedClient) Follow(ctx context.Context, in *proto.FollowRequest, opts ...grpc.CallOption) (proto.FileService_FollowClient, error) {
+	return c.reads(ctx).Follow(ctx, in, opts...)
+}
+
+func (c routedClient) Head(ctx context.Context, in *proto.HeadRequest, opts ...grpc.CallOption) (*proto.HeadResponse, error) {
+	return c.reads(ctx).Head(ctx, in, opts...)
+}
+
+func (c routedClient) StatFile(ctx context.Context, in *proto.StatRequest, opts ...grpc.CallOption) (*proto.FileInfo, error) {
+	return c.reads(ctx).StatFile(ctx, in, opts...)
+}
+
+func (c routedClient) GetSignedManifest(ctx context.Context, in *proto.ManifestRequest, opts ...grpc.CallOption) (*proto.SignedManifest, error) {
+	return c.reads(ctx).GetSignedManifest(ctx, in, opts...)
+}
+
+func (c routedClient) ExportManifest(ctx context.Context, in *proto.ExportManifestRequest, opts ...grpc.CallOption) (proto.FileService_ExportManifestClient, error) {
+	return c.reads(ctx).ExportManifest(ctx, in, opts...)
+}
+
+func (c routedClient) GetPieceHashes(ctx context.Context, in *proto.PieceHashesRequest, opts ...grpc.CallOption) (*proto.PieceHashes, error) {
+	return c.reads(ctx).GetPieceHashes(ctx, in, opts...)
+}
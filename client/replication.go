@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// replication prints how far the server's -mirror targets are behind,
+// with the files waiting for each when verbose.
+func replication(client proto.FileServiceClient, format string, verbose bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := client.GetReplicationStatus(ctx, &proto.ReplicationStatusRequest{})
+	if err != nil {
+		log.Fatalf("replication error: %v", err)
+	}
+	if format == "json" {
+		printJSON(resp)
+		return
+	}
+	if len(resp.Mirrors) == 0 {
+		fmt.Println("no mirror targets")
+		return
+	}
+	for _, m := range resp.Mirrors {
+		fmt.Printf("%s | pending: %d since %s | mirrored: %d, last %s | failures: %d",
+			m.Address, m.Pending, orDash(m.OldestPendingAt), m.Mirrored, orDash(m.LastMirroredAt), m.Failures)
+		if m.LastError != "" {
+			fmt.Printf(" | error: %s", m.LastError)
+		}
+		fmt.Println()
+		if verbose {
+			for _, name := range m.PendingFiles {
+				fmt.Printf("  %s\n", name)
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDKey is the response metadata the server returns the ID of a
+// call in, which its logs record the call under.
+const requestIDKey = "x-request-id"
+
+// quoteRequestID adds the request ID to the message of a failed call, so
+// it can be quoted when reporting the failure. The code is kept.
+func quoteRequestID(err error, mds ...metadata.MD) error {
+	for _, md := range mds {
+		if v := md.Get(requestIDKey); len(v) > 0 {
+			p := status.Convert(err).Proto()
+			p.Message += " (request id " + v[0] + ")"
+			return status.FromProto(p).Err()
+		}
+	}
+	return err
+}
+
+func unaryRequestID(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	var header, trailer metadata.MD
+	err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&header), grpc.Trailer(&trailer))...)
+	if err != nil {
+		return quoteRequestID(err, header, trailer)
+	}
+	return nil
+}
+
+func streamRequestID(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &quotedStream{cs}, nil
+}
+
+// quotedStream quotes the request ID in the error that ends a stream.
+type quotedStream struct{ grpc.ClientStream }
+
+func (s *quotedStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil && err != io.EOF {
+		return quoteRequestID(err, s.Trailer())
+	}
+	return err
+}
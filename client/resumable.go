@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// savedUpload is a resumable upload started for a local file, kept in
+// uploads.json next to the transfer queue so a rerun after a crash goes on
+// where the last one stopped. It is only reused while the file is
+// unchanged.
+type savedUpload struct {
+	ID      string `json:"id"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+}
+
+func uploadsPath(queue string) string {
+	return filepath.Join(filepath.Dir(queue), "uploads.json")
+}
+
+func loadSavedUploads(path string) map[string]savedUpload {
+	saved := make(map[string]savedUpload)
+	if b, err := os.ReadFile(path); err == nil {
+		_ = json.Unmarshal(b, &saved)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("uploads state: %v", err)
+	}
+	return saved
+}
+
+func storeSavedUploads(path string, saved map[string]savedUpload) {
+	b, err := json.MarshalIndent(saved, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0o755)
+	}
+	if err == nil {
+		err = os.WriteFile(path, b, 0o644)
+	}
+	if err != nil {
+		log.Printf("uploads state: %v", err)
+	}
+}
+
+// resumableUpload uploads path with BeginUpload and, when the stream
+// breaks, carries on from the bytes the server persisted.
+func resumableUpload(client proto.FileServiceClient, path, remote string, key ed25519.PrivateKey, statePath string, quiet bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("open error: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		log.Fatalf("open error: %v", err)
+	}
+	abs, _ := filepath.Abs(path)
+	stateKey := abs + " -> " + remote
+	saved := loadSavedUploads(statePath)
+
+	var id string
+	if prev, ok := saved[stateKey]; ok && prev.Size == info.Size() && prev.ModTime == info.ModTime().UnixNano() {
+		_, err := uploadStatus(client, prev.ID, remote)
+		if err == nil {
+			id = prev.ID
+		} else if status.Code(err) != codes.NotFound {
+			log.Fatalf("upload status error: %v", err)
+		}
+	}
+	if id == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		st, err := client.BeginUpload(ctx, &proto.BeginUploadRequest{Filename: remote, SizeBytes: info.Size()})
+		cancel()
+		if err != nil {
+			log.Fatalf("upload start error: %v", err)
+		}
+		id = st.UploadId
+		saved[stateKey] = savedUpload{ID: id, Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+		storeSavedUploads(statePath, saved)
+	}
+
+	fc := newFileClient(client, key, 0)
+	bar := newProgressBar(remote, info.Size(), quiet)
+	fc.Progress, fc.Logf = bar.update, bar.logf
+	resp, err := fc.ResumeUpload(context.Background(), f, id, remote)
+	bar.finish()
+	if err != nil {
+		log.Fatalf("upload error: %v; rerun with --resumable to continue", err)
+	}
+	delete(saved, stateKey)
+	storeSavedUploads(statePath, saved)
+	fmt.Printf("результатt: ok=%v msg=%s\n", resp.Ok, resp.Message)
+}
+
+func uploadStatus(client proto.FileServiceClient, id, remote string) (*proto.UploadStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return client.GetUploadStatus(ctx, &proto.UploadStatusRequest{UploadId: id, Filename: remote})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// loadPrivateKey reads a base64 ed25519 seed as written by keygen.
+func loadPrivateKey(path string) ed25519.PrivateKey {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("signing key error: %v", err)
+	}
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		log.Fatalf("signing key error: %s is not a base64 ed25519 seed", path)
+	}
+	return ed25519.NewKeyFromSeed(seed)
+}
+
+// keygen writes a new signing key to name.key and its public half, the line
+// to add to the server's -upload-keys file, to name.pub.
+func keygen(name string) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalf("keygen error: %v", err)
+	}
+	if err := os.WriteFile(name+".key", []byte(base64.StdEncoding.EncodeToString(priv.Seed())+"\n"), 0o600); err != nil {
+		log.Fatalf("keygen error: %v", err)
+	}
+	if err := os.WriteFile(name+".pub", []byte(base64.StdEncoding.EncodeToString(pub)+" "+name+"\n"), 0o644); err != nil {
+		log.Fatalf("keygen error: %v", err)
+	}
+	fmt.Printf("ключ: %s.key, публичный ключ: %s.pub\n", name, name)
+}
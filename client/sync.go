@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// syncDir uploads the files of dir that are missing on the server or differ
+// from the server copy. The uploads go through the persistent queue.
+func syncDir(client proto.FileServiceClient, q *transferQueue, dir string, checksum bool, filter *pathFilter, hedge time.Duration, key ed25519.PrivateKey) {
+	remote := make(map[string]*proto.FileInfo)
+	for _, f := range fetchList(client) {
+		remote[f.Filename] = f
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Fatalf("read dir error: %v", err)
+	}
+	var todo []transfer
+	skipped := 0
+	for _, e := range entries {
+		// .part files are unfinished downloads
+		if !e.Type().IsRegular() || strings.HasSuffix(e.Name(), ".part") || !filter.match(e.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if r, ok := remote[e.Name()]; ok && !changed(client, path, r, checksum) {
+			skipped++
+			continue
+		}
+		todo = append(todo, transfer{Op: "upload", Local: path, Remote: e.Name()})
+	}
+	if err := q.add(todo...); err != nil {
+		log.Fatalf("queue save error: %v", err)
+	}
+	q.run(client, hedge, key)
+	fmt.Printf("sync: uploaded %d, unchanged %d\n", len(todo), skipped)
+}
+
+// changed compares a local file with its server copy by size and mtime, or
+// by SHA-256 with checksum set, which also catches restored or touched files
+// whose timestamps lie.
+func changed(client proto.FileServiceClient, path string, remote *proto.FileInfo, checksum bool) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Fatalf("stat error: %v", err)
+	}
+	if info.Size() != remote.SizeBytes {
+		return true
+	}
+	if !checksum {
+		mtime, err := time.Parse(time.RFC3339, remote.ModifiedAt)
+		return err != nil || info.ModTime().Truncate(time.Second).After(mtime)
+	}
+
+	local, err := fileSHA256(path)
+	if err != nil {
+		log.Fatalf("hash error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	resp, err := client.HashFile(ctx, &proto.HashRequest{Filename: remote.Filename})
+	if err != nil {
+		log.Fatalf("remote hash error: %v", err)
+	}
+	return resp.Sha256 != local
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
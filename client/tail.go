@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// tail prints the last n bytes of a server file; with follow set it keeps
+// printing data appended to it until interrupted.
+func tail(client proto.FileServiceClient, filename string, n int64, follow bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.Follow(ctx, &proto.FollowRequest{Filename: filename, FromOffset: -n})
+	if err != nil {
+		log.Fatalf("follow start error: %v", err)
+	}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatalf("recv error: %v", err)
+		}
+		if len(chunk.Data) == 0 && !follow {
+			return
+		}
+		if _, werr := os.Stdout.Write(chunk.Data); werr != nil {
+			log.Fatalf("write error: %v", werr)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// rotateKey starts a new key for a tenant of the server's -tenant-keys and
+// prints it.
+func rotateKey(client proto.FileServiceClient, format string, req *proto.RotateTenantKeyRequest) {
+	// rewrapping goes through every file of the tenant
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	k, err := client.RotateTenantKey(ctx, req)
+	if err != nil {
+		log.Fatalf("rotate-key error: %v", err)
+	}
+	if format == "json" {
+		printJSON(k)
+		return
+	}
+	fmt.Printf("%s | created: %s", k.KeyId, k.CreatedAt)
+	if req.Rewrap {
+		fmt.Printf(" | rewrapped: %d files", k.Rewrapped)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// dialOptions returns the connection options of the global flags.
+func (c *cli) dialOptions() []grpc.DialOption {
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(c.creds()), grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithChainUnaryInterceptor(unaryRequestID), grpc.WithChainStreamInterceptor(streamRequestID)}
+	if c.token != "" || c.adminToken != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(callTokens{c.token, c.adminToken}))
+	}
+	if c.compress != "" && c.compress != "none" {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor(c.compress)))
+	}
+	return opts
+}
+
+// callTokens sends --token and --admin-token with every call. They are
+// allowed over plaintext for local setups; anywhere else use --tls.
+type callTokens struct{ user, admin string }
+
+func (t callTokens) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	md := make(map[string]string)
+	if t.user != "" {
+		md["authorization"] = "Bearer " + t.user
+	}
+	if t.admin != "" {
+		md["x-admin-token"] = t.admin
+	}
+	return md, nil
+}
+
+func (callTokens) RequireTransportSecurity() bool { return false }
+
+// creds returns the transport credentials of the --tls* flags: plaintext
+// unless one of them is given. --tls-ca replaces the system roots, and a
+// client certificate is presented to servers running with -mtls.
+func (c *cli) creds() credentials.TransportCredentials {
+	if !c.tls && c.tlsCA == "" && c.tlsCert == "" && c.tlsServerName == "" {
+		return insecure.NewCredentials()
+	}
+	cfg, err := c.tlsConfig()
+	if err != nil {
+		log.Fatalf("tls: %v", err)
+	}
+	return credentials.NewTLS(cfg)
+}
+
+func (c *cli) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: c.tlsServerName, MinVersion: tls.VersionTLS12}
+	if c.tlsCA != "" {
+		pem, err := os.ReadFile(c.tlsCA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", c.tlsCA)
+		}
+	}
+	if c.tlsCert != "" {
+		key := c.tlsKey
+		if key == "" {
+			key = c.tlsCert
+		}
+		cert, err := tls.LoadX509KeyPair(c.tlsCert, key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	fileclient "github.com/daniil1412412/grpc-file-service/pkg/client"
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// The server stores files flat, so upload-dir keeps a file's path, relative
+// to the parent of the uploaded dir, in its name: "/" is written as %2F and
+// "%" as %25, which maps names back to paths unambiguously.
+var (
+	treeEscape   = strings.NewReplacer("%", "%25", "/", "%2F")
+	treeUnescape = strings.NewReplacer("%2F", "/", "%25", "%")
+)
+
+// treeName is the name a file at the slash-separated path rel is stored as.
+func treeName(rel string) string { return treeEscape.Replace(rel) }
+
+// treePath is the slash-separated path stored as name.
+func treePath(name string) string { return treeUnescape.Replace(name) }
+
+// treeJob is one file of a tree transfer.
+type treeJob struct {
+	local, remote string
+}
+
+// uploadDir uploads the regular files under dir that pass filter, workers
+// at a time, as <dir name>/<path below dir>.
+func uploadDir(client proto.FileServiceClient, dir string, workers int, filter *pathFilter, key ed25519.PrivateKey, quiet bool) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		log.Fatalf("read dir error: %v", err)
+	}
+	var jobs []treeJob
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// .part files are unfinished downloads
+		if !d.Type().IsRegular() || strings.HasSuffix(d.Name(), ".part") || !filter.match(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(filepath.Dir(root), p)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, treeJob{local: p, remote: treeName(filepath.ToSlash(rel))})
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("read dir error: %v", err)
+	}
+	fc := newFileClient(client, key, 0)
+	runTree("upload-dir", jobs, workers, quiet, func(j treeJob) error {
+		f, err := os.Open(j.local)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = fc.Upload(context.Background(), f, j.remote)
+		return err
+	})
+}
+
+// downloadDir downloads the files upload-dir stored under prefix, a
+// slash-separated path, into dest, workers at a time, recreating the
+// directories below prefix.
+func downloadDir(client proto.FileServiceClient, prefix, dest string, workers int, filter *pathFilter, hedge time.Duration, quiet bool) {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var jobs []treeJob
+	for _, f := range fetchPages(client, fileclient.ListOptions{Prefix: treeName(prefix), Fields: []string{"filename"}}) {
+		rel := strings.TrimPrefix(treePath(f.Filename), prefix)
+		// a name must not reach out of dest
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			log.Printf("download-dir: skipping %s, not a path below %s", f.Filename, dest)
+			continue
+		}
+		if !filter.match(path.Base(rel)) {
+			continue
+		}
+		jobs = append(jobs, treeJob{local: filepath.Join(dest, filepath.FromSlash(rel)), remote: f.Filename})
+	}
+	fc := newFileClient(client, nil, hedge)
+	runTree("download-dir", jobs, workers, quiet, func(j treeJob) error {
+		if err := os.MkdirAll(filepath.Dir(j.local), 0o755); err != nil {
+			return err
+		}
+		return fc.DownloadFile(context.Background(), j.remote, j.local)
+	})
+}
+
+// runTree runs do for every job on workers goroutines and reports each
+// file and a summary. Failed files do not stop the others; the command
+// exits non-zero after them.
+func runTree(cmd string, jobs []treeJob, workers int, quiet bool, do func(treeJob) error) {
+	start := time.Now()
+	queue := make(chan treeJob)
+	var mu sync.Mutex
+	var failed int
+	var wg sync.WaitGroup
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				err := do(j)
+				mu.Lock()
+				if err != nil {
+					failed++
+					log.Printf("%s: %s: %v", cmd, j.local, err)
+				} else if !quiet {
+					fmt.Printf("%s <-> %s\n", j.local, j.remote)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, j := range jobs {
+		queue <- j
+	}
+	close(queue)
+	wg.Wait()
+	fmt.Printf("%s: %d files, %d failed, in %s\n", cmd, len(jobs), failed, time.Since(start).Round(time.Millisecond))
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc"
+)
+
+// verifyFile is what one side of a verify knows about a file.
+type verifyFile struct {
+	Size   int64
+	SHA256 string
+	Err    string // set when the file is listed but cannot be read
+}
+
+type verifyProblem struct {
+	Filename          string `json:"filename"`
+	Problem           string `json:"problem"` // missing, extra, checksum or unreadable
+	SourceSHA256      string `json:"source_sha256,omitempty"`
+	DestinationSHA256 string `json:"destination_sha256,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+type verifyReport struct {
+	Source      string          `json:"source"`
+	Destination string          `json:"destination"`
+	Prefix      string          `json:"prefix,omitempty"`
+	Checked     int             `json:"checked"`
+	Matched     int             `json:"matched"`
+	Problems    []verifyProblem `json:"problems"`
+}
+
+// verifySide lists the files under prefix of a backup directory, if side
+// holds one, or else of the server at that address.
+func verifySide(side, prefix string, opts []grpc.DialOption) (map[string]verifyFile, error) {
+	if _, err := os.Stat(filepath.Join(side, "manifest.json")); err == nil {
+		return backupFiles(side, prefix)
+	}
+	conn, err := dial(side, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stream, err := proto.NewFileServiceClient(conn).ExportManifest(context.Background(),
+		&proto.ExportManifestRequest{Prefix: prefix, Algorithm: "sha256"})
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]verifyFile)
+	for {
+		e, err := stream.Recv()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		files[e.Filename] = verifyFile{Size: e.SizeBytes, SHA256: e.Hash}
+	}
+}
+
+// backupFiles hashes the files a backup restores to, looking each one up
+// in the incremental chain down to the backup that copied it. The
+// manifests are not trusted: a damaged copy must show up as a mismatch.
+func backupFiles(dir, prefix string) (map[string]verifyFile, error) {
+	top, err := loadBackupManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(prefix, top.Prefix) {
+		return nil, fmt.Errorf("%s only covers prefix %q", dir, top.Prefix)
+	}
+	// where each name was last copied, walking from the newest backup down
+	copied := make(map[string]string)
+	for d, m := dir, top; ; {
+		for _, name := range m.Copied {
+			if _, ok := copied[name]; !ok {
+				copied[name] = filepath.Join(d, "files", name)
+			}
+		}
+		if m.Base == "" {
+			break
+		}
+		d = m.Base
+		if m, err = loadBackupManifest(d); err != nil {
+			return nil, fmt.Errorf("base backup: %w", err)
+		}
+	}
+
+	files := make(map[string]verifyFile)
+	for _, e := range top.Files {
+		if !strings.HasPrefix(e.Filename, prefix) {
+			continue
+		}
+		path, ok := copied[e.Filename]
+		if !ok {
+			files[e.Filename] = verifyFile{Err: "not copied by any backup in the chain"}
+			continue
+		}
+		sum, err := fileSHA256(path)
+		if err != nil {
+			files[e.Filename] = verifyFile{Err: err.Error()}
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			files[e.Filename] = verifyFile{Err: err.Error()}
+			continue
+		}
+		files[e.Filename] = verifyFile{Size: info.Size(), SHA256: sum}
+	}
+	return files, nil
+}
+
+// verify compares the checksums of every file under prefix on src and dst,
+// each a server address or a backup directory, and reports whether they
+// match.
+func verify(opts []grpc.DialOption, src, dst, prefix, format, out string) bool {
+	a, err := verifySide(src, prefix, opts)
+	if err != nil {
+		log.Fatalf("verify %s: %v", src, err)
+	}
+	b, err := verifySide(dst, prefix, opts)
+	if err != nil {
+		log.Fatalf("verify %s: %v", dst, err)
+	}
+
+	r := verifyReport{Source: src, Destination: dst, Prefix: prefix, Problems: []verifyProblem{}}
+	names := make([]string, 0, len(a)+len(b))
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		x, inSrc := a[name]
+		y, inDst := b[name]
+		p := verifyProblem{Filename: name, SourceSHA256: x.SHA256, DestinationSHA256: y.SHA256}
+		r.Checked++
+		switch {
+		case !inDst:
+			p.Problem = "missing"
+		case !inSrc:
+			p.Problem = "extra"
+		case x.Err != "" || y.Err != "":
+			p.Problem, p.Error = "unreadable", x.Err+y.Err
+		case x.SHA256 != y.SHA256 || x.Size != y.Size:
+			p.Problem = "checksum"
+		default:
+			r.Matched++
+			continue
+		}
+		r.Problems = append(r.Problems, p)
+	}
+
+	w := os.Stdout
+	if out != "" {
+		if w, err = os.Create(out); err != nil {
+			log.Fatalf("verify: %v", err)
+		}
+		defer w.Close()
+	}
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(r); err != nil {
+			log.Fatalf("verify: %v", err)
+		}
+	} else {
+		for _, p := range r.Problems {
+			fmt.Fprintf(w, "%-10s %s\n", p.Problem, p.Filename)
+		}
+		fmt.Fprintf(w, "checked %d, matched %d, problems %d\n", r.Checked, r.Matched, len(r.Problems))
+	}
+	return len(r.Problems) == 0
+}
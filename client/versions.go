@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+func listVersions(client proto.FileServiceClient, format, filename string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	resp, err := client.ListVersions(ctx, &proto.ListVersionsRequest{Filename: filename})
+	if err != nil {
+		log.Fatalf("versions error: %v", err)
+	}
+	printVersions(format, resp.Versions)
+}
+
+func printVersions(format string, versions []*proto.FileVersion) {
+	if format == "json" {
+		printJSON(versions)
+		return
+	}
+	for _, v := range versions {
+		fmt.Printf("%d | %s | обновлен: %s | заменён: %s | %d вес | %s\n", v.Version, v.Filename, v.ModifiedAt, v.ReplacedAt, v.SizeBytes, v.Sha256)
+	}
+}
+
+// downloadVersion saves a kept version of filename to outpath, checking it
+// against the checksum the server sends with it.
+func downloadVersion(client proto.FileServiceClient, filename string, version int64, outpath string) {
+	stream, err := client.DownloadVersion(context.Background(), &proto.DownloadVersionRequest{Filename: filename, Version: version})
+	if err != nil {
+		log.Fatalf("download error: %v", err)
+	}
+	part := outpath + ".part"
+	out, err := os.Create(part)
+	if err != nil {
+		log.Fatalf("create error: %v", err)
+	}
+	defer os.Remove(part)
+	hash := sha256.New()
+	var want string
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			out.Close()
+			log.Fatalf("download error: %v", err)
+		}
+		if want == "" {
+			want = chunk.Sha256
+		}
+		hash.Write(chunk.Data)
+		if _, err := out.Write(chunk.Data); err != nil {
+			out.Close()
+			log.Fatalf("write error: %v", err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("write error: %v", err)
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); want != "" && got != want {
+		log.Fatalf("download error: version %d of %s: checksum %s, expected %s", version, filename, got, want)
+	}
+	if err := os.Rename(part, outpath); err != nil {
+		log.Fatalf("rename error: %v", err)
+	}
+	fmt.Printf("Downloaded %s version %d -> %s\n", filename, version, outpath)
+}
+
+func restoreVersion(client proto.FileServiceClient, format, filename string, version int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	v, err := client.RestoreVersion(ctx, &proto.RestoreVersionRequest{Filename: filename, Version: version})
+	if err != nil {
+		log.Fatalf("restore error: %v", err)
+	}
+	if format == "json" {
+		printJSON(v)
+		return
+	}
+	fmt.Printf("восстановлен: %s, версия %d\n", v.Filename, v.Version)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	fileclient "github.com/daniil1412412/grpc-file-service/pkg/client"
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+var eventNames = map[proto.FileEvent_Kind]string{
+	proto.FileEvent_CREATED:     "создан",
+	proto.FileEvent_OVERWRITTEN: "перезаписан",
+	proto.FileEvent_DELETED:     "удалён",
+}
+
+// watch prints the events of the files opts selects until interrupted, one
+// line or, with the json format, one JSON object per event.
+func watch(client proto.FileServiceClient, format string, opts fileclient.WatchOptions) {
+	enc := json.NewEncoder(os.Stdout)
+	err := newFileClient(client, nil, 0).Watch(context.Background(), opts, func(ev *proto.FileEvent) error {
+		if format == "json" {
+			// the kind by name; CREATED, the zero value, would be left out
+			return enc.Encode(struct {
+				Kind string `json:"kind"`
+				*proto.FileEvent
+			}{ev.Kind.String(), ev})
+		}
+		at := time.Unix(0, ev.TimeUnixNano).Format(time.RFC3339)
+		if ev.Kind == proto.FileEvent_DELETED {
+			_, err := fmt.Printf("%s | %s | %s\n", at, eventNames[ev.Kind], ev.Filename)
+			return err
+		}
+		_, err := fmt.Printf("%s | %s | %s | %d вес\n", at, eventNames[ev.Kind], ev.Filename, ev.SizeBytes)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("watch error: %v", err)
+	}
+}
@@ -0,0 +1,397 @@
+// Package client uploads, downloads and lists files on a file service
+// server, with the checks the command-line client does: uploads announce
+// their SHA-256 and, with a key, are signed; downloads are verified piece
+// by piece and against the recorded checksum.
+//
+//	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+//	...
+//	c := client.New(proto.NewFileServiceClient(conn))
+//	_, err = c.Upload(ctx, f, "report.pdf")
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/pkg/uploadsig"
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// chunkSize is the file data sent per Upload message.
+const chunkSize = 64 << 10
+
+// listPageSize is how many files one ListFiles call asks for.
+const listPageSize = 1000
+
+// ErrCorrupt is wrapped by the errors of downloads that failed
+// verification: the data is corrupt or the file changed during download.
+var ErrCorrupt = errors.New("corrupt, or the file changed during download")
+
+// errStale means the bytes a download was to be resumed from are not of
+// the file's current version, or cannot be shown to be.
+var errStale = errors.New("cannot resume: the file changed or has no recorded checksum")
+
+// Client is safe for concurrent use once its fields are set.
+type Client struct {
+	// Key, if set, signs uploads for servers run with -upload-keys.
+	Key ed25519.PrivateKey
+	// Hedge, if positive, opens a second Download when the first produced
+	// no data within it, and keeps the faster one.
+	Hedge time.Duration
+	// Retries is how many times a transfer or listing broken by a transient
+	// error is carried on, after a second and then twice as long each time.
+	Retries int
+	// Progress, if set, is called as a transfer goes on with the bytes of
+	// the file done so far, counting those a resumed transfer started from.
+	// Parallel ranges call it from several goroutines.
+	Progress func(done int64)
+	// Logf, if set, reports what the client decides on its own, such as
+	// downloading a file unverified or again from the start.
+	Logf func(format string, v ...any)
+
+	rpc proto.FileServiceClient
+}
+
+// New returns a Client making its calls through rpc.
+func New(rpc proto.FileServiceClient) *Client {
+	return &Client{rpc: rpc}
+}
+
+func (c *Client) progress(done int64) {
+	if c.Progress != nil {
+		c.Progress(done)
+	}
+}
+
+func (c *Client) logf(format string, v ...any) {
+	if c.Logf != nil {
+		c.Logf(format, v...)
+	}
+}
+
+// Sign returns the detached signature -upload-keys servers check for an
+// upload of name whose content has the SHA-256 sum, given in hex.
+func Sign(name, sum string, key ed25519.PrivateKey) []byte {
+	raw, _ := hex.DecodeString(sum)
+	return uploadsig.Sign(key, name, raw)
+}
+
+// Upload stores what r holds as name, replacing an earlier version once
+// complete. A reader that can seek is hashed first and the server checks
+// the content against that checksum; the upload covers r from its current
+// offset on. With Retries it goes through BeginUpload, so a broken upload
+// resumes from the bytes the server persisted.
+func (c *Client) Upload(ctx context.Context, r io.Reader, name string) (*proto.UploadResponse, error) {
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		return c.send(ctx, &proto.UploadRequest{Filename: name}, r)
+	}
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	sum, err := hashFrom(rs, start)
+	if err != nil {
+		return nil, err
+	}
+	if c.Retries > 0 {
+		resp, err := c.uploadSession(ctx, rs, start, name, sum)
+		if status.Code(err) != codes.Unimplemented {
+			return resp, err
+		}
+	}
+	// without resumable uploads a retry starts over
+	first := &proto.UploadRequest{Filename: name, Sha256: sum}
+	if c.Key != nil {
+		first.Signature = Sign(name, sum, c.Key)
+	}
+	var resp *proto.UploadResponse
+	err = c.retry(ctx, "upload of "+name, func() error {
+		if _, err := rs.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		resp, err = c.send(ctx, first, rs)
+		return err
+	})
+	return resp, err
+}
+
+// hashFrom returns the SHA-256 of rs from offset start on, in hex, and
+// leaves rs at start.
+func hashFrom(rs io.ReadSeeker, start int64) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, rs); err != nil {
+		return "", err
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// send makes one Upload call: first, then r in chunks. Without a signature
+// in first but with a key, the content is signed once its checksum is
+// known.
+func (c *Client) send(ctx context.Context, first *proto.UploadRequest, r io.Reader) (*proto.UploadResponse, error) {
+	stream, err := c.rpc.Upload(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// a send fails with EOF when the server ended the call; its status
+	// says why
+	send := func(req *proto.UploadRequest) error {
+		err := stream.Send(req)
+		if err == io.EOF {
+			_, err = stream.CloseAndRecv()
+		}
+		return err
+	}
+	if err := send(first); err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	buf := make([]byte, chunkSize)
+	done := first.Offset
+	c.progress(done)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			if err := send(&proto.UploadRequest{Data: buf[:n]}); err != nil {
+				return nil, err
+			}
+			done += int64(n)
+			c.progress(done)
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return nil, rerr
+		}
+	}
+	if c.Key != nil && first.Signature == nil {
+		if err := send(&proto.UploadRequest{Signature: Sign(first.Filename, hex.EncodeToString(h.Sum(nil)), c.Key)}); err != nil {
+			return nil, err
+		}
+	}
+	return stream.CloseAndRecv()
+}
+
+// Download writes the content of name to w. It is checked against the
+// piece hashes as it arrives, and no data of a corrupt piece is written;
+// the checksum of the whole file is only known at the end, so on a
+// mismatch w has the data already. Both failures wrap ErrCorrupt. With
+// Retries a broken download goes on after the bytes w has, as long as the
+// server has the file's checksum recorded.
+func (c *Client) Download(ctx context.Context, name string, w io.Writer) error {
+	st, err := c.newDownload(ctx, name)
+	if err != nil {
+		return err
+	}
+	return c.retry(ctx, "download of "+name, func() error { return c.fetch(ctx, name, st, w) })
+}
+
+// DownloadFile downloads name into path.part and renames it to path once
+// verified. A .part left by an interrupted download is carried on from its
+// end if the server has the file's checksum recorded, and downloaded again
+// otherwise. A .part that fails verification is removed.
+func (c *Client) DownloadFile(ctx context.Context, name, path string) error {
+	part := path + ".part"
+	out, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	have, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	st, err := c.newDownload(ctx, name)
+	if err != nil {
+		return err
+	}
+	fetch := func() error { return c.fetch(ctx, name, st, out) }
+	if have > 0 {
+		c.logf("resuming download of %s at %d bytes", name, have)
+		// the bytes already there count towards the checks
+		if _, err = io.CopyN(st.check, io.NewSectionReader(out, 0, have), have); err != nil {
+			err = errStale
+		}
+		st.have = have
+		c.progress(have)
+	}
+	if err == nil {
+		err = c.retry(ctx, "download of "+name, fetch)
+	}
+	// the bytes had only fail the checks once a piece, or the file, is
+	// complete; whichever is to blame, starting over settles it
+	if err == errStale || have > 0 && errors.Is(err, ErrCorrupt) {
+		c.logf("cannot resume %s, downloading it again", part)
+		if err = out.Truncate(0); err == nil {
+			_, err = out.Seek(0, io.SeekStart)
+		}
+		if err == nil {
+			st, err = c.newDownload(ctx, name)
+		}
+		if err == nil {
+			err = c.retry(ctx, "download of "+name, fetch)
+		}
+	}
+	if errors.Is(err, ErrCorrupt) {
+		// corrupt data must not be resumed from
+		out.Close()
+		os.Remove(part)
+	}
+	if err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(part, path)
+}
+
+// downloadState is what a download has checked so far, so that it can go
+// on from there after a break.
+type downloadState struct {
+	hash   hash.Hash
+	verify *pieceVerifier // nil without piece hashes
+	check  io.Writer      // both of them
+	have   int64          // bytes checked and written
+	want   string         // the recorded checksum, once known
+}
+
+func (c *Client) newDownload(ctx context.Context, name string) (*downloadState, error) {
+	verify, err := c.pieces(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	st := &downloadState{hash: sha256.New(), verify: verify}
+	st.check = st.hash
+	if verify != nil {
+		st.check = io.MultiWriter(st.hash, verify)
+	}
+	return st, nil
+}
+
+// fetch downloads name from st.have on into w. Going on after bytes
+// already had takes a recorded checksum, the same as before if one was
+// seen, to show they are of the current version; errStale otherwise.
+func (c *Client) fetch(ctx context.Context, name string, st *downloadState, w io.Writer) error {
+	req := &proto.DownloadRequest{Filename: name, Offset: st.have}
+	var stream proto.FileService_DownloadClient
+	var first *proto.DownloadResponse
+	var err error
+	if c.Hedge > 0 {
+		stream, first, err = hedgedDownload(ctx, c.rpc, req, c.Hedge)
+	} else if stream, err = c.rpc.Download(ctx, req); err == nil {
+		first, err = stream.Recv()
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	if st.have > 0 && (status.Code(err) == codes.OutOfRange ||
+		err == nil && (first.GetSha256() == "" || st.want != "" && first.GetSha256() != st.want)) {
+		return errStale
+	}
+	if err != nil {
+		return err
+	}
+
+	// the server sends the recorded checksum with the first message
+	st.want = first.GetSha256()
+	write := func(b []byte) error {
+		if _, err := st.check.Write(b); err != nil {
+			return fmt.Errorf("verify %s: %w", name, err)
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		st.have += int64(len(b))
+		c.progress(st.have)
+		return nil
+	}
+	if err := write(first.GetData()); err != nil {
+		return err
+	}
+	for first != nil {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := write(chunk.Data); err != nil {
+			return err
+		}
+	}
+	if st.verify != nil {
+		if err := st.verify.finish(); err != nil {
+			return fmt.Errorf("verify %s: %w", name, err)
+		}
+	}
+	if got := hex.EncodeToString(st.hash.Sum(nil)); st.want != "" && got != st.want {
+		return fmt.Errorf("verify %s: checksum %s, expected %s: %w", name, got, st.want, ErrCorrupt)
+	}
+	return nil
+}
+
+// ListOptions selects and orders the files List returns.
+type ListOptions struct {
+	Prefix  string // only names starting with it
+	Pattern string // only names matching this glob
+	// Uploader and ContentType, a prefix such as "image/", need a server
+	// with a metadata index.
+	Uploader    string
+	ContentType string
+	OrderBy     proto.ListRequest_Order
+	Descending  bool
+	// Fields are the FileInfo fields to fill in, all by default. Names
+	// alone are much cheaper than sizes and times.
+	Fields []string
+}
+
+// List returns the files opts selects, fetched page by page.
+func (c *Client) List(ctx context.Context, opts ListOptions) ([]*proto.FileInfo, error) {
+	req := &proto.ListRequest{
+		PageSize:    listPageSize,
+		Prefix:      opts.Prefix,
+		Pattern:     opts.Pattern,
+		Uploader:    opts.Uploader,
+		ContentType: opts.ContentType,
+		OrderBy:     opts.OrderBy,
+		Descending:  opts.Descending,
+	}
+	if len(opts.Fields) > 0 {
+		req.ReadMask = &fieldmaskpb.FieldMask{Paths: opts.Fields}
+	}
+	var files []*proto.FileInfo
+	for {
+		var resp *proto.ListResponse
+		err := c.retry(ctx, "list", func() (err error) {
+			resp, err = c.rpc.ListFiles(ctx, req)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, resp.Files...)
+		if resp.NextPageToken == "" {
+			return files, nil
+		}
+		req.PageToken = resp.NextPageToken
+	}
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// hedgedDownload opens the download and, if it produces no data within
+// after, opens it once more; round-robin balancing sends the second call to
+// another replica. The stream that delivers its first chunk first wins and
+// the other one is cancelled. first is nil when the file is empty.
+func hedgedDownload(ctx context.Context, client proto.FileServiceClient, req *proto.DownloadRequest, after time.Duration) (stream proto.FileService_DownloadClient, first *proto.DownloadResponse, err error) {
+	type attempt struct {
+		id     int
+		stream proto.FileService_DownloadClient
+		first  *proto.DownloadResponse
+		err    error
+	}
+	results := make(chan attempt, 2)
+	var cancels []context.CancelFunc
+	start := func() {
+		actx, cancel := context.WithCancel(ctx)
+		id := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			a := attempt{id: id}
+			a.stream, a.err = client.Download(actx, req)
+			if a.err == nil {
+				a.first, a.err = a.stream.Recv()
+			}
+			results <- a
+		}()
+	}
+
+	start()
+	hedge := time.NewTimer(after)
+	defer hedge.Stop()
+	for pending := 1; pending > 0; {
+		select {
+		case <-hedge.C:
+			start()
+			pending++
+		case a := <-results:
+			pending--
+			if a.err == nil || a.err == io.EOF {
+				for i, cancel := range cancels {
+					if i != a.id {
+						cancel()
+					}
+				}
+				return a.stream, a.first, nil
+			}
+			cancels[a.id]()
+			err = a.err
+		}
+	}
+	return nil, nil, err
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+
+	"github.com/daniil1412412/grpc-file-service/pkg/merkle"
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// pieces returns a verifier for a download of name, or nil when the file
+// fits in one piece or the server has no piece hashes.
+func (c *Client) pieces(ctx context.Context, name string) (*pieceVerifier, error) {
+	ph, err := c.rpc.GetPieceHashes(ctx, &proto.PieceHashesRequest{Filename: name})
+	if err != nil {
+		if status.Code(err) != codes.Unimplemented {
+			c.logf("piece hashes of %s: %v, downloading unverified", name, err)
+		}
+		return nil, nil
+	}
+	if len(ph.Pieces) <= 1 || ph.PieceSize <= 0 {
+		return nil, nil
+	}
+	if !bytes.Equal(merkle.Root(ph.Pieces), ph.Root) {
+		return nil, fmt.Errorf("piece hashes of %s do not match their root", name)
+	}
+	return &pieceVerifier{pieces: ph.Pieces, size: ph.PieceSize, h: sha256.New()}, nil
+}
+
+// pieceVerifier checks downloaded data piece by piece as it is written, so
+// corruption is caught at the piece it happens in.
+type pieceVerifier struct {
+	pieces [][]byte
+	size   int64
+	h      hash.Hash
+	n      int64
+	idx    int
+}
+
+func (v *pieceVerifier) Write(b []byte) (int, error) {
+	total := len(b)
+	for len(b) > 0 {
+		k := min(int64(len(b)), v.size-v.n)
+		v.h.Write(b[:k])
+		v.n += k
+		b = b[k:]
+		if v.n == v.size {
+			if err := v.check(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return total, nil
+}
+
+func (v *pieceVerifier) check() error {
+	if v.idx >= len(v.pieces) {
+		return fmt.Errorf("more data than the %d pieces announced: %w", len(v.pieces), ErrCorrupt)
+	}
+	if !bytes.Equal(v.h.Sum(nil), v.pieces[v.idx]) {
+		return fmt.Errorf("piece %d: %w", v.idx, ErrCorrupt)
+	}
+	v.h.Reset()
+	v.n = 0
+	v.idx++
+	return nil
+}
+
+// finish checks the last, partial piece and that none are missing.
+func (v *pieceVerifier) finish() error {
+	if v.n > 0 {
+		if err := v.check(); err != nil {
+			return err
+		}
+	}
+	if v.idx != len(v.pieces) {
+		return fmt.Errorf("got %d of %d pieces: %w", v.idx, len(v.pieces), ErrCorrupt)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// minRangeBytes is the smallest range worth a stream of its own.
+const minRangeBytes = 1 << 20
+
+// DownloadFileRanges is DownloadFile over up to streams parallel Download
+// calls, each for its own byte range, and returns how many it used. Files
+// too small for two ranges are left to DownloadFile. The assembled file is
+// checked against the recorded checksum and piece hashes before it is
+// renamed into place.
+func (c *Client) DownloadFileRanges(ctx context.Context, name, path string, streams int) (int, error) {
+	head, err := c.rpc.Head(ctx, &proto.HeadRequest{Filename: name, ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"size_bytes"}}})
+	if err != nil {
+		return 0, err
+	}
+	size := head.SizeBytes
+	streams = int(min(int64(streams), size/minRangeBytes))
+	if streams < 2 {
+		return 1, c.DownloadFile(ctx, name, path)
+	}
+
+	part := path + ".part"
+	out, err := os.Create(part)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+	if err := out.Truncate(size); err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var once sync.Once
+	var done atomic.Int64 // over all ranges
+	var firstErr error
+	sums := make([]string, streams)
+	step := size / int64(streams)
+	for i := 0; i < streams; i++ {
+		offset, length := int64(i)*step, step
+		if i == streams-1 {
+			length = size - offset
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sum, err := c.fetchRange(ctx, name, out, offset, length, &done)
+			if err != nil {
+				once.Do(func() { firstErr = err })
+				cancel()
+			}
+			sums[i] = sum
+		}(i)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return 0, firstErr
+	}
+
+	if err := c.verifyRanges(ctx, name, io.NewSectionReader(out, 0, size), sums); err != nil {
+		if errors.Is(err, ErrCorrupt) {
+			out.Close()
+			os.Remove(part)
+		}
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, err
+	}
+	return streams, os.Rename(part, path)
+}
+
+// verifyRanges checks the assembled file r against the piece hashes and
+// the checksums that came with its ranges; every range carries the
+// checksum of the whole file.
+func (c *Client) verifyRanges(ctx context.Context, name string, r io.Reader, sums []string) error {
+	verify, err := c.pieces(ctx, name)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	check := []io.Writer{hash}
+	if verify != nil {
+		check = append(check, verify)
+	}
+	if _, err := io.Copy(io.MultiWriter(check...), r); err != nil {
+		return fmt.Errorf("verify %s: %w", name, err)
+	}
+	if verify != nil {
+		if err := verify.finish(); err != nil {
+			return fmt.Errorf("verify %s: %w", name, err)
+		}
+	}
+	got := hex.EncodeToString(hash.Sum(nil))
+	for _, want := range sums {
+		if want != "" && got != want {
+			return fmt.Errorf("verify %s: checksum %s, expected %s: %w", name, got, want, ErrCorrupt)
+		}
+	}
+	return nil
+}
+
+// fetchRange writes length bytes of name from offset on into out at the
+// same offset and returns the checksum the server sent. A retry asks for
+// the rest of the range only.
+func (c *Client) fetchRange(ctx context.Context, name string, out *os.File, offset, length int64, done *atomic.Int64) (string, error) {
+	var sum string
+	pos, end := offset, offset+length
+	err := c.retry(ctx, fmt.Sprintf("range at %d of %s", offset, name), func() error {
+		stream, err := c.rpc.Download(ctx, &proto.DownloadRequest{Filename: name, Offset: pos, Length: end - pos})
+		if err != nil {
+			return err
+		}
+		for pos < end {
+			chunk, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if sum == "" {
+				sum = chunk.Sha256
+			}
+			if _, err := out.WriteAt(chunk.Data, pos); err != nil {
+				return err
+			}
+			pos += int64(len(chunk.Data))
+			c.progress(done.Add(int64(len(chunk.Data))))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if pos != end {
+		return "", fmt.Errorf("range at %d: got %d of %d bytes", offset, pos-offset, length)
+	}
+	return sum, nil
+}
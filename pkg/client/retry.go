@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxBackoff caps the wait between retries.
+const maxBackoff = 30 * time.Second
+
+// retryable reports whether err may go away on its own: the connection
+// broke, the call ran out of time or another call was writing the file.
+func retryable(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	switch st.Code() {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// retry runs attempt until it succeeds or fails for good, at most
+// c.Retries more times, waiting a second and then twice as long each time
+// in between. Attempts carry on from where the last one stopped.
+func (c *Client) retry(ctx context.Context, what string, attempt func() error) error {
+	for n := 0; ; n++ {
+		err := attempt()
+		if err == nil || n >= c.Retries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		wait := min(time.Second<<n, maxBackoff)
+		c.logf("%s interrupted: %v; retrying in %v", what, err, wait)
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+	}
+}
+
+// uploadSession uploads rs from offset start on as a resumable upload.
+func (c *Client) uploadSession(ctx context.Context, rs io.ReadSeeker, start int64, name, sum string) (*proto.UploadResponse, error) {
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	var id string
+	err = c.retry(ctx, "upload of "+name, func() error {
+		st, err := c.rpc.BeginUpload(ctx, &proto.BeginUploadRequest{Filename: name, SizeBytes: end - start})
+		id = st.GetUploadId()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.resumeUpload(ctx, rs, start, id, name, sum)
+}
+
+// ResumeUpload sends r, from its start, into the resumable upload id that
+// BeginUpload returned for name. Only the bytes the server has not
+// persisted yet are sent, so it carries on an upload an earlier process
+// began; with Retries, it does so again after a transient error.
+func (c *Client) ResumeUpload(ctx context.Context, r io.ReadSeeker, id, name string) (*proto.UploadResponse, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	sum, err := hashFrom(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	return c.resumeUpload(ctx, r, 0, id, name, sum)
+}
+
+func (c *Client) resumeUpload(ctx context.Context, rs io.ReadSeeker, start int64, id, name, sum string) (*proto.UploadResponse, error) {
+	first := &proto.UploadRequest{Filename: name, UploadId: id, Sha256: sum}
+	if c.Key != nil {
+		first.Signature = Sign(name, sum, c.Key)
+	}
+	var resp *proto.UploadResponse
+	err := c.retry(ctx, "upload of "+name, func() error {
+		st, err := c.rpc.GetUploadStatus(ctx, &proto.UploadStatusRequest{UploadId: id, Filename: name})
+		if err != nil {
+			return err
+		}
+		if first.Offset = st.PersistedBytes; first.Offset > 0 {
+			c.logf("upload of %s goes on at %d of %d bytes", name, first.Offset, st.SizeBytes)
+		}
+		if _, err := rs.Seek(start+first.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		resp, err = c.send(ctx, first, rs)
+		return err
+	})
+	return resp, err
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// WatchOptions selects the files Watch reports and where it starts.
+type WatchOptions struct {
+	Prefix  string // only names starting with it
+	Pattern string // only names matching this glob
+	// Epoch and AfterSeq, from an event handled before, resume a watch;
+	// without them it starts with the changes after the call.
+	Epoch    string
+	AfterSeq uint64
+}
+
+// Watch calls fn with every create, overwrite and delete event of the files
+// opts selects until ctx ends or fn fails. A broken stream is reopened
+// after the last event, at most c.Retries times. A server whose change
+// log no longer reaches back that far fails the watch with OUT_OF_RANGE;
+// the caller has to list the files to catch up then.
+func (c *Client) Watch(ctx context.Context, opts WatchOptions, fn func(*proto.FileEvent) error) error {
+	req := &proto.WatchRequest{Prefix: opts.Prefix, Pattern: opts.Pattern, Epoch: opts.Epoch, AfterSeq: opts.AfterSeq}
+	var fnErr error
+	err := c.retry(ctx, "watch", func() error {
+		stream, err := c.rpc.WatchFiles(ctx, req)
+		if err != nil {
+			return err
+		}
+		for {
+			ev, err := stream.Recv()
+			if err != nil {
+				return err
+			}
+			if ev.Filename != "" {
+				if fnErr = fn(ev); fnErr != nil {
+					return nil
+				}
+			}
+			req.Epoch, req.AfterSeq = ev.Epoch, ev.Seq
+		}
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+// Package compress registers the compressors clients and servers of the
+// file service negotiate per call: gzip, which grpc ships, and zstd.
+// Import it for its side effect on both ends; a server answers a call in
+// the compression the client sent it with.
+package compress
+
+import (
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip" // registers "gzip"
+)
+
+// Names lists the registered compressors.
+var Names = []string{"gzip", "zstd"}
+
+func init() { encoding.RegisterCompressor(zstdCompressor{}) }
+
+// Encoders and decoders hold sizeable buffers, so they are reused across
+// messages rather than made per call.
+var (
+	encoders = sync.Pool{New: func() any {
+		e, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+		return e
+	}}
+	decoders = sync.Pool{New: func() any {
+		d, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		return d
+	}}
+)
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) Name() string { return "zstd" }
+
+func (zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	e := encoders.Get().(*zstd.Encoder)
+	e.Reset(w)
+	return &zstdWriter{e}, nil
+}
+
+func (zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	d := decoders.Get().(*zstd.Decoder)
+	if err := d.Reset(r); err != nil {
+		decoders.Put(d)
+		return nil, err
+	}
+	return &zstdReader{d}, nil
+}
+
+type zstdWriter struct{ e *zstd.Encoder }
+
+func (w *zstdWriter) Write(p []byte) (int, error) { return w.e.Write(p) }
+
+func (w *zstdWriter) Close() error {
+	err := w.e.Close()
+	encoders.Put(w.e)
+	return err
+}
+
+// zstdReader returns its decoder to the pool once the message is read.
+type zstdReader struct{ d *zstd.Decoder }
+
+func (r *zstdReader) Read(p []byte) (int, error) {
+	if r.d == nil {
+		return 0, io.EOF
+	}
+	n, err := r.d.Read(p)
+	if err == io.EOF {
+		decoders.Put(r.d)
+		r.d = nil
+	}
+	return n, err
+}
@@ -0,0 +1,256 @@
+// Package limiter bounds how many calls a gRPC server runs at once. Calls
+// take slots from named pools: each method is mapped to a pool and a
+// weight, the number of slots one call of it holds, so a heavy method can
+// count for several light ones. A call waits in line for its slots, up to
+// the pool's queue timeout, and gives them back when it returns.
+package limiter
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Pool configures a pool of slots.
+type Pool struct {
+	Capacity int64
+	// QueueTimeout is how long a call waits for slots before it fails with
+	// ResourceExhausted; 0 waits as long as the call's context allows.
+	QueueTimeout time.Duration
+}
+
+// Rule maps a method to the pool its calls take Weight slots of. An empty
+// Pool leaves the method unlimited.
+type Rule struct {
+	Pool   string
+	Weight int64
+}
+
+// Config describes a Limiter.
+type Config struct {
+	Pools map[string]Pool
+	// Methods holds the rules by full method name, as in
+	// grpc.UnaryServerInfo.
+	Methods map[string]Rule
+	// Default is the rule of unary methods not in Methods. Streaming
+	// methods not in Methods are unlimited, since a stream such as a watch
+	// may run as long as the client likes.
+	Default Rule
+	// Waited, if set, is called with how long each limited call waited for
+	// its slots, e.g. to record it on the call's trace span.
+	Waited func(ctx context.Context, pool string, d time.Duration)
+}
+
+// Stats counts what a pool has done since the Limiter was made.
+type Stats struct {
+	Capacity int64 `json:"capacity"`
+	InUse    int64 `json:"in_use"`
+	Waiting  int64 `json:"waiting"`  // calls in line now
+	Acquired int64 `json:"acquired"` // calls that got their slots
+	Timeouts int64 `json:"timeouts"` // calls that gave up after QueueTimeout
+}
+
+// Limiter applies a Config. Make it with New.
+type Limiter struct {
+	pools   map[string]*pool
+	methods map[string]Rule
+	def     Rule
+	waited  func(context.Context, string, time.Duration)
+}
+
+// New checks cfg and makes its pools: every rule must name a configured
+// pool, with a weight between 1 and the pool's capacity. A zero weight is
+// taken as 1.
+func New(cfg Config) (*Limiter, error) {
+	l := &Limiter{pools: make(map[string]*pool), methods: make(map[string]Rule), waited: cfg.Waited}
+	for name, p := range cfg.Pools {
+		if p.Capacity < 1 || p.QueueTimeout < 0 {
+			return nil, fmt.Errorf("pool %s: want a capacity of at least 1 and no negative queue timeout", name)
+		}
+		l.pools[name] = &pool{name: name, capacity: p.Capacity, queueTimeout: p.QueueTimeout}
+	}
+	check := func(method string, r Rule) (Rule, error) {
+		if r.Pool == "" {
+			return r, nil
+		}
+		p, ok := l.pools[r.Pool]
+		if !ok {
+			return r, fmt.Errorf("%s: no pool %s", method, r.Pool)
+		}
+		if r.Weight == 0 {
+			r.Weight = 1
+		}
+		if r.Weight < 0 || r.Weight > p.capacity {
+			return r, fmt.Errorf("%s: weight %d is outside 1..%d, the capacity of %s", method, r.Weight, p.capacity, r.Pool)
+		}
+		return r, nil
+	}
+	var err error
+	for method, r := range cfg.Methods {
+		if l.methods[method], err = check(method, r); err != nil {
+			return nil, err
+		}
+	}
+	if l.def, err = check("default", cfg.Default); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Limiter) rule(fullMethod string, stream bool) Rule {
+	if r, ok := l.methods[fullMethod]; ok {
+		return r
+	}
+	if stream {
+		return Rule{}
+	}
+	return l.def
+}
+
+// Acquire waits for the slots of a call of fullMethod. The returned release
+// gives them back; calling it more than once does no harm.
+func (l *Limiter) Acquire(ctx context.Context, fullMethod string, stream bool) (release func(), err error) {
+	r := l.rule(fullMethod, stream)
+	if r.Pool == "" {
+		return func() {}, nil
+	}
+	p := l.pools[r.Pool]
+	start := time.Now()
+	err = p.acquire(ctx, r.Weight)
+	if l.waited != nil {
+		l.waited(ctx, p.name, time.Since(start))
+	}
+	if err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	return func() { once.Do(func() { p.release(r.Weight) }) }, nil
+}
+
+// Unary limits unary calls.
+func (l *Limiter) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		release, err := l.Acquire(ctx, info.FullMethod, false)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// Stream limits streaming calls for as long as the stream runs.
+func (l *Limiter) Stream() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		release, err := l.Acquire(ss.Context(), info.FullMethod, true)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
+
+// Stats returns the counts of every pool by name.
+func (l *Limiter) Stats() map[string]Stats {
+	out := make(map[string]Stats, len(l.pools))
+	for name, p := range l.pools {
+		out[name] = p.stats()
+	}
+	return out
+}
+
+// pool is a weighted semaphore that serves waiters in order, so a heavy
+// call is not starved by a stream of light ones.
+type pool struct {
+	name         string
+	capacity     int64
+	queueTimeout time.Duration
+
+	mu       sync.Mutex
+	used     int64
+	waiters  list.List // of *waiter
+	acquired int64
+	timeouts int64
+}
+
+type waiter struct {
+	n     int64
+	ready chan struct{} // closed once the slots are taken for it
+}
+
+func (p *pool) acquire(ctx context.Context, n int64) error {
+	p.mu.Lock()
+	if p.waiters.Len() == 0 && p.used+n <= p.capacity {
+		p.used += n
+		p.acquired++
+		p.mu.Unlock()
+		return nil
+	}
+	w := &waiter{n: n, ready: make(chan struct{})}
+	elem := p.waiters.PushBack(w)
+	p.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if p.queueTimeout > 0 {
+		t := time.NewTimer(p.queueTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	var err error
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		err = status.FromContextError(ctx.Err()).Err()
+	case <-timeout:
+		err = status.Errorf(codes.ResourceExhausted, "no free %s slot within %v", p.name, p.queueTimeout)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-w.ready:
+		// granted while giving up: hand the slots on
+		p.used -= n
+	default:
+		p.waiters.Remove(elem)
+	}
+	if status.Code(err) == codes.ResourceExhausted {
+		p.timeouts++
+	}
+	p.grantLocked()
+	return err
+}
+
+func (p *pool) release(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.used -= n
+	p.grantLocked()
+}
+
+// grantLocked hands free slots to the waiters at the front of the line.
+func (p *pool) grantLocked() {
+	for e := p.waiters.Front(); e != nil; e = p.waiters.Front() {
+		w := e.Value.(*waiter)
+		if p.used+w.n > p.capacity {
+			return
+		}
+		p.used += w.n
+		p.acquired++
+		p.waiters.Remove(e)
+		close(w.ready)
+	}
+}
+
+func (p *pool) stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return Stats{Capacity: p.capacity, InUse: p.used, Waiting: int64(p.waiters.Len()), Acquired: p.acquired, Timeouts: p.timeouts}
+}
@@ -0,0 +1,124 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewChecks(t *testing.T) {
+	pools := map[string]Pool{"transfers": {Capacity: 4}}
+	tests := []struct {
+		name string
+		cfg  Config
+		ok   bool
+	}{
+		{"valid", Config{Pools: pools, Methods: map[string]Rule{"/s/Upload": {Pool: "transfers", Weight: 4}}}, true},
+		{"zero weight is one", Config{Pools: pools, Methods: map[string]Rule{"/s/Upload": {Pool: "transfers"}}}, true},
+		{"unlimited", Config{Pools: pools, Methods: map[string]Rule{"/s/Upload": {}}}, true},
+		{"no pool", Config{Pools: pools, Methods: map[string]Rule{"/s/Upload": {Pool: "lists"}}}, false},
+		{"default without pool", Config{Pools: pools, Default: Rule{Pool: "lists"}}, false},
+		{"weight over capacity", Config{Pools: pools, Methods: map[string]Rule{"/s/Upload": {Pool: "transfers", Weight: 5}}}, false},
+		{"negative weight", Config{Pools: pools, Methods: map[string]Rule{"/s/Upload": {Pool: "transfers", Weight: -1}}}, false},
+		{"empty pool", Config{Pools: map[string]Pool{"transfers": {}}}, false},
+		{"negative queue timeout", Config{Pools: map[string]Pool{"transfers": {Capacity: 1, QueueTimeout: -time.Second}}}, false},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.cfg); (err == nil) != tt.ok {
+			t.Errorf("%s: New = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestRuleMatching(t *testing.T) {
+	l, err := New(Config{
+		Pools:   map[string]Pool{"transfers": {Capacity: 4}, "lists": {Capacity: 2}},
+		Methods: map[string]Rule{"/s/Upload": {Pool: "transfers", Weight: 2}, "/s/List": {Pool: "lists"}, "/s/Ping": {}},
+		Default: Rule{Pool: "transfers"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method string
+		stream bool
+		want   Rule
+	}{
+		{"/s/Upload", true, Rule{Pool: "transfers", Weight: 2}},
+		{"/s/List", false, Rule{Pool: "lists", Weight: 1}},
+		{"/s/Ping", false, Rule{}},
+		{"/s/Stat", false, Rule{Pool: "transfers", Weight: 1}},
+		{"/s/Watch", true, Rule{}},
+	}
+	for _, tt := range tests {
+		if got := l.rule(tt.method, tt.stream); got != tt.want {
+			t.Errorf("rule(%s, %v) = %+v, want %+v", tt.method, tt.stream, got, tt.want)
+		}
+	}
+}
+
+func TestAcquireWaitsInLine(t *testing.T) {
+	l, err := New(Config{
+		Pools:   map[string]Pool{"transfers": {Capacity: 2}},
+		Methods: map[string]Rule{"/s/Heavy": {Pool: "transfers", Weight: 2}, "/s/Light": {Pool: "transfers"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	first, err := l.Acquire(ctx, "/s/Light", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	heavy := make(chan func())
+	go func() {
+		release, err := l.Acquire(ctx, "/s/Heavy", false)
+		if err != nil {
+			t.Error(err)
+		}
+		heavy <- release
+	}()
+	for l.Stats()["transfers"].Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// a free slot is there, but the heavy call is first in line
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(short, "/s/Light", false); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("light call jumped the line: %v", err)
+	}
+	first()
+	first() // a second release does nothing
+	release := <-heavy
+	if s := l.Stats()["transfers"]; s.InUse != 2 || s.Waiting != 0 || s.Acquired != 2 {
+		t.Errorf("stats = %+v, want 2 in use, none waiting, 2 acquired", s)
+	}
+	release()
+	if s := l.Stats()["transfers"]; s.InUse != 0 {
+		t.Errorf("%d slots still in use", s.InUse)
+	}
+}
+
+func TestQueueTimeout(t *testing.T) {
+	l, err := New(Config{
+		Pools:   map[string]Pool{"lists": {Capacity: 1, QueueTimeout: 10 * time.Millisecond}},
+		Methods: map[string]Rule{"/s/List": {Pool: "lists"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, err := l.Acquire(context.Background(), "/s/List", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if _, err := l.Acquire(context.Background(), "/s/List", false); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got %v, want ResourceExhausted", err)
+	}
+	if s := l.Stats()["lists"]; s.Timeouts != 1 || s.Waiting != 0 {
+		t.Errorf("stats = %+v, want 1 timeout and none waiting", s)
+	}
+}
@@ -0,0 +1,30 @@
+// Package merkle computes the Merkle root over piece hashes that servers
+// publish and clients check in proto.PieceHashes.
+package merkle
+
+import "crypto/sha256"
+
+// Root folds piece hashes into their root: a parent is the SHA-256 of its
+// two children concatenated and an odd last node moves up unchanged. No
+// pieces give the SHA-256 of nothing.
+func Root(level [][]byte) []byte {
+	if len(level) == 0 {
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	}
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			h := sha256.New()
+			h.Write(level[i])
+			h.Write(level[i+1])
+			next = append(next, h.Sum(nil))
+		}
+		level = next
+	}
+	return level[0]
+}
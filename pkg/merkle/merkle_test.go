@@ -0,0 +1,47 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func leaf(s string) []byte {
+	sum := sha256.Sum256([]byte(s))
+	return sum[:]
+}
+
+func node(l, r []byte) []byte {
+	sum := sha256.Sum256(append(append([]byte(nil), l...), r...))
+	return sum[:]
+}
+
+func TestRoot(t *testing.T) {
+	a, b, c, d, e := leaf("a"), leaf("b"), leaf("c"), leaf("d"), leaf("e")
+	empty := sha256.Sum256(nil)
+	tests := []struct {
+		name   string
+		pieces [][]byte
+		want   []byte
+	}{
+		{"none", nil, empty[:]},
+		{"one", [][]byte{a}, a},
+		{"two", [][]byte{a, b}, node(a, b)},
+		{"odd last moves up", [][]byte{a, b, c}, node(node(a, b), c)},
+		{"four", [][]byte{a, b, c, d}, node(node(a, b), node(c, d))},
+		{"five", [][]byte{a, b, c, d, e}, node(node(node(a, b), node(c, d)), e)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Root(tt.pieces); !bytes.Equal(got, tt.want) {
+				t.Errorf("Root = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRootOrder(t *testing.T) {
+	if bytes.Equal(Root([][]byte{leaf("a"), leaf("b")}), Root([][]byte{leaf("b"), leaf("a")})) {
+		t.Error("swapped pieces give the same root")
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// accessLog logs every failed, slow or large request and only one in
+// sample of the rest, so the log stays readable at thousands of requests
+// per second. Records carry the request ID the client got back.
+type accessLog struct {
+	slow   time.Duration // 0 disables the duration threshold
+	large  int64         // bytes in plus out; 0 disables the size threshold
+	sample int64         // log every sample-th routine request, 0 none
+
+	seen atomic.Int64
+}
+
+// rpcRecord collects what is known about one call.
+type rpcRecord struct {
+	method   string
+	start    time.Time
+	filename string
+	in, out  int64
+}
+
+func (r *rpcRecord) observe(m any, in bool) {
+	if msg, ok := m.(protobuf.Message); ok {
+		if in {
+			r.in += int64(protobuf.Size(msg))
+		} else {
+			r.out += int64(protobuf.Size(msg))
+		}
+	}
+	if r.filename == "" && in {
+		if named, ok := m.(interface{ GetFilename() string }); ok {
+			r.filename = named.GetFilename()
+		}
+	}
+}
+
+func (l *accessLog) done(ctx context.Context, r *rpcRecord, err error) {
+	dur := time.Since(r.start)
+	// the call's span, if it is traced, gets the same facts
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("file.name", r.filename),
+		attribute.Int64("rpc.bytes_in", r.in), attribute.Int64("rpc.bytes_out", r.out))
+	attrs := []any{"method", r.method, "file", r.filename, "duration", dur, "bytes_in", r.in, "bytes_out", r.out}
+	level := slog.LevelInfo
+	switch {
+	case err != nil:
+		attrs = append(attrs, "why", "error")
+		level = slog.LevelWarn
+		switch status.Code(err) {
+		case codes.Internal, codes.Unknown, codes.DataLoss:
+			level = slog.LevelError
+		}
+	case l.slow > 0 && dur >= l.slow:
+		attrs = append(attrs, "why", "slow")
+	case l.large > 0 && r.in+r.out >= l.large:
+		attrs = append(attrs, "why", "large")
+	case l.sample > 0 && l.seen.Add(1)%l.sample == 0:
+		attrs = append(attrs, "why", fmt.Sprintf("sampled 1/%d", l.sample))
+	default:
+		return
+	}
+	addr := "?"
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	var ua []string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ua = md.Get("user-agent")
+	}
+	attrs = append(attrs, "peer", addr, "user_agent", strings.Join(ua, " "), "code", status.Code(err).String())
+	if err != nil {
+		attrs = append(attrs, "error", status.Convert(err).Message())
+	}
+	slog.Log(ctx, level, "rpc", attrs...)
+}
+
+func (l *accessLog) unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		r := &rpcRecord{method: info.FullMethod, start: time.Now()}
+		r.observe(req, true)
+		resp, err := handler(ctx, req)
+		if err == nil {
+			r.observe(resp, false)
+		}
+		l.done(ctx, r, err)
+		return resp, err
+	}
+}
+
+func (l *accessLog) stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		r := &rpcRecord{method: info.FullMethod, start: time.Now()}
+		err := handler(srv, &loggedStream{ServerStream: ss, r: r})
+		l.done(ss.Context(), r, err)
+		return err
+	}
+}
+
+type loggedStream struct {
+	grpc.ServerStream
+	r *rpcRecord
+}
+
+func (s *loggedStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.r.observe(m, true)
+	}
+	return err
+}
+
+func (s *loggedStream) SendMsg(m any) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.r.observe(m, false)
+	}
+	return err
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const adminTokenKey = "x-admin-token"
+
+func incomingAdminToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get(adminTokenKey); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// requireAdmin lets a call through only with the configured admin token.
+// Without -admin-token admin RPCs are disabled.
+func (s *fileServer) requireAdmin(ctx context.Context) error {
+	token := s.secret().adminToken
+	if token == "" {
+		return status.Error(codes.PermissionDenied, "admin RPCs are disabled, start the server with -admin-token")
+	}
+	if subtle.ConstantTimeCompare([]byte(incomingAdminToken(ctx)), []byte(token)) != 1 {
+		return status.Error(codes.PermissionDenied, "bad admin token")
+	}
+	return nil
+}
+
+// adminOutgoing passes the caller's admin token on to a peer.
+func adminOutgoing(in, out context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(out, adminTokenKey, incomingAdminToken(in))
+}
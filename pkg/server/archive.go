@@ -0,0 +1,217 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DownloadArchive writes the archive straight into the stream: nothing is
+// staged on disk and only the file being added is open. A file is read
+// from the node that owns it.
+func (s *fileServer) DownloadArchive(req *proto.ArchiveRequest, stream proto.FileService_DownloadArchiveServer) error {
+	ctx := stream.Context()
+	names, listed, err := s.archiveNames(ctx, req)
+	if err != nil {
+		return err
+	}
+	out := &chunkSender{buf: make([]byte, 0, s.chunkSize), send: func(b []byte) error {
+		return stream.Send(&proto.DownloadResponse{Data: b})
+	}}
+	var aw archiveWriter
+	switch req.GetFormat() {
+	case proto.ArchiveRequest_TAR_GZ:
+		aw = newTarGz(out)
+	case proto.ArchiveRequest_ZIP:
+		aw = zipArchive{zip.NewWriter(out)}
+	default:
+		return status.Errorf(codes.InvalidArgument, "unknown archive format %v", req.GetFormat())
+	}
+	for _, name := range names {
+		f, size, mtime, err := s.archiveFile(ctx, name)
+		if status.Code(err) == codes.NotFound && listed {
+			continue // removed since the listing
+		}
+		if err != nil {
+			return err
+		}
+		err = addToArchive(aw, name, f, size, mtime)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := aw.Close(); err != nil {
+		return err
+	}
+	return out.flush()
+}
+
+// archiveNames returns the names, as the caller knows them, of the files
+// to archive, and whether they came from a listing rather than the
+// request.
+func (s *fileServer) archiveNames(ctx context.Context, req *proto.ArchiveRequest) ([]string, bool, error) {
+	if len(req.GetFilenames()) > 0 {
+		if req.GetPrefix() != "" || req.GetPattern() != "" {
+			return nil, false, status.Error(codes.InvalidArgument, "give filenames or a prefix and pattern, not both")
+		}
+		seen := make(map[string]bool)
+		var names []string
+		for _, name := range req.GetFilenames() {
+			if name == "" {
+				return nil, false, status.Error(codes.InvalidArgument, "empty filename")
+			}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return names, false, nil
+	}
+	if req.GetPrefix() == "" && req.GetPattern() == "" {
+		return nil, false, status.Error(codes.InvalidArgument, "filenames, or a prefix or pattern, are required")
+	}
+	// ListFiles covers the shard peers and the caller's namespace
+	list, err := s.ListFiles(ctx, &proto.ListRequest{Prefix: req.GetPrefix(), Pattern: req.GetPattern()})
+	if err != nil {
+		return nil, false, err
+	}
+	names := make([]string, 0, len(list.Files))
+	for _, f := range list.Files {
+		names = append(names, shownName(ctx, f.Filename))
+	}
+	sort.Strings(names)
+	return names, true, nil
+}
+
+// archiveFile opens the file the caller knows as shown, locally or on the
+// peer that owns it, with its size and modification time.
+func (s *fileServer) archiveFile(ctx context.Context, shown string) (io.ReadCloser, int64, time.Time, error) {
+	name := s.requestName(ctx, shown)
+	if addr, fctx := s.route(ctx, name); addr != "" {
+		c, err := s.peers.client(addr)
+		if err != nil {
+			return nil, 0, time.Time{}, err
+		}
+		fi, err := c.StatFile(fctx, &proto.StatRequest{Filename: shown})
+		if err != nil {
+			return nil, 0, time.Time{}, err
+		}
+		fctx, cancel := context.WithCancel(fctx)
+		f, err := downloadReader(fctx, c, shown)
+		if err != nil {
+			cancel()
+			return nil, 0, time.Time{}, err
+		}
+		mtime, _ := time.Parse(time.RFC3339, fi.GetModifiedAt())
+		return cancelOnClose{f, cancel}, fi.GetSizeBytes(), mtime, nil
+	}
+	f, info, err := s.openCached(name)
+	if err != nil {
+		return nil, 0, time.Time{}, err
+	}
+	return f, info.Size(), info.ModTime(), nil
+}
+
+func addToArchive(aw archiveWriter, name string, f io.Reader, size int64, mtime time.Time) error {
+	w, err := aw.add(name, size, mtime)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(w, io.LimitReader(f, size))
+	if err != nil {
+		return err
+	}
+	if n < size {
+		return status.Errorf(codes.Aborted, "%s changed while it was archived", name)
+	}
+	return nil
+}
+
+// archiveWriter adds files to an archive one after the other.
+type archiveWriter interface {
+	add(name string, size int64, mtime time.Time) (io.Writer, error)
+	Close() error
+}
+
+type tarGz struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func newTarGz(w io.Writer) *tarGz {
+	gz := gzip.NewWriter(w)
+	return &tarGz{gz: gz, tw: tar.NewWriter(gz)}
+}
+
+func (a *tarGz) add(name string, size int64, mtime time.Time) (io.Writer, error) {
+	err := a.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size, Mode: 0o644, ModTime: mtime, Format: tar.FormatPAX})
+	return a.tw, err
+}
+
+func (a *tarGz) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+type zipArchive struct{ zw *zip.Writer }
+
+func (a zipArchive) add(name string, _ int64, mtime time.Time) (io.Writer, error) {
+	return a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: mtime})
+}
+
+func (a zipArchive) Close() error { return a.zw.Close() }
+
+// chunkSender sends what is written to it in messages of up to cap(buf)
+// bytes.
+type chunkSender struct {
+	buf  []byte
+	send func([]byte) error
+}
+
+func (c *chunkSender) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(c.buf) == cap(c.buf) {
+			if err := c.flush(); err != nil {
+				return n - len(p), err
+			}
+		}
+		k := min(len(p), cap(c.buf)-len(c.buf))
+		c.buf = append(c.buf, p[:k]...)
+		p = p[k:]
+	}
+	return n, nil
+}
+
+func (c *chunkSender) flush() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	err := c.send(c.buf)
+	c.buf = c.buf[:0]
+	return err
+}
+
+// cancelOnClose ends the download it reads, read to the end or not, when
+// closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r cancelOnClose) Close() error {
+	r.cancel()
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,313 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// With -api-keys or -jwt-secret every call must authenticate with
+// "authorization: Bearer <API key or JWT>", and its files live in the
+// caller's namespace: stored as <user>/<name>, so on disk in
+// <storage>/<user>/. Callers see and send bare names. A call with the admin
+// token instead is not scoped and may name any file as <user>/<name>.
+const authKey = "authorization"
+
+var validUser = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+type userKey struct{}
+
+// callerUser returns the namespace of an authenticated call, "" for calls
+// that are not scoped.
+func callerUser(ctx context.Context) string {
+	u, _ := ctx.Value(userKey{}).(string)
+	return u
+}
+
+// parseAPIKeys parses "<user> <key>" lines. Keys are kept hashed, so
+// looking one up takes the same time whatever it matches.
+func parseAPIKeys(src string, b []byte) (map[[32]byte]string, error) {
+	keys := make(map[[32]byte]string)
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 || !validUser.MatchString(fields[0]) {
+			return nil, fmt.Errorf("%s:%d: want <user> <key>, user made of letters, digits, '.', '_' and '-'", src, line)
+		}
+		keys[sha256.Sum256([]byte(fields[1]))] = fields[0]
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", src)
+	}
+	return keys, nil
+}
+
+// authenticate returns ctx carrying the caller's namespace.
+func (s *fileServer) authenticate(ctx context.Context) (context.Context, error) {
+	if !s.auth {
+		return ctx, nil
+	}
+	sec := s.secret()
+	if t := incomingAdminToken(ctx); t != "" && sec.adminToken != "" &&
+		subtle.ConstantTimeCompare([]byte(t), []byte(sec.adminToken)) == 1 {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var token string
+	if v := md.Get(authKey); len(v) > 0 {
+		token, _ = strings.CutPrefix(v[0], "Bearer ")
+	}
+	if token == "" {
+		return nil, status.Error(codes.Unauthenticated, "authorization: Bearer <API key or JWT> required")
+	}
+	var user string
+	if strings.Count(token, ".") == 2 && sec.jwtSecret != nil {
+		var err error
+		if user, err = verifyJWT(token, sec.jwtSecret, time.Now()); err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "bad token: %v", err)
+		}
+	} else if user = sec.apiKeys[sha256.Sum256([]byte(token))]; user == "" {
+		return nil, status.Error(codes.Unauthenticated, "unknown API key")
+	}
+	if !validUser.MatchString(user) {
+		return nil, status.Errorf(codes.PermissionDenied, "user %q cannot have a namespace", user)
+	}
+	return context.WithValue(ctx, userKey{}, user), nil
+}
+
+// verifyJWT checks an HS256 token and returns its subject.
+func verifyJWT(token string, secret []byte, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return "", err
+	}
+	if header.Alg != "HS256" {
+		return "", fmt.Errorf("algorithm %q, want HS256", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("malformed signature")
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", errors.New("signature mismatch")
+	}
+	var claims struct {
+		Sub string  `json:"sub"`
+		Exp float64 `json:"exp"`
+		Nbf float64 `json:"nbf"`
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return "", err
+	}
+	switch {
+	case claims.Exp != 0 && now.After(time.Unix(int64(claims.Exp), 0)):
+		return "", errors.New("expired")
+	case claims.Nbf != 0 && now.Before(time.Unix(int64(claims.Nbf), 0)):
+		return "", errors.New("not valid yet")
+	case claims.Sub == "":
+		return "", errors.New("no subject")
+	}
+	return claims.Sub, nil
+}
+
+func decodeJWTPart(part string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err == nil {
+		err = json.Unmarshal(b, v)
+	}
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	return nil
+}
+
+// requestName turns a file name from a request into the stored name.
+func (s *fileServer) requestName(ctx context.Context, raw string) string {
+	name := sanitizeFilename(raw)
+	if name == "" || !s.auth {
+		return name
+	}
+	if user := callerUser(ctx); user != "" {
+		return user + "/" + name
+	}
+	if dir, _, ok := strings.Cut(raw, "/"); ok && validUser.MatchString(dir) {
+		return dir + "/" + name
+	}
+	return name
+}
+
+// visible reports whether the caller may see the stored name.
+func visible(ctx context.Context, name string) bool {
+	user := callerUser(ctx)
+	return user == "" || strings.HasPrefix(name, user+"/")
+}
+
+// shownName is the name the caller knows the stored name by.
+func shownName(ctx context.Context, name string) string {
+	if user := callerUser(ctx); user != "" {
+		return strings.TrimPrefix(name, user+"/")
+	}
+	return name
+}
+
+// validStoredName reports whether name is a file name, optionally inside
+// a namespace.
+func validStoredName(name string) bool {
+	if dir, file, ok := strings.Cut(name, "/"); ok {
+		return validUser.MatchString(dir) && sanitizeFilename(file) == file && !internalName(file)
+	}
+	return sanitizeFilename(name) == name && !internalName(name)
+}
+
+// hideNamespace strips prefix from the file names in a response, so
+// callers see the names they sent.
+func hideNamespace(m protoreflect.Message, prefix string) {
+	var names []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.Kind() == protoreflect.StringKind && !fd.IsList() && fd.Name() == "filename":
+			names = append(names, fd)
+		case fd.Kind() == protoreflect.MessageKind && fd.IsList():
+			for i := 0; i < v.List().Len(); i++ {
+				hideNamespace(v.List().Get(i).Message(), prefix)
+			}
+		case fd.Kind() == protoreflect.MessageKind && !fd.IsMap():
+			hideNamespace(v.Message(), prefix)
+		}
+		return true
+	})
+	for _, fd := range names {
+		m.Set(fd, protoreflect.ValueOfString(strings.TrimPrefix(m.Get(fd).String(), prefix)))
+	}
+}
+
+// namespacedName matches a namespace and its slash where a file name may
+// start in an error message.
+var namespacedName = regexp.MustCompile(`(^|[^A-Za-z0-9._-])([A-Za-z0-9][A-Za-z0-9._-]{0,63})/`)
+
+// hideNamespaceError strips the namespace of user from the file names in
+// the message of err, keeping its code and details.
+func hideNamespaceError(err error, user string) error {
+	if err == nil || user == "" {
+		return err
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		st = status.FromContextError(err)
+	}
+	msg := namespacedName.ReplaceAllStringFunc(st.Message(), func(m string) string {
+		sub := namespacedName.FindStringSubmatch(m)
+		if sub[2] != user {
+			return m
+		}
+		return sub[1]
+	})
+	if msg == st.Message() {
+		return err
+	}
+	p := st.Proto()
+	p.Message = msg
+	return status.FromProto(p).Err()
+}
+
+func unaryAuth(srv *fileServer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if publicMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		ctx, err := srv.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		if m, ok := resp.(protobuf.Message); ok && err == nil && callerUser(ctx) != "" {
+			hideNamespace(m.ProtoReflect(), callerUser(ctx)+"/")
+		}
+		return resp, hideNamespaceError(err, callerUser(ctx))
+	}
+}
+
+func streamAuth(srv *fileServer) grpc.StreamServerInterceptor {
+	return func(srvInterface interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if publicMethod(info.FullMethod) {
+			return handler(srvInterface, ss)
+		}
+		ctx, err := srv.authenticate(ss.Context())
+		if err != nil {
+			return err
+		}
+		if callerUser(ctx) == "" {
+			return handler(srvInterface, ss)
+		}
+		err = handler(srvInterface, &scopedStream{ServerStream: ss, ctx: ctx, prefix: callerUser(ctx) + "/"})
+		return hideNamespaceError(err, callerUser(ctx))
+	}
+}
+
+type scopedStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	prefix string
+}
+
+func (s *scopedStream) Context() context.Context { return s.ctx }
+
+func (s *scopedStream) SendMsg(m interface{}) error {
+	if pm, ok := m.(protobuf.Message); ok {
+		hideNamespace(pm.ProtoReflect(), s.prefix)
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+// passAuth hands the caller's credentials on to the peers a call is
+// forwarded to, which authenticate and scope it themselves, and the
+// request ID, so the peer logs the call under the same one.
+func passAuth(ctx context.Context) context.Context {
+	in, _ := metadata.FromIncomingContext(ctx)
+	out, _ := metadata.FromOutgoingContext(ctx)
+	for _, key := range []string{authKey, adminTokenKey} {
+		if v := in.Get(key); len(v) > 0 && len(out.Get(key)) == 0 {
+			ctx = metadata.AppendToOutgoingContext(ctx, key, v[0])
+		}
+	}
+	if id := requestID(ctx); id != "" && len(out.Get(requestIDKey)) == 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, requestIDKey, id)
+	}
+	return ctx
+}
+
+func unaryPassAuth(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(passAuth(ctx), method, req, reply, cc, opts...)
+}
+
+func streamPassAuth(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(passAuth(ctx), desc, cc, method, opts...)
+}
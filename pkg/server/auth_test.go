@@ -0,0 +1,61 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeJWT(secret []byte, header, claims string) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	signed := enc([]byte(header)) + "." + enc([]byte(claims))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + enc(mac.Sum(nil))
+}
+
+func TestVerifyJWT(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1_700_000_000, 0)
+	hs256 := `{"alg":"HS256","typ":"JWT"}`
+	valid := makeJWT(secret, hs256, `{"sub":"alice","exp":1700000060}`)
+	parts := strings.Split(valid, ".")
+	tests := []struct {
+		name    string
+		token   string
+		user    string
+		errText string
+	}{
+		{"valid", valid, "alice", ""},
+		{"no expiry", makeJWT(secret, hs256, `{"sub":"alice"}`), "alice", ""},
+		{"started", makeJWT(secret, hs256, `{"sub":"alice","nbf":1700000000}`), "alice", ""},
+		{"expired", makeJWT(secret, hs256, `{"sub":"alice","exp":1699999999}`), "", "expired"},
+		{"not yet valid", makeJWT(secret, hs256, `{"sub":"alice","nbf":1700000060}`), "", "not valid yet"},
+		{"no subject", makeJWT(secret, hs256, `{"exp":1700000060}`), "", "no subject"},
+		{"other secret", makeJWT([]byte("other"), hs256, `{"sub":"alice"}`), "", "signature mismatch"},
+		{"alg none", makeJWT(secret, `{"alg":"none"}`, `{"sub":"alice"}`), "", "want HS256"},
+		{"alg none unsigned", strings.Join([]string{base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)), parts[1], ""}, "."), "", "want HS256"},
+		{"claims swapped", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"mallory"}`)) + "." + parts[2], "", "signature mismatch"},
+		{"bad signature encoding", parts[0] + "." + parts[1] + ".!!", "", "malformed signature"},
+		{"bad header", "!!." + parts[1] + "." + parts[2], "", "malformed token"},
+		{"header not json", base64.RawURLEncoding.EncodeToString([]byte("HS256")) + "." + parts[1] + "." + parts[2], "", "malformed token"},
+		{"claims not json", makeJWT(secret, hs256, `sub=alice`), "", "malformed token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := verifyJWT(tt.token, secret, now)
+			if tt.errText == "" {
+				if err != nil || user != tt.user {
+					t.Fatalf("verifyJWT = %q, %v; want %q", user, err, tt.user)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errText) {
+				t.Fatalf("verifyJWT = %q, %v; want an error containing %q", user, err, tt.errText)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// awsKMS wraps data keys with a key in AWS KMS through its Encrypt and
+// Decrypt calls, so the master key never leaves KMS. Requests are signed
+// like the S3 backend's, with the same AWS_* credentials. Unwrapped keys
+// are cached as with a KMS plugin.
+type awsKMS struct {
+	keyID    string // key id, ARN or alias/NAME
+	region   string
+	endpoint string
+	creds    awsCredentials
+
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+// newAWSKMS parses <key>[?region=REGION&endpoint=URL]. The region defaults
+// to the one in a key ARN, then AWS_REGION, then us-east-1; the endpoint
+// to the region's KMS, another one serving the same API for testing.
+func newAWSKMS(spec string) (*awsKMS, error) {
+	key, rawQuery, _ := strings.Cut(spec, "?")
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil || key == "" {
+		return nil, fmt.Errorf("bad aws kms key %q, want awskms:<key>[?region=REGION&endpoint=URL]", spec)
+	}
+	k := &awsKMS{
+		keyID:    key,
+		region:   q.Get("region"),
+		endpoint: strings.TrimSuffix(q.Get("endpoint"), "/"),
+		creds:    awsCredentialsFromEnv(),
+		cache:    make(map[string][]byte),
+	}
+	if k.region == "" {
+		// arn:aws:kms:REGION:ACCOUNT:key/ID
+		if parts := strings.Split(key, ":"); len(parts) > 3 && parts[0] == "arn" {
+			k.region = parts[3]
+		}
+	}
+	if k.region == "" {
+		k.region = os.Getenv("AWS_REGION")
+	}
+	if k.region == "" {
+		k.region = "us-east-1"
+	}
+	if k.endpoint == "" {
+		k.endpoint = "https://kms." + k.region + ".amazonaws.com"
+	}
+	if k.creds.keyID == "" || k.creds.secret == "" {
+		return nil, errors.New("aws kms needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return k, nil
+}
+
+// call sends one KMS API call, e.g. "Encrypt", and decodes its result into
+// out.
+func (k *awsKMS) call(op string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kmsTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+op)
+	sum := sha256.Sum256(body)
+	k.creds.sign(req, k.region, "kms", "/", "", hex.EncodeToString(sum[:]))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("kms %s: %w", op, err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("kms %s: %w", op, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(b, &e)
+		return fmt.Errorf("kms %s: %s %s %s", op, resp.Status, e.Type, e.Message)
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("kms %s: %w", op, err)
+	}
+	return nil
+}
+
+func (k *awsKMS) wrap(dataKey []byte) ([]byte, error) {
+	var out struct {
+		CiphertextBlob []byte
+	}
+	err := k.call("Encrypt", struct {
+		KeyId     string
+		Plaintext []byte
+	}{k.keyID, dataKey}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return out.CiphertextBlob, nil
+}
+
+func (k *awsKMS) unwrap(wrapped []byte) ([]byte, error) {
+	k.mu.Lock()
+	key, ok := k.cache[string(wrapped)]
+	k.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	var out struct {
+		Plaintext []byte
+	}
+	err := k.call("Decrypt", struct {
+		KeyId          string
+		CiphertextBlob []byte
+	}{k.keyID, wrapped}, &out)
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Plaintext) != 32 {
+		return nil, fmt.Errorf("kms Decrypt: got %d bytes, want a 32-byte key", len(out.Plaintext))
+	}
+	k.mu.Lock()
+	if len(k.cache) >= kmsCacheSize {
+		k.cache = make(map[string][]byte)
+	}
+	k.cache[string(wrapped)] = out.Plaintext
+	k.mu.Unlock()
+	return out.Plaintext, nil
+}
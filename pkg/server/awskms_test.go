@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewAWSKMSRegion(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "")
+	tests := []struct {
+		spec     string
+		region   string
+		endpoint string
+	}{
+		{"alias/files", "us-east-1", "https://kms.us-east-1.amazonaws.com"},
+		{"alias/files?region=eu-west-1", "eu-west-1", "https://kms.eu-west-1.amazonaws.com"},
+		{"arn:aws:kms:eu-central-1:111122223333:key/abcd", "eu-central-1", "https://kms.eu-central-1.amazonaws.com"},
+		{"abcd?endpoint=http://localhost:4566/", "us-east-1", "http://localhost:4566"},
+	}
+	for _, tt := range tests {
+		k, err := newAWSKMS(tt.spec)
+		if err != nil {
+			t.Fatalf("newAWSKMS(%q): %v", tt.spec, err)
+		}
+		if k.region != tt.region || k.endpoint != tt.endpoint {
+			t.Errorf("newAWSKMS(%q) = %s %s, want %s %s", tt.spec, k.region, k.endpoint, tt.region, tt.endpoint)
+		}
+	}
+	if _, err := newAWSKMS(""); err == nil {
+		t.Error("empty key accepted")
+	}
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	if _, err := newAWSKMS("alias/files"); err == nil {
+		t.Error("missing credentials accepted")
+	}
+}
+
+// fakeKMS answers Encrypt and Decrypt by flipping the key's bits.
+func fakeKMS(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(auth, "/kms/aws4_request") {
+			http.Error(w, `{"__type":"UnrecognizedClientException","message":"bad signature"}`, http.StatusBadRequest)
+			return
+		}
+		var in struct {
+			KeyId                     string
+			Plaintext, CiphertextBlob []byte
+		}
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.KeyId != "alias/files" {
+			http.Error(w, `{"__type":"NotFoundException","message":"no such key"}`, http.StatusBadRequest)
+			return
+		}
+		flip := func(b []byte) []byte {
+			out := make([]byte, len(b))
+			for i := range b {
+				out[i] = ^b[i]
+			}
+			return out
+		}
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.Encrypt":
+			json.NewEncoder(w).Encode(map[string][]byte{"CiphertextBlob": flip(in.Plaintext)})
+		case "TrentService.Decrypt":
+			json.NewEncoder(w).Encode(map[string][]byte{"Plaintext": flip(in.CiphertextBlob)})
+		default:
+			http.Error(w, `{"__type":"UnknownOperationException"}`, http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestAWSKMSWrap(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	srv := fakeKMS(t)
+	defer srv.Close()
+
+	k, err := newAWSKMS("alias/files?region=eu-west-1&endpoint=" + srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := bytes.Repeat([]byte{7}, 32)
+	wrapped, err := k.wrap(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(wrapped, key) {
+		t.Fatal("key not wrapped")
+	}
+	got, err := k.unwrap(wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Errorf("unwrap = %x, want %x", got, key)
+	}
+
+	other, err := newAWSKMS("alias/other?endpoint=" + srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.unwrap(wrapped); err == nil || !strings.Contains(err.Error(), "NotFoundException") {
+		t.Errorf("unwrap with a missing key: %v, want NotFoundException", err)
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// With -blob-pool every stored file is one blob in the chunk store, named
+// by the SHA-256 of its content, and its name holds a recipe listing that
+// single blob. Identical uploads share the blob and copies only write a
+// recipe. The chunk index counts the recipes referencing each blob, so a
+// delete leaves a blob other files use alone and the chunk-gc job reclaims
+// it once the last one lets go. Unlike -chunking files are never split, so
+// a blob is read in place.
+
+// storeBlob moves the finished file at tmp into the blob pool, unless an
+// identical blob is there already, and stores name as a reference to it.
+func (s *fileServer) storeBlob(name, tmp string) error {
+	f, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	return s.poolBlob(name, tmp, h, size)
+}
+
+// poolBlob finishes storing name from the file at tmp, size bytes hashed
+// into h: it becomes the blob, or is dropped for the identical one stored
+// already, and name's recipe references it.
+func (s *fileServer) poolBlob(name, tmp string, h hash.Hash, size int64) error {
+	defer os.Remove(tmp)
+	sum := hex.EncodeToString(h.Sum(nil))
+	path := chunkPath(s.storageDir, sum)
+	err := s.chunks.use(sum, path)
+	defer s.chunks.release([]string{sum})
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := os.Chmod(tmp, 0o644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return fmt.Errorf("store blob: %w", err)
+		}
+	} else if err != nil {
+		return err
+	}
+	rec := recipe{Size: size, Chunks: []recipeChunk{{Hash: sum, Size: size}}}
+	if err := writeRecipe(s.storageDir, filepath.Join(s.storageDir, name), rec); err != nil {
+		return err
+	}
+	s.chunks.link(name, rec.Chunks)
+	return nil
+}
+
+// blobWriter writes a file for the blob pool, hashing it as it goes, and
+// stores it on Close. Until then the previous version stays intact.
+type blobWriter struct {
+	s    *fileServer
+	name string
+	f    *os.File
+	h    hash.Hash
+	n    int64
+}
+
+func (s *fileServer) newBlobWriter(name string) (*blobWriter, error) {
+	dir := filepath.Join(s.storageDir, ".chunks")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	return &blobWriter{s: s, name: name, f: f, h: sha256.New()}, nil
+}
+
+func (w *blobWriter) Write(p []byte) (int, error) {
+	n, err := w.f.Write(p)
+	w.h.Write(p[:n])
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *blobWriter) Close() error {
+	if err := w.f.Close(); err != nil {
+		os.Remove(w.f.Name())
+		return err
+	}
+	return w.s.poolBlob(w.name, w.f.Name(), w.h, w.n)
+}
+
+// blobSum returns the SHA-256 of a file whose recipe is a single blob or
+// chunk, which is named by it, or ok=false.
+func (s *fileServer) blobSum(name string) (string, bool) {
+	rec, ok, err := storedRecipe(s.storageDir, filepath.Join(s.storageDir, name), true)
+	if !ok || err != nil || len(rec.Chunks) != 1 || rec.Chunks[0].Size != rec.Size {
+		return "", false
+	}
+	return rec.Chunks[0].Hash, true
+}
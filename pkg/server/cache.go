@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"expvar"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+var (
+	cacheHits   = expvar.NewInt("download_cache_hits")
+	cacheMisses = expvar.NewInt("download_cache_misses")
+	cacheBytes  = expvar.NewInt("download_cache_bytes")
+)
+
+// downloadCache keeps the content of recently downloaded small files in
+// memory, least recently used out first. Entries are checked against a
+// stat of the stored file on every hit, so a replaced file is never served
+// from the cache.
+type downloadCache struct {
+	max     int64 // total bytes
+	maxFile int64 // larger files are not cached
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List // front is most recent
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	name string
+	info fs.FileInfo
+	data []byte
+}
+
+func newDownloadCache(max, maxFile int64) *downloadCache {
+	if max <= 0 {
+		return nil
+	}
+	return &downloadCache{max: max, maxFile: min(maxFile, max), lru: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *downloadCache) get(name string, info fs.FileInfo) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[name]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if !sameStored(e.info, info) || e.info.Size() != info.Size() || !e.info.ModTime().Equal(info.ModTime()) {
+		c.drop(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e.data, true
+}
+
+func (c *downloadCache) put(name string, info fs.FileInfo, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[name]; ok {
+		c.drop(el)
+	}
+	c.items[name] = c.lru.PushFront(&cacheEntry{name: name, info: info, data: data})
+	c.size += int64(len(data))
+	for c.size > c.max {
+		c.drop(c.lru.Back())
+	}
+	cacheBytes.Set(c.size)
+}
+
+func (c *downloadCache) remove(name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[name]; ok {
+		c.drop(el)
+	}
+}
+
+func (c *downloadCache) drop(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.items, e.name)
+	c.size -= int64(len(e.data))
+	cacheBytes.Set(c.size)
+}
+
+// openCached is openStored for downloads: small files come from the cache
+// when it holds the current version and are added to it otherwise.
+func (s *fileServer) openCached(name string) (io.ReadSeekCloser, fs.FileInfo, error) {
+	if s.cache == nil {
+		return s.openStored(name)
+	}
+	info, err := s.statStored(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if data, ok := s.cache.get(name, info); ok {
+		cacheHits.Add(1)
+		s.cold.touch(name)
+		return nopSeekCloser{bytes.NewReader(data)}, info, nil
+	}
+	cacheMisses.Add(1)
+	f, info, err := s.openStored(name)
+	if err != nil || info.Size() > s.cache.maxFile {
+		return f, info, err
+	}
+	data, err := io.ReadAll(io.LimitReader(f, s.cache.maxFile+1))
+	f.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(data)) == info.Size() {
+		s.cache.put(name, info, data)
+	}
+	return nopSeekCloser{bytes.NewReader(data)}, info, nil
+}
+
+type nopSeekCloser struct{ io.ReadSeeker }
+
+func (nopSeekCloser) Close() error { return nil }
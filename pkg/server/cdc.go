@@ -0,0 +1,310 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FastCDC parameters. Boundaries depend only on content, so an insert or
+// edit in a large file changes the chunks around it and the rest dedup.
+const (
+	cdcMinSize = 16 << 10
+	cdcAvgSize = 64 << 10
+	cdcMaxSize = 256 << 10
+
+	// normalized chunking: a stricter mask below the average size and a
+	// looser one above it pull chunk sizes towards cdcAvgSize
+	cdcMaskS = uint64(1<<18-1) << (64 - 18)
+	cdcMaskL = uint64(1<<14-1) << (64 - 14)
+)
+
+// recipeMagic starts every recipe. Files stored before chunking was
+// enabled are plain; which files are recipes is recorded in their layout.
+var recipeMagic = []byte("\x00fscdc1\n")
+
+// cdcGear is the rolling hash table. It comes from a fixed seed: changing it
+// would move every chunk boundary and defeat dedup against stored data.
+var cdcGear = func() (g [256]uint64) {
+	x := uint64(0x6a09e667f3bcc908)
+	for i := range g {
+		// splitmix64
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		g[i] = z ^ (z >> 31)
+	}
+	return g
+}()
+
+// cdcCut returns the length of the first chunk of data.
+func cdcCut(data []byte) int {
+	n := len(data)
+	if n <= cdcMinSize {
+		return n
+	}
+	n = min(n, cdcMaxSize)
+	normal := min(n, cdcAvgSize)
+	var fp uint64
+	i := cdcMinSize
+	for ; i < normal; i++ {
+		fp = fp<<1 + cdcGear[data[i]]
+		if fp&cdcMaskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + cdcGear[data[i]]
+		if fp&cdcMaskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+type recipeChunk struct {
+	Hash string `json:"h"`
+	Size int64  `json:"n"`
+}
+
+// recipe lists the chunks a file is made of; it is stored under the
+// file's name while the chunks live in <storage>/.chunks by hash. On disk
+// it is recipeMagic, the size on its own line and the chunk list as JSON, so
+// stat calls only read the first line.
+type recipe struct {
+	Size   int64
+	Chunks []recipeChunk
+}
+
+func chunkPath(storageDir, hash string) string {
+	return filepath.Join(storageDir, ".chunks", hash[:2], hash)
+}
+
+// validChunkHash reports whether hash names a chunk: a SHA-256 in lower
+// case hex, and so a path that stays in .chunks.
+func validChunkHash(hash string) bool {
+	if len(hash) != 2*sha256.Size {
+		return false
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// readRecipe parses the recipe at path, or returns ok=false for a file
+// that does not start like one. Without withChunks only the size is read.
+// Callers go through storedRecipe, which only trusts the files recorded as
+// recipes.
+func readRecipe(path string, withChunks bool) (rec *recipe, ok bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	head := make([]byte, len(recipeMagic))
+	if _, err := io.ReadFull(br, head); err != nil || !bytes.Equal(head, recipeMagic) {
+		return nil, false, nil
+	}
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, false, nil
+	}
+	rec = &recipe{}
+	if rec.Size, err = strconv.ParseInt(strings.TrimSuffix(line, "\n"), 10, 64); err != nil {
+		return nil, false, nil
+	}
+	if withChunks {
+		if err := json.NewDecoder(br).Decode(&rec.Chunks); err != nil {
+			return nil, false, fmt.Errorf("corrupt recipe %s: %w", path, err)
+		}
+		var total int64
+		for _, c := range rec.Chunks {
+			if !validChunkHash(c.Hash) || c.Size < 0 {
+				return nil, false, fmt.Errorf("corrupt recipe %s: bad chunk %q", path, c.Hash)
+			}
+			total += c.Size
+		}
+		if total != rec.Size {
+			return nil, false, fmt.Errorf("corrupt recipe %s: chunks add up to %d bytes, not %d", path, total, rec.Size)
+		}
+	}
+	return rec, true, nil
+}
+
+// cdcWriter splits written data into chunks, stores chunks it has not seen
+// before and writes the recipe on Close. Until then the previous version of
+// the file stays intact.
+type cdcWriter struct {
+	storageDir string
+	name       string
+	path       string
+	index      *chunkIndex
+	pinned     []string // chunks to release from index on Close
+	buf        []byte
+	rec        recipe
+}
+
+func (w *cdcWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= cdcMaxSize {
+		if err := w.emit(cdcCut(w.buf)); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *cdcWriter) emit(n int) error {
+	chunk := w.buf[:n]
+	sum := sha256.Sum256(chunk)
+	hash := hex.EncodeToString(sum[:])
+	path := chunkPath(w.storageDir, hash)
+	err := w.index.use(hash, path)
+	w.pinned = append(w.pinned, hash)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := writeFileAtomic(path, chunk); err != nil {
+			return fmt.Errorf("store chunk: %w", err)
+		}
+	}
+	w.rec.Chunks = append(w.rec.Chunks, recipeChunk{Hash: hash, Size: int64(n)})
+	w.rec.Size += int64(n)
+	w.buf = append(w.buf[:0], w.buf[n:]...)
+	return nil
+}
+
+func (w *cdcWriter) Close() error {
+	defer w.index.release(w.pinned)
+	for len(w.buf) > 0 {
+		if err := w.emit(cdcCut(w.buf)); err != nil {
+			return err
+		}
+	}
+	if err := writeRecipe(w.storageDir, w.path, w.rec); err != nil {
+		return err
+	}
+	w.index.link(w.name, w.rec.Chunks)
+	return nil
+}
+
+// writeRecipe stores rec at path, in storageDir, and records it as a
+// recipe once it is in place.
+func writeRecipe(storageDir, path string, rec recipe) error {
+	var out bytes.Buffer
+	out.Write(recipeMagic)
+	fmt.Fprintf(&out, "%d\n", rec.Size)
+	if err := json.NewEncoder(&out).Encode(rec.Chunks); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, out.Bytes()); err != nil {
+		return err
+	}
+	return setLayout(storageDir, path, recipeLayout)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// chunkReader reads a chunked file back as one stream. Chunks are read in
+// place rather than loaded, since a -blob-pool blob is a whole file.
+type chunkReader struct {
+	storageDir string
+	rec        *recipe
+	starts     []int64 // offset of each chunk
+	off        int64
+	cur        int      // index of the open chunk, -1 if none
+	f          *os.File // chunk cur
+}
+
+func newChunkReader(storageDir string, rec *recipe) *chunkReader {
+	r := &chunkReader{storageDir: storageDir, rec: rec, cur: -1}
+	var pos int64
+	for _, c := range rec.Chunks {
+		r.starts = append(r.starts, pos)
+		pos += c.Size
+	}
+	return r
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.off >= r.rec.Size {
+		return 0, io.EOF
+	}
+	i := sort.Search(len(r.starts), func(i int) bool { return r.starts[i] > r.off }) - 1
+	if i != r.cur {
+		r.Close()
+		f, err := os.Open(chunkPath(r.storageDir, r.rec.Chunks[i].Hash))
+		if err != nil {
+			return 0, fmt.Errorf("read chunk: %w", err)
+		}
+		r.cur, r.f = i, f
+	}
+	want := min(int64(len(p)), r.starts[i]+r.rec.Chunks[i].Size-r.off)
+	n, err := r.f.ReadAt(p[:want], r.off-r.starts[i])
+	r.off += int64(n)
+	if err == io.EOF && int64(n) < want {
+		return n, fmt.Errorf("read chunk: %w", io.ErrUnexpectedEOF)
+	}
+	if err != nil && err != io.EOF {
+		return n, fmt.Errorf("read chunk: %w", err)
+	}
+	return n, nil
+}
+
+func (r *chunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.rec.Size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start of file")
+	}
+	r.off = offset
+	return offset, nil
+}
+
+func (r *chunkReader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.cur, r.f = -1, nil
+	return err
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadRecipe(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		content string
+		ok      bool
+		wantErr bool
+		size    int64
+	}{
+		{"plain file", "hello\n", false, false, 0},
+		{"empty", "", false, false, 0},
+		{"magic only", "\x00fscdc1\n", false, false, 0},
+		{"bad size", "\x00fscdc1\nbig\n[]\n", false, false, 0},
+		{"no chunks", "\x00fscdc1\n0\n[]\n", true, false, 0},
+		{"one chunk", "\x00fscdc1\n5\n[{\"h\":\"" + hash + "\",\"n\":5}]\n", true, false, 5},
+		{"bad json", "\x00fscdc1\n5\n[{\n", false, true, 0},
+		{"path traversal", "\x00fscdc1\n5\n[{\"h\":\"../../../etc/passwd\",\"n\":5}]\n", false, true, 0},
+		{"short hash", "\x00fscdc1\n5\n[{\"h\":\"a\",\"n\":5}]\n", false, true, 0},
+		{"empty hash", "\x00fscdc1\n5\n[{\"h\":\"\",\"n\":5}]\n", false, true, 0},
+		{"upper case hash", "\x00fscdc1\n5\n[{\"h\":\"" + strings.ToUpper(hash) + "\",\"n\":5}]\n", false, true, 0},
+		{"negative chunk", "\x00fscdc1\n0\n[{\"h\":\"" + hash + "\",\"n\":-5},{\"h\":\"" + hash + "\",\"n\":5}]\n", false, true, 0},
+		{"sizes disagree", "\x00fscdc1\n10\n[{\"h\":\"" + hash + "\",\"n\":5}]\n", false, true, 0},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "f")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			rec, ok, err := readRecipe(path, true)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && rec.Size != tt.size {
+				t.Errorf("size = %d, want %d", rec.Size, tt.size)
+			}
+		})
+	}
+}
+
+func TestValidChunkHash(t *testing.T) {
+	tests := []struct {
+		hash string
+		want bool
+	}{
+		{strings.Repeat("0", 64), true},
+		{strings.Repeat("f", 64), true},
+		{strings.Repeat("F", 64), false},
+		{strings.Repeat("0", 63), false},
+		{strings.Repeat("0", 65), false},
+		{strings.Repeat("g", 64), false},
+		{"../" + strings.Repeat("0", 61), false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := validChunkHash(tt.hash); got != tt.want {
+			t.Errorf("validChunkHash(%q) = %v, want %v", tt.hash, got, tt.want)
+		}
+	}
+}
+
+// An upload that looks like a recipe is still a plain file.
+func TestStoredRecipeNeedsLayout(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "fake")
+	fake := "\x00fscdc1\n5\n[{\"h\":\"" + strings.Repeat("ab", 32) + "\",\"n\":5}]\n"
+	if err := os.WriteFile(path, []byte(fake), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := storedRecipe(dir, path, true); ok || err != nil {
+		t.Fatalf("uploaded recipe: ok = %v, err = %v; want a plain file", ok, err)
+	}
+
+	rec := recipe{Size: 5, Chunks: []recipeChunk{{Hash: strings.Repeat("cd", 32), Size: 5}}}
+	if err := writeRecipe(dir, path, rec); err != nil {
+		t.Fatal(err)
+	}
+	got, ok, err := storedRecipe(dir, path, true)
+	if !ok || err != nil {
+		t.Fatalf("written recipe: ok = %v, err = %v", ok, err)
+	}
+	if got.Size != 5 || len(got.Chunks) != 1 || got.Chunks[0] != rec.Chunks[0] {
+		t.Errorf("recipe = %+v, want %+v", got, rec)
+	}
+
+	if err := setLayout(dir, path, plainLayout); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := storedRecipe(dir, path, true); ok {
+		t.Error("recipe still read after its layout was dropped")
+	}
+}
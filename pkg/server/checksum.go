@@ -0,0 +1,140 @@
+package server
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sumRecord is the cached digest of a stored file. Size and mtime tie it to
+// the file version it was computed for, so files changed behind the
+// server's back get hashed again.
+type sumRecord struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	MTime  int64  `json:"mtime"`
+}
+
+// expectedSHA256 validates the checksum an upload announces and returns it
+// in lower case.
+func expectedSHA256(sum string) (string, error) {
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size {
+		return "", status.Errorf(codes.InvalidArgument, "sha256 %q is not a hex SHA-256", sum)
+	}
+	return strings.ToLower(sum), nil
+}
+
+// checkUpload compares the checksum of received content with the one the
+// client announced, if it did.
+func checkUpload(name, want, got string) error {
+	if want != "" && want != got {
+		return status.Errorf(codes.DataLoss, "%s: checksum mismatch, received %s, expected %s", name, got, want)
+	}
+	return nil
+}
+
+func (s *fileServer) sumPath(name string) string {
+	return filepath.Join(s.storageDir, ".sums", name)
+}
+
+// storeChecksum records the digest of the current content of name.
+func (s *fileServer) storeChecksum(name, sum string) {
+	info, err := s.statStored(name)
+	if err != nil {
+		return
+	}
+	if _, packed := info.(packInfo); packed {
+		// hashing a small file again is cheap, a sidecar would cost the
+		// inode packing saves
+		return
+	}
+	b, _ := json.Marshal(sumRecord{SHA256: sum, Size: info.Size(), MTime: info.ModTime().UnixNano()})
+	if err := os.MkdirAll(filepath.Dir(s.sumPath(name)), 0o755); err != nil {
+		return
+	}
+	_ = os.WriteFile(s.sumPath(name), b, 0o644)
+}
+
+// cachedChecksum returns the recorded digest of name if it still belongs to
+// the version described by info.
+func (s *fileServer) cachedChecksum(name string, info fs.FileInfo) (string, bool) {
+	var rec sumRecord
+	b, err := os.ReadFile(s.sumPath(name))
+	if err != nil || json.Unmarshal(b, &rec) != nil ||
+		rec.Size != info.Size() || rec.MTime != info.ModTime().UnixNano() {
+		return "", false
+	}
+	return rec.SHA256, true
+}
+
+// checksum returns the SHA-256 of name, from the cache when it is still
+// valid and by reading the file otherwise.
+func (s *fileServer) checksum(name string) (string, int64, error) {
+	info, err := s.statStored(name)
+	if err != nil {
+		return "", 0, err
+	}
+	if sum, ok := s.cachedChecksum(name, info); ok {
+		return sum, info.Size(), nil
+	}
+
+	f, _, err := s.openStored(name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	s.storeChecksum(name, sum)
+	return sum, n, nil
+}
+
+// newHash returns a hash for one of the algorithms HashRequest accepts.
+func newHash(alg string) (hash.Hash, error) {
+	switch alg {
+	case "", "sha256":
+		return sha256.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	case "md5":
+		return md5.New(), nil
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "unknown hash algorithm %q, want sha256, sha1, sha512 or md5", alg)
+}
+
+// digest hashes name with alg. Only SHA-256 is cached; other algorithms are
+// asked for by audits and exports, which read the file without thawing it.
+func (s *fileServer) digest(name, alg string) (string, int64, error) {
+	h, err := newHash(alg)
+	if err != nil {
+		return "", 0, err
+	}
+	f, err := s.readStored(name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var chunkGCReclaimed = expvar.NewInt("chunk_gc_reclaimed_bytes")
+
+// chunkIndex counts the recipes referencing each chunk so the chunk-gc job
+// can reclaim the ones nothing uses. Recipes on disk are the truth: every
+// GC run rebuilds the index from them, and in between uploads and deletes
+// keep it current. A crash therefore loses nothing, and chunks of an upload
+// that died before writing its recipe are reclaimed once they are older
+// than grace. Chunks a file stops using are kept for grace too, so
+// downloads of the old version can finish.
+type chunkIndex struct {
+	dir   string // storage dir
+	grace time.Duration
+
+	mu    sync.Mutex
+	names map[string][]string  // name -> distinct chunks of its recipe
+	refs  map[string]int       // chunk -> recipes using it
+	pins  map[string]int       // chunk -> uploads in progress using it
+	freed map[string]time.Time // chunk -> when its last recipe let go of it
+	dirty map[string]bool      // names changed during a rebuild, nil outside one
+}
+
+func newChunkIndex(storageDir string, grace time.Duration) *chunkIndex {
+	x := &chunkIndex{
+		dir:   storageDir,
+		grace: grace,
+		names: make(map[string][]string),
+		refs:  make(map[string]int),
+		pins:  make(map[string]int),
+		freed: make(map[string]time.Time),
+	}
+	expvar.Publish("chunk_refs", expvar.Func(func() interface{} {
+		x.mu.Lock()
+		defer x.mu.Unlock()
+		return map[string]int{"files": len(x.names), "chunks": len(x.refs)}
+	}))
+	return x
+}
+
+// use pins a chunk for an upload in progress and refreshes its mtime if it
+// is already stored, so neither this process nor another one sharing the
+// storage reclaims it before the recipe is written. It returns
+// fs.ErrNotExist when the chunk still has to be written.
+func (x *chunkIndex) use(hash, path string) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.pins[hash]++
+	now := time.Now()
+	return os.Chtimes(path, now, now)
+}
+
+func (x *chunkIndex) release(hashes []string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, h := range hashes {
+		if x.pins[h]--; x.pins[h] <= 0 {
+			delete(x.pins, h)
+		}
+	}
+}
+
+// link records that name's recipe now lists chunks, replacing whatever it
+// listed before.
+func (x *chunkIndex) link(name string, chunks []recipeChunk) {
+	hashes := chunkHashes(chunks)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.unlinkLocked(name)
+	x.names[name] = hashes
+	for _, h := range hashes {
+		x.refs[h]++
+		delete(x.freed, h)
+	}
+}
+
+func chunkHashes(chunks []recipeChunk) []string {
+	seen := make(map[string]bool, len(chunks))
+	var hashes []string
+	for _, c := range chunks {
+		if !seen[c.Hash] {
+			seen[c.Hash] = true
+			hashes = append(hashes, c.Hash)
+		}
+	}
+	return hashes
+}
+
+// unlink records that name is no longer a recipe.
+func (x *chunkIndex) unlink(name string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.unlinkLocked(name)
+}
+
+func (x *chunkIndex) unlinkLocked(name string) {
+	if x.dirty != nil {
+		x.dirty[name] = true
+	}
+	for _, h := range x.names[name] {
+		if x.refs[h]--; x.refs[h] <= 0 {
+			delete(x.refs, h)
+			x.freed[h] = time.Now()
+		}
+	}
+	delete(x.names, name)
+}
+
+// rebuild recounts references from the recipes in the storage dir. Names
+// linked or unlinked while it reads keep their newer state.
+func (x *chunkIndex) rebuild(ctx context.Context) error {
+	x.mu.Lock()
+	x.dirty = make(map[string]bool)
+	x.mu.Unlock()
+	defer func() {
+		x.mu.Lock()
+		x.dirty = nil
+		x.mu.Unlock()
+	}()
+
+	names, err := storedPaths(x.dir)
+	if err != nil {
+		return err
+	}
+	scanned := make(map[string][]string)
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rec, ok, err := storedRecipe(x.dir, filepath.Join(x.dir, name), true)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			// a recipe we cannot read may still reference chunks
+			return err
+		}
+		if ok {
+			scanned[name] = chunkHashes(rec.Chunks)
+		}
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for name := range x.dirty {
+		delete(scanned, name)
+		if hashes, ok := x.names[name]; ok {
+			scanned[name] = hashes
+		}
+	}
+	x.names, x.refs = scanned, make(map[string]int)
+	for _, hashes := range scanned {
+		for _, h := range hashes {
+			x.refs[h]++
+			delete(x.freed, h)
+		}
+	}
+	return nil
+}
+
+// reclaim removes the chunk at path if no recipe or upload uses it and it
+// is older than the grace period. Temporary files of interrupted chunk
+// writes are never referenced and go the same way.
+func (x *chunkIndex) reclaim(path, hash string) (removed bool, size int64, err error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.refs[hash] > 0 || x.pins[hash] > 0 || time.Since(x.freed[hash]) < x.grace {
+		return false, 0, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) < x.grace {
+		return false, 0, nil
+	}
+	if err := os.Remove(path); err != nil {
+		return false, 0, err
+	}
+	delete(x.freed, hash)
+	return true, info.Size(), nil
+}
+
+// chunkGCJob reclaims chunks no recipe references. The scheduler runs it
+// daily by default.
+func (s *fileServer) chunkGCJob(ctx context.Context) (string, error) {
+	if err := s.chunks.rebuild(ctx); err != nil {
+		return "", fmt.Errorf("count references: %w", err)
+	}
+	removed, freed := 0, int64(0)
+	root := filepath.Join(s.storageDir, ".chunks")
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root && os.IsNotExist(err) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		ok, n, err := s.chunks.reclaim(path, d.Name())
+		if ok {
+			removed++
+			freed += n
+		}
+		return err
+	})
+	chunkGCReclaimed.Add(freed)
+	return fmt.Sprintf("reclaimed %d chunks, %d bytes", removed, freed), err
+}
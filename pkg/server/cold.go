@@ -0,0 +1,445 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// coldMagic starts a compressed file. Like a recipe it is followed by the
+// original size on its own line, then the gzip stream.
+var coldMagic = []byte("\x00fsgz1\n")
+
+// coldStore tracks when files were last read so the cold job can compress
+// the ones nobody reads. atime is useless on noatime mounts, so reads are
+// recorded here and saved to <storage>/.cold/access by the job.
+type coldStore struct {
+	dir    string
+	after  time.Duration // 0 disables compression; cold files are still served
+	atRest bool          // compress uploads as they are stored and never thaw
+
+	mu      sync.Mutex
+	access  map[string]int64
+	skipped map[string]int64 // mtime of files that did not compress well
+}
+
+func openColdStore(storageDir string, after time.Duration, atRest bool) *coldStore {
+	c := &coldStore{
+		dir:     filepath.Join(storageDir, ".cold"),
+		after:   after,
+		atRest:  atRest,
+		access:  make(map[string]int64),
+		skipped: make(map[string]int64),
+	}
+	if b, err := os.ReadFile(filepath.Join(c.dir, "access")); err == nil {
+		_ = json.Unmarshal(b, &c.access)
+	}
+	return c
+}
+
+func (c *coldStore) touch(name string) {
+	if c.after <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.access[name] = time.Now().Unix()
+	c.mu.Unlock()
+}
+
+func (c *coldStore) forget(name string) {
+	c.mu.Lock()
+	delete(c.access, name)
+	delete(c.skipped, name)
+	c.mu.Unlock()
+}
+
+func (c *coldStore) lastAccess(name string) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Unix(c.access[name], 0)
+}
+
+func (c *coldStore) save() error {
+	c.mu.Lock()
+	b, err := json.Marshal(c.access)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(c.dir, "access"), b)
+}
+
+// coldSize returns the original size of a file that starts like a
+// compressed one, or ok=false. Callers go through storedColdSize, which
+// only trusts the files recorded as compressed.
+func coldSize(path string) (size int64, ok bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	head := make([]byte, len(coldMagic))
+	if _, err := io.ReadFull(br, head); err != nil || !bytes.Equal(head, coldMagic) {
+		return 0, false
+	}
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return 0, false
+	}
+	size, err = strconv.ParseInt(strings.TrimSuffix(line, "\n"), 10, 64)
+	return size, err == nil
+}
+
+// coldJob compresses files that have not been read or written for
+// s.cold.after. The scheduler runs it hourly by default.
+func (s *fileServer) coldJob(ctx context.Context) (string, error) {
+	names, err := storedPaths(s.storageDir)
+	if err != nil {
+		return "", err
+	}
+	compressed, failed := 0, 0
+	for _, name := range names {
+		if ctx.Err() != nil {
+			continue
+		}
+		ok, err := s.freeze(name)
+		if err != nil {
+			slog.Warn("cold job: compress failed", "file", name, "error", err)
+			failed++
+		} else if ok {
+			compressed++
+		}
+	}
+	result := fmt.Sprintf("compressed %d files", compressed)
+	if err := s.cold.save(); err != nil {
+		return result, fmt.Errorf("save access times: %w", err)
+	}
+	if failed > 0 {
+		return result, fmt.Errorf("%d files failed, see the log", failed)
+	}
+	return result, ctx.Err()
+}
+
+// freeze compresses name if it is a plain file gone cold and reports
+// whether it did. The mtime is kept so checksums, ETags and sync
+// comparisons do not see a change.
+func (s *fileServer) freeze(name string) (bool, error) {
+	path := filepath.Join(s.storageDir, name)
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false, nil
+	}
+	last := max(s.cold.lastAccess(name).Unix(), info.ModTime().Unix())
+	if time.Since(time.Unix(last, 0)) < s.cold.after {
+		return false, nil
+	}
+	s.cold.mu.Lock()
+	skip := s.cold.skipped[name] == info.ModTime().UnixNano()
+	s.cold.mu.Unlock()
+	if skip {
+		return false, nil
+	}
+	if layoutOf(s.storageDir, path) != plainLayout {
+		return false, nil
+	}
+
+	unlock, err := s.locks.Lock(context.Background(), name)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	// an upload may have replaced the file while we waited for the lock
+	if cur, err := os.Stat(path); err != nil || !os.SameFile(info, cur) ||
+		cur.Size() != info.Size() || !cur.ModTime().Equal(info.ModTime()) {
+		return false, nil
+	}
+
+	packed, err := s.compressFile(path, info.Size())
+	if err != nil {
+		return false, err
+	}
+	if packed == "" {
+		s.cold.mu.Lock()
+		s.cold.skipped[name] = info.ModTime().UnixNano()
+		s.cold.mu.Unlock()
+		return false, nil
+	}
+	defer os.Remove(packed)
+	if err := os.Chtimes(packed, time.Now(), info.ModTime()); err != nil {
+		return false, err
+	}
+	if err := os.Rename(packed, path); err != nil {
+		return false, err
+	}
+	return true, setLayout(s.storageDir, path, coldLayout)
+}
+
+// compressFile writes the plain file at path, size bytes long, to a new
+// compressed file and returns its path, or "" if it came out no smaller.
+func (s *fileServer) compressFile(path string, size int64) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	tmp, err := s.coldTemp()
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(tmp, "%s%d\n", coldMagic, size)
+	zw := gzip.NewWriter(tmp)
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		var packed os.FileInfo
+		if packed, err = os.Stat(tmp.Name()); err == nil && packed.Size() < size {
+			return tmp.Name(), nil
+		}
+	}
+	os.Remove(tmp.Name())
+	return "", err
+}
+
+// coldSample is how much of an upload is compressed to judge whether
+// compressing all of it at rest pays off.
+const coldSample = 64 << 10
+
+// compressible reports whether the start of the file at path shrinks by at
+// least a tenth; media and archives do not, and are stored plain.
+func compressible(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	sample, err := io.ReadAll(io.LimitReader(f, coldSample))
+	if err != nil || len(sample) == 0 {
+		return false
+	}
+	var out countWriter
+	zw, _ := gzip.NewWriterLevel(&out, gzip.BestSpeed)
+	zw.Write(sample)
+	zw.Close()
+	return int64(out) < int64(len(sample))*9/10
+}
+
+type countWriter int64
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	*w += countWriter(len(p))
+	return len(p), nil
+}
+
+// storeCompressed replaces the finished upload at tmp with a compressed
+// copy if it is worth it, for -compress-stored, and returns the file to
+// move into place.
+func (s *fileServer) storeCompressed(tmp string) (string, error) {
+	info, err := os.Stat(tmp)
+	if err != nil || !compressible(tmp) {
+		return tmp, err
+	}
+	packed, err := s.compressFile(tmp, info.Size())
+	if err != nil || packed == "" {
+		return tmp, err
+	}
+	os.Remove(tmp)
+	return packed, nil
+}
+
+// thaw turns a compressed file back into a plain one. Reads of cold files
+// are rare, so the file stays plain until it goes cold again.
+func (s *fileServer) thaw(name string) error {
+	unlock, err := s.locks.Lock(context.Background(), name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	path := filepath.Join(s.storageDir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := storedColdSize(s.storageDir, path); !ok {
+		return nil // thawed while we waited
+	}
+	zr, err := openCold(path)
+	if err != nil {
+		return fmt.Errorf("compressed file %s: %w", name, err)
+	}
+	defer zr.Close()
+	tmp, err := s.coldTemp()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, zr)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), time.Now(), info.ModTime()); err != nil {
+		return err
+	}
+	if err := setLayout(s.storageDir, path, plainLayout); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// errColdSize is returned by a compressed file that holds more than its
+// recorded size, which would otherwise decompress past -max-file-size and
+// the quotas.
+var errColdSize = errors.New("compressed file is larger than its recorded size")
+
+// openCold returns the original content of the compressed file at path.
+// Reading fails rather than return more than the size it records.
+func openCold(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	if _, err := br.ReadString('\n'); err != nil { // magic
+		f.Close()
+		return nil, err
+	}
+	line, err := br.ReadString('\n')
+	var size int64
+	if err == nil {
+		size, err = strconv.ParseInt(strings.TrimSuffix(line, "\n"), 10, 64)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("bad size line: %w", err)
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &coldReader{zr: zr, f: f, left: size}, nil
+}
+
+type coldReader struct {
+	zr   *gzip.Reader
+	f    *os.File
+	left int64 // of the recorded size
+}
+
+func (r *coldReader) Read(p []byte) (int, error) {
+	if r.left <= 0 {
+		var b [1]byte
+		if n, _ := io.ReadFull(r.zr, b[:]); n > 0 {
+			return 0, errColdSize
+		}
+		return 0, io.EOF
+	}
+	p = p[:min(int64(len(p)), r.left)]
+	n, err := r.zr.Read(p)
+	r.left -= int64(n)
+	if err == io.EOF && r.left > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *coldReader) Close() error { return r.f.Close() }
+
+// coldFile serves a compressed file without thawing it. A seek takes
+// effect on the next read: going back starts decompressing over, going
+// forward skips; downloads seek once, to their offset.
+type coldFile struct {
+	path string
+	size int64
+	off  int64 // where the next read starts
+	pos  int64 // how far r has been read
+	r    io.ReadCloser
+}
+
+func openColdFile(path string, size int64) (*coldFile, error) {
+	r, err := openCold(path)
+	if err != nil {
+		return nil, err
+	}
+	return &coldFile{path: path, size: size, r: r}, nil
+}
+
+func (f *coldFile) Read(p []byte) (int, error) {
+	if f.off >= f.size {
+		return 0, io.EOF
+	}
+	if f.off < f.pos {
+		r, err := openCold(f.path)
+		if err != nil {
+			return 0, err
+		}
+		f.r.Close()
+		f.r, f.pos = r, 0
+	}
+	if f.off > f.pos {
+		n, err := io.CopyN(io.Discard, f.r, f.off-f.pos)
+		f.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.r.Read(p)
+	f.pos += int64(n)
+	f.off = f.pos
+	return n, err
+}
+
+func (f *coldFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return f.off, fmt.Errorf("seek %s: negative offset", f.path)
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *coldFile) Close() error { return f.r.Close() }
+
+func (s *fileServer) coldTemp() (*os.File, error) {
+	if err := os.MkdirAll(s.cold.dir, 0o755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(s.cold.dir, ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCold(t *testing.T, path string, size int64, content []byte) {
+	t.Helper()
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s%d\n", coldMagic, size)
+	zw := gzip.NewWriter(&b)
+	zw.Write(content)
+	zw.Close()
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenColdCapsSize(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 1000)
+	tests := []struct {
+		name    string
+		size    int64
+		wantErr error
+	}{
+		{"recorded size", 1000, nil},
+		{"more than recorded", 10, errColdSize},
+		{"less than recorded", 2000, io.ErrUnexpectedEOF},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "f")
+			writeCold(t, path, tt.size, content)
+			r, err := openCold(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if int64(len(got)) > tt.size {
+				t.Errorf("read %d bytes, past the recorded %d", len(got), tt.size)
+			}
+			if tt.wantErr == nil && !bytes.Equal(got, content) {
+				t.Error("content differs")
+			}
+		})
+	}
+}
+
+// An upload that looks compressed is still a plain file.
+func TestStoredColdSizeNeedsLayout(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "f")
+	writeCold(t, path, 3, []byte("abc"))
+	if _, ok := storedColdSize(dir, path); ok {
+		t.Fatal("uploaded cold file taken as compressed")
+	}
+	if err := setLayout(dir, path, coldLayout); err != nil {
+		t.Fatal(err)
+	}
+	if size, ok := storedColdSize(dir, path); !ok || size != 3 {
+		t.Fatalf("storedColdSize = %d, %v; want 3, true", size, ok)
+	}
+}
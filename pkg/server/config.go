@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Every flag can also be set in the environment as FILE_SERVICE_<NAME>,
+// upper case with underscores for dashes, or in the YAML file named by
+// -config. The command line wins over the environment, which wins over the
+// file.
+const envPrefix = "FILE_SERVICE_"
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadConfig sets the flags not given on the command line from the
+// environment and the config file, if any.
+func loadConfig(fs *flag.FlagSet, path string) error {
+	var file map[string]string
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if file, err = parseConfig(path, b); err != nil {
+			return err
+		}
+		for name := range file {
+			if fs.Lookup(name) == nil || name == "config" {
+				return fmt.Errorf("%s: unknown setting %q", path, name)
+			}
+		}
+	}
+	onCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { onCommandLine[f.Name] = true })
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || onCommandLine[f.Name] || f.Name == "config" {
+			return
+		}
+		if v, ok := os.LookupEnv(envName(f.Name)); ok {
+			if serr := f.Value.Set(v); serr != nil {
+				err = fmt.Errorf("%s: %w", envName(f.Name), serr)
+			}
+			return
+		}
+		if v, ok := file[f.Name]; ok {
+			if serr := f.Value.Set(v); serr != nil {
+				err = fmt.Errorf("%s: %s: %w", path, f.Name, serr)
+			}
+		}
+	})
+	return err
+}
+
+// parseConfig reads the flat YAML mapping of a config file: "name: value"
+// lines, where the name is a flag's with dashes or underscores. A list,
+// in flow style or as "- item" lines below its name, becomes the
+// comma-separated value the list flags take.
+func parseConfig(src string, b []byte) (map[string]string, error) {
+	out := make(map[string]string)
+	var list string // the key whose block list is being read
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimRight(sc.Text(), " \t\r")
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || trimmed == "---" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if item, ok := strings.CutPrefix(trimmed, "- "); ok && list != "" && text != trimmed {
+			v, err := configScalar(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", src, line, err)
+			}
+			if out[list] != "" {
+				out[list] += ","
+			}
+			out[list] += v
+			continue
+		}
+		if text != trimmed {
+			return nil, fmt.Errorf("%s:%d: nested mappings are not supported", src, line)
+		}
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: want name: value", src, line)
+		}
+		key = strings.ReplaceAll(strings.TrimSpace(key), "_", "-")
+		if _, dup := out[key]; dup {
+			return nil, fmt.Errorf("%s:%d: %s is set twice", src, line, key)
+		}
+		value = strings.TrimSpace(value)
+		list = ""
+		var err error
+		switch {
+		case value == "" || strings.HasPrefix(value, "#"):
+			list, out[key] = key, ""
+		case strings.HasPrefix(value, "["):
+			out[key], err = configFlowList(value)
+		default:
+			out[key], err = configScalar(value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", src, line, err)
+		}
+	}
+	return out, sc.Err()
+}
+
+// configScalar unquotes a scalar and drops a trailing comment.
+func configScalar(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		end := strings.LastIndex(v, `"`)
+		if end == 0 || !configRestIsComment(v[end+1:]) {
+			return "", fmt.Errorf("bad quoted value %s", v)
+		}
+		return strconv.Unquote(v[:end+1])
+	case strings.HasPrefix(v, "'"):
+		end := strings.LastIndex(v, "'")
+		if end == 0 || !configRestIsComment(v[end+1:]) {
+			return "", fmt.Errorf("bad quoted value %s", v)
+		}
+		return strings.ReplaceAll(v[1:end], "''", "'"), nil
+	}
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v), nil
+}
+
+func configRestIsComment(rest string) bool {
+	rest = strings.TrimSpace(rest)
+	return rest == "" || strings.HasPrefix(rest, "#")
+}
+
+func configFlowList(v string) (string, error) {
+	end := strings.LastIndex(v, "]")
+	if end < 0 || !configRestIsComment(v[end+1:]) {
+		return "", fmt.Errorf("unterminated list %s", v)
+	}
+	var items []string
+	for _, item := range strings.Split(v[1:end], ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		s, err := configScalar(item)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, s)
+	}
+	return strings.Join(items, ","), nil
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsConfig lets browser apps on other origins use the HTTP gateway.
+type corsConfig struct {
+	origins     []string // "*" allows any origin
+	methods     string
+	headers     string
+	credentials bool
+	maxAge      time.Duration
+}
+
+// corsExposed are the response headers scripts need for ranged and
+// conditional downloads, and the request ID to quote on failure.
+const corsExposed = "Accept-Ranges, Content-Length, Content-Range, ETag, Last-Modified, X-Request-Id"
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func (c corsConfig) allowed(origin string) bool {
+	for _, o := range c.origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap adds CORS headers for allowed origins and answers preflight requests.
+// Without configured origins the handler is returned unchanged.
+func (c corsConfig) wrap(next http.Handler) http.Handler {
+	if len(c.origins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !c.allowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		// a wildcard cannot be combined with credentials, so the
+		// request origin is always echoed back
+		h.Set("Access-Control-Allow-Origin", origin)
+		if c.credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			h.Set("Access-Control-Expose-Headers", corsExposed)
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Set("Access-Control-Allow-Methods", c.methods)
+		h.Set("Access-Control-Allow-Headers", c.headers)
+		if c.maxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.maxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
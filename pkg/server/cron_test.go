@@ -0,0 +1,94 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/-1 * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"@every 10ms",
+		"@every soon",
+		"@yearly",
+	} {
+		if _, err := parseSchedule(spec); err == nil {
+			t.Errorf("parseSchedule(%q) accepted", spec)
+		}
+	}
+	if s, err := parseSchedule("off"); s != nil || err != nil {
+		t.Errorf("parseSchedule(off) = %v, %v; want nil, nil", s, err)
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	// 2025-01-15 is a Wednesday
+	tests := []struct {
+		spec, after, want string
+	}{
+		{"* * * * *", "2025-01-15 10:30", "2025-01-15 10:31"},
+		{"0 3 * * *", "2025-01-15 10:30", "2025-01-16 03:00"},
+		{"0 3 * * *", "2025-01-15 02:59", "2025-01-15 03:00"},
+		{"@hourly", "2025-01-15 10:30", "2025-01-15 11:00"},
+		{"@daily", "2025-12-31 23:59", "2026-01-01 00:00"},
+		{"@weekly", "2025-01-15 10:30", "2025-01-19 00:00"},
+		{"0 0 * * 7", "2025-01-15 10:30", "2025-01-19 00:00"},
+		{"@monthly", "2025-01-15 10:30", "2025-02-01 00:00"},
+		{"*/15 * * * *", "2025-01-15 10:31", "2025-01-15 10:45"},
+		{"10/20 * * * *", "2025-01-15 10:31", "2025-01-15 10:50"},
+		{"0 9-17/4 * * *", "2025-01-15 10:00", "2025-01-15 13:00"},
+		{"0,30 12 * * *", "2025-01-15 12:00", "2025-01-15 12:30"},
+		{"0 0 * * 1-5", "2025-01-17 12:00", "2025-01-20 00:00"},
+		// both day fields restricted: either one matching is enough
+		{"0 0 1 * 5", "2025-01-15 10:30", "2025-01-17 00:00"},
+		{"0 0 29 2 *", "2025-01-15 10:30", "2028-02-29 00:00"},
+		{"0 0 30 2 *", "2025-01-15 10:30", ""},
+	}
+	for _, tt := range tests {
+		s, err := parseSchedule(tt.spec)
+		if err != nil {
+			t.Fatalf("parseSchedule(%q): %v", tt.spec, err)
+		}
+		got := s.next(at(tt.after))
+		if tt.want == "" {
+			if !got.IsZero() {
+				t.Errorf("%q after %s = %s, want never", tt.spec, tt.after, got)
+			}
+			continue
+		}
+		if want := at(tt.want); !got.Equal(want) {
+			t.Errorf("%q after %s = %s, want %s", tt.spec, tt.after, got, want)
+		}
+	}
+}
+
+func TestEverySchedule(t *testing.T) {
+	s, err := parseSchedule("@every 90s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 1, 15, 10, 30, 15, 0, time.UTC)
+	if got := s.next(now); !got.Equal(now.Add(90 * time.Second)) {
+		t.Errorf("next = %s, want 90s later", got)
+	}
+}
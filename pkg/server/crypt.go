@@ -0,0 +1,561 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// With -encryption-key every stored file is sealed with a key of its own,
+// which is kept in the file wrapped by a master key. A sealed file is
+// sealMagic, the length of the wrapped key as a big-endian uint16, the
+// wrapped key, and then the content in AES-256-GCM sealed segments of up
+// to sealSegment bytes. A segment's nonce is its index and its additional
+// data marks the last one, so segments can be neither reordered nor cut
+// off; the last segment may be empty. Segments let downloads seek. A file
+// whose data key is wrapped by another key than the master key starts with
+// sealKeyedMagic instead, followed by the key's id, its length also as a
+// big-endian uint16, before the wrapped key.
+var (
+	sealMagic      = []byte("\x00fsenc1\n")
+	sealKeyedMagic = []byte("\x00fsenc2\n")
+)
+
+const (
+	sealSegment  = 64 << 10
+	sealOverhead = 16 // GCM tag per segment
+	// kmsTimeout limits one call of a KMS plugin.
+	kmsTimeout = 10 * time.Second
+)
+
+// keyWrapper wraps and unwraps data keys with a master key it does not
+// hand out: masterKeys holds the key in memory, AWS KMS or a KMS plugin
+// elsewhere.
+type keyWrapper interface {
+	wrap(dataKey []byte) ([]byte, error)
+	unwrap(wrapped []byte) ([]byte, error)
+}
+
+// masterKeys wraps with the first of the server's master keys and unwraps
+// with whichever one a wrapped key names, so files sealed before a key was
+// rotated stay readable while the old key is still listed.
+type masterKeys struct{ s *fileServer }
+
+// masterKeyID names a master key in the keys it wrapped.
+func masterKeyID(key []byte) []byte {
+	sum := sha256.Sum256(key)
+	return sum[:8]
+}
+
+// parseMasterKeys parses AES-256 keys, 64 hex digits per line. The first
+// one encrypts.
+func parseMasterKeys(src string, b []byte) ([][]byte, error) {
+	var keys [][]byte
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, err := hex.DecodeString(text)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("%s:%d: want a 256-bit key as 64 hex digits", src, line)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", src)
+	}
+	return keys, nil
+}
+
+// mergeKeys puts keys first and keeps the older keys it does not repeat,
+// so a rotation in Vault does not lock out files sealed before it.
+func mergeKeys(keys, older [][]byte) [][]byte {
+	merged := append([][]byte(nil), keys...)
+	for _, old := range older {
+		seen := false
+		for _, k := range keys {
+			seen = seen || bytes.Equal(k, old)
+		}
+		if !seen {
+			merged = append(merged, old)
+		}
+	}
+	return merged
+}
+
+func (m masterKeys) wrap(dataKey []byte) ([]byte, error) {
+	keys := m.s.secret().masterKeys
+	if len(keys) == 0 {
+		return nil, errors.New("no master key")
+	}
+	aead, err := newGCM(keys[0])
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte(nil), masterKeyID(keys[0])...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, dataKey, out[:8]), nil
+}
+
+func (m masterKeys) unwrap(wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 8+12 {
+		return nil, errors.New("wrapped key too short")
+	}
+	for _, key := range m.s.secret().masterKeys {
+		if !bytes.Equal(masterKeyID(key), wrapped[:8]) {
+			continue
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		return aead.Open(nil, wrapped[8:20], wrapped[20:], wrapped[:8])
+	}
+	return nil, fmt.Errorf("sealed with master key %x, which is not configured", wrapped[:8])
+}
+
+// kmsPlugin wraps keys by running a command, "<command> wrap" or
+// "<command> unwrap", with the key on stdin and the result on stdout, so
+// any KMS can be used through a small script. Unwrapped keys are cached,
+// since every read of a file needs its key.
+type kmsPlugin struct {
+	args []string
+
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+// kmsCacheSize bounds the cache of unwrapped keys; a full cache starts over.
+const kmsCacheSize = 4096
+
+func newKMSPlugin(command string) (*kmsPlugin, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("kms: empty command")
+	}
+	return &kmsPlugin{args: args, cache: make(map[string][]byte)}, nil
+}
+
+func (k *kmsPlugin) run(op string, in []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kmsTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, k.args[0], append(k.args[1:], op)...)
+	cmd.Stdin = bytes.NewReader(in)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("kms %s: %v: %s", op, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func (k *kmsPlugin) wrap(dataKey []byte) ([]byte, error) { return k.run("wrap", dataKey) }
+
+func (k *kmsPlugin) unwrap(wrapped []byte) ([]byte, error) {
+	k.mu.Lock()
+	key, ok := k.cache[string(wrapped)]
+	k.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	key, err := k.run("unwrap", wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("kms unwrap: got %d bytes, want a 32-byte key", len(key))
+	}
+	k.mu.Lock()
+	if len(k.cache) >= kmsCacheSize {
+		k.cache = make(map[string][]byte)
+	}
+	k.cache[string(wrapped)] = key
+	k.mu.Unlock()
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func segmentNonce(i int64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], uint64(i))
+	return nonce
+}
+
+func segmentAD(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// sealHeader reads the id of the key wrapping the data key of a sealed
+// file, "" for the master key, and the wrapped key, and returns them with
+// the length of the header, or ok=false for a file that is not sealed.
+func sealHeader(r io.Reader) (keyID string, wrapped []byte, n int64, ok bool) {
+	magic := make([]byte, len(sealMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return "", nil, 0, false
+	}
+	keyed := bytes.Equal(magic, sealKeyedMagic)
+	if !keyed && !bytes.Equal(magic, sealMagic) {
+		return "", nil, 0, false
+	}
+	n = int64(len(magic))
+	field := func() ([]byte, bool) {
+		length := make([]byte, 2)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return nil, false
+		}
+		b := make([]byte, binary.BigEndian.Uint16(length))
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, false
+		}
+		n += int64(len(length) + len(b))
+		return b, true
+	}
+	if keyed {
+		id, ok := field()
+		if !ok || len(id) == 0 {
+			return "", nil, 0, false
+		}
+		keyID = string(id)
+	}
+	if wrapped, ok = field(); !ok {
+		return "", nil, 0, false
+	}
+	return keyID, wrapped, n, true
+}
+
+// sealHeaderBytes is the header of a file sealed under the data key
+// wrapped, which the key keyID wraps.
+func sealHeaderBytes(keyID string, wrapped []byte) []byte {
+	head := append([]byte(nil), sealMagic...)
+	if keyID != "" {
+		head = binary.BigEndian.AppendUint16(append([]byte(nil), sealKeyedMagic...), uint16(len(keyID)))
+		head = append(head, keyID...)
+	}
+	head = binary.BigEndian.AppendUint16(head, uint16(len(wrapped)))
+	return append(head, wrapped...)
+}
+
+// sealKey returns the wrapper for the data key of a file stored as name
+// and the id the file records for it: the current key of the name's
+// tenant with -tenant-keys, the master key, "", otherwise.
+func (s *fileServer) sealKey(name string) (string, keyWrapper, error) {
+	if s.tenantKeys == nil || !s.tenantKeys.seal {
+		return "", s.crypt, nil
+	}
+	return s.tenantKeys.current(tenantOf(name))
+}
+
+// unsealKey returns the wrapper that unwraps data keys wrapped by the key
+// keyID.
+func (s *fileServer) unsealKey(keyID string) (keyWrapper, error) {
+	if keyID == "" || s.crypt == nil {
+		return s.crypt, nil
+	}
+	return s.tenantKeys.wrapper(keyID)
+}
+
+// storedKeyID returns the id of the key wrapping the data key of the
+// stored name: "master" for the master key, "" for a file stored plain.
+func (s *fileServer) storedKeyID(name string) string {
+	path := filepath.Join(s.storageDir, name)
+	if !s.onDisk() || layoutOf(s.storageDir, path) != sealedLayout {
+		return ""
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	id, _, _, ok := sealHeader(bufio.NewReader(f))
+	switch {
+	case !ok:
+		return ""
+	case id == "":
+		return "master"
+	}
+	return id
+}
+
+// sealedLength is the content length of a sealed file of size bytes whose
+// header is head bytes long.
+func sealedLength(size, head int64) int64 {
+	body := size - head
+	segments := (body + sealSegment + sealOverhead - 1) / (sealSegment + sealOverhead)
+	if segments == 0 {
+		return 0
+	}
+	return body - segments*sealOverhead
+}
+
+// sealedSize returns the content length of a file that starts like a
+// sealed one, or ok=false. Callers go through storedSealedSize, which only
+// trusts the files recorded as sealed.
+func sealedSize(path string) (size int64, ok bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	_, _, head, ok := sealHeader(bufio.NewReader(f))
+	if !ok {
+		return 0, false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, false
+	}
+	return sealedLength(info.Size(), head), true
+}
+
+// sealWriter seals what is written to it into f under a new data key,
+// which w wraps as the key keyID.
+type sealWriter struct {
+	f    *os.File
+	aead cipher.AEAD
+	buf  []byte
+	n    int64 // segments written
+}
+
+func newSealWriter(f *os.File, keyID string, w keyWrapper) (*sealWriter, error) {
+	if w == nil {
+		return nil, errors.New("no encryption key")
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	wrapped, err := w.wrap(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(sealHeaderBytes(keyID, wrapped)); err != nil {
+		return nil, err
+	}
+	return &sealWriter{f: f, aead: aead, buf: make([]byte, 0, sealSegment)}, nil
+}
+
+func (w *sealWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		// a full segment is only written once more data shows it is not
+		// the last
+		if len(w.buf) == sealSegment {
+			if err := w.flush(false); err != nil {
+				return 0, err
+			}
+		}
+		take := min(len(p), sealSegment-len(w.buf))
+		w.buf = append(w.buf, p[:take]...)
+		p = p[take:]
+	}
+	return n, nil
+}
+
+func (w *sealWriter) flush(last bool) error {
+	if len(w.buf) == sealSegment && last {
+		// a full final segment is followed by an empty one
+		if err := w.flush(false); err != nil {
+			return err
+		}
+	}
+	out := w.aead.Seal(nil, segmentNonce(w.n), w.buf, segmentAD(last))
+	w.n++
+	w.buf = w.buf[:0]
+	_, err := w.f.Write(out)
+	return err
+}
+
+func (w *sealWriter) Close() error {
+	err := w.flush(true)
+	if cerr := w.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// sealFile replaces the plain file at tmp, an upload about to be stored as
+// name, with a sealed copy and returns the copy's path.
+func (s *fileServer) sealFile(name, tmp string) (string, error) {
+	src, err := os.Open(tmp)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	out, err := os.CreateTemp(filepath.Dir(tmp), ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	if err := out.Chmod(0o644); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return "", err
+	}
+	keyID, w, err := s.sealKey(name)
+	var sw *sealWriter
+	if err == nil {
+		sw, err = newSealWriter(out, keyID, w)
+	}
+	if err == nil {
+		_, err = io.Copy(sw, src)
+		if cerr := sw.Close(); err == nil {
+			err = cerr
+		}
+	} else {
+		out.Close()
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("encrypt: %w", err)
+	}
+	os.Remove(tmp)
+	return out.Name(), nil
+}
+
+// verifySealed reports whether the file at path was sealed under one of
+// the server's keys: its first segment authenticates, which no upload
+// made to look sealed does.
+func (s *fileServer) verifySealed(path string) bool {
+	f, err := s.openSealedFile(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return f.segments > 0 && f.load(0) == nil
+}
+
+// sealedFile decrypts a sealed file as it is read, one segment at a time.
+type sealedFile struct {
+	f        *os.File
+	aead     cipher.AEAD
+	head     int64 // header length
+	size     int64 // content length
+	segments int64
+	off      int64
+	cur      int64 // index of the segment in data, -1 if none
+	data     []byte
+}
+
+// openSealedFile opens the sealed file at path.
+func (s *fileServer) openSealedFile(path string) (*sealedFile, error) {
+	if s.crypt == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is encrypted; the server needs -encryption-key", filepath.Base(path))
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	keyID, wrapped, head, ok := sealHeader(f)
+	info, err := f.Stat()
+	if !ok || err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: bad encryption header", path)
+	}
+	w, err := s.unsealKey(keyID)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	key, err := w.unwrap(wrapped)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: unwrap data key: %w", path, err)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	body := info.Size() - head
+	return &sealedFile{
+		f:        f,
+		aead:     aead,
+		head:     head,
+		size:     sealedLength(info.Size(), head),
+		segments: (body + sealSegment + sealOverhead - 1) / (sealSegment + sealOverhead),
+		cur:      -1,
+	}, nil
+}
+
+func (f *sealedFile) Read(p []byte) (int, error) {
+	if f.off >= f.size {
+		return 0, io.EOF
+	}
+	i := f.off / sealSegment
+	if i != f.cur {
+		if err := f.load(i); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, f.data[f.off-i*sealSegment:])
+	f.off += int64(n)
+	return n, nil
+}
+
+func (f *sealedFile) load(i int64) error {
+	buf := make([]byte, sealSegment+sealOverhead)
+	n, err := f.f.ReadAt(buf, f.head+i*(sealSegment+sealOverhead))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	data, err := f.aead.Open(buf[:0], segmentNonce(i), buf[:n], segmentAD(i == f.segments-1))
+	if err != nil {
+		return status.Errorf(codes.DataLoss, "%s: segment %d fails authentication", f.f.Name(), i)
+	}
+	f.cur, f.data = i, data
+	return nil
+}
+
+func (f *sealedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start of file")
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *sealedFile) Close() error { return f.f.Close() }
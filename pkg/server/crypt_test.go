@@ -0,0 +1,50 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// An upload that starts like a sealed file is still a plain file.
+func TestStoredSealedSizeNeedsLayout(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "f")
+	content := append(sealHeaderBytes("", bytes.Repeat([]byte{1}, 40)), bytes.Repeat([]byte{2}, 100)...)
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := storedSealedSize(dir, path); ok {
+		t.Fatal("uploaded sealed file taken as sealed")
+	}
+	if err := setLayout(dir, path, sealedLayout); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := storedSealedSize(dir, path); !ok {
+		t.Fatal("sealed file not read as sealed")
+	}
+}
+
+func TestSealHeader(t *testing.T) {
+	wrapped := []byte("wrapped data key")
+	tests := []struct {
+		name  string
+		keyID string
+	}{
+		{"master key", ""},
+		{"tenant key", "alice:3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			head := sealHeaderBytes(tt.keyID, wrapped)
+			keyID, got, n, ok := sealHeader(bytes.NewReader(append(head, "body"...)))
+			if !ok || keyID != tt.keyID || !bytes.Equal(got, wrapped) || n != int64(len(head)) {
+				t.Fatalf("sealHeader = %q, %q, %d, %v", keyID, got, n, ok)
+			}
+			if _, _, _, ok := sealHeader(bytes.NewReader(head[:len(head)-1])); ok {
+				t.Error("truncated header accepted")
+			}
+		})
+	}
+}
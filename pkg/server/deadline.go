@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// methodDeadline is applied to calls that come without a deadline (def) or
+// with one further out than max.
+type methodDeadline struct {
+	def, max time.Duration
+}
+
+// parseDeadlines parses "Method=dur" (default and cap) or
+// "Method=default/max" items, comma-separated. Methods not listed keep
+// whatever the client sent.
+func parseDeadlines(spec string) (map[string]methodDeadline, error) {
+	out := make(map[string]methodDeadline)
+	for _, item := range splitList(spec) {
+		method, value, ok := strings.Cut(item, "=")
+		if !ok || method == "" {
+			return nil, fmt.Errorf("deadline %q: want Method=duration[/max]", item)
+		}
+		defText, maxText, capped := strings.Cut(value, "/")
+		def, err := time.ParseDuration(defText)
+		if err != nil {
+			return nil, fmt.Errorf("deadline %q: %w", item, err)
+		}
+		max := def
+		if capped {
+			if max, err = time.ParseDuration(maxText); err != nil {
+				return nil, fmt.Errorf("deadline %q: %w", item, err)
+			}
+		}
+		if def <= 0 || max < def {
+			return nil, fmt.Errorf("deadline %q: want 0 < default <= max", item)
+		}
+		out[method] = methodDeadline{def: def, max: max}
+	}
+	return out, nil
+}
+
+// applyDeadline returns ctx with the deadline of fullMethod applied, and
+// whether it changed.
+func applyDeadline(ctx context.Context, deadlines map[string]methodDeadline, fullMethod string) (context.Context, context.CancelFunc, bool) {
+	d, ok := deadlines[path.Base(fullMethod)]
+	if !ok {
+		return ctx, func() {}, false
+	}
+	var timeout time.Duration
+	switch dl, has := ctx.Deadline(); {
+	case !has:
+		timeout = d.def
+	case time.Until(dl) > d.max:
+		timeout = d.max
+	default:
+		return ctx, func() {}, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, true
+}
+
+func unaryDeadlineInterceptor(deadlines map[string]methodDeadline) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, cancel, _ := applyDeadline(ctx, deadlines, info.FullMethod)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
+// streamDeadlineInterceptor ends streams at the server's deadline even while
+// the handler is blocked on the client, like withIdleTimeout does. It
+// returns once the handler has, so the call keeps its slot until then.
+func streamDeadlineInterceptor(deadlines map[string]methodDeadline) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel, changed := applyDeadline(ss.Context(), deadlines, info.FullMethod)
+		defer cancel()
+		if !changed {
+			return handler(srv, ss)
+		}
+		err := handler(srv, &abortStream{ctxStream{ServerStream: ss, ctx: ctx}})
+		if err != nil && ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		return err
+	}
+}
+
+// ctxStream replaces the context of a server stream.
+type ctxStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *ctxStream) Context() context.Context { return s.ctx }
+
+// abortStream is a ctxStream whose RecvMsg and SendMsg give up when its
+// context ends. The transport only unblocks a pending call once the RPC
+// returns, which would wait for the handler that is stuck in it.
+type abortStream struct {
+	ctxStream
+}
+
+func (s *abortStream) RecvMsg(m any) error {
+	return s.wait(func() error { return s.ServerStream.RecvMsg(m) })
+}
+
+func (s *abortStream) SendMsg(m any) error {
+	return s.wait(func() error { return s.ServerStream.SendMsg(m) })
+}
+
+// wait runs op on a goroutine of its own. If the context ends first, op is
+// left to finish when the RPC is torn down.
+func (s *abortStream) wait(op func() error) error {
+	if err := s.ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	done := make(chan error, 1)
+	go func() { done <- op() }()
+	select {
+	case err := <-done:
+		return err
+	case <-s.ctx.Done():
+		return status.FromContextError(s.ctx.Err()).Err()
+	}
+}
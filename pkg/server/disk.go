@@ -0,0 +1,59 @@
+package server
+
+import (
+	"expvar"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const diskCheckInterval = time.Second
+
+var (
+	diskUsedRatio = expvar.NewFloat("storage_disk_used_ratio")
+	// diskFull is 1 while uploads are rejected; alert on it
+	diskFull = expvar.NewInt("storage_disk_full")
+)
+
+// diskGuard rejects uploads once the storage volume fills past high and
+// lets them in again only below low, so uploads do not flap around a
+// single threshold.
+type diskGuard struct {
+	dir       string
+	high, low float64 // used fraction of the volume; high 0 disables
+
+	mu      sync.Mutex
+	checked time.Time
+	over    bool
+}
+
+// full reports whether the volume is above the watermark, refreshing the
+// usage at most once per diskCheckInterval.
+func (g *diskGuard) full() bool {
+	if g == nil || g.high <= 0 {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if time.Since(g.checked) < diskCheckInterval {
+		return g.over
+	}
+	g.checked = time.Now()
+	used, err := diskUsage(g.dir)
+	if err != nil {
+		slog.Warn("disk usage", "dir", g.dir, "error", err)
+		return g.over
+	}
+	diskUsedRatio.Set(used)
+	switch {
+	case !g.over && used >= g.high:
+		g.over = true
+		diskFull.Set(1)
+		slog.Warn("storage full, rejecting uploads", "used_percent", used*100)
+	case g.over && used < g.low:
+		g.over = false
+		diskFull.Set(0)
+		slog.Info("storage below the limit, accepting uploads again", "used_percent", used*100)
+	}
+	return g.over
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+
+package server
+
+import "syscall"
+
+// diskUsage returns the used fraction of the volume holding dir, counting
+// space reserved for root as used since the server cannot write there.
+func diskUsage(dir string) (float64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	if st.Blocks == 0 {
+		return 0, nil
+	}
+	return 1 - float64(st.Bavail)/float64(st.Blocks), nil
+}
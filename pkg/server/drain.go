@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// callsGrace is how long a stopped server waits for cancelled calls to
+// return and remove their staging files before it exits anyway; the
+// staging-cleanup job collects whatever they leave.
+const callsGrace = 10 * time.Second
+
+// checkDraining refuses uploads once the server is shutting down. Chunks
+// of resumable uploads already begun still go through.
+func (s *fileServer) checkDraining(method string) error {
+	if s.draining.Load() && (method == fullMethod("Upload") || method == fullMethod("BeginUpload")) {
+		return status.Error(codes.Unavailable, "server is shutting down, retry on another instance")
+	}
+	return nil
+}
+
+// unaryCalls and streamCalls count the handlers still running. They sit
+// inside the deadline and idle checks, which leave the handler running on
+// its own goroutine when they give up on it.
+func unaryCalls(srv *fileServer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		srv.calls.Add(1)
+		defer srv.calls.Done()
+		return handler(ctx, req)
+	}
+}
+
+func streamCalls(srv *fileServer) grpc.StreamServerInterceptor {
+	return func(srvInterface interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		srv.calls.Add(1)
+		defer srv.calls.Done()
+		return handler(srvInterface, ss)
+	}
+}
+
+// drainOnSignal waits for SIGINT or SIGTERM and then stops the servers:
+// new uploads are refused at once, calls in progress get up to timeout to
+// finish, and those still running after it are cancelled. It returns once
+// their handlers have cleaned up. A second signal kills the process.
+func (s *fileServer) drainOnSignal(grpcServer *grpc.Server, hs *http.Server, timeout time.Duration) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	slog.Info("draining", "signal", (<-sig).String(), "timeout", timeout)
+	signal.Stop(sig)
+	s.draining.Store(true)
+	s.health.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if hs != nil {
+		go hs.Shutdown(ctx)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		slog.Warn("drain timeout, cancelling the calls still running")
+		if hs != nil {
+			hs.Close()
+		}
+		grpcServer.Stop()
+	}
+
+	returned := make(chan struct{})
+	go func() {
+		s.calls.Wait()
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(callsGrace):
+		slog.Warn("calls still running, exiting anyway", "after", callsGrace)
+	}
+}
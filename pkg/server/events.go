@@ -0,0 +1,106 @@
+package server
+
+import (
+	"io/fs"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fileStamp tells versions of a file apart.
+type fileStamp struct{ size, mtime int64 }
+
+// observe turns what recount found into a WatchFiles event, which the next
+// notify of name logs: existed is whether name was stored before, info its
+// stat now or nil. The storage watcher reports most API writes a second
+// time; the stamp of the version seen last makes that no event.
+func (f *changeFeed) observe(name string, existed bool, info fs.FileInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stamps == nil {
+		f.stamps = make(map[string]fileStamp)
+		f.pending = make(map[string]*proto.FileEvent)
+	}
+	ev := &proto.FileEvent{Kind: proto.FileEvent_DELETED}
+	switch {
+	case info == nil && !existed:
+		return
+	case info == nil:
+		delete(f.stamps, name)
+	default:
+		st := fileStamp{size: info.Size(), mtime: info.ModTime().UnixNano()}
+		if existed && f.stamps[name] == st {
+			return
+		}
+		f.stamps[name] = st
+		ev.Kind, ev.SizeBytes = proto.FileEvent_CREATED, st.size
+		if existed {
+			ev.Kind = proto.FileEvent_OVERWRITTEN
+		}
+	}
+	f.pending[name] = ev
+}
+
+// WatchFiles streams the events of the change log from the caller's
+// position on. Messages without a filename carry the position only: the
+// first one, so a client that loses the stream before any event can still
+// resume, and one per changesHeartbeat while nothing happens.
+func (s *fileServer) WatchFiles(req *proto.WatchRequest, stream proto.FileService_WatchFilesServer) error {
+	ctx := stream.Context()
+	match, err := listMatcher(&proto.ListRequest{Prefix: req.GetPrefix(), Pattern: req.GetPattern()})
+	if err != nil {
+		return err
+	}
+	_, epoch, after, _, _ := s.changes.since("", 0)
+	if req.GetEpoch() != "" {
+		if _, _, _, ok, _ := s.changes.since(req.GetEpoch(), req.GetAfterSeq()); !ok {
+			return status.Errorf(codes.OutOfRange, "position %s/%d is not in the change log any more; list the files and watch again", req.GetEpoch(), req.GetAfterSeq())
+		}
+		epoch, after = req.GetEpoch(), req.GetAfterSeq()
+	}
+	if err := stream.Send(&proto.FileEvent{Epoch: epoch, Seq: after}); err != nil {
+		return err
+	}
+	for {
+		recs, _, _, ok, wake := s.changes.since(epoch, after)
+		if !ok {
+			return status.Error(codes.OutOfRange, "the stream fell behind the change log; list the files and watch again")
+		}
+		for _, rec := range recs {
+			after = rec.seq
+			if rec.event == nil || !visible(ctx, rec.name) {
+				continue
+			}
+			shown := shownName(ctx, rec.name)
+			if !match(shown) {
+				continue
+			}
+			ev := &proto.FileEvent{
+				Kind:         rec.event.Kind,
+				Filename:     shown,
+				SizeBytes:    rec.event.SizeBytes,
+				TimeUnixNano: rec.at,
+				Epoch:        epoch,
+				Seq:          rec.seq,
+			}
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		}
+		if len(recs) > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-wake:
+		case <-time.After(changesHeartbeat):
+			// a position update, which also finds a dead connection
+			if err := stream.Send(&proto.FileEvent{Epoch: epoch, Seq: after}); err != nil {
+				return err
+			}
+		}
+	}
+}
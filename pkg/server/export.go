@@ -0,0 +1,63 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ExportManifest streams one entry per file, so manifests of large stores
+// never have to fit in a single message. Files removed while the export
+// runs are left out.
+func (s *fileServer) ExportManifest(req *proto.ExportManifestRequest, stream proto.FileService_ExportManifestServer) error {
+	ctx := stream.Context()
+	alg := req.GetAlgorithm()
+	if alg == "" {
+		alg = "sha256"
+	}
+	if _, err := newHash(alg); err != nil {
+		return err
+	}
+	list, err := s.ListFiles(ctx, &proto.ListRequest{})
+	if err != nil {
+		return err
+	}
+	files := list.Files
+	// peers already answer with the names the caller knows
+	for _, f := range files {
+		f.Filename = shownName(ctx, f.Filename)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Filename < files[j].Filename })
+	for _, f := range files {
+		if !strings.HasPrefix(f.Filename, req.GetPrefix()) {
+			continue
+		}
+		// HashFile routes to the owner, so sharded files are covered too
+		sum, err := s.HashFile(ctx, &proto.HashRequest{Filename: f.Filename, Algorithm: alg})
+		if status.Code(err) == codes.NotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if sum.Hash == "" { // a peer from before HashRequest.algorithm
+			if alg != "sha256" {
+				return status.Errorf(codes.Unimplemented, "the node holding %s only supports sha256", f.Filename)
+			}
+			sum.Hash = sum.Sha256
+		}
+		if err := stream.Send(&proto.ManifestEntry{
+			Filename:   f.Filename,
+			SizeBytes:  sum.SizeBytes,
+			Algorithm:  alg,
+			Hash:       sum.Hash,
+			ModifiedAt: f.ModifiedAt,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// fieldMask is the set of response fields a read_mask asks for.
+type fieldMask map[string]bool
+
+// parseMask checks m against the fields of msg. An empty mask asks for the
+// defaults.
+func parseMask(m *fieldmaskpb.FieldMask, msg protobuf.Message, defaults ...string) (fieldMask, error) {
+	paths := m.GetPaths()
+	if len(paths) == 0 {
+		paths = defaults
+	} else if !m.IsValid(msg) {
+		return nil, status.Errorf(codes.InvalidArgument, "read_mask %v names fields %s does not have",
+			paths, msg.ProtoReflect().Descriptor().Name())
+	}
+	mask := make(fieldMask, len(paths))
+	for _, p := range paths {
+		mask[p] = true
+	}
+	return mask, nil
+}
+
+// has reports whether any of fields is asked for.
+func (m fieldMask) has(fields ...string) bool {
+	for _, f := range fields {
+		if m[f] {
+			return true
+		}
+	}
+	return false
+}
+
+// storedSHA256 returns the SHA-256 of name, from the cache when it still
+// matches info. Unlike checksum it does not thaw cold files.
+func (s *fileServer) storedSHA256(name string, info fs.FileInfo) (string, error) {
+	if sum, ok := s.cachedChecksum(name, info); ok {
+		return sum, nil
+	}
+	if sum, ok := s.blobSum(name); ok {
+		return sum, nil
+	}
+	sum, _, err := s.digest(name, "sha256")
+	if err != nil {
+		return "", err
+	}
+	s.storeChecksum(name, sum)
+	return sum, nil
+}
+
+// describe fills the FileInfo fields in mask for name, whose stat is info.
+func (s *fileServer) describe(name string, info fs.FileInfo, mask fieldMask) (*proto.FileInfo, error) {
+	fi := &proto.FileInfo{Filename: name}
+	// without an index, or for a file it has not caught up with yet, the
+	// record is empty
+	rec := s.meta.get(name)
+	if rec == nil {
+		rec = &fileMeta{Created: info.ModTime()}
+	} else if !rec.current(info) {
+		rec.SHA256, rec.ContentType = "", ""
+	}
+	if mask["created_at"] {
+		fi.CreatedAt = rec.Created.Format(time.RFC3339)
+	}
+	if mask["original_filename"] {
+		fi.OriginalFilename = rec.Original
+	}
+	if mask["uploader"] {
+		fi.Uploader = rec.Uploader
+	}
+	if mask["modified_at"] {
+		fi.ModifiedAt = info.ModTime().Format(time.RFC3339)
+	}
+	if mask["size_bytes"] {
+		fi.SizeBytes = info.Size()
+	}
+	if mask["sha256"] {
+		fi.Sha256 = rec.SHA256
+		if fi.Sha256 == "" {
+			sum, err := s.storedSHA256(name, info)
+			if err != nil {
+				return nil, err
+			}
+			fi.Sha256 = sum
+			s.meta.fill(name, info, sum, "")
+		}
+	}
+	if mask["encryption_key_id"] {
+		fi.EncryptionKeyId = s.storedKeyID(name)
+	}
+	if mask["content_type"] {
+		fi.ContentType = rec.ContentType
+		if fi.ContentType == "" {
+			ct, err := s.sniffType(name)
+			if err != nil {
+				return nil, err
+			}
+			fi.ContentType = ct
+			s.meta.fill(name, info, "", ct)
+		}
+	}
+	return fi, nil
+}
+
+// sniffType returns the content type of the stored name from its first
+// bytes.
+func (s *fileServer) sniffType(name string) (string, error) {
+	f, err := s.readStored(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return contentType(name, head[:n]), nil
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+const followPollInterval = 500 * time.Millisecond
+
+// Follow streams a file from req.FromOffset and keeps the stream open,
+// pushing bytes as they are appended. Follow streams are long-lived, so the
+// stream interceptor does not count them against the transfer slots.
+// Changes seen by the storage watcher wake the stream at once; polling
+// covers the rest.
+func (s *fileServer) Follow(req *proto.FollowRequest, stream proto.FileService_FollowServer) error {
+	filename := s.requestName(stream.Context(), req.GetFilename())
+	if filename == "" {
+		return errors.New("имя файла пустое")
+	}
+	if addr, ctx := s.route(stream.Context(), filename); addr != "" {
+		return s.proxyFollow(ctx, req, stream, addr)
+	}
+
+	changed, unsubscribe := s.changes.subscribe(filename)
+	defer unsubscribe()
+	f, opened, err := s.openStored(filename)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+
+	offset := req.GetFromOffset()
+	if offset < 0 {
+		offset = max(opened.Size()+offset, 0)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	buf := make([]byte, s.chunkSize)
+	idle := false
+	for {
+		n, rerr := f.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&proto.FollowResponse{Data: buf[:n], Offset: offset}); err != nil {
+				return err
+			}
+			offset += int64(n)
+			idle = false
+		}
+		if rerr != nil && rerr != io.EOF {
+			return rerr
+		}
+		if rerr == nil {
+			continue
+		}
+
+		if !idle {
+			if err := stream.Send(&proto.FollowResponse{Offset: offset}); err != nil {
+				return err
+			}
+			idle = true
+		}
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-changed:
+		case <-time.After(followPollInterval):
+		}
+
+		// the file may have been truncated or replaced by a new upload
+		cur, err := s.statStored(filename)
+		if err != nil {
+			continue
+		}
+		if !sameStored(opened, cur) {
+			if nf, info, err := s.openStored(filename); err == nil {
+				f.Close()
+				f, offset, opened = nf, 0, info
+				continue
+			}
+		}
+		if cur.Size() < offset {
+			if _, err := f.Seek(0, io.SeekStart); err == nil {
+				offset = 0
+			}
+		}
+	}
+}
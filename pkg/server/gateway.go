@@ -0,0 +1,382 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// gateway serves the file service over plain HTTP for browsers and curl.
+// It is a client of the gRPC server, so routing, limits and checks apply
+// the same way on both surfaces.
+type gateway struct {
+	client proto.FileServiceClient
+	cors   corsConfig
+}
+
+func newGateway(grpcAddr string, cors corsConfig, creds credentials.TransportCredentials) (*gateway, error) {
+	if strings.HasPrefix(grpcAddr, ":") {
+		grpcAddr = "localhost" + grpcAddr
+	}
+	conn, err := grpc.Dial(grpcAddr, grpc.WithTransportCredentials(creds), grpc.WithStatsHandler(otelgrpc.NewClientHandler()))
+	if err != nil {
+		return nil, err
+	}
+	return &gateway{client: proto.NewFileServiceClient(conn), cors: cors}, nil
+}
+
+func (g *gateway) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /files", g.list)
+	mux.HandleFunc("GET /files/{name}", g.download)
+	mux.HandleFunc("POST /files", g.upload)
+	mux.HandleFunc("GET /links/{token}", g.link)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	return g.cors.wrap(httpRequestID(mux))
+}
+
+// callContext passes the Authorization header of r on as gRPC metadata,
+// so HTTP callers authenticate the same way, along with the request ID,
+// and continues the trace a traceparent header names.
+func callContext(r *http.Request) context.Context {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx = metadata.AppendToOutgoingContext(ctx, requestIDKey, r.Header.Get("X-Request-Id"))
+	if a := r.Header.Get("Authorization"); a != "" {
+		return metadata.AppendToOutgoingContext(ctx, authKey, a)
+	}
+	return ctx
+}
+
+// list answers with a ListResponse as JSON. The query parameters prefix,
+// pattern, uploader, content_type, sort (name, size or modified), desc,
+// fields (comma-separated FileInfo fields), page_size and page_token are
+// those of ListFiles.
+func (g *gateway) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := &proto.ListRequest{
+		Prefix:      q.Get("prefix"),
+		Pattern:     q.Get("pattern"),
+		Uploader:    q.Get("uploader"),
+		ContentType: q.Get("content_type"),
+		PageToken:   q.Get("page_token"),
+	}
+	if v := q.Get("sort"); v != "" {
+		by, ok := proto.ListRequest_Order_value[strings.ToUpper(v)]
+		if !ok {
+			http.Error(w, "sort must be name, size or modified", http.StatusBadRequest)
+			return
+		}
+		req.OrderBy = proto.ListRequest_Order(by)
+	}
+	if v := q.Get("desc"); v != "" {
+		desc, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "desc must be true or false", http.StatusBadRequest)
+			return
+		}
+		req.Descending = desc
+	}
+	if v := q.Get("page_size"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || n < 0 {
+			http.Error(w, "page_size must be a count", http.StatusBadRequest)
+			return
+		}
+		req.PageSize = int32(n)
+	}
+	if v := q.Get("fields"); v != "" {
+		req.ReadMask = &fieldmaskpb.FieldMask{Paths: strings.Split(v, ",")}
+	}
+	resp, err := g.client.ListFiles(callContext(r), req)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// download streams a file, honoring a single-range Range header with 206
+// Partial Content so browsers can seek and resume.
+func (g *gateway) download(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	// one Head gives the size, time and digest of the same version; the
+	// digest is the one recorded for it, computed only on a miss
+	head, err := g.client.Head(callContext(r), &proto.HeadRequest{Filename: name,
+		ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"content_type", "size_bytes", "modified_at", "sha256"}}})
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	size := head.SizeBytes
+	etag := `"` + head.Sha256 + `"`
+	modified, _ := time.Parse(time.RFC3339, head.ModifiedAt)
+
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	code, start, length := http.StatusOK, int64(0), size
+	if rng := r.Header.Get("Range"); strings.HasPrefix(rng, "bytes=") && !strings.Contains(rng, ",") && rangeStillValid(r, etag, modified) {
+		var ok bool
+		if start, length, ok = parseRange(rng, size); !ok {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			http.Error(w, "range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		code = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+	}
+
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Content-Type", head.ContentType)
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	if r.Method == http.MethodHead || length == 0 {
+		w.WriteHeader(code)
+		return
+	}
+
+	stream, err := g.client.Download(callContext(r), &proto.DownloadRequest{Filename: name, Offset: start, Length: length})
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	// the first message carries any error, so read it before committing
+	// to a status line
+	chunk, err := stream.Recv()
+	if err != nil && err != io.EOF {
+		httpError(w, err)
+		return
+	}
+	w.WriteHeader(code)
+	for err == nil {
+		if _, werr := w.Write(chunk.Data); werr != nil {
+			return
+		}
+		chunk, err = stream.Recv()
+	}
+}
+
+// link serves the file a download link grants, as an attachment. The link
+// is the credential, so no Authorization header is needed.
+func (g *gateway) link(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	stream, err := g.client.RedeemDownload(callContext(r), &proto.RedeemRequest{Token: token})
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+	chunk, err := stream.Recv()
+	if err != nil && err != io.EOF {
+		httpError(w, err)
+		return
+	}
+	// the server checked the link; only the name is needed here
+	claims, _, _, _ := decodeLink(token)
+	name := path.Base(claims.File)
+	ct := mime.TypeByExtension(path.Ext(name))
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	if err == nil && chunk.Sha256 != "" {
+		w.Header().Set("ETag", `"`+chunk.Sha256+`"`)
+	}
+	for err == nil {
+		if _, werr := w.Write(chunk.Data); werr != nil {
+			return
+		}
+		chunk, err = stream.Recv()
+	}
+}
+
+// upload accepts multipart/form-data with one or more file parts and
+// streams every part into an Upload call as it is read, so large files are
+// never held in memory. A "signature" field (base64) signs the file part
+// that follows it.
+func (g *gateway) upload(w http.ResponseWriter, r *http.Request) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	type result struct {
+		Filename string `json:"filename"`
+		Ok       bool   `json:"ok"`
+		Message  string `json:"message"`
+	}
+	var results []result
+	var sig []byte
+	buf := make([]byte, 64*1024)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FileName() == "" {
+			if part.FormName() == "signature" {
+				b, _ := io.ReadAll(io.LimitReader(part, 1024))
+				if sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(b))); err != nil {
+					http.Error(w, "signature is not base64", http.StatusBadRequest)
+					return
+				}
+			}
+			continue // plain form field
+		}
+		resp, err := g.uploadPart(r, part.FileName(), part, sig, buf)
+		sig = nil
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		results = append(results, result{Filename: resp.Filename, Ok: resp.Ok, Message: resp.Message})
+	}
+	if len(results) == 0 {
+		http.Error(w, "no file parts in form", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(results)
+}
+
+func (g *gateway) uploadPart(r *http.Request, name string, body io.Reader, sig, buf []byte) (*proto.UploadResponse, error) {
+	if reservedName(name) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is a reserved name", name)
+	}
+	stream, err := g.client.Upload(callContext(r))
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(&proto.UploadRequest{Filename: name, Signature: sig}); err != nil {
+		return stream.CloseAndRecv()
+	}
+	for {
+		n, rerr := body.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&proto.UploadRequest{Data: buf[:n]}); err != nil {
+				return stream.CloseAndRecv()
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return nil, status.Error(codes.InvalidArgument, rerr.Error())
+		}
+	}
+	return stream.CloseAndRecv()
+}
+
+// notModified evaluates If-None-Match and, when that is absent,
+// If-Modified-Since (RFC 9110 section 13.2.2).
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modified.Truncate(time.Second).After(since)
+}
+
+// rangeStillValid evaluates If-Range: a resumed download may only get a
+// part of the file if the file is still the version the client has.
+func rangeStillValid(r *http.Request, etag string, modified time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) {
+		return ir == etag
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && modified.Truncate(time.Second).Equal(t)
+}
+
+// parseRange parses a single "bytes=first-last" or "bytes=-suffix" range
+// against size and returns the start and length of the satisfiable part.
+func parseRange(h string, size int64) (start, length int64, ok bool) {
+	first, last, found := strings.Cut(strings.TrimSpace(strings.TrimPrefix(h, "bytes=")), "-")
+	if !found {
+		return 0, 0, false
+	}
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		n = min(n, size)
+		return size - n, n, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		e, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || e < start {
+			return 0, 0, false
+		}
+		end = min(e, end)
+	}
+	return start, end - start + 1, true
+}
+
+// httpError writes err with the HTTP status matching its gRPC code.
+func httpError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch status.Code(err) {
+	case codes.NotFound:
+		code = http.StatusNotFound
+	case codes.InvalidArgument, codes.FailedPrecondition:
+		code = http.StatusBadRequest
+	case codes.OutOfRange:
+		code = http.StatusRequestedRangeNotSatisfiable
+	case codes.ResourceExhausted:
+		code = http.StatusTooManyRequests
+	case codes.Unauthenticated:
+		code = http.StatusUnauthorized
+	case codes.PermissionDenied:
+		code = http.StatusForbidden
+	case codes.Unavailable:
+		code = http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		code = http.StatusGatewayTimeout
+	}
+	http.Error(w, status.Convert(err).Message(), code)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header        string
+		size          int64
+		start, length int64
+		ok            bool
+	}{
+		{"bytes=0-99", 1000, 0, 100, true},
+		{"bytes=100-", 1000, 100, 900, true},
+		{"bytes=990-2000", 1000, 990, 10, true},
+		{"bytes=999-999", 1000, 999, 1, true},
+		{"bytes=-100", 1000, 900, 100, true},
+		{"bytes=-5000", 1000, 0, 1000, true},
+		{"bytes=1000-", 1000, 0, 0, false},
+		{"bytes=5-4", 1000, 0, 0, false},
+		{"bytes=-0", 1000, 0, 0, false},
+		{"bytes=-1", 0, 0, 0, false},
+		{"bytes=0-", 0, 0, 0, false},
+		{"bytes=-1-5", 1000, 0, 0, false},
+		{"bytes=0-1,5-6", 1000, 0, 0, false},
+		{"bytes=a-b", 1000, 0, 0, false},
+		{"bytes=5", 1000, 0, 0, false},
+		{"bytes=9223372036854775808-", 1000, 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, length, ok := parseRange(tt.header, tt.size)
+		if start != tt.start || length != tt.length || ok != tt.ok {
+			t.Errorf("parseRange(%q, %d) = %d, %d, %v; want %d, %d, %v",
+				tt.header, tt.size, start, length, ok, tt.start, tt.length, tt.ok)
+		}
+	}
+}
+
+func TestRangeStillValid(t *testing.T) {
+	modified := time.Date(2025, 1, 15, 10, 30, 0, 500, time.UTC)
+	etag := `"abc"`
+	tests := []struct {
+		ifRange string
+		want    bool
+	}{
+		{"", true},
+		{`"abc"`, true},
+		{`"abd"`, false},
+		{`W/"abc"`, false},
+		{modified.Format(http.TimeFormat), true},
+		{modified.Add(time.Second).Format(http.TimeFormat), false},
+		{"yesterday", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.ifRange != "" {
+			r.Header.Set("If-Range", tt.ifRange)
+		}
+		if got := rangeStillValid(r, etag, modified); got != tt.want {
+			t.Errorf("If-Range %q: %v, want %v", tt.ifRange, got, tt.want)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	modified := time.Date(2025, 1, 15, 10, 30, 0, 500, time.UTC)
+	etag := `"abc"`
+	tests := []struct {
+		name, header, value string
+		want                bool
+	}{
+		{"no conditions", "", "", false},
+		{"same etag", "If-None-Match", `"abc"`, true},
+		{"weak etag", "If-None-Match", `W/"abc"`, true},
+		{"one of several", "If-None-Match", `"x", "abc"`, true},
+		{"any", "If-None-Match", "*", true},
+		{"other etag", "If-None-Match", `"x"`, false},
+		{"not modified since", "If-Modified-Since", modified.Format(http.TimeFormat), true},
+		{"modified since", "If-Modified-Since", modified.Add(-time.Second).Format(http.TimeFormat), false},
+		{"bad date", "If-Modified-Since", "yesterday", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		if got := notModified(r, etag, modified); got != tt.want {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,584 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	replicateRetry    = 5 * time.Second
+	replicateSaveEach = 100 // applied changes between state saves
+	changesHeartbeat  = 10 * time.Second
+)
+
+// replicationVars holds per-site lag metrics, served under /debug/vars.
+var replicationVars = expvar.NewMap("replication")
+
+var siteName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// parseReplicas reads -replicate: comma-separated site=addr peers.
+func parseReplicas(spec string) (map[string]string, error) {
+	peers := make(map[string]string)
+	for _, item := range splitList(spec) {
+		site, addr, ok := strings.Cut(item, "=")
+		if !ok || addr == "" || !siteName.MatchString(site) {
+			return nil, fmt.Errorf("bad replica %q, want site=addr", item)
+		}
+		peers[site] = addr
+	}
+	return peers, nil
+}
+
+// replicator pulls the changes of one peer site into this one. Both sites
+// run one for the other, so changes flow both ways asynchronously.
+//
+// For every file it remembers the content both sites last agreed on.
+// Changes carry the sender's idea of it, so a change made on top of what
+// this site holds is applied, and one made while this site changed the
+// file too is a conflict: the later write wins, with the site name as tie
+// breaker, and in rename mode the losing version is kept under a
+// .conflict- name. Both sites see the same two versions and pick the same
+// winner, so they converge without talking about it.
+type replicator struct {
+	s      *fileServer
+	site   string // the peer
+	addr   string
+	rename bool
+	path   string // saved position and agreed contents
+
+	mu        sync.Mutex
+	down      time.Time // since when the peer is unreachable, zero while connected
+	epoch     string
+	seq       uint64
+	synced    map[string]string // name -> sha256 both sites hold
+	unsaved   int
+	connected bool
+	head      uint64
+	recorded  int64 // log time of the last applied change
+	applied   int64
+	conflicts int64
+	lastErr   string
+}
+
+type replicaState struct {
+	Epoch  string            `json:"epoch"`
+	Seq    uint64            `json:"seq"`
+	Synced map[string]string `json:"synced"`
+}
+
+func newReplicator(s *fileServer, site, addr string, rename bool) *replicator {
+	r := &replicator{
+		s: s, site: site, addr: addr, rename: rename,
+		path:   filepath.Join(s.storageDir, ".replication", site+".json"),
+		synced: make(map[string]string),
+		down:   time.Now(),
+	}
+	var st replicaState
+	if b, err := os.ReadFile(r.path); err == nil && json.Unmarshal(b, &st) == nil {
+		r.epoch, r.seq = st.Epoch, st.Seq
+		if st.Synced != nil {
+			r.synced = st.Synced
+		}
+	}
+	replicationVars.Set(site, expvar.Func(r.stats))
+	return r
+}
+
+func (r *replicator) stats() any {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lag := 0.0
+	if r.seq < r.head && r.recorded > 0 {
+		lag = time.Since(time.Unix(0, r.recorded)).Seconds()
+	}
+	return map[string]any{
+		"connected":   r.connected,
+		"lag_seconds": lag,
+		"pending":     r.head - min(r.seq, r.head),
+		"applied":     r.applied,
+		"conflicts":   r.conflicts,
+		"last_error":  r.lastErr,
+	}
+}
+
+// downFor returns how long the peer has been unreachable.
+func (r *replicator) downFor() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.down.IsZero() {
+		return 0
+	}
+	return time.Since(r.down)
+}
+
+func (r *replicator) base(name string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.synced[name]
+}
+
+// agree records that both sites hold sum for name; "" means neither has it.
+func (r *replicator) agree(name, sum string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sum == "" {
+		delete(r.synced, name)
+	} else {
+		r.synced[name] = sum
+	}
+}
+
+func (r *replicator) save() error {
+	r.mu.Lock()
+	b, err := json.Marshal(replicaState{Epoch: r.epoch, Seq: r.seq, Synced: r.synced})
+	r.unsaved = 0
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(r.path, b)
+}
+
+// run follows the peer forever, reconnecting after errors.
+func (r *replicator) run() {
+	for {
+		err := r.follow()
+		r.mu.Lock()
+		if r.connected {
+			r.down = time.Now()
+		}
+		r.connected = false
+		if err != nil {
+			r.lastErr = err.Error()
+		}
+		r.mu.Unlock()
+		slog.Warn("replicate", "site", r.site, "error", err)
+		time.Sleep(replicateRetry)
+	}
+}
+
+func (r *replicator) follow() error {
+	c, err := r.s.peers.client(r.addr)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if token := r.s.secret().adminToken; token != "" {
+		// a peer with authentication serves the feed to admins only
+		ctx = metadata.AppendToOutgoingContext(ctx, adminTokenKey, token)
+	}
+	r.mu.Lock()
+	req := &proto.ChangesRequest{Site: r.s.site, Epoch: r.epoch, AfterSeq: r.seq}
+	r.mu.Unlock()
+	stream, err := c.Changes(ctx, req)
+	if err != nil {
+		return err
+	}
+	for {
+		ch, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if ch.Site != r.site {
+			return fmt.Errorf("%s is site %q, not %q", r.addr, ch.Site, r.site)
+		}
+		if ch.Filename != "" {
+			if err := r.apply(ctx, c, ch); err != nil {
+				return fmt.Errorf("%s: %w", ch.Filename, err)
+			}
+		}
+		r.mu.Lock()
+		r.connected, r.down, r.lastErr, r.head = true, time.Time{}, "", ch.HeadSeq
+		if ch.Epoch != "" {
+			r.epoch, r.seq = ch.Epoch, ch.Seq
+		}
+		if ch.Filename != "" {
+			r.recorded = ch.RecordedUnixNano
+			r.applied++
+			r.unsaved++
+		}
+		save := r.unsaved >= replicateSaveEach || (ch.Filename == "" && r.unsaved > 0)
+		r.mu.Unlock()
+		if save {
+			if err := r.save(); err != nil {
+				slog.Warn("replicate: save state", "site", r.site, "error", err)
+			}
+		}
+	}
+}
+
+// localVersion describes the stored content of name.
+type localVersion struct {
+	exists bool
+	sum    string
+	mtime  int64
+}
+
+func (s *fileServer) localVersion(name string) (localVersion, error) {
+	info, err := s.statStored(name)
+	if status.Code(err) == codes.NotFound {
+		return localVersion{}, nil
+	}
+	if err != nil {
+		return localVersion{}, err
+	}
+	sum, err := s.storedSHA256(name, info)
+	if err != nil {
+		return localVersion{}, err
+	}
+	return localVersion{exists: true, sum: sum, mtime: info.ModTime().UnixNano()}, nil
+}
+
+// apply brings one change of the peer into this site.
+func (r *replicator) apply(ctx context.Context, c proto.FileServiceClient, ch *proto.Change) error {
+	name := ch.Filename
+	if !validStoredName(name) {
+		slog.Warn("replicate: skipping a bad name", "site", r.site, "file", name)
+		return nil
+	}
+	for attempt := 0; attempt < 3; attempt++ {
+		local, err := r.s.localVersion(name)
+		if err != nil {
+			return err
+		}
+		var (
+			fetchAs   string // where the peer's version goes
+			keepLocal string // where this site's version is moved first
+			remove    bool
+		)
+		base := ch.BaseSha256
+		switch {
+		case ch.Deleted && !local.exists:
+			r.agree(name, "")
+			return nil
+		case ch.Deleted && base != "" && local.sum == base:
+			remove = true
+		case ch.Deleted:
+			// changed here since the peer last saw it: the change wins over
+			// the delete and reaches the peer in turn
+			if base != "" {
+				r.conflict(name, "deleted on %s, changed here; keeping it", r.site)
+			}
+			return nil
+		case local.exists && local.sum == ch.Sha256:
+			r.agree(name, ch.Sha256)
+			return nil
+		case ch.Sha256 == base:
+			// nothing new on the peer, only its view of an older write
+			r.agree(name, base)
+			return nil
+		case !local.exists || local.sum == base:
+			fetchAs = name
+		default:
+			remoteWins := ch.ModifiedUnixNano > local.mtime ||
+				ch.ModifiedUnixNano == local.mtime && ch.Site > r.s.site
+			switch {
+			case remoteWins && r.rename:
+				fetchAs, keepLocal = name, conflictName(name, r.s.site, local.mtime)
+			case remoteWins:
+				fetchAs = name
+			case r.rename:
+				fetchAs = conflictName(name, ch.Site, ch.ModifiedUnixNano)
+			}
+			winner := r.s.site
+			if remoteWins {
+				winner = r.site
+			}
+			r.conflict(name, "changed on both %s and %s, %s's version wins", r.site, r.s.site, winner)
+			if fetchAs == "" {
+				return nil // ours wins and reaches the peer in turn
+			}
+		}
+
+		var tmp string
+		var sum string
+		var pieces [][]byte
+		if fetchAs != "" {
+			if tmp, sum, pieces, err = r.s.stageFrom(func() (io.ReadCloser, error) { return downloadReader(ctx, c, name) }); err != nil {
+				return err
+			}
+			if sum != ch.Sha256 {
+				// changed again on the peer; its newer change follows
+				os.Remove(tmp)
+				return nil
+			}
+		}
+
+		unlock, err := r.s.locks.Lock(ctx, name)
+		if err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if now, err := r.s.localVersion(name); err != nil || now != local {
+			unlock()
+			os.Remove(tmp)
+			continue // written here meanwhile, decide again
+		}
+		err = r.commit(name, local, fetchAs, keepLocal, remove, tmp, sum, pieces, ch)
+		unlock()
+		os.Remove(tmp)
+		if err != nil {
+			return err
+		}
+		if remove {
+			r.agree(name, "")
+		} else if fetchAs == name {
+			r.agree(name, ch.Sha256)
+		}
+		return nil
+	}
+	return fmt.Errorf("kept changing here while replicating")
+}
+
+// commit carries out what apply decided, with the lock of name held.
+func (r *replicator) commit(name string, local localVersion, fetchAs, keepLocal string, remove bool, tmp, sum string, pieces [][]byte, ch *proto.Change) error {
+	if keepLocal != "" {
+		ktmp, ksum, kpieces, err := r.s.stageFrom(func() (io.ReadCloser, error) { return r.s.readStored(name) })
+		if err != nil {
+			return err
+		}
+		err = r.s.publishReplica(keepLocal, ktmp, ksum, kpieces, local.mtime, true)
+		os.Remove(ktmp)
+		if err != nil {
+			return err
+		}
+	}
+	if remove {
+		if err := r.s.removeStored(name); err != nil {
+			return err
+		}
+		r.s.cold.forget(name)
+		r.s.changes.notify(name)
+		return nil
+	}
+	return r.s.publishReplica(fetchAs, tmp, sum, pieces, ch.ModifiedUnixNano, fetchAs != name)
+}
+
+func (r *replicator) conflict(name, format string, args ...any) {
+	r.mu.Lock()
+	r.conflicts++
+	r.mu.Unlock()
+	slog.Warn("replication conflict", "file", name, "detail", fmt.Sprintf(format, args...))
+}
+
+// conflictName is where the losing version of a conflict is kept in
+// rename mode. Both sites derive the same name from the same version.
+func conflictName(name, site string, mtime int64) string {
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s.conflict-%s-%s%s", strings.TrimSuffix(name, ext), site,
+		time.Unix(0, mtime).UTC().Format("20060102T150405.000000000"), ext)
+}
+
+func downloadReader(ctx context.Context, c proto.FileServiceClient, name string) (io.ReadCloser, error) {
+	stream, err := c.Download(ctx, &proto.DownloadRequest{Filename: name})
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				pw.Close()
+				return
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := pw.Write(resp.Data); err != nil {
+				return
+			}
+		}
+	}()
+	return pr, nil
+}
+
+// stageFrom copies what open returns into the staging dir, hashing it on
+// the way.
+func (s *fileServer) stageFrom(open func() (io.ReadCloser, error)) (tmp, sum string, pieces [][]byte, err error) {
+	src, err := open()
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer src.Close()
+	f, err := s.stage()
+	if err != nil {
+		return "", "", nil, err
+	}
+	h := sha256.New()
+	ph := newPieceHasher()
+	_, err = io.Copy(io.MultiWriter(f, h, ph), src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", "", nil, err
+	}
+	return f.Name(), hex.EncodeToString(h.Sum(nil)), ph.sum(), nil
+}
+
+// publishReplica stores the staged tmp as name with the writer's mtime, so
+// conflict resolution on both sites compares the same times. Packed files
+// keep the time they were packed. lock is set when the caller does not hold
+// the lock of name.
+func (s *fileServer) publishReplica(name, tmp, sum string, pieces [][]byte, mtime int64, lock bool) error {
+	if lock {
+		unlock, err := s.locks.Lock(context.Background(), name)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	if err := s.importStored(name, tmp); err != nil {
+		return fmt.Errorf("store %s: %w", name, err)
+	}
+	t := time.Unix(0, mtime)
+	if err := os.Chtimes(filepath.Join(s.storageDir, name), t, t); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.storeChecksum(name, sum)
+	s.storePieces(name, pieces)
+	s.cold.forget(name)
+	s.changes.notify(name)
+	return nil
+}
+
+// Changes streams this site's changes to a replicating peer. Each change
+// is the current state of the file, read under its lock so uploads in
+// progress are never sent half written.
+func (s *fileServer) Changes(req *proto.ChangesRequest, stream proto.FileService_ChangesServer) error {
+	if s.auth {
+		// the feed names every user's files
+		if err := s.requireAdmin(stream.Context()); err != nil {
+			return err
+		}
+	}
+	if req.GetSite() == "" {
+		return status.Error(codes.InvalidArgument, "site is required")
+	}
+	if req.GetSite() == s.site {
+		return status.Errorf(codes.InvalidArgument, "site %q replicating from itself", s.site)
+	}
+	rep := s.replicas[req.GetSite()] // nil for a one-way reader
+	ctx := stream.Context()
+	epoch, after := req.GetEpoch(), req.GetAfterSeq()
+	for {
+		recs, cur, head, ok, wake := s.changes.since(epoch, after)
+		if !ok {
+			if err := s.sendSnapshot(stream, rep, head); err != nil {
+				return err
+			}
+			epoch, after = cur, head
+			if err := stream.Send(&proto.Change{Epoch: epoch, Seq: after, HeadSeq: head, Site: s.site}); err != nil {
+				return err
+			}
+			continue
+		}
+		last := make(map[string]uint64, len(recs))
+		for _, rec := range recs {
+			last[rec.name] = rec.seq
+		}
+		for _, rec := range recs {
+			if last[rec.name] != rec.seq {
+				continue // the later entry sends the same current state
+			}
+			ch, err := s.changeOf(ctx, rec.name, rep)
+			if err != nil {
+				return err
+			}
+			ch.Epoch, ch.Seq, ch.HeadSeq, ch.RecordedUnixNano = cur, rec.seq, head, rec.at
+			if err := stream.Send(ch); err != nil {
+				return err
+			}
+			after = rec.seq
+		}
+		if len(recs) > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-wake:
+		case <-time.After(changesHeartbeat):
+			if err := stream.Send(&proto.Change{Epoch: epoch, Seq: after, HeadSeq: head, Site: s.site}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendSnapshot sends every stored file, and deletions of files the peer
+// was known to hold, for a peer whose log position is lost.
+func (s *fileServer) sendSnapshot(stream proto.FileService_ChangesServer, rep *replicator, head uint64) error {
+	names, err := s.store.List()
+	if err != nil {
+		return err
+	}
+	if rep != nil {
+		have := make(map[string]bool, len(names))
+		for _, n := range names {
+			have[n] = true
+		}
+		rep.mu.Lock()
+		for n := range rep.synced {
+			if !have[n] {
+				names = append(names, n)
+			}
+		}
+		rep.mu.Unlock()
+	}
+	now := time.Now().UnixNano()
+	for _, name := range names {
+		ch, err := s.changeOf(stream.Context(), name, rep)
+		if err != nil {
+			return err
+		}
+		ch.HeadSeq, ch.RecordedUnixNano = head, now
+		if err := stream.Send(ch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileServer) changeOf(ctx context.Context, name string, rep *replicator) (*proto.Change, error) {
+	unlock, err := s.locks.Lock(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	v, err := s.localVersion(name)
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+	ch := &proto.Change{Filename: name, Site: s.site, Deleted: !v.exists, Sha256: v.sum, ModifiedUnixNano: v.mtime}
+	if v.exists {
+		if info, err := s.statStored(name); err == nil {
+			ch.SizeBytes = info.Size()
+		}
+	}
+	if rep != nil {
+		ch.BaseSha256 = rep.base(name)
+	}
+	return ch, nil
+}
@@ -0,0 +1,563 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/pkg/limiter"
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/status"
+)
+
+// fileServer implements proto.FileServiceServer
+type fileServer struct {
+	proto.UnimplementedFileServiceServer
+	storageDir        string
+	limiter           *limiter.Limiter
+	locks             fileLocker
+	ring              *shardRing
+	peers             *peerPool
+	relay             []relayRule
+	relayCacheTTL     time.Duration
+	chunking          bool
+	blobPool          bool // with chunking: a file is one chunk, named by its SHA-256
+	packs             *packStore
+	cold              *coldStore
+	disk              *diskGuard
+	usage             *usage
+	meta              *metaIndex  // nil without -meta-index
+	crypt             keyWrapper  // seals stored files; nil without -encryption-key
+	tenantKeys        *tenantKeys // nil without -encryption-key
+	changes           changeFeed
+	secrets           atomic.Pointer[serverSecrets]
+	auth              bool // calls authenticate and are scoped to the user's namespace
+	moderator         moderator
+	moderationTimeout time.Duration
+	idleTimeout       time.Duration // aborts transfers stalled by the peer, 0 disables
+	bandwidth         *bandwidth    // paces transfers
+	chunkSize         int           // file data per streamed message
+	maxFileSize       int64         // largest upload accepted, 0 for no limit
+	maxChunkSize      int           // most data in one Upload message, 0 for no limit
+	keepVersions      int           // replaced versions kept per file, 0 keeps none
+	retention         retentionPolicy
+	links             linkPolicy
+	jobs              *scheduler
+	importRoots       []string // host dirs ImportFiles may read, none disables it
+	site              string
+	replicas          map[string]*replicator // by peer site
+	mirrors           []*mirror              // -mirror targets, pushed to
+	readOnly          bool                   // a replica of primary
+	primary           string
+	failoverAfter     time.Duration
+	takenOver         atomic.Bool    // a read-only replica accepting writes
+	cache             *downloadCache // nil disables it
+	chunks            *chunkIndex
+	store             fileStore
+	draining          atomic.Bool    // shutting down, refusing new uploads
+	calls             sync.WaitGroup // handlers running
+	health            *health.Server
+}
+
+// checkSize refuses an upload that has grown past -max-file-size or would
+// not fit in the quotas.
+func (s *fileServer) checkSize(name string, size int64) error {
+	if s.maxFileSize > 0 && size > s.maxFileSize {
+		return status.Errorf(codes.ResourceExhausted, "%s: larger than the %d byte limit", name, s.maxFileSize)
+	}
+	return s.usage.checkQuota(name, size)
+}
+
+// checkChunk refuses an Upload message carrying more than -max-chunk-size
+// bytes of data.
+func (s *fileServer) checkChunk(name string, n int) error {
+	if s.maxChunkSize > 0 && n > s.maxChunkSize {
+		return status.Errorf(codes.ResourceExhausted, "%s: chunk of %d bytes is over the %d byte limit", name, n, s.maxChunkSize)
+	}
+	return nil
+}
+
+// fileError maps filesystem errors onto gRPC status codes.
+func fileError(name string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return status.Errorf(codes.NotFound, "%s: not found", name)
+	}
+	return err
+}
+
+func sanitizeFilename(name string) string {
+	name = filepath.Base(name)
+	name = strings.ReplaceAll(name, string(os.PathSeparator), "_")
+	return name
+}
+
+func (s *fileServer) Upload(stream proto.FileService_UploadServer) error {
+	if err := os.MkdirAll(s.storageDir, 0o755); err != nil {
+		return fmt.Errorf("mkdir error: %w", err)
+	}
+
+	var filename, original string
+	var staged *os.File // receives the upload until it is complete
+	var sig []byte
+	var want string // checksum the client announced
+	var size int64
+	defer func() {
+		if staged != nil {
+			staged.Close()
+			os.Remove(staged.Name())
+		}
+	}()
+	sum := sha256.New()
+	pieces := newPieceHasher()
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			if staged == nil {
+				return stream.SendAndClose(&proto.UploadResponse{Ok: true, Message: "успешно", Filename: filename})
+			}
+			if cerr := staged.Close(); cerr != nil {
+				return fmt.Errorf("store %s: %w", filename, cerr)
+			}
+			if cerr := checkUpload(filename, want, hex.EncodeToString(sum.Sum(nil))); cerr != nil {
+				return cerr
+			}
+			if s.secret().uploadKeys != nil {
+				if verr := s.verifyUpload(original, sum.Sum(nil), sig); verr != nil {
+					return verr
+				}
+			}
+			return s.publishStaged(stream, filename, original, staged.Name(), hex.EncodeToString(sum.Sum(nil)), pieces.sum())
+		}
+		if err != nil {
+			// the deferred cleanup drops what arrived so far
+			return err
+		}
+
+		if filename == "" {
+			original = req.GetFilename()
+			filename = s.requestName(stream.Context(), original)
+			if filename == "" {
+				return errors.New("название обязательно")
+			}
+			if reservedName(filename) {
+				return status.Errorf(codes.InvalidArgument, "%s is a reserved name", original)
+			}
+			if upstream := s.relayUpstream(filename); upstream != "" {
+				return s.relayUpload(stream, req, filename, upstream)
+			}
+			if owner := s.remoteOwner(stream.Context(), filename); owner != "" {
+				return s.proxyUpload(s.forwardContext(stream.Context()), stream, req, owner)
+			}
+			if s.disk.full() {
+				return status.Error(codes.ResourceExhausted, "storage is above the high watermark")
+			}
+			if req.GetUploadId() != "" {
+				return s.resumeUpload(stream, req, filename)
+			}
+			if req.GetOffset() != 0 {
+				return status.Error(codes.InvalidArgument, "offset needs an upload_id from BeginUpload")
+			}
+			if req.GetSha256() != "" {
+				if want, err = expectedSHA256(req.GetSha256()); err != nil {
+					return err
+				}
+			}
+			unlock, lerr := s.locks.Lock(stream.Context(), filename)
+			if lerr != nil {
+				return lerr
+			}
+			defer unlock()
+			// the upload is written to a staging file and replaces the
+			// stored version only once it is complete and has passed the
+			// checks, so an aborted upload leaves nothing behind
+			var ferr error
+			if staged, ferr = s.stage(); ferr != nil {
+				return fmt.Errorf("файл успешно создан: %w", ferr)
+			}
+		}
+
+		if len(req.GetSignature()) > 0 {
+			sig = req.GetSignature()
+		}
+		if len(req.GetData()) > 0 {
+			if serr := s.checkChunk(filename, len(req.GetData())); serr != nil {
+				return serr
+			}
+			size += int64(len(req.GetData()))
+			if serr := s.checkSize(filename, size); serr != nil {
+				return serr
+			}
+			if _, werr := staged.Write(req.GetData()); werr != nil {
+				return fmt.Errorf("ошибка чтения: %w", werr)
+			}
+			sum.Write(req.GetData())
+			pieces.Write(req.GetData())
+		}
+	}
+}
+
+func (s *fileServer) Download(req *proto.DownloadRequest, stream proto.FileService_DownloadServer) error {
+	filename := s.requestName(stream.Context(), req.GetFilename())
+	if filename == "" {
+		return errors.New("имя файла пустое")
+	}
+	var f io.ReadSeekCloser
+	var info fs.FileInfo
+	if upstream := s.relayUpstream(filename); upstream != "" {
+		cached, cachedInfo, ok := s.openRelayCache(filename)
+		if !ok {
+			return s.relayDownload(req, stream, filename, upstream)
+		}
+		f, info = cached, cachedInfo
+	} else if owner := s.remoteOwner(stream.Context(), filename); owner != "" {
+		return s.proxyDownload(s.forwardContext(stream.Context()), req, stream, owner, nil)
+	} else {
+		var err error
+		if f, info, err = s.openCached(filename); err != nil {
+			return err
+		}
+	}
+	defer f.Close()
+
+	offset, length := req.GetOffset(), req.GetLength()
+	if offset < 0 || length < 0 {
+		return status.Error(codes.InvalidArgument, "negative offset or length")
+	}
+
+	if length > 0 && offset+length > info.Size() {
+		return status.Errorf(codes.OutOfRange, "range of %d bytes at %d is past the end of %s (%d bytes)", length, offset, filename, info.Size())
+	}
+	var r io.Reader = f
+	if offset > 0 {
+		if offset > info.Size() {
+			return status.Errorf(codes.OutOfRange, "offset %d is past the end of %s (%d bytes)", offset, filename, info.Size())
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	if length > 0 {
+		r = io.LimitReader(f, length)
+	}
+
+	// the first message carries the recorded checksum, on its own for an
+	// empty file
+	recorded, _ := s.cachedChecksum(filename, info)
+	buf := make([]byte, s.chunkSize)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 || (rerr == io.EOF && recorded != "") {
+			if serr := stream.Send(&proto.DownloadResponse{Data: buf[:n], Sha256: recorded}); serr != nil {
+				return serr
+			}
+			recorded = ""
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+	return nil
+}
+
+func (s *fileServer) ListFiles(ctx context.Context, req *proto.ListRequest) (*proto.ListResponse, error) {
+	if err := os.MkdirAll(s.storageDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	mask, err := parseMask(req.GetReadMask(), &proto.FileInfo{}, "filename", "created_at", "modified_at", "size_bytes")
+	if err != nil {
+		return nil, err
+	}
+	// names alone come from the store's listing; anything else needs a
+	// stat, which opens chunked and compressed files
+	stat := mask.has("created_at", "modified_at", "size_bytes", "sha256", "content_type", "original_filename", "uploader")
+	token, err := decodePageToken(req)
+	if err != nil {
+		return nil, err
+	}
+	match, err := listMatcher(req)
+	if err != nil {
+		return nil, err
+	}
+	order := req.GetOrderBy()
+
+	entries, err := s.listLocal(ctx, req, match)
+	if err != nil {
+		return nil, err
+	}
+	if order != proto.ListRequest_NAME {
+		// sorting by size or time needs every file's stat, not only the page's
+		kept := entries[:0]
+		for _, e := range entries {
+			if e.info == nil {
+				if e.info, err = s.statStored(e.name); err != nil {
+					continue
+				}
+			}
+			e.sortKey(order)
+			kept = append(kept, e)
+		}
+		entries = kept
+	}
+	if s.ring != nil && !isForwarded(ctx) {
+		peerFiles, err := s.listPeers(ctx, peerListRequest(req, mask))
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range peerFiles {
+			e := &listEntry{shown: shownName(ctx, fi.GetFilename()), fi: fi}
+			e.sortKey(order)
+			if !mask["size_bytes"] {
+				fi.SizeBytes = 0
+			}
+			if !mask["modified_at"] {
+				fi.ModifiedAt = ""
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	page, next := pageOf(req, entries, token)
+	files := make([]*proto.FileInfo, 0, len(page))
+	for _, e := range page {
+		if e.fi != nil {
+			files = append(files, e.fi)
+			continue
+		}
+		if !stat {
+			files = append(files, &proto.FileInfo{Filename: e.name})
+			continue
+		}
+		if e.info == nil {
+			if e.info, err = s.statStored(e.name); err != nil {
+				continue // removed while listing
+			}
+		}
+		fi, err := s.describe(e.name, e.info, mask)
+		if err != nil {
+			continue
+		}
+		files = append(files, fi)
+	}
+	return &proto.ListResponse{Files: files, NextPageToken: next}, nil
+}
+
+func (s *fileServer) HashFile(ctx context.Context, req *proto.HashRequest) (*proto.HashResponse, error) {
+	filename := s.requestName(ctx, req.GetFilename())
+	if filename == "" {
+		return nil, errors.New("имя файла пустое")
+	}
+	if addr, fctx := s.route(ctx, filename); addr != "" {
+		c, err := s.peers.client(addr)
+		if err != nil {
+			return nil, err
+		}
+		return c.HashFile(fctx, req)
+	}
+
+	if alg := req.GetAlgorithm(); alg != "" && alg != "sha256" {
+		sum, size, err := s.digest(filename, alg)
+		if err != nil {
+			return nil, err
+		}
+		return &proto.HashResponse{Filename: filename, SizeBytes: size, Algorithm: alg, Hash: sum}, nil
+	}
+	sum, size, err := s.checksum(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.HashResponse{
+		Filename:  filename,
+		Sha256:    sum,
+		SizeBytes: size,
+		Algorithm: "sha256",
+		Hash:      sum,
+	}, nil
+}
+
+func (s *fileServer) Delete(ctx context.Context, req *proto.DeleteRequest) (*proto.DeleteResponse, error) {
+	filename := s.requestName(ctx, req.GetFilename())
+	if filename == "" {
+		return nil, errors.New("имя файла пустое")
+	}
+	if addr, fctx := s.route(ctx, filename); addr != "" {
+		c, err := s.peers.client(addr)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.Delete(fctx, req)
+		if (err == nil || status.Code(err) == codes.NotFound) && s.relayUpstream(filename) != "" {
+			// gone upstream either way: the cached copy is stale
+			s.dropRelayCache(filename)
+		}
+		return resp, err
+	}
+
+	unlock, err := s.locks.Lock(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if _, err := s.statStored(filename); err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "%s not found", filename)
+		}
+		return nil, err
+	}
+	if err := s.keepVersion(filename); err != nil {
+		return nil, err
+	}
+	if err := s.removeStored(filename); err != nil {
+		return nil, fmt.Errorf("delete %s: %w", filename, err)
+	}
+	s.cold.forget(filename)
+	s.changes.notify(filename)
+	return &proto.DeleteResponse{Filename: filename}, nil
+}
+
+func (s *fileServer) RenameFile(ctx context.Context, req *proto.RenameRequest) (*proto.RenameResponse, error) {
+	from, to := s.requestName(ctx, req.GetFilename()), s.requestName(ctx, req.GetNewFilename())
+	if from == "" || to == "" {
+		return nil, status.Error(codes.InvalidArgument, "filename and new_filename are required")
+	}
+	if reservedName(to) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is a reserved name", to)
+	}
+	addr, fctx := s.route(ctx, from)
+	if other, _ := s.route(ctx, to); other != addr {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s and %s are stored on different nodes", from, to)
+	}
+	if addr != "" {
+		c, err := s.peers.client(addr)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.RenameFile(fctx, req)
+		if err == nil && s.relayUpstream(from) != "" {
+			s.dropRelayCache(from)
+			s.dropRelayCache(to)
+		}
+		return resp, err
+	}
+
+	// both names are locked, in order so two opposite renames cannot
+	// deadlock
+	names := []string{from, to}
+	if to < from {
+		names = []string{to, from}
+	}
+	if from == to {
+		names = names[:1]
+	}
+	for _, name := range names {
+		unlock, err := s.locks.Lock(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+	if _, err := s.statStored(from); err != nil {
+		return nil, err
+	}
+	if from == to {
+		return &proto.RenameResponse{Filename: to}, nil
+	}
+	if !req.GetOverwrite() {
+		if _, err := s.statStored(to); err == nil {
+			return nil, status.Errorf(codes.AlreadyExists, "%s already exists", to)
+		} else if status.Code(err) != codes.NotFound {
+			return nil, err
+		}
+	}
+	if err := s.keepVersion(to); err != nil {
+		return nil, err
+	}
+	if err := s.renameStored(from, to); err != nil {
+		return nil, fmt.Errorf("rename %s: %w", from, err)
+	}
+	s.cold.forget(from)
+	s.changes.notify(from)
+	s.changes.notify(to)
+	return &proto.RenameResponse{Filename: to}, nil
+}
+
+func (s *fileServer) CopyFile(ctx context.Context, req *proto.CopyRequest) (*proto.CopyResponse, error) {
+	from, to := s.requestName(ctx, req.GetFilename()), s.requestName(ctx, req.GetNewFilename())
+	if from == "" || to == "" {
+		return nil, status.Error(codes.InvalidArgument, "filename and new_filename are required")
+	}
+	if from == to {
+		return nil, status.Error(codes.InvalidArgument, "new_filename is the file itself")
+	}
+	if reservedName(to) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is a reserved name", to)
+	}
+	addr, fctx := s.route(ctx, from)
+	if other, _ := s.route(ctx, to); other != addr {
+		if err := s.copyAcross(ctx, req.GetFilename(), req.GetNewFilename(), req.GetOverwrite()); err != nil {
+			return nil, err
+		}
+		return &proto.CopyResponse{Filename: to}, nil
+	}
+	if addr != "" {
+		c, err := s.peers.client(addr)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.CopyFile(fctx, req)
+		if err == nil && s.relayUpstream(to) != "" {
+			s.dropRelayCache(to)
+		}
+		return resp, err
+	}
+
+	names := []string{from, to}
+	if to < from {
+		names = []string{to, from}
+	}
+	for _, name := range names {
+		unlock, err := s.locks.Lock(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+	info, err := s.statStored(from)
+	if err != nil {
+		return nil, err
+	}
+	if !req.GetOverwrite() {
+		if _, err := s.statStored(to); err == nil {
+			return nil, status.Errorf(codes.AlreadyExists, "%s already exists", to)
+		} else if status.Code(err) != codes.NotFound {
+			return nil, err
+		}
+	}
+	if err := s.checkSize(to, info.Size()); err != nil {
+		return nil, err
+	}
+	if err := s.keepVersion(to); err != nil {
+		return nil, err
+	}
+	if err := s.copyStored(from, to); err != nil {
+		return nil, fmt.Errorf("copy %s: %w", from, err)
+	}
+	s.changes.notify(to)
+	return &proto.CopyResponse{Filename: to}, nil
+}
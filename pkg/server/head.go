@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultHeadBytes = 512
+	maxHeadBytes     = 1 << 20
+)
+
+// Head returns the first bytes of a file and its detected content type, for
+// previews. It is cheap, so it shares the ListFiles limit instead of taking
+// a transfer slot.
+func (s *fileServer) Head(ctx context.Context, req *proto.HeadRequest) (*proto.HeadResponse, error) {
+	filename := s.requestName(ctx, req.GetFilename())
+	if filename == "" {
+		return nil, errors.New("имя файла пустое")
+	}
+	if addr, fctx := s.route(ctx, filename); addr != "" {
+		c, err := s.peers.client(addr)
+		if err != nil {
+			return nil, err
+		}
+		return c.Head(fctx, req)
+	}
+
+	mask, err := parseMask(req.GetReadMask(), &proto.HeadResponse{}, "filename", "data", "content_type", "size_bytes", "modified_at")
+	if err != nil {
+		return nil, err
+	}
+	n := req.GetNBytes()
+	if n <= 0 {
+		n = defaultHeadBytes
+	}
+	n = min(n, maxHeadBytes)
+
+	resp := &proto.HeadResponse{Filename: filename}
+	var info fs.FileInfo
+	if mask.has("data", "content_type") {
+		var f io.ReadSeekCloser
+		if f, info, err = s.openStored(filename); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		buf := make([]byte, min(n, info.Size()))
+		read, err := io.ReadFull(f, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		buf = buf[:read]
+		if mask["data"] {
+			resp.Data = buf
+		}
+		if mask["content_type"] {
+			resp.ContentType = contentType(filename, buf)
+		}
+	} else if info, err = s.statStored(filename); err != nil {
+		return nil, err
+	}
+	if mask["size_bytes"] {
+		resp.SizeBytes = info.Size()
+	}
+	if mask["modified_at"] {
+		resp.ModifiedAt = info.ModTime().Format(time.RFC3339)
+	}
+	if mask["sha256"] {
+		if resp.Sha256, err = s.storedSHA256(filename, info); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// contentType sniffs the leading bytes and falls back to the extension
+// when sniffing finds nothing specific.
+func contentType(name string, head []byte) string {
+	ct := http.DetectContentType(head)
+	if ct == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(filepath.Ext(name)); byExt != "" {
+			return byExt
+		}
+	}
+	return ct
+}
+
+// StatFile describes one file without listing the others. Like Head it
+// shares the ListFiles limit.
+func (s *fileServer) StatFile(ctx context.Context, req *proto.StatRequest) (*proto.FileInfo, error) {
+	filename := s.requestName(ctx, req.GetFilename())
+	if filename == "" {
+		return nil, status.Error(codes.InvalidArgument, "filename is required")
+	}
+	if addr, fctx := s.route(ctx, filename); addr != "" {
+		c, err := s.peers.client(addr)
+		if err != nil {
+			return nil, err
+		}
+		return c.StatFile(fctx, req)
+	}
+
+	mask, err := parseMask(req.GetReadMask(), &proto.FileInfo{}, "filename", "created_at", "modified_at", "size_bytes", "sha256", "content_type", "original_filename", "uploader", "encryption_key_id")
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.statStored(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, status.Errorf(codes.NotFound, "%s not found", filename)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.describe(filename, info, mask)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthInterval is how often the storage dir is checked for writability.
+const healthInterval = 10 * time.Second
+
+// publicMethod reports whether method is served without authentication:
+// health checks, for probes, reflection, which only describes the API, and
+// RedeemDownload, whose link is its credential.
+func publicMethod(method string) bool {
+	return strings.HasPrefix(method, "/grpc.health.v1.Health/") || strings.HasPrefix(method, "/grpc.reflection.") ||
+		method == fullMethod("RedeemDownload")
+}
+
+// watchHealth reports the server, under "" and its service name, as
+// serving while a file can be created in the storage dir and as not
+// serving otherwise. Draining shuts s.health down, which pins it at not
+// serving.
+func (s *fileServer) watchHealth() {
+	serving := true
+	for {
+		err := probeWritable(s.storageDir)
+		if ok := err == nil; ok != serving {
+			serving = ok
+			if ok {
+				slog.Info("health: storage writable again", "dir", s.storageDir)
+			} else {
+				slog.Error("health: not serving", "error", err)
+			}
+		}
+		st := healthpb.HealthCheckResponse_SERVING
+		if !serving {
+			st = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		s.health.SetServingStatus("", st)
+		s.health.SetServingStatus(proto.FileService_ServiceDesc.ServiceName, st)
+		time.Sleep(healthInterval)
+	}
+}
+
+func probeWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".tmp-health-*")
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if rerr := os.Remove(f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// idleStream records how long the handler has been waiting on the peer.
+// Time spent on our side, such as hashing or moderating a finished upload,
+// does not count as idle.
+type idleStream struct {
+	abortStream
+	blocked atomic.Int64 // unix nanos the pending Recv or Send started, 0 if none
+}
+
+func (s *idleStream) RecvMsg(m any) error {
+	s.blocked.Store(time.Now().UnixNano())
+	defer s.blocked.Store(0)
+	return s.abortStream.RecvMsg(m)
+}
+
+func (s *idleStream) SendMsg(m any) error {
+	s.blocked.Store(time.Now().UnixNano())
+	defer s.blocked.Store(0)
+	return s.abortStream.SendMsg(m)
+}
+
+// withIdleTimeout runs handler and aborts the stream once the peer has
+// neither sent nor accepted a message for s.idleTimeout, so a stalled client
+// cannot hold a transfer slot forever. The abort cancels the handler's
+// context and fails its pending call; the transfer ends when the handler
+// returns.
+func (s *fileServer) withIdleTimeout(ss grpc.ServerStream, handler func(grpc.ServerStream) error) error {
+	if s.idleTimeout <= 0 {
+		return handler(ss)
+	}
+	ctx, cancel := context.WithCancel(ss.Context())
+	defer cancel()
+	w := &idleStream{abortStream: abortStream{ctxStream{ServerStream: ss, ctx: ctx}}}
+	var idle atomic.Bool
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		// at least a millisecond apart: a tiny -idle-timeout must not spin
+		// or, at 1ns, make the interval zero
+		tick := time.NewTicker(max(min(s.idleTimeout/2, time.Second), time.Millisecond))
+		defer tick.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+				if since := w.blocked.Load(); since != 0 && time.Since(time.Unix(0, since)) >= s.idleTimeout {
+					idle.Store(true)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	err := handler(w)
+	if idle.Load() {
+		return status.Errorf(codes.DeadlineExceeded, "no progress for %s", s.idleTimeout)
+	}
+	return err
+}
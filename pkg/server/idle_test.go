@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// stalledStream is a peer that never sends; its calls block until the RPC
+// is torn down.
+type stalledStream struct {
+	ctx      context.Context
+	teardown chan struct{}
+}
+
+func (s *stalledStream) SetHeader(metadata.MD) error  { return nil }
+func (s *stalledStream) SendHeader(metadata.MD) error { return nil }
+func (s *stalledStream) SetTrailer(metadata.MD)       {}
+func (s *stalledStream) Context() context.Context     { return s.ctx }
+func (s *stalledStream) SendMsg(any) error            { <-s.teardown; return context.Canceled }
+func (s *stalledStream) RecvMsg(any) error            { <-s.teardown; return context.Canceled }
+
+func TestIdleTimeoutWaitsForHandler(t *testing.T) {
+	for _, timeout := range []time.Duration{time.Nanosecond, 20 * time.Millisecond} {
+		ss := &stalledStream{ctx: context.Background(), teardown: make(chan struct{})}
+		s := &fileServer{idleTimeout: timeout}
+		exited := false
+		err := s.withIdleTimeout(ss, func(ss grpc.ServerStream) error {
+			defer func() { exited = true }()
+			return ss.RecvMsg(nil)
+		})
+		close(ss.teardown)
+		if status.Code(err) != codes.DeadlineExceeded {
+			t.Errorf("idle timeout %s: %v, want DeadlineExceeded", timeout, err)
+		}
+		if !exited {
+			t.Errorf("idle timeout %s: returned before the handler", timeout)
+		}
+	}
+}
+
+func TestStreamDeadlineWaitsForHandler(t *testing.T) {
+	ss := &stalledStream{ctx: context.Background(), teardown: make(chan struct{})}
+	defer close(ss.teardown)
+	deadlines := map[string]methodDeadline{"Upload": {def: 20 * time.Millisecond, max: 20 * time.Millisecond}}
+	exited := false
+	err := streamDeadlineInterceptor(deadlines)(nil, ss, &grpc.StreamServerInfo{FullMethod: fullMethod("Upload")},
+		func(_ any, ss grpc.ServerStream) error {
+			defer func() { exited = true }()
+			return ss.RecvMsg(nil)
+		})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("got %v, want DeadlineExceeded", err)
+	}
+	if !exited {
+		t.Error("returned before the handler")
+	}
+}
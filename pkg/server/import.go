@@ -0,0 +1,182 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// importRoot resolves path and checks it lies under one of -import-roots,
+// following symlinks so a link cannot point the import elsewhere.
+func (s *fileServer) importRoot(path string) (string, error) {
+	if len(s.importRoots) == 0 {
+		return "", status.Error(codes.FailedPrecondition, "imports are disabled, start the server with -import-roots")
+	}
+	if !filepath.IsAbs(path) {
+		return "", status.Errorf(codes.InvalidArgument, "%s: want an absolute path on the server", path)
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", status.Errorf(codes.NotFound, "%s: %v", path, err)
+	}
+	for _, root := range s.importRoots {
+		if rel, err := filepath.Rel(root, real); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return real, nil
+		}
+	}
+	return "", status.Errorf(codes.PermissionDenied, "%s is outside -import-roots", path)
+}
+
+func (s *fileServer) ImportFiles(req *proto.ImportRequest, stream proto.FileService_ImportFilesServer) error {
+	if err := s.requireAdmin(stream.Context()); err != nil {
+		return err
+	}
+	root, err := s.importRoot(req.GetPath())
+	if err != nil {
+		return err
+	}
+	storage, _ := filepath.Abs(s.storageDir)
+	if real, err := filepath.EvalSymlinks(storage); err == nil {
+		storage = real
+	}
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return stream.Send(&proto.ImportResult{Source: path, Status: "failed", Error: err.Error()})
+		}
+		if d.IsDir() {
+			if path == storage {
+				return filepath.SkipDir
+			}
+			if path != root && !req.GetRecursive() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if err := stream.Context().Err(); err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		if rel == "." {
+			rel = filepath.Base(path)
+		}
+		name := sanitizeFilename(req.GetPrefix() + strings.ReplaceAll(rel, string(filepath.Separator), "_"))
+		return stream.Send(s.importFile(stream, path, name, req))
+	})
+}
+
+// importFile stores the host file src as name with its checksum and piece
+// hashes recorded, as if it had been uploaded.
+func (s *fileServer) importFile(stream proto.FileService_ImportFilesServer, src, name string, req *proto.ImportRequest) *proto.ImportResult {
+	res := &proto.ImportResult{Source: src, Filename: name}
+	var staged *os.File
+	var moved bool
+	fail := func(err error) *proto.ImportResult {
+		if moved {
+			// put the source back rather than lose it with the staged copy
+			if rerr := os.Rename(staged.Name(), src); rerr != nil {
+				err = fmt.Errorf("%w; source left at %s: %v", err, staged.Name(), rerr)
+			}
+		}
+		res.Status, res.Error = "failed", err.Error()
+		return res
+	}
+	if name == "" || reservedName(name) {
+		return fail(fmt.Errorf("%q cannot be stored", name))
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return fail(err)
+	}
+	res.SizeBytes, res.Owner = info.Size(), fileOwner(info)
+	if s.disk.full() {
+		return fail(status.Error(codes.ResourceExhausted, "storage is full"))
+	}
+
+	unlock, err := s.locks.Lock(stream.Context(), name)
+	if err != nil {
+		return fail(err)
+	}
+	defer unlock()
+	if _, err := s.statStored(name); err == nil && !req.GetOverwrite() {
+		res.Status = "skipped"
+		res.Error = "already stored"
+		return res
+	}
+
+	if staged, err = s.stage(); err != nil {
+		return fail(err)
+	}
+	defer os.Remove(staged.Name())
+	// a rename is free on the same volume; elsewhere fall back to copying
+	moved = req.GetMove() && os.Rename(src, staged.Name()) == nil
+	from := src
+	if moved {
+		from = staged.Name()
+	}
+	in, err := os.Open(from)
+	if err != nil {
+		staged.Close()
+		return fail(err)
+	}
+	defer in.Close()
+
+	sum := sha256.New()
+	pieces := newPieceHasher()
+	head := &headWriter{max: 512}
+	w := io.MultiWriter(sum, pieces, head)
+	if !moved {
+		w = io.MultiWriter(staged, w)
+	}
+	_, err = io.Copy(w, in)
+	if cerr := staged.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fail(err)
+	}
+	if err := s.keepVersion(name); err != nil {
+		return fail(err)
+	}
+	if err := s.importStored(name, staged.Name()); err != nil {
+		return fail(err)
+	}
+	moved = false // published, nothing to roll back
+	if req.GetMove() && from == src {
+		if err := os.Remove(src); err != nil {
+			res.Error = "copied, source not removed: " + err.Error()
+		}
+	}
+	res.Status = "imported"
+	res.Sha256 = hex.EncodeToString(sum.Sum(nil))
+	res.ContentType = contentType(name, head.buf)
+	s.storeChecksum(name, res.Sha256)
+	s.storePieces(name, pieces.sum())
+	s.meta.uploaded(name, src, res.Owner, res.Sha256, res.ContentType)
+	s.changes.notify(name)
+	return res
+}
+
+// headWriter keeps the first max bytes written to it.
+type headWriter struct {
+	buf []byte
+	max int
+}
+
+func (h *headWriter) Write(b []byte) (int, error) {
+	if n := min(len(b), h.max-len(h.buf)); n > 0 {
+		h.buf = append(h.buf, b[:n]...)
+	}
+	return len(b), nil
+}
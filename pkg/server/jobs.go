@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// job is a maintenance task run by the scheduler. run returns a one-line
+// summary of what it did.
+type job struct {
+	name  string
+	spec  string
+	sched schedule // nil: on demand only
+	run   func(ctx context.Context) (string, error)
+
+	running           bool
+	started, finished time.Time
+	result            string
+	err               error
+	next              time.Time
+}
+
+// scheduler runs registered jobs on their schedules, one run of a job at a
+// time, and keeps the outcome of the last run for ListJobs.
+type scheduler struct {
+	overrides map[string]string // schedules from -jobs by job name
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// parseJobSpecs parses "name=schedule;name=schedule". Schedules contain
+// spaces and commas, hence the semicolons.
+func parseJobSpecs(spec string) (map[string]string, error) {
+	out := make(map[string]string)
+	for _, item := range strings.Split(spec, ";") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, sched, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("job schedule %q: want name=schedule", item)
+		}
+		if _, err := parseSchedule(sched); err != nil {
+			return nil, err
+		}
+		out[strings.TrimSpace(name)] = strings.TrimSpace(sched)
+	}
+	return out, nil
+}
+
+func newScheduler(overrides map[string]string) *scheduler {
+	return &scheduler{overrides: overrides, jobs: make(map[string]*job)}
+}
+
+// add registers a job with its default schedule, unless -jobs overrides it.
+func (sc *scheduler) add(name, spec string, run func(ctx context.Context) (string, error)) {
+	if o, ok := sc.overrides[name]; ok {
+		spec = o
+	}
+	sched, err := parseSchedule(spec)
+	if err != nil {
+		log.Fatalf("job %s: %v", name, err)
+	}
+	sc.mu.Lock()
+	sc.jobs[name] = &job{name: name, spec: spec, sched: sched, run: run}
+	sc.mu.Unlock()
+}
+
+// start checks the overrides name real jobs and starts the timers.
+func (sc *scheduler) start() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for name := range sc.overrides {
+		if sc.jobs[name] == nil {
+			return fmt.Errorf("unknown job %q", name)
+		}
+	}
+	for _, j := range sc.jobs {
+		if j.sched != nil {
+			go sc.loop(j)
+		}
+	}
+	return nil
+}
+
+func (sc *scheduler) loop(j *job) {
+	for {
+		next := j.sched.next(time.Now())
+		if next.IsZero() {
+			slog.Warn("job schedule never fires", "job", j.name, "schedule", j.spec)
+			return
+		}
+		sc.mu.Lock()
+		j.next = next
+		sc.mu.Unlock()
+		time.Sleep(time.Until(next))
+		if err := sc.trigger(j.name); err != nil {
+			slog.Warn("job not started", "job", j.name, "error", err)
+		}
+	}
+}
+
+// trigger starts a run of the job unless one is already going.
+func (sc *scheduler) trigger(name string) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	j := sc.jobs[name]
+	if j == nil {
+		return status.Errorf(codes.NotFound, "no job %q", name)
+	}
+	if j.running {
+		return status.Errorf(codes.FailedPrecondition, "job %s is already running", name)
+	}
+	j.running, j.started = true, time.Now()
+	go func() {
+		result, err := j.run(context.Background())
+		if err != nil {
+			slog.Error("job failed", "job", name, "result", result, "error", err)
+		} else {
+			slog.Info("job done", "job", name, "result", result)
+		}
+		sc.mu.Lock()
+		j.running, j.finished, j.result, j.err = false, time.Now(), result, err
+		sc.mu.Unlock()
+	}()
+	return nil
+}
+
+func (sc *scheduler) status(name string) *proto.JobStatus {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	j := sc.jobs[name]
+	st := &proto.JobStatus{
+		Name:         j.name,
+		Running:      j.running,
+		LastStarted:  formatTime(j.started),
+		LastFinished: formatTime(j.finished),
+		LastResult:   j.result,
+		NextRun:      formatTime(j.next),
+	}
+	if j.sched != nil {
+		st.Schedule = j.spec
+	}
+	if j.err != nil {
+		st.LastError = j.err.Error()
+	}
+	return st
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func (s *fileServer) ListJobs(ctx context.Context, _ *proto.ListJobsRequest) (*proto.ListJobsResponse, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	s.jobs.mu.Lock()
+	names := make([]string, 0, len(s.jobs.jobs))
+	for name := range s.jobs.jobs {
+		names = append(names, name)
+	}
+	s.jobs.mu.Unlock()
+	sort.Strings(names)
+	resp := &proto.ListJobsResponse{}
+	for _, name := range names {
+		resp.Jobs = append(resp.Jobs, s.jobs.status(name))
+	}
+	return resp, nil
+}
+
+func (s *fileServer) RunJob(ctx context.Context, req *proto.RunJobRequest) (*proto.JobStatus, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.jobs.trigger(req.GetName()); err != nil {
+		return nil, err
+	}
+	return s.jobs.status(req.GetName()), nil
+}
+
+// cleanupJob removes temporary files that crashed or killed writers left
+// behind: staged uploads, relay downloads and .tmp- files older than a
+// day, and resumable uploads that got no data for uploadExpiry. Expired
+// relay cache copies go too.
+func (s *fileServer) cleanupJob(ctx context.Context) (string, error) {
+	const age = 24 * time.Hour
+	removed := 0
+	var errs []error
+	for _, dir := range []string{s.storageDir, filepath.Join(s.storageDir, ".staging"), filepath.Join(s.storageDir, ".relay"), s.cold.dir, filepath.Join(s.storageDir, ".sums"), filepath.Join(s.storageDir, ".pieces")} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		staging := filepath.Base(dir) == ".staging" || filepath.Base(dir) == ".relay"
+		for _, e := range entries {
+			if e.IsDir() || !(staging || strings.HasPrefix(e.Name(), ".tmp-")) {
+				continue
+			}
+			if info, err := e.Info(); err != nil || time.Since(info.ModTime()) < age {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+				continue
+			}
+			removed++
+		}
+	}
+	entries, _ := os.ReadDir(filepath.Join(s.storageDir, ".uploads"))
+	for _, e := range entries {
+		id := e.Name()
+		if e.IsDir() || strings.HasSuffix(id, ".json") || strings.HasPrefix(id, ".tmp-") {
+			continue
+		}
+		if info, err := e.Info(); err != nil || time.Since(info.ModTime()) < uploadExpiry {
+			continue
+		}
+		s.removeSession(id)
+		removed++
+	}
+	if s.relayCacheTTL > 0 {
+		n, err := s.cleanRelayCache()
+		removed += n
+		errs = append(errs, err)
+	}
+	return fmt.Sprintf("removed %d stale temporary files", removed), errors.Join(errs...)
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// layout is how the server laid out a file in the storage dir when it is
+// not stored plain. Such files start with a magic line of their own, but
+// an upload can start with the same bytes, and taking one for a recipe
+// would serve whatever host files it names. So the layout is recorded out
+// of band, in <storage>/.layout/files by the file's path in the storage
+// dir, and a file is only read in its layout when the record and the
+// content agree.
+//
+// The record errs towards plain: it is written once the file it describes
+// is in place and removed before a plain file replaces it, so a reader
+// racing a write sees the new file's raw bytes at worst, never user bytes
+// taken for a layout.
+type layout string
+
+const (
+	plainLayout  layout = ""
+	recipeLayout layout = "recipe"
+	coldLayout   layout = "cold"
+	sealedLayout layout = "sealed"
+)
+
+// layoutPath returns where the layout of the file at path, in storageDir,
+// is recorded, or "" for a path outside it.
+func layoutPath(storageDir, path string) string {
+	rel, err := filepath.Rel(storageDir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return filepath.Join(storageDir, ".layout", "files", rel)
+}
+
+// layoutOf returns the recorded layout of the file at path.
+func layoutOf(storageDir, path string) layout {
+	p := layoutPath(storageDir, path)
+	if p == "" {
+		return plainLayout
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return plainLayout
+	}
+	return layout(strings.TrimSpace(string(b)))
+}
+
+// setLayout records the layout of the file at path; plainLayout drops the
+// record.
+func setLayout(storageDir, path string, l layout) error {
+	p := layoutPath(storageDir, path)
+	if p == "" {
+		return fmt.Errorf("%s is outside the storage dir", path)
+	}
+	if l == plainLayout {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return writeFileAtomic(p, []byte(l+"\n"))
+}
+
+// copyLayout records the layout of the file at from for its copy at to.
+func copyLayout(storageDir, from, to string) error {
+	return setLayout(storageDir, to, layoutOf(storageDir, from))
+}
+
+// moveLayout moves the layout record of the file at from, renamed to to.
+func moveLayout(storageDir, from, to string) error {
+	if err := copyLayout(storageDir, from, to); err != nil {
+		return err
+	}
+	return setLayout(storageDir, from, plainLayout)
+}
+
+// storedRecipe returns the recipe stored at path, in storageDir, or
+// ok=false for a file not stored as one.
+func storedRecipe(storageDir, path string, withChunks bool) (rec *recipe, ok bool, err error) {
+	if layoutOf(storageDir, path) != recipeLayout {
+		if _, err := os.Stat(path); err != nil {
+			return nil, false, err
+		}
+		return nil, false, nil
+	}
+	return readRecipe(path, withChunks)
+}
+
+// storedColdSize returns the original size of the file at path, in
+// storageDir, if it is stored compressed, or ok=false.
+func storedColdSize(storageDir, path string) (size int64, ok bool) {
+	if layoutOf(storageDir, path) != coldLayout {
+		return 0, false
+	}
+	return coldSize(path)
+}
+
+// storedSealedSize returns the content length of the file at path, in
+// storageDir, if it is stored sealed, or ok=false.
+func storedSealedSize(storageDir, path string) (size int64, ok bool) {
+	if layoutOf(storageDir, path) != sealedLayout {
+		return 0, false
+	}
+	return sealedSize(path)
+}
+
+// layoutWriter records the layout of the file at path once the writer
+// that fills it is closed without error.
+type layoutWriter struct {
+	io.WriteCloser
+	storageDir, path string
+	kind             layout
+}
+
+func (w layoutWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return setLayout(w.storageDir, w.path, w.kind)
+}
+
+// scanLayouts records the layouts of the files a storage dir held before
+// they were recorded, once per layout: afterwards only the server writes
+// the records, and uploads that look like one of its layouts stay plain.
+// A file is only taken for sealed if sealed, which checks it against the
+// server's keys, says so; without keys sealed is nil and sealed files are
+// looked for once there are.
+func scanLayouts(storageDir string, sealed func(path string) bool) error {
+	stamp := filepath.Join(storageDir, ".layout", "scanned")
+	b, err := os.ReadFile(stamp)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	done := strings.Fields(string(b))
+	sniff := map[layout]func(path string) bool{
+		recipeLayout: func(path string) bool {
+			_, ok, _ := readRecipe(path, false)
+			return ok
+		},
+		coldLayout: func(path string) bool {
+			_, ok := coldSize(path)
+			return ok
+		},
+	}
+	if sealed != nil {
+		sniff[sealedLayout] = sealed
+	}
+	var todo []layout
+	for l := range sniff {
+		if !slices.Contains(done, string(l)) {
+			todo = append(todo, l)
+		}
+	}
+	if len(todo) == 0 {
+		return nil
+	}
+	names, err := storedPaths(storageDir)
+	if err != nil {
+		return err
+	}
+	paths := make(map[string]bool) // path -> kept version
+	for _, name := range names {
+		paths[filepath.Join(storageDir, name)] = false
+	}
+	err = filepath.WalkDir(filepath.Join(storageDir, ".versions"), func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fs.SkipAll
+		}
+		if err == nil && d.Type().IsRegular() && !strings.HasSuffix(path, ".json") {
+			paths[path] = true
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	found := 0
+	for path, version := range paths {
+		if layoutOf(storageDir, path) != plainLayout {
+			continue
+		}
+		for _, l := range todo {
+			// kept versions are plain or sealed copies
+			if version && l != sealedLayout {
+				continue
+			}
+			if sniff[l](path) {
+				if err := setLayout(storageDir, path, l); err != nil {
+					return err
+				}
+				found++
+				break
+			}
+		}
+	}
+	for _, l := range todo {
+		done = append(done, string(l))
+	}
+	slog.Info("storage layouts recorded", "dir", storageDir, "files", found)
+	return writeFileAtomic(stamp, []byte(strings.Join(done, "\n")+"\n"))
+}
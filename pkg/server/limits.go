@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/pkg/limiter"
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+// defaultLimits maps methods to the transfers and lists pools, sized by
+// -max-transfers and -max-lists. -limits is applied on top.
+const defaultLimits = "ListFiles=lists,Head=lists,StatFile=lists,Upload=transfers,Download=transfers,DownloadVersion=transfers,DownloadArchive=transfers,RedeemDownload=transfers,*=transfers"
+
+// fullMethod is the full gRPC name of a FileService method.
+func fullMethod(method string) string {
+	return "/" + proto.FileService_ServiceDesc.ServiceName + "/" + method
+}
+
+// serviceMethods returns the methods of FileService and whether each
+// streams.
+func serviceMethods() map[string]bool {
+	out := make(map[string]bool)
+	for _, m := range proto.FileService_ServiceDesc.Methods {
+		out[m.MethodName] = false
+	}
+	for _, m := range proto.FileService_ServiceDesc.Streams {
+		out[m.StreamName] = true
+	}
+	return out
+}
+
+// parseLimits builds the limiter config. pools holds "name=capacity" or
+// "name=capacity/queue-timeout" items, comma-separated, which add pools or
+// replace the transfers and lists ones; spec holds "Method=pool" or
+// "Method=pool*weight" items, where pool none leaves the method unlimited
+// and Method * stands for the unary FileService methods not listed. Other
+// services, health checks and reflection, are never limited: a probe must
+// not wait behind transfers.
+func parseLimits(maxTransfers, maxLists int, pools, spec string) (limiter.Config, error) {
+	cfg := limiter.Config{
+		Pools: map[string]limiter.Pool{
+			"transfers": {Capacity: int64(maxTransfers)},
+			"lists":     {Capacity: int64(maxLists)},
+		},
+		Methods: make(map[string]limiter.Rule),
+		Waited:  traceWait,
+	}
+	for _, item := range splitList(pools) {
+		name, value, ok := strings.Cut(item, "=")
+		if !ok || name == "" || name == "none" {
+			return cfg, fmt.Errorf("pool %q: want name=capacity[/queue-timeout]", item)
+		}
+		capText, timeoutText, queued := strings.Cut(value, "/")
+		var p limiter.Pool
+		var err error
+		if p.Capacity, err = strconv.ParseInt(capText, 10, 64); err != nil {
+			return cfg, fmt.Errorf("pool %q: %w", item, err)
+		}
+		if queued {
+			if p.QueueTimeout, err = time.ParseDuration(timeoutText); err != nil {
+				return cfg, fmt.Errorf("pool %q: %w", item, err)
+			}
+		}
+		cfg.Pools[name] = p
+	}
+	methods := serviceMethods()
+	var def limiter.Rule
+	for _, item := range append(splitList(defaultLimits), splitList(spec)...) {
+		method, value, ok := strings.Cut(item, "=")
+		if !ok || method == "" {
+			return cfg, fmt.Errorf("limit %q: want Method=pool[*weight]", item)
+		}
+		if _, known := methods[method]; !known && method != "*" {
+			return cfg, fmt.Errorf("limit %q: FileService has no method %s", item, method)
+		}
+		var r limiter.Rule
+		poolName, weightText, weighted := strings.Cut(value, "*")
+		if poolName != "none" {
+			r.Pool = poolName
+		}
+		if weighted {
+			w, err := strconv.ParseInt(weightText, 10, 64)
+			if err != nil {
+				return cfg, fmt.Errorf("limit %q: %w", item, err)
+			}
+			r.Weight = w
+		}
+		if method == "*" {
+			def = r
+		} else {
+			cfg.Methods[fullMethod(method)] = r
+		}
+	}
+	for method, stream := range methods {
+		if _, listed := cfg.Methods[fullMethod(method)]; !listed && !stream {
+			cfg.Methods[fullMethod(method)] = def
+		}
+	}
+	return cfg, nil
+}
+
+// traceWait records on the call's span how long it waited for a slot.
+func traceWait(ctx context.Context, pool string, d time.Duration) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("semaphore.slot", pool),
+		attribute.Float64("semaphore.wait_ms", float64(d.Microseconds())/1000))
+}
+
+// publishLimits serves the counts of the limiter's pools under
+// /debug/vars.
+func publishLimits(l *limiter.Limiter) {
+	expvar.Publish("call_slots", expvar.Func(func() any { return l.Stats() }))
+}
+
+// transferMethods are the streams that move file data: they are paced by
+// the bandwidth limits and aborted when the peer stalls.
+var transferMethods = map[string]bool{
+	fullMethod("Upload"):          true,
+	fullMethod("Download"):        true,
+	fullMethod("DownloadVersion"): true,
+	fullMethod("DownloadArchive"): true,
+	fullMethod("RedeemDownload"):  true,
+}
+
+func streamTransfers(srv *fileServer) grpc.StreamServerInterceptor {
+	return func(srvInterface any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !transferMethods[info.FullMethod] {
+			return handler(srvInterface, ss)
+		}
+		// paced inside the idle check: waiting on our own limit is not the
+		// peer stalling
+		return srv.withIdleTimeout(ss, func(ss grpc.ServerStream) error { return handler(srvInterface, srv.bandwidth.stream(ss)) })
+	}
+}
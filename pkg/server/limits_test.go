@@ -0,0 +1,75 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/pkg/limiter"
+)
+
+func TestParseLimits(t *testing.T) {
+	cfg, err := parseLimits(10, 100, "heavy=3/5s,lists=20", "Upload=heavy*3,ListFiles=none,*=lists*2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := limiter.New(cfg); err != nil {
+		t.Fatalf("limiter.New: %v", err)
+	}
+	pools := map[string]limiter.Pool{
+		"transfers": {Capacity: 10},
+		"lists":     {Capacity: 20},
+		"heavy":     {Capacity: 3, QueueTimeout: 5 * time.Second},
+	}
+	for name, want := range pools {
+		if got := cfg.Pools[name]; got != want {
+			t.Errorf("pool %s = %+v, want %+v", name, got, want)
+		}
+	}
+	rules := map[string]limiter.Rule{
+		"Upload":    {Pool: "heavy", Weight: 3},
+		"Download":  {Pool: "transfers"},
+		"ListFiles": {},
+		"StatFile":  {Pool: "lists"},
+		"Delete":    {Pool: "lists", Weight: 2},
+	}
+	for method, want := range rules {
+		if got := cfg.Methods[fullMethod(method)]; got != want {
+			t.Errorf("%s = %+v, want %+v", method, got, want)
+		}
+	}
+	// streams not listed stay unlimited
+	if r, ok := cfg.Methods[fullMethod("WatchFiles")]; ok {
+		t.Errorf("WatchFiles = %+v, want no rule", r)
+	}
+}
+
+func TestParseLimitsErrors(t *testing.T) {
+	tests := []struct {
+		pools, spec string
+	}{
+		{"", "Upload"},
+		{"", "=transfers"},
+		{"", "Frobnicate=transfers"},
+		{"", "Upload=transfers*x"},
+		{"x", ""},
+		{"=3", ""},
+		{"none=3", ""},
+		{"heavy=three", ""},
+		{"heavy=3/soon", ""},
+	}
+	for _, tt := range tests {
+		if _, err := parseLimits(10, 100, tt.pools, tt.spec); err == nil {
+			t.Errorf("parseLimits(%q, %q) accepted", tt.pools, tt.spec)
+		}
+	}
+	// well-formed but inconsistent specs are left to limiter.New
+	for _, spec := range []string{"Upload=missing", "Upload=transfers*11"} {
+		cfg, err := parseLimits(10, 100, "", spec)
+		if err != nil {
+			t.Fatalf("parseLimits(%q): %v", spec, err)
+		}
+		if _, err := limiter.New(cfg); err == nil {
+			t.Errorf("limiter.New accepted %q", spec)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// linkPolicy configures download links.
+type linkPolicy struct {
+	ttl     time.Duration // of links created without one
+	maxTTL  time.Duration
+	baseURL string // of the gateway; "" leaves DownloadLink.url empty
+}
+
+// A link token is <payload>.<signature>, both base64url: the payload is
+// linkClaims as JSON and the signature its HMAC-SHA256 with -link-secret.
+// It names the stored file, so any node sharing the secret can serve it.
+type linkClaims struct {
+	File string `json:"f"`
+	Exp  int64  `json:"exp"` // Unix seconds
+}
+
+func signLink(secret []byte, c linkClaims) string {
+	b, _ := json.Marshal(c)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(linkMAC(secret, payload))
+}
+
+func linkMAC(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// decodeLink returns the claims of token without checking them.
+func decodeLink(token string) (c linkClaims, payload string, sig []byte, err error) {
+	payload, sigText, ok := strings.Cut(token, ".")
+	if ok {
+		sig, err = base64.RawURLEncoding.DecodeString(sigText)
+	}
+	var b []byte
+	if ok && err == nil {
+		b, err = base64.RawURLEncoding.DecodeString(payload)
+	}
+	if ok && err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if !ok || err != nil || c.File == "" {
+		return c, "", nil, errors.New("malformed link")
+	}
+	return c, payload, sig, nil
+}
+
+// verifyLink returns the stored name a link token grants, if it is signed
+// with the link secret and has not expired.
+func (s *fileServer) verifyLink(token string, now time.Time) (string, error) {
+	secret := s.secret().linkSecret
+	if secret == nil {
+		return "", status.Error(codes.FailedPrecondition, "download links need -link-secret")
+	}
+	c, payload, sig, err := decodeLink(token)
+	if err != nil {
+		return "", status.Error(codes.PermissionDenied, err.Error())
+	}
+	if !hmac.Equal(sig, linkMAC(secret, payload)) {
+		return "", status.Error(codes.PermissionDenied, "link signature mismatch")
+	}
+	if now.Unix() >= c.Exp {
+		return "", status.Error(codes.PermissionDenied, "link expired")
+	}
+	if !validStoredName(c.File) {
+		return "", status.Error(codes.PermissionDenied, "malformed link")
+	}
+	return c.File, nil
+}
+
+func (s *fileServer) CreateDownloadLink(ctx context.Context, req *proto.DownloadLinkRequest) (*proto.DownloadLink, error) {
+	secret := s.secret().linkSecret
+	if secret == nil {
+		return nil, status.Error(codes.FailedPrecondition, "download links need -link-secret")
+	}
+	name := s.requestName(ctx, req.GetFilename())
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "filename is required")
+	}
+	ttl := time.Duration(req.GetTtlSeconds()) * time.Second
+	switch {
+	case req.GetTtlSeconds() < 0:
+		return nil, status.Error(codes.InvalidArgument, "negative ttl_seconds")
+	case ttl == 0:
+		ttl = s.links.ttl
+	case ttl > s.links.maxTTL:
+		return nil, status.Errorf(codes.InvalidArgument, "links may last at most %v", s.links.maxTTL)
+	}
+	// only a file the caller can read gets a link
+	mask := &fieldmaskpb.FieldMask{Paths: []string{"size_bytes"}}
+	if _, err := s.StatFile(ctx, &proto.StatRequest{Filename: req.GetFilename(), ReadMask: mask}); err != nil {
+		return nil, err
+	}
+	exp := time.Now().Add(ttl).Truncate(time.Second)
+	link := &proto.DownloadLink{
+		Token:     signLink(secret, linkClaims{File: name, Exp: exp.Unix()}),
+		ExpiresAt: exp.UTC().Format(time.RFC3339),
+	}
+	if s.links.baseURL != "" {
+		link.Url = strings.TrimSuffix(s.links.baseURL, "/") + "/links/" + link.Token
+	}
+	slog.InfoContext(ctx, "download link created", "file", name, "expires_at", link.ExpiresAt)
+	return link, nil
+}
+
+// RedeemDownload sends the file a link grants like Download. Links name
+// the stored file, so they are redeemed outside any namespace; a file on
+// another node is redeemed there.
+func (s *fileServer) RedeemDownload(req *proto.RedeemRequest, stream proto.FileService_RedeemDownloadServer) error {
+	name, err := s.verifyLink(req.GetToken(), time.Now())
+	if err != nil {
+		return err
+	}
+	if addr, fctx := s.route(stream.Context(), name); addr != "" {
+		c, err := s.peers.client(addr)
+		if err != nil {
+			return err
+		}
+		down, err := c.RedeemDownload(fctx, req)
+		if err != nil {
+			return err
+		}
+		for {
+			resp, err := down.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		}
+	}
+	return s.Download(&proto.DownloadRequest{Filename: name}, stream)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVerifyLink(t *testing.T) {
+	secret := []byte("link secret")
+	s := &fileServer{}
+	s.secrets.Store(&serverSecrets{linkSecret: secret})
+	now := time.Unix(1_700_000_000, 0)
+	valid := signLink(secret, linkClaims{File: "alice/report.pdf", Exp: now.Unix() + 60})
+	payload, sig, _ := strings.Cut(valid, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"f":"bob/secret.pdf","exp":1700000060}`))
+	tests := []struct {
+		name  string
+		token string
+		file  string
+	}{
+		{"valid", valid, "alice/report.pdf"},
+		{"expires now", signLink(secret, linkClaims{File: "a", Exp: now.Unix()}), ""},
+		{"expired", signLink(secret, linkClaims{File: "a", Exp: now.Unix() - 1}), ""},
+		{"no expiry", signLink(secret, linkClaims{File: "a"}), ""},
+		{"other secret", signLink([]byte("other"), linkClaims{File: "a", Exp: now.Unix() + 60}), ""},
+		{"payload swapped", forged + "." + sig, ""},
+		{"no signature", payload, ""},
+		{"signature not base64", payload + ".!!", ""},
+		{"payload not json", base64.RawURLEncoding.EncodeToString([]byte("a")) + "." + sig, ""},
+		{"no file", signLink(secret, linkClaims{Exp: now.Unix() + 60}), ""},
+		{"escapes storage", signLink(secret, linkClaims{File: "../etc/passwd", Exp: now.Unix() + 60}), ""},
+		{"hidden dir", signLink(secret, linkClaims{File: ".layout/files/a", Exp: now.Unix() + 60}), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := s.verifyLink(tt.token, now)
+			if tt.file != "" {
+				if err != nil || file != tt.file {
+					t.Fatalf("verifyLink = %q, %v; want %q", file, err, tt.file)
+				}
+				return
+			}
+			if status.Code(err) != codes.PermissionDenied {
+				t.Fatalf("verifyLink = %q, %v; want PermissionDenied", file, err)
+			}
+		})
+	}
+	if _, err := (&fileServer{}).verifyLink(valid, now); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("without a link secret: %v, want FailedPrecondition", err)
+	}
+}
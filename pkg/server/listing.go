@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// maxListPage caps page_size, so no page outgrows gRPC's message limit.
+const maxListPage = 5000
+
+// listEntry is a file in a listing. Local files carry their stored name
+// and, once needed, their stat; shard peers' files come as FileInfo.
+type listEntry struct {
+	shown string // the name the caller knows
+	key   int64  // size or modification time in seconds, by the order
+	name  string
+	info  fs.FileInfo
+	fi    *proto.FileInfo
+}
+
+// pageToken marks the last file of a page. Pages continue after it rather
+// than at an index, so files added or removed between calls neither repeat
+// nor skip others.
+type pageToken struct {
+	Order proto.ListRequest_Order `json:"o,omitempty"`
+	Desc  bool                    `json:"d,omitempty"`
+	Key   int64                   `json:"k,omitempty"`
+	Name  string                  `json:"n"`
+}
+
+func (t pageToken) encode() string {
+	b, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodePageToken(req *proto.ListRequest) (*pageToken, error) {
+	if req.GetPageToken() == "" {
+		return nil, nil
+	}
+	var t pageToken
+	b, err := base64.RawURLEncoding.DecodeString(req.GetPageToken())
+	if err == nil {
+		err = json.Unmarshal(b, &t)
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "malformed page_token")
+	}
+	if t.Order != req.GetOrderBy() || t.Desc != req.GetDescending() {
+		return nil, status.Error(codes.InvalidArgument, "page_token is for another order")
+	}
+	return &t, nil
+}
+
+// listMatcher returns whether a name the caller knows passes the request's
+// prefix and pattern.
+func listMatcher(req *proto.ListRequest) (func(string) bool, error) {
+	prefix, pattern := req.GetPrefix(), req.GetPattern()
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "bad pattern %q", pattern)
+	}
+	return func(name string) bool {
+		if !strings.HasPrefix(name, prefix) {
+			return false
+		}
+		ok, _ := path.Match(pattern, name)
+		return pattern == "" || ok
+	}, nil
+}
+
+// listLess orders entries by the request's order, then by name.
+func listLess(order proto.ListRequest_Order, desc bool, a, b *listEntry) bool {
+	if desc {
+		a, b = b, a
+	}
+	if order != proto.ListRequest_NAME && a.key != b.key {
+		return a.key < b.key
+	}
+	return a.shown < b.shown
+}
+
+// sortKey sets e.key from its stat or FileInfo.
+func (e *listEntry) sortKey(order proto.ListRequest_Order) {
+	switch {
+	case order == proto.ListRequest_NAME:
+	case order == proto.ListRequest_SIZE && e.info != nil:
+		e.key = e.info.Size()
+	case order == proto.ListRequest_SIZE:
+		e.key = e.fi.GetSizeBytes()
+	case e.info != nil:
+		e.key = e.info.ModTime().Unix()
+	default:
+		t, _ := time.Parse(time.RFC3339, e.fi.GetModifiedAt())
+		e.key = t.Unix()
+	}
+}
+
+// pageOf sorts entries and returns the page after token, and the token of
+// the page after it.
+func pageOf(req *proto.ListRequest, entries []*listEntry, token *pageToken) ([]*listEntry, string) {
+	order, desc := req.GetOrderBy(), req.GetDescending()
+	sort.Slice(entries, func(i, j int) bool { return listLess(order, desc, entries[i], entries[j]) })
+	if token != nil {
+		last := &listEntry{shown: token.Name, key: token.Key}
+		entries = entries[sort.Search(len(entries), func(i int) bool { return listLess(order, desc, last, entries[i]) }):]
+	}
+	size := int(req.GetPageSize())
+	if size <= 0 || len(entries) <= min(size, maxListPage) {
+		return entries, ""
+	}
+	entries = entries[:min(size, maxListPage)]
+	last := entries[len(entries)-1]
+	return entries, pageToken{Order: order, Desc: desc, Key: last.key, Name: last.shown}.encode()
+}
+
+// peerListRequest asks shard peers for all their matching files, with the
+// fields this node sorts by.
+func peerListRequest(req *proto.ListRequest, mask fieldMask) *proto.ListRequest {
+	peer := &proto.ListRequest{ReadMask: req.GetReadMask(), Prefix: req.GetPrefix(), Pattern: req.GetPattern(),
+		Uploader: req.GetUploader(), ContentType: req.GetContentType()}
+	field := map[proto.ListRequest_Order]string{proto.ListRequest_SIZE: "size_bytes", proto.ListRequest_MODIFIED: "modified_at"}[req.GetOrderBy()]
+	if field != "" && !mask[field] {
+		paths := []string{field}
+		for p := range mask {
+			paths = append(paths, p)
+		}
+		peer.ReadMask = &fieldmaskpb.FieldMask{Paths: paths}
+	}
+	return peer
+}
+
+// listLocal returns the stored files the caller may see that pass the
+// request's filters. With the metadata index the names and stats come from
+// it rather than the store, and the uploader and content type filters
+// apply; without it those filters are refused.
+func (s *fileServer) listLocal(ctx context.Context, req *proto.ListRequest, match func(string) bool) ([]*listEntry, error) {
+	uploader, ct := req.GetUploader(), req.GetContentType()
+	if s.meta == nil {
+		if uploader != "" || ct != "" {
+			return nil, status.Error(codes.FailedPrecondition, "uploader and content_type filters need a server with -meta-index")
+		}
+		names, err := s.store.List()
+		if err != nil {
+			return nil, err
+		}
+		return s.localEntries(ctx, names, match), nil
+	}
+	files, err := s.meta.all()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	entries := s.localEntries(ctx, names, match)
+	kept := entries[:0]
+	for _, e := range entries {
+		m := files[e.name]
+		if uploader != "" && m.Uploader != uploader {
+			continue
+		}
+		if ct != "" && m.ContentType == "" {
+			// found on first use and kept
+			if m.ContentType, err = s.sniffType(e.name); err != nil {
+				continue
+			}
+			s.meta.fill(e.name, metaInfo{e.name, m}, "", m.ContentType)
+		}
+		if !strings.HasPrefix(m.ContentType, ct) {
+			continue
+		}
+		e.info = metaInfo{e.name, m}
+		kept = append(kept, e)
+	}
+	return kept, nil
+}
+
+// localEntries returns the stored files the caller may see that match.
+func (s *fileServer) localEntries(ctx context.Context, names []string, match func(string) bool) []*listEntry {
+	var entries []*listEntry
+	for _, name := range names {
+		if !visible(ctx, name) {
+			continue
+		}
+		if shown := shownName(ctx, name); match(shown) {
+			entries = append(entries, &listEntry{shown: shown, name: name})
+		}
+	}
+	return entries
+}
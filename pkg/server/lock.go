@@ -0,0 +1,262 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const lockRetryInterval = 200 * time.Millisecond
+
+var lockTimeouts = expvar.NewInt("lock_timeouts")
+
+// fileLocker serializes writers of the same file. With several server
+// instances on a shared backend the lock has to be distributed too.
+type fileLocker interface {
+	Lock(ctx context.Context, name string) (unlock func(), err error)
+}
+
+// newLocker builds the lock backend. A writer waits up to wait for a file
+// another call is writing, or as long as its context allows when wait is 0.
+func newLocker(backend, storageDir, redisAddr string, ttl, wait time.Duration) (fileLocker, error) {
+	var l fileLocker
+	switch backend {
+	case "", "local":
+		l = newLocalLocker()
+	case "dir":
+		l = &dirLocker{dir: filepath.Join(storageDir, ".locks"), ttl: ttl}
+	case "redis":
+		l = &redisLocker{addr: redisAddr, ttl: ttl}
+	default:
+		return nil, fmt.Errorf("unknown lock backend %q", backend)
+	}
+	return busyLocker{fileLocker: l, wait: wait}, nil
+}
+
+// busyLocker reports a call that ran out of time waiting for a file another
+// call is writing as Aborted, so clients can tell a busy file from a broken
+// server and retry.
+type busyLocker struct {
+	fileLocker
+	wait time.Duration
+}
+
+func (l busyLocker) Lock(ctx context.Context, name string) (func(), error) {
+	lctx := ctx
+	if l.wait > 0 {
+		var cancel context.CancelFunc
+		lctx, cancel = context.WithTimeout(ctx, l.wait)
+		defer cancel()
+	}
+	unlock, err := l.fileLocker.Lock(lctx, name)
+	if err == nil {
+		return unlock, nil
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	// the redis backend runs out of time inside a dial or read, with a net
+	// timeout rather than the context's error, and may do so a moment
+	// before the context notices
+	if errors.Is(err, context.DeadlineExceeded) || lctx.Err() != nil || deadlineTimeout(lctx, err) {
+		lockTimeouts.Add(1)
+		return nil, status.Errorf(codes.Aborted, "%s is being written by another call", name)
+	}
+	return nil, err
+}
+
+// deadlineTimeout reports whether err is a network timeout at the
+// deadline of ctx.
+func deadlineTimeout(ctx context.Context, err error) bool {
+	var ne net.Error
+	_, ok := ctx.Deadline()
+	return ok && errors.As(err, &ne) && ne.Timeout()
+}
+
+func lockToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// keepAlive calls refresh every ttl/3 until the returned stop func is called.
+func keepAlive(ttl time.Duration, refresh func()) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(ttl / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				refresh()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+func waitRetry(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(lockRetryInterval):
+		return nil
+	}
+}
+
+// ---- in-process locks (single instance) ----
+type localLocker struct {
+	mu   sync.Mutex
+	held map[string]chan struct{}
+}
+
+func newLocalLocker() *localLocker {
+	return &localLocker{held: make(map[string]chan struct{})}
+}
+
+func (l *localLocker) Lock(ctx context.Context, name string) (func(), error) {
+	for {
+		l.mu.Lock()
+		ch, busy := l.held[name]
+		if !busy {
+			ch = make(chan struct{})
+			l.held[name] = ch
+			l.mu.Unlock()
+			return func() {
+				l.mu.Lock()
+				delete(l.held, name)
+				l.mu.Unlock()
+				close(ch)
+			}, nil
+		}
+		l.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// ---- lock files next to the data (NFS and other shared mounts) ----
+
+// dirLocker takes a lock by exclusively creating <dir>/<name>.lock. The holder
+// keeps touching the file; a lock file not touched for ttl belongs to a
+// crashed instance and is broken.
+type dirLocker struct {
+	dir string
+	ttl time.Duration
+}
+
+func (l *dirLocker) Lock(ctx context.Context, name string) (func(), error) {
+	path := filepath.Join(l.dir, name+".lock")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("lock dir: %w", err)
+	}
+	token := lockToken()
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			_, werr := f.WriteString(token)
+			_ = f.Close()
+			if werr != nil {
+				_ = os.Remove(path)
+				return nil, fmt.Errorf("lock write: %w", werr)
+			}
+			stop := keepAlive(l.ttl, func() {
+				now := time.Now()
+				_ = os.Chtimes(path, now, now)
+			})
+			return func() {
+				stop()
+				if b, err := os.ReadFile(path); err == nil && string(b) == token {
+					_ = os.Remove(path)
+				}
+			}, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("lock create: %w", err)
+		}
+		if l.breakStale(path, token) {
+			continue
+		}
+		if err := waitRetry(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// breakStale removes path if its holder stopped refreshing it. The file is
+// first renamed to a private name so two instances breaking the same lock at
+// once cannot delete each other's fresh lock.
+func (l *dirLocker) breakStale(path, token string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.Is(err, fs.ErrNotExist)
+	}
+	if time.Since(info.ModTime()) < l.ttl {
+		return false
+	}
+	private := path + "." + token
+	if err := os.Rename(path, private); err != nil {
+		return false
+	}
+	defer os.Remove(private)
+	if info, err := os.Stat(private); err == nil && time.Since(info.ModTime()) < l.ttl {
+		// raced with a fresh holder: put its lock back
+		_ = os.Link(private, path)
+		return false
+	}
+	return true
+}
+
+// ---- redis locks (SET NX PX with a per-holder token) ----
+type redisLocker struct {
+	addr string
+	ttl  time.Duration
+}
+
+const (
+	redisRefreshScript = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`
+	redisUnlockScript  = `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`
+)
+
+func (l *redisLocker) Lock(ctx context.Context, name string) (func(), error) {
+	key := "grpc-file-service:lock:" + name
+	token := lockToken()
+	ttl := fmt.Sprint(l.ttl.Milliseconds())
+	for {
+		reply, err := redisDo(ctx, l.addr, "SET", key, token, "NX", "PX", ttl)
+		if err != nil {
+			return nil, fmt.Errorf("redis lock: %w", err)
+		}
+		if reply != nil {
+			stop := keepAlive(l.ttl, func() {
+				_, _ = redisDo(context.Background(), l.addr, "EVAL", redisRefreshScript, "1", key, token, ttl)
+			})
+			return func() {
+				stop()
+				_, _ = redisDo(context.Background(), l.addr, "EVAL", redisUnlockScript, "1", key, token)
+			}, nil
+		}
+		if err := waitRetry(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
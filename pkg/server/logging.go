@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// setupLogging makes slog's default logger write text or json records at
+// level and up to stderr. The log package writes through it too. Records
+// logged with a call's context carry its request ID.
+func setupLogging(format, level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("-log-level: %w", err)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler
+	switch format {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("-log-format: want text or json, not %q", format)
+	}
+	slog.SetDefault(slog.New(requestIDHandler{h}))
+	return nil
+}
+
+// requestIDHandler adds the request ID of the context a record was logged
+// with.
+type requestIDHandler struct{ slog.Handler }
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// requestIDKey carries a call's request ID: clients and the HTTP gateway
+// may send one, and the server returns the ID it used in the response
+// header and trailer, so a failed call can be found in the logs.
+const requestIDKey = "x-request-id"
+
+type requestIDCtxKey struct{}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDCtxKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// usableRequestID reports whether a request ID chosen by the caller is
+// short and plain enough to be logged as it is.
+func usableRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID returns ctx carrying the caller's request ID, or a new one,
+// and the metadata that returns it.
+func withRequestID(ctx context.Context) (context.Context, metadata.MD) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(requestIDKey); len(v) > 0 && usableRequestID(v[0]) {
+			id = v[0]
+		}
+	}
+	if id == "" {
+		id = newRequestID()
+	}
+	return context.WithValue(ctx, requestIDCtxKey{}, id), metadata.Pairs(requestIDKey, id)
+}
+
+func unaryRequestID(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, md := withRequestID(ctx)
+	// the trailer gets it too: a call that fails before sending anything
+	// answers with trailers only
+	_ = grpc.SetHeader(ctx, md)
+	_ = grpc.SetTrailer(ctx, md)
+	return handler(ctx, req)
+}
+
+func streamRequestID(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, md := withRequestID(ss.Context())
+	_ = ss.SetHeader(md)
+	ss.SetTrailer(md)
+	return handler(srv, &ctxStream{ServerStream: ss, ctx: ctx})
+}
+
+// httpRequestID gives every gateway request an ID, the caller's
+// X-Request-Id if usable, which callContext passes on to the call and the
+// response returns.
+func httpRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !usableRequestID(r.Header.Get("X-Request-Id")) {
+			r.Header.Set("X-Request-Id", newRequestID())
+		}
+		w.Header().Set("X-Request-Id", r.Header.Get("X-Request-Id"))
+		next.ServeHTTP(w, r)
+	})
+}
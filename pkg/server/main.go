@@ -0,0 +1,469 @@
+// Package server is the file service server. The server command runs it with
+// Main; custom builds do the same with their own middleware.
+package server
+
+import (
+	"context"
+	"flag"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/daniil1412412/grpc-file-service/pkg/compress" // gzip and zstd calls
+	"github.com/daniil1412412/grpc-file-service/pkg/limiter"
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// Main runs the file server the way the server command does: it reads the
+// flags, the -config file and the environment, serves until a signal drains
+// it and exits the process on a fatal error. A custom build calls it from
+// its own main with Options.
+func Main(opts ...Option) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	configFile := flag.String("config", os.Getenv(envName("config")), "YAML file of settings, one \"flag-name: value\" per line; FILE_SERVICE_<FLAG_NAME> environment variables override it, and command-line flags override both")
+	addr := flag.String("addr", ":50051", "listen address")
+	storageDir := flag.String("storage", "uploads", "storage directory; HA instances share one mount (NFS)")
+	maxTransfers := flag.Int("max-transfers", 10, "uploads and downloads served at once; further ones wait for a slot")
+	maxLists := flag.Int("max-lists", 100, "listings served at once; further ones wait for a slot")
+	limitPools := flag.String("limit-pools", "", "more pools of call slots, or queue timeouts for the transfers and lists pools: name=capacity or name=capacity/queue-timeout, comma-separated")
+	limitSpec := flag.String("limits", "", "which pool each method takes slots of, on top of "+defaultLimits+": Method=pool or Method=pool*weight, comma-separated; none leaves a method unlimited, * stands for unary methods not listed")
+	chunkSize := flag.Int("chunk-size", 64<<10, "bytes of file data sent per Download and Follow message")
+	maxFileSize := flag.Int64("max-file-size", 0, "reject uploads larger than this many bytes (0 no limit)")
+	quota := flag.Int64("quota", 0, "bytes the server may store in all; uploads that would exceed it are refused (0 no limit)")
+	userQuota := flag.Int64("user-quota", 0, "bytes each user's namespace may hold, with authentication (0 no limit)")
+	retentionTTL := flag.Duration("retention-ttl", 0, "delete files not modified for this long (0 disables)")
+	retentionMax := flag.Int64("retention-max-bytes", 0, "delete the least recently modified files while more than this many bytes are stored (0 disables)")
+	retentionDryRun := flag.Bool("retention-dry-run", false, "have the retention job only log what it would delete")
+	metaIndex := flag.Bool("meta-index", false, "keep file metadata (uploaded name, uploader, creation time, checksum, content type) in a bbolt index under -storage for listings and filters; the storage dir must not be shared with another instance")
+	keepVersions := flag.Int("keep-versions", 0, "versions of a file kept when an upload, import, rename or delete replaces it (0 keeps none)")
+	maxChunkSize := flag.Int("max-chunk-size", 0, "reject Upload messages carrying more than this many bytes of data (0: only gRPC's 4 MiB message limit)")
+	backend := flag.String("backend", "disk", "where files are stored: disk (the -storage dir), memory, or s3://bucket[/prefix]?endpoint=URL&region=REGION; server state stays in -storage")
+	lockBackend := flag.String("lock", "local", "per-file lock backend: local, dir (lock files on the shared storage) or redis")
+	redisAddr := flag.String("redis", "localhost:6379", "redis address for -lock=redis")
+	lockTTL := flag.Duration("lock-ttl", 30*time.Second, "lease of distributed locks; a crashed holder releases them after it")
+	lockWait := flag.Duration("lock-wait", 0, "how long a write waits for a file another call is writing before failing with ABORTED (0 waits until the call's deadline)")
+	self := flag.String("self", "", "this node's address as listed in -peers")
+	peers := flag.String("peers", "", "comma-separated addresses of all shard nodes; files are spread over them by consistent hashing")
+	vnodes := flag.Int("vnodes", 128, "virtual nodes per shard peer")
+	relay := flag.String("relay", "", "comma-separated pattern=addr rules; matching files are relayed to the upstream instance")
+	relayCache := flag.Duration("relay-cache", 0, "keep local copies of relayed downloads for this long (0 disables)")
+	chunking := flag.Bool("chunking", false, "store uploads as content-defined chunks shared between files (dedup)")
+	blobPool := flag.Bool("blob-pool", false, "store each file whole as a blob named by its SHA-256, shared by identical uploads and copies and reclaimed by chunk-gc once no file references it")
+	chunkGrace := flag.Duration("chunk-gc-grace", time.Hour, "keep unreferenced chunks at least this long before the chunk-gc job reclaims them; longer than any upload")
+	packSmall := flag.Int64("pack-small", 0, "store files up to this many bytes in shared pack files (0 disables)")
+	compressAfter := flag.Duration("compress-after", 0, "compress files not read or written for this long (0 disables)")
+	compressStored := flag.Bool("compress-stored", false, "store uploads that compress well gzip-compressed and decompress them as they are downloaded, without thawing; needs neither -chunking nor -pack-small")
+	encryptionKey := flag.String("encryption-key", "", "encrypt stored files with per-file keys wrapped by this master key: a file of 64-hex-digit keys, one per line (the first encrypts, all decrypt), env:NAME, vault:<path>#<field>, awskms:<key id or ARN>[?region=REGION&endpoint=URL] for AWS KMS with the AWS_* credentials, or kms:<command> for a plugin run as \"<command> wrap|unwrap\" with the key on stdin (empty stores files plain)")
+	tenantKeys := flag.Bool("tenant-keys", false, "with -encryption-key, wrap the data keys of each namespace's files with keys of the namespace's own, kept in .tenant-keys wrapped by the master key, and rotated with RotateTenantKey")
+	diskHigh := flag.Float64("disk-high", 0, "reject uploads once the storage volume is this full, e.g. 0.9 (0 disables)")
+	diskLow := flag.Float64("disk-low", 0, "accept uploads again below this fill level (default -disk-high)")
+	adminToken := flag.String("admin-token", "", "token admin RPCs must send in x-admin-token metadata, or vault:<path>#<field> (empty disables them)")
+	moderation := flag.String("moderation", "", "classifier for image and video uploads: grpc://host:port or an http(s) URL (empty disables)")
+	moderationTimeout := flag.Duration("moderation-timeout", time.Minute, "time limit of one classifier call")
+	uploadKeys := flag.String("upload-keys", "", "file or vault:<path>#<field> of trusted ed25519 public keys; uploads must carry a signature by one of them")
+	signingKey := flag.String("signing-key", "", "ed25519 key file (client keygen format) or vault:<path>#<field> used to sign manifests")
+	httpAddr := flag.String("http", "", "listen address of the HTTP gateway (empty disables it)")
+	corsOrigins := flag.String("cors-origins", "", "comma-separated origins allowed to call the HTTP gateway from browsers, * for any")
+	corsMethods := flag.String("cors-methods", "GET, HEAD, POST, OPTIONS", "methods allowed in CORS preflight")
+	corsHeaders := flag.String("cors-headers", "Authorization, Content-Type, If-Modified-Since, If-None-Match, If-Range, Range", "request headers allowed in CORS preflight")
+	corsCredentials := flag.Bool("cors-credentials", false, "allow cookies and auth headers on cross-origin requests")
+	corsMaxAge := flag.Duration("cors-max-age", 10*time.Minute, "how long browsers may cache preflight results")
+	slowRequest := flag.Duration("slow-request", 5*time.Second, "log requests taking at least this long in full (0 disables)")
+	largeRequest := flag.Int64("large-request", 1<<30, "log requests moving at least this many bytes in full (0 disables)")
+	logSample := flag.Int64("log-sample", 100, "log one in this many routine successful requests (0 logs none)")
+	logFormat := flag.String("log-format", "text", "log records as text (key=value) or json")
+	logLevel := flag.String("log-level", "info", "least severe records logged: debug, info, warn or error")
+	streamBandwidth := flag.Int64("stream-bandwidth", 0, "bytes per second each upload and download may transfer (0 is unlimited); admins can change it at runtime")
+	totalBandwidth := flag.Int64("bandwidth", 0, "bytes per second all uploads and downloads together may transfer (0 is unlimited); admins can change it at runtime")
+	idleTimeout := flag.Duration("idle-timeout", time.Minute, "abort uploads and downloads whose client sends or accepts nothing for this long (0 disables)")
+	deadlineSpec := flag.String("deadlines", "ListFiles=10s,Head=10s,StatFile=10m,HashFile=10m,GetPieceHashes=10m,GetSignedManifest=30m,Upload=2h,Download=2h,DownloadVersion=2h,DownloadArchive=2h,RedeemDownload=2h,CopyFile=2h,MoveFile=2h",
+		"per-method deadlines for calls without one or with a longer one: Method=duration or Method=default/max, comma-separated")
+	jobSpecs := flag.String("jobs", "", `job schedules overriding the defaults, e.g. "scrub=0 3 * * 0;cold-compress=@every 30m;staging-cleanup=off"; see ListJobs for the jobs`)
+	importRoots := flag.String("import-roots", "", "comma-separated host directories admins may import files from with ImportFiles (empty disables it)")
+	site := flag.String("site", "", "this site's name for geo-replication (default the host name)")
+	replicate := flag.String("replicate", "", "comma-separated site=addr peers to replicate changes with; each peer lists this site too (empty disables)")
+	conflicts := flag.String("conflicts", "lww", "geo-replication conflict handling: lww keeps the last write, rename also keeps the other under a .conflict- name")
+	mirrorTo := flag.String("mirror", "", "comma-separated addresses of servers every change is copied to after it is made, e.g. standbys (empty disables)")
+	mirrorRetryMax := flag.Duration("mirror-retry-max", 10*time.Minute, "longest wait between attempts to copy a file to a -mirror target")
+	readOnly := flag.Bool("read-only", false, "serve as a read-only replica of the single -replicate peer, refusing writes")
+	failoverAfter := flag.Duration("failover-after", 30*time.Second, "let a read-only replica accept writes once its primary is unreachable this long (0 never)")
+	cacheSize := flag.Int64("cache-size", 0, "bytes of memory for caching downloaded files (0 disables)")
+	cacheMaxFile := flag.Int64("cache-max-file", 4<<20, "only files up to this many bytes are cached")
+	vaultAddr := flag.String("vault-addr", os.Getenv("VAULT_ADDR"), "Vault server for vault: secret references; the token comes from VAULT_TOKEN")
+	vaultTokenFile := flag.String("vault-token-file", "", "read the Vault token from this file instead of VAULT_TOKEN")
+	vaultRefresh := flag.Duration("vault-refresh", 5*time.Minute, "how often secrets from Vault are re-read to pick up rotations")
+	apiKeys := flag.String("api-keys", "", "file or vault:<path>#<field> of \"<user> <key>\" lines; calls must then authenticate and see only their user's files")
+	jwtSecret := flag.String("jwt-secret", "", "HS256 secret, or vault:<path>#<field>, of JWTs whose sub is the user; calls must then authenticate like with -api-keys")
+	linkSecret := flag.String("link-secret", "", "HMAC secret, or vault:<path>#<field>, signing download links; shard nodes share it (empty disables links)")
+	linkTTL := flag.Duration("link-ttl", time.Hour, "how long download links last unless created with another ttl")
+	linkMaxTTL := flag.Duration("link-max-ttl", 7*24*time.Hour, "the longest ttl a download link may be created with")
+	linkBaseURL := flag.String("link-base-url", "", "public URL of the HTTP gateway, e.g. https://files.example.com, put in front of /links/<token> in created links")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate to serve gRPC and the HTTP gateway over TLS (empty serves plaintext)")
+	tlsKey := flag.String("tls-key", "", "PEM private key of -tls-cert (default: read from the -tls-cert file)")
+	tlsCA := flag.String("tls-ca", "", "PEM CA bundle peers' certificates are verified against, and with -mtls clients' (default system roots)")
+	mtls := flag.Bool("mtls", false, "require clients to present a certificate signed by -tls-ca")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "on SIGINT or SIGTERM, how long calls in progress may run before they are cancelled")
+	middlewareSpec := flag.String("middleware", "", "comma-separated middlewares given to this build to enable, in order (default all)")
+	flag.Parse()
+	if err := loadConfig(flag.CommandLine, *configFile); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if err := setupLogging(*logFormat, *logLevel); err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if *maxTransfers < 1 || *maxLists < 1 {
+		log.Fatalf("config: -max-transfers and -max-lists must be at least 1")
+	}
+	if *streamBandwidth < 0 || *totalBandwidth < 0 {
+		log.Fatalf("config: -stream-bandwidth and -bandwidth must not be negative")
+	}
+	if *linkTTL <= 0 || *linkMaxTTL < *linkTTL {
+		log.Fatalf("config: -link-ttl must be positive and at most -link-max-ttl")
+	}
+	// clients accept messages of up to 4 MiB by default
+	if *chunkSize < 1<<10 || *chunkSize > 4<<20-64<<10 {
+		log.Fatalf("config: -chunk-size must be between 1 KiB and 4032 KiB")
+	}
+
+	shutdownTracing, err := setupTracing(context.Background(), "grpc-file-service")
+	if err != nil {
+		log.Fatalf("tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	security, err := loadTransportSecurity(*tlsCert, *tlsKey, *tlsCA, *mtls)
+	if err != nil {
+		log.Fatalf("tls: %v", err)
+	}
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("listen on %s: %v", *addr, err)
+	}
+
+	locks, err := newLocker(*lockBackend, *storageDir, *redisAddr, *lockTTL, *lockWait)
+	if err != nil {
+		log.Fatalf("lock backend: %v", err)
+	}
+
+	limits, err := parseLimits(*maxTransfers, *maxLists, *limitPools, *limitSpec)
+	if err != nil {
+		log.Fatalf("limits: %v", err)
+	}
+	lim, err := limiter.New(limits)
+	if err != nil {
+		log.Fatalf("limits: %v", err)
+	}
+	publishLimits(lim)
+
+	srv := &fileServer{
+		storageDir:        *storageDir,
+		limiter:           lim,
+		locks:             locks,
+		relayCacheTTL:     *relayCache,
+		chunking:          *chunking || *blobPool,
+		blobPool:          *blobPool,
+		moderationTimeout: *moderationTimeout,
+		idleTimeout:       *idleTimeout,
+		bandwidth:         newBandwidth(*streamBandwidth, *totalBandwidth),
+		chunkSize:         *chunkSize,
+		maxFileSize:       *maxFileSize,
+		maxChunkSize:      *maxChunkSize,
+		keepVersions:      *keepVersions,
+		retention:         retentionPolicy{ttl: *retentionTTL, maxBytes: *retentionMax, dryRun: *retentionDryRun},
+		links:             linkPolicy{ttl: *linkTTL, maxTTL: *linkMaxTTL, baseURL: *linkBaseURL},
+		usage:             newUsage(*quota, *userQuota),
+		cache:             newDownloadCache(*cacheSize, *cacheMaxFile),
+		chunks:            newChunkIndex(*storageDir, *chunkGrace),
+	}
+	if srv.store, err = openFileStore(*backend, srv); err != nil {
+		log.Fatalf("backend: %v", err)
+	}
+	if *chunking && *blobPool {
+		log.Fatal("-chunking and -blob-pool are two ways of storing files; pick one")
+	}
+	if !srv.onDisk() && (srv.chunking || *packSmall > 0 || *compressAfter > 0 || *compressStored || *encryptionKey != "" || *replicate != "" || *readOnly || *relayCache > 0) {
+		log.Fatalf("backend %s: -chunking, -blob-pool, -pack-small, -compress-after, -compress-stored, -encryption-key, -replicate, -read-only and -relay-cache need the disk backend", *backend)
+	}
+	if *compressStored && (srv.chunking || *packSmall > 0) {
+		log.Fatal("-compress-stored does not work with -chunking, -blob-pool or -pack-small")
+	}
+	if *encryptionKey != "" && (srv.chunking || *packSmall > 0 || *compressAfter > 0 || *compressStored) {
+		log.Fatal("-encryption-key does not work with -chunking, -blob-pool, -pack-small, -compress-after or -compress-stored")
+	}
+	masterKeyRef := *encryptionKey
+	if command, ok := strings.CutPrefix(*encryptionKey, "kms:"); ok {
+		if srv.crypt, err = newKMSPlugin(command); err != nil {
+			log.Fatalf("encryption-key: %v", err)
+		}
+		masterKeyRef = ""
+	} else if key, ok := strings.CutPrefix(*encryptionKey, "awskms:"); ok {
+		if srv.crypt, err = newAWSKMS(key); err != nil {
+			log.Fatalf("encryption-key: %v", err)
+		}
+		masterKeyRef = ""
+	} else if masterKeyRef != "" {
+		srv.crypt = masterKeys{srv}
+	}
+	if *tenantKeys && srv.crypt == nil {
+		log.Fatal("-tenant-keys needs -encryption-key")
+	}
+	if srv.crypt != nil {
+		// read also without -tenant-keys, for the files sealed with them
+		if srv.tenantKeys, err = openTenantKeys(*storageDir, srv.crypt, *tenantKeys); err != nil {
+			log.Fatalf("tenant keys: %v", err)
+		}
+	}
+	if srv.packs, err = openPackStore(*storageDir, *packSmall); err != nil {
+		log.Fatalf("pack store: %v", err)
+	}
+	if *diskLow <= 0 || *diskLow > *diskHigh {
+		*diskLow = *diskHigh
+	}
+	srv.disk = &diskGuard{dir: *storageDir, high: *diskHigh, low: *diskLow}
+	srv.cold = openColdStore(*storageDir, *compressAfter, *compressStored)
+	if err := os.MkdirAll(*storageDir, 0o755); err != nil {
+		log.Fatalf("storage: %v", err)
+	}
+	for _, root := range splitList(*importRoots) {
+		abs, err := filepath.Abs(root)
+		if err == nil {
+			abs, err = filepath.EvalSymlinks(abs)
+		}
+		if err != nil {
+			log.Fatalf("import roots: %v", err)
+		}
+		srv.importRoots = append(srv.importRoots, abs)
+	}
+	overrides, err := parseJobSpecs(*jobSpecs)
+	if err != nil {
+		log.Fatalf("jobs: %v", err)
+	}
+	srv.jobs = newScheduler(overrides)
+	if *compressAfter > 0 {
+		srv.jobs.add("cold-compress", "@hourly", srv.coldJob)
+	}
+	if !*readOnly && srv.onDisk() {
+		// a replica quarantining a file would delete it on the primary too
+		srv.jobs.add("scrub", "0 3 * * 0", srv.scrubJob)
+	}
+	if !*readOnly && srv.retention.enabled() {
+		// a replica deletes what its primary deletes
+		srv.jobs.add("retention", "@hourly", srv.retentionJob)
+	}
+	srv.jobs.add("staging-cleanup", "@every 6h", srv.cleanupJob)
+	if _, err := os.Stat(filepath.Join(*storageDir, ".chunks")); srv.chunking || err == nil {
+		srv.jobs.add("chunk-gc", "30 4 * * *", srv.chunkGCJob)
+	}
+	if _, err := os.Stat(filepath.Join(*storageDir, ".packs")); *packSmall > 0 || err == nil {
+		srv.jobs.add("pack-compact", "0 5 * * *", srv.packCompactJob)
+	}
+	sources := []*secretSource{
+		{flag: "admin-token", ref: *adminToken, literal: true, set: func(sec *serverSecrets, _ string, b []byte) error {
+			sec.adminToken = string(b)
+			return nil
+		}},
+		{flag: "upload-keys", ref: *uploadKeys, set: func(sec *serverSecrets, src string, b []byte) (err error) {
+			sec.uploadKeys, err = parsePublicKeys(src, b)
+			return err
+		}},
+		{flag: "signing-key", ref: *signingKey, set: func(sec *serverSecrets, src string, b []byte) (err error) {
+			sec.signingKey, err = parseSigningKey(src, b)
+			return err
+		}},
+		{flag: "api-keys", ref: *apiKeys, set: func(sec *serverSecrets, src string, b []byte) (err error) {
+			sec.apiKeys, err = parseAPIKeys(src, b)
+			return err
+		}},
+		{flag: "jwt-secret", ref: *jwtSecret, literal: true, set: func(sec *serverSecrets, _ string, b []byte) error {
+			sec.jwtSecret = b
+			return nil
+		}},
+		{flag: "link-secret", ref: *linkSecret, literal: true, set: func(sec *serverSecrets, _ string, b []byte) error {
+			sec.linkSecret = b
+			return nil
+		}},
+		{flag: "encryption-key", ref: masterKeyRef, set: func(sec *serverSecrets, src string, b []byte) error {
+			keys, err := parseMasterKeys(src, b)
+			sec.masterKeys = mergeKeys(keys, sec.masterKeys)
+			return err
+		}},
+	}
+	srv.auth = *apiKeys != "" || *jwtSecret != ""
+	var vault *vaultClient
+	for _, src := range sources {
+		if isVaultRef(src.ref) && vault == nil {
+			if vault, err = newVaultClient(*vaultAddr, *vaultTokenFile); err != nil {
+				log.Fatalf("%v", err)
+			}
+		}
+		if src.ref == "" {
+			continue
+		}
+		b, err := src.read(context.Background(), vault)
+		if err == nil {
+			err = srv.applySecret(src, b)
+		}
+		if err != nil {
+			log.Fatalf("%s: %v", src.flag, err)
+		}
+	}
+	if vault != nil {
+		go srv.watchSecrets(vault, sources, *vaultRefresh)
+	}
+	if srv.onDisk() {
+		// after the secrets, which sealed files are checked against, and
+		// before anything reads the files
+		var sealed func(string) bool
+		if srv.crypt != nil {
+			sealed = srv.verifySealed
+		}
+		if err := scanLayouts(*storageDir, sealed); err != nil {
+			log.Fatalf("storage layouts: %v", err)
+		}
+	}
+	if *metaIndex {
+		if srv.meta, err = openMetaIndex(*storageDir); err != nil {
+			log.Fatalf("meta index: %v", err)
+		}
+		if err := srv.reconcileMeta(); err != nil {
+			log.Fatalf("meta index: %v", err)
+		}
+	}
+	if err := srv.scanUsage(); err != nil {
+		log.Fatalf("usage: %v", err)
+	}
+	srv.usage.publish()
+	if srv.onDisk() {
+		go srv.watchStorage()
+	}
+	if err := srv.jobs.start(); err != nil {
+		log.Fatalf("jobs: %v", err)
+	}
+	if *moderation != "" {
+		if srv.moderator, err = newModerator(*moderation); err != nil {
+			log.Fatalf("moderation: %v", err)
+		}
+	}
+	if srv.relay, err = parseRelayRules(*relay); err != nil {
+		log.Fatalf("relay: %v", err)
+	}
+	if *peers != "" {
+		ring, err := newShardRing(*self, strings.Split(*peers, ","), *vnodes)
+		if err != nil {
+			log.Fatalf("shard ring: %v", err)
+		}
+		srv.ring = ring
+	}
+	replicas, err := parseReplicas(*replicate)
+	if err != nil {
+		log.Fatalf("replicate: %v", err)
+	}
+	if *conflicts != "lww" && *conflicts != "rename" {
+		log.Fatalf("unknown -conflicts %q, want lww or rename", *conflicts)
+	}
+	if len(replicas) > 0 && srv.ring != nil {
+		log.Fatalf("replicate: not supported together with -peers")
+	}
+	if srv.site = *site; srv.site == "" {
+		srv.site, _ = os.Hostname()
+	}
+	if srv.ring != nil || len(srv.relay) > 0 || len(replicas) > 0 || *mirrorTo != "" {
+		srv.peers = newPeerPool(security.peers)
+	}
+	srv.replicas = make(map[string]*replicator, len(replicas))
+	for peer, addr := range replicas {
+		if peer == srv.site {
+			log.Fatalf("replicate: %s is this site", peer)
+		}
+		srv.replicas[peer] = newReplicator(srv, peer, addr, *conflicts == "rename")
+	}
+	if *readOnly {
+		if len(replicas) != 1 {
+			log.Fatalf("read-only: -replicate must name exactly the primary")
+		}
+		for peer := range replicas {
+			srv.primary = peer
+		}
+		srv.readOnly, srv.failoverAfter = true, *failoverAfter
+	}
+	for _, r := range srv.replicas {
+		go r.run()
+	}
+	for _, addr := range splitList(*mirrorTo) {
+		srv.mirrors = append(srv.mirrors, newMirror(srv, addr, *mirrorRetryMax))
+	}
+	if len(srv.mirrors) > 0 {
+		for _, m := range srv.mirrors {
+			go m.run()
+		}
+		go srv.followMirrors()
+	}
+
+	deadlines, err := parseDeadlines(*deadlineSpec)
+	if err != nil {
+		log.Fatalf("deadlines: %v", err)
+	}
+	extra, err := selectMiddleware(o.middlewares, *middlewareSpec)
+	if err != nil {
+		log.Fatalf("middleware: %v", err)
+	}
+	accessLog := &accessLog{slow: *slowRequest, large: *largeRequest, sample: *logSample}
+	grpcServer := newGRPCServer(srv, accessLog, deadlines, extra, security.server)
+
+	proto.RegisterFileServiceServer(grpcServer, srv)
+	srv.health = health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, srv.health)
+	reflection.Register(grpcServer)
+	go srv.watchHealth()
+
+	var hs *http.Server
+	if *httpAddr != "" {
+		cors := corsConfig{
+			origins:     splitList(*corsOrigins),
+			methods:     *corsMethods,
+			headers:     *corsHeaders,
+			credentials: *corsCredentials,
+			maxAge:      *corsMaxAge,
+		}
+		gw, err := newGateway(*addr, cors, security.self)
+		if err != nil {
+			log.Fatalf("http gateway: %v", err)
+		}
+		hs = &http.Server{Addr: *httpAddr, Handler: gw.handler(), TLSConfig: security.server}
+		go func() {
+			var err error
+			if hs.TLSConfig != nil {
+				err = hs.ListenAndServeTLS("", "")
+			} else {
+				err = hs.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				log.Fatalf("http gateway: %v", err)
+			}
+		}()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		srv.drainOnSignal(grpcServer, hs, *drainTimeout)
+		close(drained)
+	}()
+	slog.Info("server started", "addr", lis.Addr().String(), "storage", *storageDir)
+	if err := grpcServer.Serve(lis); err != nil {
+		log.Fatalf("serve: %v", err)
+	}
+	<-drained
+	slog.Info("server stopped")
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type manifestFile struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+type manifest struct {
+	CreatedAt string         `json:"created_at"`
+	Files     []manifestFile `json:"files"`
+}
+
+// parseSigningKey reads the server key, a base64 ed25519 seed as written by
+// the client's keygen command. src names where b came from in errors.
+func parseSigningKey(src string, b []byte) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s: not a base64 ed25519 seed", src)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// GetSignedManifest lists sizes and checksums of the requested files and
+// signs the list, so a downloaded set can be verified offline against the
+// server's public key.
+func (s *fileServer) GetSignedManifest(ctx context.Context, req *proto.ManifestRequest) (*proto.SignedManifest, error) {
+	key := s.secret().signingKey
+	if key == nil {
+		return nil, status.Error(codes.FailedPrecondition, "server has no signing key, start it with -signing-key")
+	}
+	names := req.GetFilenames()
+	if len(names) == 0 {
+		list, err := s.ListFiles(ctx, &proto.ListRequest{})
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range list.Files {
+			names = append(names, f.Filename)
+		}
+	}
+	m := manifest{CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+	for _, name := range names {
+		// HashFile routes to the owner, so sharded files are covered too
+		sum, err := s.HashFile(ctx, &proto.HashRequest{Filename: name})
+		if err != nil {
+			return nil, err
+		}
+		m.Files = append(m.Files, manifestFile{Filename: shownName(ctx, sum.Filename), Size: sum.SizeBytes, SHA256: sum.Sha256})
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.SignedManifest{
+		Manifest:  b,
+		Signature: ed25519.Sign(key, b),
+		PublicKey: key.Public().(ed25519.PublicKey),
+	}, nil
+}
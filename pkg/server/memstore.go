@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// objectInfo describes a file in an object store. The tag changes with
+// every new version.
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	tag     string
+}
+
+func (i objectInfo) Name() string       { return i.name }
+func (i objectInfo) Size() int64        { return i.size }
+func (i objectInfo) Mode() fs.FileMode  { return 0o644 }
+func (i objectInfo) ModTime() time.Time { return i.modTime }
+func (i objectInfo) IsDir() bool        { return false }
+func (i objectInfo) Sys() any           { return nil }
+
+// memStore keeps files in memory. Everything is lost on restart, which
+// suits tests and scratch instances.
+type memStore struct {
+	mu    sync.RWMutex
+	files map[string]memFile
+	gen   int64
+}
+
+type memFile struct {
+	data []byte
+	info objectInfo
+}
+
+func newMemStore() *memStore {
+	return &memStore{files: make(map[string]memFile)}
+}
+
+func (m *memStore) Put(name string) (io.WriteCloser, error) {
+	return &memWriter{m: m, name: name}, nil
+}
+
+type memWriter struct {
+	m    *memStore
+	name string
+	buf  bytes.Buffer
+}
+
+func (w *memWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+func (w *memWriter) Close() error {
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+	w.m.gen++
+	w.m.files[w.name] = memFile{
+		data: w.buf.Bytes(),
+		info: objectInfo{name: w.name, size: int64(w.buf.Len()), modTime: time.Now(), tag: strconv.FormatInt(w.m.gen, 10)},
+	}
+	return nil
+}
+
+func (m *memStore) Get(name string) (io.ReadSeekCloser, fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f, ok := m.files[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	// versions are never modified, only replaced
+	return nopSeekCloser{bytes.NewReader(f.data)}, f.info, nil
+}
+
+func (m *memStore) Stat(name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	f, ok := m.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	return f.info, nil
+}
+
+func (m *memStore) List() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *memStore) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, name)
+	return nil
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// metaBucket holds a fileMeta per stored name.
+var metaBucket = []byte("files")
+
+// metaIndex records what the store itself does not keep about a file: the
+// name it was uploaded with, by whom and when it was first stored, along
+// with its size, checksum and content type, so listings need neither walk
+// nor read the store. It lives in .meta/index.db, a bbolt file one server
+// holds open at a time. The store helpers keep it current through recount;
+// the startup reconcile catches what changed while the server was down.
+// A nil index is disabled and its methods do nothing.
+type metaIndex struct {
+	db *bolt.DB
+}
+
+type fileMeta struct {
+	Original    string    `json:"original,omitempty"`
+	Uploader    string    `json:"uploader,omitempty"`
+	Created     time.Time `json:"created"`
+	Uploaded    time.Time `json:"uploaded,omitempty"`
+	Size        int64     `json:"size"`
+	MTime       int64     `json:"mtime"` // unix nanoseconds
+	SHA256      string    `json:"sha256,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+}
+
+// current reports whether m still describes the file info is the stat of;
+// its checksum and content type are only trusted then.
+func (m *fileMeta) current(info fs.FileInfo) bool {
+	return m.Size == info.Size() && m.MTime == info.ModTime().UnixNano()
+}
+
+// metaInfo serves an index record as a stat.
+type metaInfo struct {
+	name string
+	m    *fileMeta
+}
+
+func (i metaInfo) Name() string       { return path.Base(i.name) }
+func (i metaInfo) Size() int64        { return i.m.Size }
+func (i metaInfo) Mode() fs.FileMode  { return 0o644 }
+func (i metaInfo) ModTime() time.Time { return time.Unix(0, i.m.MTime) }
+func (i metaInfo) IsDir() bool        { return false }
+func (i metaInfo) Sys() any           { return nil }
+
+// openMetaIndex opens the index in dir. Another server holding it open
+// fails the open after a second rather than blocking.
+func openMetaIndex(dir string) (*metaIndex, error) {
+	if err := os.MkdirAll(filepath.Join(dir, ".meta"), 0o755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filepath.Join(dir, ".meta", "index.db"), 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", filepath.Join(dir, ".meta", "index.db"), err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(metaBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &metaIndex{db: db}, nil
+}
+
+func getMeta(b *bolt.Bucket, name string) *fileMeta {
+	v := b.Get([]byte(name))
+	if v == nil {
+		return nil
+	}
+	var m fileMeta
+	if err := json.Unmarshal(v, &m); err != nil {
+		slog.Warn("meta index", "file", name, "error", err)
+		return nil
+	}
+	return &m
+}
+
+func putMeta(b *bolt.Bucket, name string, m *fileMeta) error {
+	v, _ := json.Marshal(m)
+	return b.Put([]byte(name), v)
+}
+
+// get returns the record of name, or nil.
+func (x *metaIndex) get(name string) *fileMeta {
+	if x == nil {
+		return nil
+	}
+	var m *fileMeta
+	_ = x.db.View(func(tx *bolt.Tx) error {
+		m = getMeta(tx.Bucket(metaBucket), name)
+		return nil
+	})
+	return m
+}
+
+// all returns every record by name.
+func (x *metaIndex) all() (map[string]*fileMeta, error) {
+	files := make(map[string]*fileMeta)
+	err := x.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).ForEach(func(k, v []byte) error {
+			var m fileMeta
+			if err := json.Unmarshal(v, &m); err != nil {
+				slog.Warn("meta index", "file", string(k), "error", err)
+				return nil
+			}
+			files[string(k)] = &m
+			return nil
+		})
+	})
+	return files, err
+}
+
+// update applies fn to the record of name in a write transaction. fn gets
+// nil for a name without one and returns the record to store, or nil to
+// delete it.
+func (x *metaIndex) update(name string, fn func(*fileMeta) *fileMeta) {
+	if x == nil {
+		return
+	}
+	err := x.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(metaBucket)
+		m := fn(getMeta(b, name))
+		if m == nil {
+			return b.Delete([]byte(name))
+		}
+		return putMeta(b, name, m)
+	})
+	if err != nil {
+		slog.Warn("meta index", "file", name, "error", err)
+	}
+}
+
+// sync brings the record of name in line with its stat, nil when it is no
+// longer stored. A changed file keeps when and by whom it was created, but
+// its checksum and content type are found again when next asked for.
+func (x *metaIndex) sync(name string, info fs.FileInfo) {
+	if x == nil {
+		return
+	}
+	if m := x.get(name); m != nil && info != nil && m.current(info) {
+		return // most calls change nothing; skip the write transaction
+	}
+	x.update(name, func(m *fileMeta) *fileMeta { return synced(m, info) })
+}
+
+func synced(m *fileMeta, info fs.FileInfo) *fileMeta {
+	switch {
+	case info == nil:
+		return nil
+	case m == nil:
+		// the best guess for files stored before the index was
+		m = &fileMeta{Created: info.ModTime().UTC()}
+	case m.current(info):
+		return m
+	}
+	m.Size, m.MTime = info.Size(), info.ModTime().UnixNano()
+	m.SHA256, m.ContentType = "", ""
+	return m
+}
+
+// uploaded records who stored name, under which name, and what it holds.
+// The store helpers have synced the record already.
+func (x *metaIndex) uploaded(name, original, uploader, sum, ct string) {
+	x.update(name, func(m *fileMeta) *fileMeta {
+		if m != nil {
+			m.Original, m.Uploader, m.Uploaded = original, uploader, time.Now().UTC()
+			m.SHA256, m.ContentType = sum, ct
+		}
+		return m
+	})
+}
+
+// fill stores a checksum or content type found for name while its record
+// still describes info.
+func (x *metaIndex) fill(name string, info fs.FileInfo, sum, ct string) {
+	x.update(name, func(m *fileMeta) *fileMeta {
+		if m != nil && m.current(info) {
+			if sum != "" {
+				m.SHA256 = sum
+			}
+			if ct != "" {
+				m.ContentType = ct
+			}
+		}
+		return m
+	})
+}
+
+// put stores m as the record of name, carrying a record over a rename.
+func (x *metaIndex) put(name string, m *fileMeta) {
+	if m != nil {
+		x.update(name, func(*fileMeta) *fileMeta { return m })
+	}
+}
+
+// reconcileMeta brings the index in line with the store in one transaction:
+// records of files gone are dropped, files without one get one and
+// records of changed files are synced.
+func (s *fileServer) reconcileMeta() error {
+	start := time.Now()
+	names, err := s.store.List()
+	if err != nil {
+		return err
+	}
+	stored := make(map[string]bool, len(names))
+	var added, dropped int
+	err = s.meta.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(metaBucket)
+		for _, name := range names {
+			info, err := s.statStored(name)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			stored[name] = true
+			m := getMeta(b, name)
+			if m != nil && m.current(info) {
+				continue
+			}
+			if m == nil {
+				added++
+			}
+			if err := putMeta(b, name, synced(m, info)); err != nil {
+				return err
+			}
+		}
+		var stale [][]byte
+		_ = b.ForEach(func(k, _ []byte) error {
+			if !stored[string(k)] {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		dropped = len(stale)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("meta index reconciled", "files", len(stored), "added", added, "dropped", dropped, "took", time.Since(start).Round(time.Millisecond))
+	return nil
+}
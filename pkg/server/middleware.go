@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sort"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// Stage is where in the interceptor chain a Middleware runs. The built-in
+// chain is: request ID, access log, [AfterLog], authentication, deadlines,
+// read-only guard, [BeforeLimit], limiter, transfer pacing, panic recovery,
+// [Last], handler.
+type Stage int
+
+const (
+	// AfterLog sees every call and its rejections are logged: auth,
+	// tenancy.
+	AfterLog Stage = iota
+	// BeforeLimit runs with the call's deadline applied, before the call
+	// waits for a transfer slot, so cheap rejections do not take one.
+	BeforeLimit
+	// Last wraps just the handler, e.g. to measure handler time.
+	Last
+)
+
+// Middleware is a pair of interceptors added to the server's chain. Either
+// may be nil.
+type Middleware struct {
+	Name   string
+	Stage  Stage
+	Unary  grpc.UnaryServerInterceptor
+	Stream grpc.StreamServerInterceptor
+}
+
+// An Option configures a server started by Main.
+type Option func(*options)
+
+type options struct {
+	middlewares []Middleware
+}
+
+// WithMiddleware makes middlewares available to the server; -middleware
+// picks which of them run and in what order.
+func WithMiddleware(m ...Middleware) Option {
+	return func(o *options) { o.middlewares = append(o.middlewares, m...) }
+}
+
+// selectMiddleware returns the middlewares named in spec, comma-separated,
+// in that order within each stage. Empty spec enables all of them in name
+// order.
+func selectMiddleware(available []Middleware, spec string) ([]Middleware, error) {
+	byName := make(map[string]Middleware, len(available))
+	for _, m := range available {
+		if _, dup := byName[m.Name]; dup {
+			return nil, fmt.Errorf("middleware %q given twice", m.Name)
+		}
+		byName[m.Name] = m
+	}
+	names := splitList(spec)
+	if len(names) == 0 {
+		for name := range byName {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	var out []Middleware
+	for _, name := range names {
+		m, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %q", name)
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// defaultMaxRecvMsgSize is grpc's limit on a received message, and
+// msgOverhead leaves room beside an Upload message's data for its other
+// fields.
+const (
+	defaultMaxRecvMsgSize = 4 << 20
+	msgOverhead           = 64 << 10
+)
+
+// newGRPCServer assembles the interceptor chain around srv. A nil tlsConfig
+// serves plaintext.
+func newGRPCServer(srv *fileServer, accessLog *accessLog, deadlines map[string]methodDeadline, extra []Middleware, tlsConfig *tls.Config) *grpc.Server {
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+	add := func(stage Stage) {
+		for _, m := range extra {
+			if m.Stage != stage {
+				continue
+			}
+			if m.Unary != nil {
+				unary = append(unary, m.Unary)
+			}
+			if m.Stream != nil {
+				stream = append(stream, m.Stream)
+			}
+		}
+	}
+	// the access log goes first so waiting for a slot counts as slow, and
+	// deadlines apply to that wait too
+	unary = append(unary, unaryRequestID, accessLog.unary())
+	stream = append(stream, streamRequestID, accessLog.stream())
+	add(AfterLog)
+	unary = append(unary, unaryAuth(srv))
+	stream = append(stream, streamAuth(srv))
+	unary = append(unary, unaryDeadlineInterceptor(deadlines), unaryWriteGuard(srv))
+	stream = append(stream, streamDeadlineInterceptor(deadlines), streamWriteGuard(srv))
+	add(BeforeLimit)
+	unary = append(unary, unaryCalls(srv), srv.limiter.Unary(), unaryRecover)
+	stream = append(stream, streamCalls(srv), srv.limiter.Stream(), streamTransfers(srv), streamRecover)
+	add(Last)
+	opts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...),
+		grpc.StatsHandler(otelgrpc.NewServerHandler())}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	if srv.maxChunkSize > defaultMaxRecvMsgSize-msgOverhead {
+		// let chunks up to the limit through; checkChunk refuses larger ones
+		opts = append(opts, grpc.MaxRecvMsgSize(srv.maxChunkSize+msgOverhead))
+	}
+	return grpc.NewServer(opts...)
+}
+
+// unaryRecover turns a panicking call into an Internal error instead of a
+// crashed server.
+func unaryRecover(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer recoverCall(ctx, info.FullMethod, &err)
+	return handler(ctx, req)
+}
+
+func streamRecover(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer recoverCall(ss.Context(), info.FullMethod, &err)
+	return handler(srv, ss)
+}
+
+func recoverCall(ctx context.Context, method string, err *error) {
+	if p := recover(); p != nil {
+		slog.ErrorContext(ctx, "panic", "method", method, "panic", fmt.Sprint(p), "stack", string(debug.Stack()))
+		*err = status.Error(codes.Internal, "internal error")
+	}
+}
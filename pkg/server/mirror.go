@@ -0,0 +1,277 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+const (
+	mirrorRetry   = 5 * time.Second // after the first failure, doubling up to -mirror-retry-max
+	mirrorTimeout = 2 * time.Hour   // of copying one file
+)
+
+// mirrorVars holds the counts of each -mirror target, served under
+// /debug/vars.
+var mirrorVars = expvar.NewMap("mirror")
+
+// mirror copies the changes of this server to one target, any server of
+// this API, with Upload and Delete calls. Unlike -replicate nothing runs on
+// the target: it is a plain server, such as a standby. Every change queues
+// its file; the queue holds the latest state to send, not the history, so
+// a file changed ten times while the target is down is sent once. Failed
+// files are retried with backoff. The queue is saved in .mirror, so
+// changes made before a restart are still sent.
+type mirror struct {
+	s        *fileServer
+	addr     string
+	path     string
+	retryMax time.Duration
+
+	mu       sync.Mutex
+	queue    map[string]*mirrorItem
+	gen      uint64
+	wake     chan struct{}
+	mirrored int64
+	failures int64
+	lastErr  string
+	lastOK   time.Time
+}
+
+type mirrorItem struct {
+	Since    time.Time `json:"since"` // when the oldest change not sent was queued
+	next     time.Time
+	attempts int
+	gen      uint64 // changes since a send started requeue the file
+}
+
+func newMirror(s *fileServer, addr string, retryMax time.Duration) *mirror {
+	m := &mirror{
+		s: s, addr: addr, retryMax: retryMax,
+		path:  filepath.Join(s.storageDir, ".mirror", strings.NewReplacer(":", "_", "/", "_").Replace(addr)+".json"),
+		queue: make(map[string]*mirrorItem),
+		wake:  make(chan struct{}, 1),
+	}
+	if b, err := os.ReadFile(m.path); err == nil {
+		if err := json.Unmarshal(b, &m.queue); err != nil {
+			slog.Warn("mirror: queue unreadable, starting empty", "target", addr, "error", err)
+			m.queue = make(map[string]*mirrorItem)
+		}
+	}
+	mirrorVars.Set(addr, expvar.Func(func() any { return m.status(0) }))
+	return m
+}
+
+// enqueue queues name to be sent as it is stored when its turn comes.
+func (m *mirror) enqueue(name string) {
+	m.mu.Lock()
+	it := m.queue[name]
+	if it == nil {
+		it = &mirrorItem{Since: time.Now()}
+		m.queue[name] = it
+	}
+	m.gen++
+	it.gen, it.next, it.attempts = m.gen, time.Time{}, 0
+	m.mu.Unlock()
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// due returns the longest queued file whose turn has come, or how long
+// until the next one's does; 0 with nothing queued.
+func (m *mirror) due() (string, uint64, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	var wait time.Duration
+	var pick string
+	for name, it := range m.queue {
+		if !it.next.After(now) {
+			if pick == "" || it.Since.Before(m.queue[pick].Since) {
+				pick = name
+			}
+		} else if d := it.next.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+	if pick != "" {
+		return pick, m.queue[pick].gen, 0
+	}
+	return "", 0, wait
+}
+
+// run sends the queued files one at a time, forever.
+func (m *mirror) run() {
+	for {
+		name, gen, wait := m.due()
+		if name == "" {
+			var timer *time.Timer
+			var fire <-chan time.Time
+			if wait > 0 {
+				timer = time.NewTimer(wait)
+				fire = timer.C
+			}
+			select {
+			case <-m.wake:
+			case <-fire:
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			continue
+		}
+		m.done(name, gen, m.send(name))
+	}
+}
+
+// send makes the target hold what this server holds as name: the file, or
+// nothing if it was deleted.
+func (m *mirror) send(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+	defer cancel()
+	// the target may require authentication; the admin token lets the
+	// stored name, namespace included, through as it is
+	if tok := m.s.secret().adminToken; tok != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, adminTokenKey, tok)
+	}
+	c, err := m.s.peers.client(m.addr)
+	if err != nil {
+		return err
+	}
+	info, err := m.s.statStored(name)
+	if status.Code(err) == codes.NotFound {
+		if _, err := c.Delete(ctx, &proto.DeleteRequest{Filename: name}); status.Code(err) != codes.NotFound {
+			return err
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// a target already holding the content is left alone, which also ends
+	// the echo of a target that mirrors back to this server
+	if sum, ok := m.s.cachedChecksum(name, info); ok {
+		mask := &fieldmaskpb.FieldMask{Paths: []string{"sha256"}}
+		if fi, err := c.StatFile(ctx, &proto.StatRequest{Filename: name, ReadMask: mask}); err == nil && fi.GetSha256() == sum {
+			return nil
+		}
+	}
+	f, _, err := m.s.openCached(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.s.uploadTo(ctx, m.addr, name, f)
+}
+
+func (m *mirror) done(name string, gen uint64, err error) {
+	m.mu.Lock()
+	it := m.queue[name]
+	switch {
+	case err == nil:
+		m.mirrored++
+		m.lastOK = time.Now()
+		if it.gen == gen {
+			delete(m.queue, name)
+		}
+	case it.gen == gen:
+		m.failures++
+		m.lastErr = name + ": " + err.Error()
+		it.next = time.Now().Add(min(mirrorRetry<<min(it.attempts, 20), m.retryMax))
+		it.attempts++
+	}
+	b, merr := json.Marshal(m.queue)
+	m.mu.Unlock()
+	if err != nil {
+		slog.Warn("mirror", "target", m.addr, "file", name, "error", err)
+	}
+	if merr == nil {
+		merr = writeFileAtomic(m.path, b)
+	}
+	if merr != nil {
+		slog.Warn("mirror: save queue", "target", m.addr, "error", merr)
+	}
+}
+
+// status describes the target with up to files of its queued names.
+func (m *mirror) status(files int) *proto.MirrorStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := &proto.MirrorStatus{
+		Address:   m.addr,
+		Pending:   int64(len(m.queue)),
+		Mirrored:  m.mirrored,
+		Failures:  m.failures,
+		LastError: m.lastErr,
+	}
+	if !m.lastOK.IsZero() {
+		st.LastMirroredAt = m.lastOK.UTC().Format(time.RFC3339)
+	}
+	names := make([]string, 0, len(m.queue))
+	for name := range m.queue {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return m.queue[names[i]].Since.Before(m.queue[names[j]].Since) })
+	if len(names) > 0 {
+		st.OldestPendingAt = m.queue[names[0]].Since.UTC().Format(time.RFC3339)
+	}
+	st.PendingFiles = names[:min(files, len(names))]
+	return st
+}
+
+// followMirrors queues every change logged in the change feed on all
+// mirrors. Changes the log dropped before they were read queue every
+// stored file.
+func (s *fileServer) followMirrors() {
+	epoch, after := "", uint64(0)
+	for {
+		recs, cur, head, ok, wake := s.changes.since(epoch, after)
+		switch {
+		case ok:
+			for _, rec := range recs {
+				for _, m := range s.mirrors {
+					m.enqueue(rec.name)
+				}
+			}
+		case epoch != "":
+			slog.Warn("mirror: fell behind the change log, queueing every file")
+			names, err := s.store.List()
+			if err != nil {
+				slog.Warn("mirror: list", "error", err)
+			}
+			for _, name := range names {
+				for _, m := range s.mirrors {
+					m.enqueue(name)
+				}
+			}
+		}
+		epoch, after = cur, head
+		<-wake
+	}
+}
+
+func (s *fileServer) GetReplicationStatus(ctx context.Context, _ *proto.ReplicationStatusRequest) (*proto.ReplicationStatus, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	resp := &proto.ReplicationStatus{}
+	for _, m := range s.mirrors {
+		resp.Mirrors = append(resp.Mirrors, m.status(100))
+	}
+	return resp, nil
+}
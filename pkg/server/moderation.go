@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// moderator classifies an upload before it is published.
+type moderator interface {
+	classify(ctx context.Context, name, contentType string, size int64, r io.Reader) (proto.ClassifyResponse_Verdict, string, error)
+}
+
+// newModerator returns the classifier behind target: grpc://host:port for
+// a proto.Moderator service, or an http(s) URL that gets the file POSTed and
+// answers {"verdict": "accept|quarantine|reject", "reason": "..."}.
+func newModerator(target string) (moderator, error) {
+	switch {
+	case strings.HasPrefix(target, "grpc://"):
+		conn, err := grpc.Dial(strings.TrimPrefix(target, "grpc://"), grpc.WithInsecure(), grpc.WithStatsHandler(otelgrpc.NewClientHandler()))
+		if err != nil {
+			return nil, err
+		}
+		return grpcModerator{proto.NewModeratorClient(conn)}, nil
+	case strings.HasPrefix(target, "http://"), strings.HasPrefix(target, "https://"):
+		return httpModerator{url: target}, nil
+	}
+	return nil, fmt.Errorf("unsupported moderation target %q, want grpc:// or http(s)://", target)
+}
+
+type grpcModerator struct {
+	client proto.ModeratorClient
+}
+
+func (m grpcModerator) classify(ctx context.Context, name, contentType string, size int64, r io.Reader) (proto.ClassifyResponse_Verdict, string, error) {
+	stream, err := m.client.Classify(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+	if err := stream.Send(&proto.ClassifyRequest{Filename: name, ContentType: contentType, SizeBytes: size}); err != nil {
+		_, err = stream.CloseAndRecv()
+		return 0, "", err
+	}
+	buf := make([]byte, 64*1024)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&proto.ClassifyRequest{Data: buf[:n]}); err != nil {
+				_, err = stream.CloseAndRecv()
+				return 0, "", err
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return 0, "", rerr
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return 0, "", err
+	}
+	return resp.Verdict, resp.Reason, nil
+}
+
+type httpModerator struct {
+	url string
+}
+
+func (m httpModerator) classify(ctx context.Context, name, contentType string, size int64, r io.Reader) (proto.ClassifyResponse_Verdict, string, error) {
+	// the client closes a body it is given, and r is still needed after
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, io.NopCloser(r))
+	if err != nil {
+		return 0, "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Filename", name)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return 0, "", fmt.Errorf("classifier answered %s", resp.Status)
+	}
+	var out struct {
+		Verdict string `json:"verdict"`
+		Reason  string `json:"reason"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return 0, "", fmt.Errorf("classifier response: %w", err)
+	}
+	return proto.ClassifyResponse_Verdict(proto.ClassifyResponse_Verdict_value[strings.ToUpper(out.Verdict)]), out.Reason, nil
+}
+
+// stage creates the file an upload is received into until it is complete
+// and has passed the checksum, signature and moderation checks.
+func (s *fileServer) stage() (*os.File, error) {
+	dir := filepath.Join(s.storageDir, ".staging")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, "*")
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// publishStaged runs the classifier, if one is configured, on an image or
+// video upload and publishes, quarantines or drops the staged file according
+// to its verdict. Other uploads are published as they are. Classifier errors
+// reject the upload: unchecked media must not become downloadable.
+func (s *fileServer) publishStaged(stream proto.FileService_UploadServer, name, original, tmp, sum string, pieces [][]byte) error {
+	f, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	ct := contentType(name, head[:n])
+
+	verdict, reason := proto.ClassifyResponse_ACCEPT, ""
+	if s.moderator != nil && (strings.HasPrefix(ct, "image/") || strings.HasPrefix(ct, "video/")) {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(stream.Context(), s.moderationTimeout)
+		defer cancel()
+		if verdict, reason, err = s.moderator.classify(ctx, name, ct, info.Size(), f); err != nil {
+			return status.Errorf(codes.Unavailable, "moderation of %s failed: %v", name, err)
+		}
+	}
+
+	switch verdict {
+	case proto.ClassifyResponse_ACCEPT:
+		if err := s.keepVersion(name); err != nil {
+			return err
+		}
+		if err := s.importStored(name, tmp); err != nil {
+			return fmt.Errorf("store %s: %w", name, err)
+		}
+		s.storeChecksum(name, sum)
+		s.storePieces(name, pieces)
+		s.meta.uploaded(name, original, callerUser(stream.Context()), sum, ct)
+		s.changes.notify(name)
+		return stream.SendAndClose(&proto.UploadResponse{Ok: true, Message: "успешно", Filename: name})
+	case proto.ClassifyResponse_QUARANTINE:
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := s.quarantineData(name, "moderation", reason, f, info.Size()); err != nil {
+			return err
+		}
+		return stream.SendAndClose(&proto.UploadResponse{Ok: false, Message: "held for review: " + reason, Filename: name})
+	case proto.ClassifyResponse_REJECT:
+		return status.Errorf(codes.PermissionDenied, "%s rejected by moderation: %s", name, reason)
+	}
+	return status.Errorf(codes.Unavailable, "moderation of %s failed: no verdict", name)
+}
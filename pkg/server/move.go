@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// MoveFile renames a file on the node that stores it, or streams it to the
+// node that owns the new name and deletes the original.
+func (s *fileServer) MoveFile(ctx context.Context, req *proto.MoveRequest) (*proto.MoveResponse, error) {
+	from, to := s.requestName(ctx, req.GetFilename()), s.requestName(ctx, req.GetNewFilename())
+	if from == "" || to == "" {
+		return nil, status.Error(codes.InvalidArgument, "filename and new_filename are required")
+	}
+	if reservedName(to) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is a reserved name", to)
+	}
+	fromAddr, _ := s.route(ctx, from)
+	if toAddr, _ := s.route(ctx, to); toAddr == fromAddr {
+		resp, err := s.RenameFile(ctx, &proto.RenameRequest{Filename: req.GetFilename(), NewFilename: req.GetNewFilename(), Overwrite: req.GetOverwrite()})
+		if err != nil {
+			return nil, err
+		}
+		return &proto.MoveResponse{Filename: resp.GetFilename()}, nil
+	}
+	if err := s.copyAcross(ctx, req.GetFilename(), req.GetNewFilename(), req.GetOverwrite()); err != nil {
+		return nil, err
+	}
+	if _, err := s.Delete(ctx, &proto.DeleteRequest{Filename: req.GetFilename()}); err != nil {
+		st := status.Convert(err)
+		return nil, status.Errorf(st.Code(), "%s copied to %s but not removed: %s", from, to, st.Message())
+	}
+	return &proto.MoveResponse{Filename: to}, nil
+}
+
+// copyAcross copies the file the caller knows as from to the name to, which
+// another node stores, by streaming it from one node to the other. Without
+// overwrite a file already stored as to is left alone; one stored while the
+// copy runs is replaced.
+func (s *fileServer) copyAcross(ctx context.Context, from, to string, overwrite bool) error {
+	name := s.requestName(ctx, to)
+	if !overwrite {
+		_, err := s.StatFile(ctx, &proto.StatRequest{Filename: to, ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"size_bytes"}}})
+		if err == nil {
+			return status.Errorf(codes.AlreadyExists, "%s already exists", name)
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		}
+	}
+	src, size, _, err := s.archiveFile(ctx, from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if addr, fctx := s.route(ctx, name); addr != "" {
+		err := s.uploadTo(fctx, addr, to, src)
+		if err == nil && s.relayUpstream(name) != "" {
+			s.dropRelayCache(name)
+		}
+		return err
+	}
+	return s.storeFrom(ctx, name, src, size)
+}
+
+// uploadTo uploads what r holds as name to the peer at addr. An error
+// reading r aborts the upload, so the peer keeps nothing of it.
+func (s *fileServer) uploadTo(ctx context.Context, addr, name string, r io.Reader) error {
+	c, err := s.peers.client(addr)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	up, err := c.Upload(ctx)
+	if err != nil {
+		return err
+	}
+	req := &proto.UploadRequest{Filename: name}
+	buf := make([]byte, s.chunkSize)
+	for {
+		if err := up.Send(req); err != nil {
+			// the peer closed the stream; its status comes with CloseAndRecv
+			break
+		}
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		req = &proto.UploadRequest{Data: buf[:n]}
+	}
+	_, err = up.CloseAndRecv()
+	return err
+}
+
+// storeFrom stores what r holds, size bytes, as name on this node.
+func (s *fileServer) storeFrom(ctx context.Context, name string, r io.Reader, size int64) error {
+	if s.disk.full() {
+		return status.Error(codes.ResourceExhausted, "storage is above the high watermark")
+	}
+	if err := s.checkSize(name, size); err != nil {
+		return err
+	}
+	tmp, sum, pieces, err := s.stageFrom(func() (io.ReadCloser, error) { return io.NopCloser(r), nil })
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	unlock, err := s.locks.Lock(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := s.keepVersion(name); err != nil {
+		return err
+	}
+	if err := s.importStored(name, tmp); err != nil {
+		return fmt.Errorf("store %s: %w", name, err)
+	}
+	s.storeChecksum(name, sum)
+	s.storePieces(name, pieces)
+	s.cold.forget(name)
+	s.changes.notify(name)
+	return nil
+}
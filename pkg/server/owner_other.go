@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package server
+
+import "io/fs"
+
+func fileOwner(info fs.FileInfo) string {
+	return ""
+}
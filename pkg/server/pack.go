@@ -0,0 +1,380 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// packMaxSize is the size after which a new pack file is started.
+const packMaxSize = 256 << 20
+
+// packEntry locates a small file inside a pack. The index is an append-only
+// log of entries; the last one for a name wins and Size -1 removes it.
+type packEntry struct {
+	Name  string `json:"name"`
+	Pack  int    `json:"pack"`
+	Off   int64  `json:"off"`
+	Size  int64  `json:"size"`
+	MTime int64  `json:"mtime"`
+}
+
+// packStore keeps small files in a few large pack files under
+// <storage>/.packs instead of one inode each. The index lives in memory and
+// is rebuilt from the log on start. Replaced and deleted files leave dead
+// bytes in their pack and lines in the log until the pack-compact job
+// reclaims them.
+type packStore struct {
+	dir       string
+	threshold int64 // files up to this size are packed, 0 disables packing
+
+	mu      sync.Mutex
+	index   map[string]packEntry
+	cur     int   // pack being appended to
+	curSize int64 // its size
+	log     *os.File
+}
+
+func openPackStore(storageDir string, threshold int64) (*packStore, error) {
+	p := &packStore{
+		dir:       filepath.Join(storageDir, ".packs"),
+		threshold: threshold,
+		index:     make(map[string]packEntry),
+	}
+	f, err := os.Open(filepath.Join(p.dir, "index"))
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e packEntry
+		if json.Unmarshal(sc.Bytes(), &e) != nil {
+			continue // torn write from a crash
+		}
+		if e.Size < 0 {
+			delete(p.index, e.Name)
+			continue
+		}
+		p.index[e.Name] = e
+		p.cur = max(p.cur, e.Pack)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read pack index: %w", err)
+	}
+	if info, err := os.Stat(p.packPath(p.cur)); err == nil {
+		p.curSize = info.Size()
+	}
+	return p, nil
+}
+
+func (p *packStore) packPath(n int) string {
+	return filepath.Join(p.dir, fmt.Sprintf("%06d.pack", n))
+}
+
+func (p *packStore) get(name string) (packEntry, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.index[name]
+	return e, ok
+}
+
+func (p *packStore) list() []packEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]packEntry, 0, len(p.index))
+	for _, e := range p.index {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// put appends data to the current pack and records it under name.
+func (p *packStore) put(name string, data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.putLocked(name, data, time.Now().UnixNano())
+}
+
+func (p *packStore) putLocked(name string, data []byte, mtime int64) error {
+	if err := os.MkdirAll(p.dir, 0o755); err != nil {
+		return err
+	}
+	if p.curSize > 0 && p.curSize+int64(len(data)) > packMaxSize {
+		p.cur, p.curSize = p.cur+1, 0
+	}
+	f, err := os.OpenFile(p.packPath(p.cur), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write pack: %w", err)
+	}
+	e := packEntry{Name: name, Pack: p.cur, Off: p.curSize, Size: int64(len(data)), MTime: mtime}
+	p.curSize += int64(len(data))
+	if err := p.appendIndex(e); err != nil {
+		return err
+	}
+	p.index[name] = e
+	return nil
+}
+
+// remove drops name from the index. The bytes stay in the pack.
+func (p *packStore) remove(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.index[name]; !ok {
+		return nil
+	}
+	if err := p.appendIndex(packEntry{Name: name, Size: -1}); err != nil {
+		return err
+	}
+	delete(p.index, name)
+	return nil
+}
+
+func (p *packStore) appendIndex(e packEntry) error {
+	if p.log == nil {
+		f, err := os.OpenFile(filepath.Join(p.dir, "index"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		p.log = f
+	}
+	b, _ := json.Marshal(e)
+	if _, err := p.log.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("write pack index: %w", err)
+	}
+	return nil
+}
+
+// open opens the packed file e. If compaction moved it since e was looked
+// up, it is opened where it is now.
+func (p *packStore) open(e packEntry) (io.ReadSeekCloser, error) {
+	f, err := os.Open(p.packPath(e.Pack))
+	if os.IsNotExist(err) {
+		if moved, ok := p.get(e.Name); ok && moved.Pack != e.Pack && moved.MTime == e.MTime {
+			e = moved
+			f, err = os.Open(p.packPath(e.Pack))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return packReader{io.NewSectionReader(f, e.Off, e.Size), f}, nil
+}
+
+type packReader struct {
+	*io.SectionReader
+	f *os.File
+}
+
+func (r packReader) Close() error { return r.f.Close() }
+
+// packCompactDead is the share of dead bytes from which the pack-compact
+// job rewrites a pack.
+const packCompactDead = 0.25
+
+// compact moves the files still live in packs that are mostly dead to the
+// current pack, deletes those packs and rewrites the index log with only
+// the live entries. Moved files keep their mtime. It returns the bytes
+// freed.
+func (p *packStore) compact(ctx context.Context) (packs int, freed int64, err error) {
+	p.mu.Lock()
+	live := make(map[int]int64)
+	for _, e := range p.index {
+		live[e.Pack] += e.Size
+	}
+	p.mu.Unlock()
+	entries, err := os.ReadDir(p.dir)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	var victims []int
+	sizes := make(map[int]int64)
+	for _, d := range entries {
+		var n int
+		if _, err := fmt.Sscanf(d.Name(), "%06d.pack", &n); err != nil || d.Name() != filepath.Base(p.packPath(n)) {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			return 0, 0, err
+		}
+		if dead := info.Size() - live[n]; dead > 0 && float64(dead) >= packCompactDead*float64(info.Size()) {
+			victims = append(victims, n)
+			sizes[n] = info.Size()
+		}
+	}
+	for _, n := range victims {
+		if err := ctx.Err(); err != nil {
+			return packs, freed, err
+		}
+		moved, err := p.compactPack(n)
+		if err != nil {
+			return packs, freed, fmt.Errorf("compact pack %d: %w", n, err)
+		}
+		packs++
+		freed += sizes[n] - moved
+	}
+	return packs, freed, p.rewriteIndex()
+}
+
+// compactPack copies the live files of pack n to the current pack and
+// deletes it, returning the bytes copied. Files are moved one at a time,
+// so uploads and reads wait for one small file at most.
+func (p *packStore) compactPack(n int) (int64, error) {
+	src, err := os.Open(p.packPath(n))
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	p.mu.Lock()
+	if p.cur == n {
+		// nothing new goes into the pack from here on
+		p.cur, p.curSize = p.cur+1, 0
+	}
+	var live []packEntry
+	for _, e := range p.index {
+		if e.Pack == n {
+			live = append(live, e)
+		}
+	}
+	p.mu.Unlock()
+	sort.Slice(live, func(i, j int) bool { return live[i].Off < live[j].Off })
+	var moved int64
+	for _, e := range live {
+		if err := p.move(src, e); err != nil {
+			return moved, err
+		}
+		moved += e.Size
+	}
+	return moved, os.Remove(p.packPath(n))
+}
+
+// move copies the packed file e from its pack, src, to the current pack,
+// unless it was replaced or removed meanwhile.
+func (p *packStore) move(src *os.File, e packEntry) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.index[e.Name] != e {
+		return nil
+	}
+	data := make([]byte, e.Size)
+	if _, err := src.ReadAt(data, e.Off); err != nil {
+		return err
+	}
+	return p.putLocked(e.Name, data, e.MTime)
+}
+
+// rewriteIndex replaces the index log with the live entries.
+func (p *packStore) rewriteIndex() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	path := filepath.Join(p.dir, "index")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	names := make([]string, 0, len(p.index))
+	for name := range p.index {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b []byte
+	for _, name := range names {
+		line, _ := json.Marshal(p.index[name])
+		b = append(append(b, line...), '\n')
+	}
+	if err := writeFileAtomic(path, b); err != nil {
+		return fmt.Errorf("rewrite pack index: %w", err)
+	}
+	// appends go to the new log from now on
+	if p.log != nil {
+		p.log.Close()
+		p.log = nil
+	}
+	return nil
+}
+
+// packCompactJob reclaims the space replaced and deleted packed files left
+// in their packs. The scheduler runs it daily by default.
+func (s *fileServer) packCompactJob(ctx context.Context) (string, error) {
+	packs, freed, err := s.packs.compact(ctx)
+	return fmt.Sprintf("compacted %d packs, %d bytes", packs, freed), err
+}
+
+// packInfo describes a packed file.
+type packInfo struct{ e packEntry }
+
+func (i packInfo) Name() string       { return i.e.Name }
+func (i packInfo) Size() int64        { return i.e.Size }
+func (i packInfo) Mode() fs.FileMode  { return 0o644 }
+func (i packInfo) ModTime() time.Time { return time.Unix(0, i.e.MTime) }
+func (i packInfo) IsDir() bool        { return false }
+func (i packInfo) Sys() any           { return nil }
+
+// packWriter buffers an upload while it is small enough to be packed and
+// switches to a regular stored file once it grows past the threshold.
+type packWriter struct {
+	s     *fileServer
+	name  string
+	buf   []byte
+	spill io.WriteCloser
+}
+
+func (w *packWriter) Write(b []byte) (int, error) {
+	if w.spill == nil && int64(len(w.buf)+len(b)) > w.s.packs.threshold {
+		spill, err := w.s.createUnpacked(w.name)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := spill.Write(w.buf); err != nil {
+			spill.Close()
+			return 0, err
+		}
+		w.spill, w.buf = spill, nil
+	}
+	if w.spill != nil {
+		return w.spill.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	return len(b), nil
+}
+
+// Close stores the file; whichever copy it replaces, packed or plain, is
+// dropped so a name only ever lives in one place.
+func (w *packWriter) Close() error {
+	if w.spill != nil {
+		if err := w.spill.Close(); err != nil {
+			return err
+		}
+		return w.s.packs.remove(w.name)
+	}
+	if err := w.s.packs.put(w.name, w.buf); err != nil {
+		return err
+	}
+	path := filepath.Join(w.s.storageDir, w.name)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return setLayout(w.s.storageDir, path, plainLayout)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readPacked(t *testing.T, p *packStore, name string) []byte {
+	t.Helper()
+	e, ok := p.get(name)
+	if !ok {
+		t.Fatalf("%s not packed", name)
+	}
+	f, err := p.open(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPackCompact(t *testing.T) {
+	dir := t.TempDir()
+	p, err := openPackStore(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"a": bytes.Repeat([]byte("a"), 100),
+		"b": bytes.Repeat([]byte("b"), 100),
+		"c": bytes.Repeat([]byte("c"), 100),
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if err := p.put(name, files[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files["a"] = bytes.Repeat([]byte("A"), 50)
+	if err := p.put("a", files["a"]); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.remove("b"); err != nil {
+		t.Fatal(err)
+	}
+	delete(files, "b")
+	stale, _ := p.get("c")
+
+	packs, freed, err := p.compact(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packs != 1 || freed != 200 {
+		t.Errorf("compact = %d packs, %d bytes; want 1, 200", packs, freed)
+	}
+	if _, err := os.Stat(p.packPath(0)); !os.IsNotExist(err) {
+		t.Errorf("compacted pack still there: %v", err)
+	}
+	for name, want := range files {
+		if got := readPacked(t, p, name); !bytes.Equal(got, want) {
+			t.Errorf("%s = %q after compaction, want %q", name, got, want)
+		}
+	}
+	if moved, _ := p.get("c"); moved.MTime != stale.MTime || moved.Pack == stale.Pack {
+		t.Errorf("c moved from %+v to %+v, want another pack and the same mtime", stale, moved)
+	}
+	// a reader that looked the file up before it moved still finds it
+	f, err := p.open(stale)
+	if err != nil {
+		t.Fatalf("open moved file: %v", err)
+	}
+	f.Close()
+
+	index, err := os.ReadFile(filepath.Join(dir, ".packs", "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(index, []byte("\n")); lines != len(files) {
+		t.Errorf("index has %d lines, want %d", lines, len(files))
+	}
+	if err := p.put("d", []byte("d")); err != nil {
+		t.Fatal(err)
+	}
+	files["d"] = []byte("d")
+
+	reopened, err := openPackStore(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range files {
+		if got := readPacked(t, reopened, name); !bytes.Equal(got, want) {
+			t.Errorf("%s = %q after reopening, want %q", name, got, want)
+		}
+	}
+	if _, ok := reopened.get("b"); ok {
+		t.Error("removed file is back after reopening")
+	}
+}
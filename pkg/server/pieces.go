@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/daniil1412412/grpc-file-service/pkg/merkle"
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// pieceSize is the unit of piece verification. Files larger than one piece
+// get their piece hashes stored on upload; for smaller ones they are cheap
+// to compute on request.
+const pieceSize = 1 << 20
+
+// pieceRecord caches the piece hashes of a file version, tied to it by size
+// and mtime like sumRecord.
+type pieceRecord struct {
+	Size   int64    `json:"size"`
+	MTime  int64    `json:"mtime"`
+	Pieces [][]byte `json:"pieces"`
+}
+
+// pieceHasher hashes written data piece by piece.
+type pieceHasher struct {
+	h      hash.Hash
+	n      int64
+	pieces [][]byte
+}
+
+func newPieceHasher() *pieceHasher { return &pieceHasher{h: sha256.New()} }
+
+func (p *pieceHasher) Write(b []byte) (int, error) {
+	total := len(b)
+	for len(b) > 0 {
+		k := min(int64(len(b)), pieceSize-p.n)
+		p.h.Write(b[:k])
+		p.n += k
+		b = b[k:]
+		if p.n == pieceSize {
+			p.pieces = append(p.pieces, p.h.Sum(nil))
+			p.h.Reset()
+			p.n = 0
+		}
+	}
+	return total, nil
+}
+
+// sum returns the hashes of all pieces including the last partial one.
+func (p *pieceHasher) sum() [][]byte {
+	if p.n > 0 {
+		return append(p.pieces, p.h.Sum(nil))
+	}
+	return p.pieces
+}
+
+func (s *fileServer) piecesPath(name string) string {
+	return filepath.Join(s.storageDir, ".pieces", name)
+}
+
+// storePieces records the piece hashes of the current content of name if
+// it spans more than one piece.
+func (s *fileServer) storePieces(name string, pieces [][]byte) {
+	if len(pieces) <= 1 {
+		return
+	}
+	info, err := s.statStored(name)
+	if err != nil {
+		return
+	}
+	b, _ := json.Marshal(pieceRecord{Size: info.Size(), MTime: info.ModTime().UnixNano(), Pieces: pieces})
+	_ = writeFileAtomic(s.piecesPath(name), b)
+}
+
+// pieces returns the piece hashes of name, from the cache when it is still
+// valid and by reading the file otherwise.
+func (s *fileServer) pieces(name string) ([][]byte, int64, error) {
+	info, err := s.statStored(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	var rec pieceRecord
+	if b, err := os.ReadFile(s.piecesPath(name)); err == nil && json.Unmarshal(b, &rec) == nil &&
+		rec.Size == info.Size() && rec.MTime == info.ModTime().UnixNano() {
+		return rec.Pieces, rec.Size, nil
+	}
+	f, _, err := s.openStored(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	p := newPieceHasher()
+	n, err := io.Copy(p, f)
+	if err != nil {
+		return nil, 0, err
+	}
+	s.storePieces(name, p.sum())
+	return p.sum(), n, nil
+}
+
+func (s *fileServer) GetPieceHashes(ctx context.Context, req *proto.PieceHashesRequest) (*proto.PieceHashes, error) {
+	filename := s.requestName(ctx, req.GetFilename())
+	if filename == "" {
+		return nil, errors.New("имя файла пустое")
+	}
+	if addr, fctx := s.route(ctx, filename); addr != "" {
+		c, err := s.peers.client(addr)
+		if err != nil {
+			return nil, err
+		}
+		return c.GetPieceHashes(fctx, req)
+	}
+	pieces, size, err := s.pieces(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.PieceHashes{
+		Filename:  filename,
+		SizeBytes: size,
+		PieceSize: pieceSize,
+		Pieces:    pieces,
+		Root:      merkle.Root(pieces),
+	}, nil
+}
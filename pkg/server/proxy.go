@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// peerPool keeps one client connection per peer address.
+type peerPool struct {
+	creds credentials.TransportCredentials
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+func newPeerPool(creds credentials.TransportCredentials) *peerPool {
+	return &peerPool{creds: creds, conns: make(map[string]*grpc.ClientConn)}
+}
+
+func (p *peerPool) client(addr string) (proto.FileServiceClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conn, ok := p.conns[addr]
+	if !ok {
+		var err error
+		conn, err = grpc.Dial(addr, grpc.WithTransportCredentials(p.creds), grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+			grpc.WithChainUnaryInterceptor(unaryPassAuth), grpc.WithChainStreamInterceptor(streamPassAuth))
+		if err != nil {
+			return nil, fmt.Errorf("dial peer %s: %w", addr, err)
+		}
+		p.conns[addr] = conn
+	}
+	return proto.NewFileServiceClient(conn), nil
+}
+
+// proxyUpload streams an upload through to another instance at addr; the
+// outgoing call uses ctx.
+func (s *fileServer) proxyUpload(ctx context.Context, stream proto.FileService_UploadServer, first *proto.UploadRequest, addr string) error {
+	c, err := s.peers.client(addr)
+	if err != nil {
+		return err
+	}
+	up, err := c.Upload(ctx)
+	if err != nil {
+		return err
+	}
+	req := first
+	for {
+		if err := up.Send(req); err != nil {
+			// the peer closed the stream; its status comes with CloseAndRecv
+			break
+		}
+		req, err = stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	resp, err := up.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	return stream.SendAndClose(resp)
+}
+
+// proxyDownload streams a download from another instance at addr, copying
+// the data into sink as well when it is not nil.
+func (s *fileServer) proxyDownload(ctx context.Context, req *proto.DownloadRequest, stream proto.FileService_DownloadServer, addr string, sink io.Writer) error {
+	c, err := s.peers.client(addr)
+	if err != nil {
+		return err
+	}
+	down, err := c.Download(ctx, req)
+	if err != nil {
+		return err
+	}
+	for {
+		resp, err := down.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if sink != nil {
+			if _, err := sink.Write(resp.GetData()); err != nil {
+				return err
+			}
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *fileServer) proxyFollow(ctx context.Context, req *proto.FollowRequest, stream proto.FileService_FollowServer, addr string) error {
+	c, err := s.peers.client(addr)
+	if err != nil {
+		return err
+	}
+	up, err := c.Follow(ctx, req)
+	if err != nil {
+		return err
+	}
+	for {
+		resp, err := up.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// route picks the instance a call about name belongs to: a relay
+// upstream or the owning shard. It returns "" when this node serves it.
+func (s *fileServer) route(ctx context.Context, name string) (string, context.Context) {
+	if upstream := s.relayUpstream(name); upstream != "" {
+		return upstream, ctx
+	}
+	if owner := s.remoteOwner(ctx, name); owner != "" {
+		return owner, s.forwardContext(ctx)
+	}
+	return "", ctx
+}
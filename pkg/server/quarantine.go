@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Quarantined files live in <storage>/.quarantine/<id>/ as the file content
+// (data) and its QuarantineEntry (entry.json). Download and List never look
+// there; admins release or purge entries by id.
+
+func (s *fileServer) quarantineDir(id string) string {
+	return filepath.Join(s.storageDir, ".quarantine", id)
+}
+
+// quarantine moves a stored file out of sight. source names what flagged it.
+func (s *fileServer) quarantine(ctx context.Context, name, source, reason string) (*proto.QuarantineEntry, error) {
+	if err := s.warm(name); err != nil {
+		return nil, fileError(name, err)
+	}
+	unlock, err := s.locks.Lock(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	src, info, err := s.openWarm(name)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	entry, err := s.quarantineData(name, source, reason, src, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.removeStored(name); err != nil {
+		return nil, fmt.Errorf("quarantine %s: %w", name, err)
+	}
+	return entry, nil
+}
+
+// quarantineData stores src as a quarantined version of name. The caller
+// holds the file lock.
+func (s *fileServer) quarantineData(name, source, reason string, src io.Reader, size int64) (*proto.QuarantineEntry, error) {
+	rnd := make([]byte, 4)
+	_, _ = rand.Read(rnd)
+	id := time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(rnd)
+	dir := s.quarantineDir(id)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entry := &proto.QuarantineEntry{
+		Id:            id,
+		Filename:      name,
+		Source:        source,
+		Reason:        reason,
+		SizeBytes:     size,
+		QuarantinedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := copyToFile(filepath.Join(dir, "data"), src); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("quarantine %s: %w", name, err)
+	}
+	b, _ := json.Marshal(entry)
+	if err := os.WriteFile(filepath.Join(dir, "entry.json"), b, 0o644); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("quarantine %s: %w", name, err)
+	}
+	slog.Info("quarantined", "file", name, "id", id, "source", source, "reason", reason)
+	return entry, nil
+}
+
+func copyToFile(path string, r io.Reader) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *fileServer) quarantineEntry(id string) (*proto.QuarantineEntry, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return nil, status.Error(codes.InvalidArgument, "bad quarantine id")
+	}
+	b, err := os.ReadFile(filepath.Join(s.quarantineDir(id), "entry.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, status.Errorf(codes.NotFound, "quarantine entry %s: not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e proto.QuarantineEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, fmt.Errorf("quarantine entry %s: %w", id, err)
+	}
+	return &e, nil
+}
+
+func (s *fileServer) QuarantineFile(ctx context.Context, req *proto.QuarantineRequest) (*proto.QuarantineEntry, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	filename := s.requestName(ctx, req.GetFilename())
+	if filename == "" {
+		return nil, errors.New("имя файла пустое")
+	}
+	if addr, fctx := s.route(ctx, filename); addr != "" {
+		c, err := s.peers.client(addr)
+		if err != nil {
+			return nil, err
+		}
+		return c.QuarantineFile(adminOutgoing(ctx, fctx), req)
+	}
+	return s.quarantine(ctx, filename, "admin", req.GetReason())
+}
+
+// ListQuarantine lists the entries of this node only.
+func (s *fileServer) ListQuarantine(ctx context.Context, req *proto.ListQuarantineRequest) (*proto.ListQuarantineResponse, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	dirs, err := os.ReadDir(filepath.Join(s.storageDir, ".quarantine"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	resp := &proto.ListQuarantineResponse{}
+	for _, d := range dirs {
+		if e, err := s.quarantineEntry(d.Name()); err == nil {
+			resp.Entries = append(resp.Entries, e)
+		}
+	}
+	return resp, nil
+}
+
+// ReleaseQuarantined puts a file back under its name. It refuses to
+// overwrite a file uploaded since.
+func (s *fileServer) ReleaseQuarantined(ctx context.Context, req *proto.QuarantineIDRequest) (*proto.QuarantineEntry, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	e, err := s.quarantineEntry(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := s.locks.Lock(ctx, e.Filename)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if _, err := s.statStored(e.Filename); err == nil {
+		return nil, status.Errorf(codes.AlreadyExists, "%s exists, purge the entry or remove the file first", e.Filename)
+	}
+	dir := s.quarantineDir(e.Id)
+	if err := s.importStored(e.Filename, filepath.Join(dir, "data")); err != nil {
+		return nil, fmt.Errorf("release %s: %w", e.Id, err)
+	}
+	_ = os.RemoveAll(dir)
+	slog.InfoContext(ctx, "released from quarantine", "file", e.Filename, "id", e.Id)
+	return e, nil
+}
+
+func (s *fileServer) PurgeQuarantined(ctx context.Context, req *proto.QuarantineIDRequest) (*proto.QuarantineEntry, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	e, err := s.quarantineEntry(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(s.quarantineDir(e.Id)); err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "purged from quarantine", "file", e.Filename, "id", e.Id)
+	return e, nil
+}
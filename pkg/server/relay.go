@@ -0,0 +1,144 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+)
+
+// relayRule forwards files whose names match pattern to an upstream
+// file-service instance.
+type relayRule struct {
+	pattern  string
+	upstream string
+}
+
+// parseRelayRules parses "pattern=addr,pattern=addr"; the first matching
+// rule wins.
+func parseRelayRules(spec string) ([]relayRule, error) {
+	var rules []relayRule
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pattern, upstream, ok := strings.Cut(item, "=")
+		if !ok || pattern == "" || upstream == "" {
+			return nil, fmt.Errorf("relay rule %q: want pattern=addr", item)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("relay rule %q: %w", item, err)
+		}
+		rules = append(rules, relayRule{pattern: pattern, upstream: upstream})
+	}
+	return rules, nil
+}
+
+func (s *fileServer) relayUpstream(name string) string {
+	for _, r := range s.relay {
+		if ok, _ := path.Match(r.pattern, name); ok {
+			return r.upstream
+		}
+	}
+	return ""
+}
+
+// relayCachePath is where the local copy of a relayed file is kept: apart
+// from the stored files, so it is not listed, counted against quotas or
+// replicated as one of them.
+func (s *fileServer) relayCachePath(name string) string {
+	return filepath.Join(s.storageDir, ".relay", "cache", name)
+}
+
+// openRelayCache opens the local copy of a relayed file if it is fresh.
+func (s *fileServer) openRelayCache(name string) (*os.File, fs.FileInfo, bool) {
+	if s.relayCacheTTL <= 0 {
+		return nil, nil, false
+	}
+	f, err := os.Open(s.relayCachePath(name))
+	if err != nil {
+		return nil, nil, false
+	}
+	info, err := f.Stat()
+	if err != nil || time.Since(info.ModTime()) >= s.relayCacheTTL {
+		f.Close()
+		return nil, nil, false
+	}
+	return f, info, true
+}
+
+// dropRelayCache removes the local copy of a relayed file once the
+// upstream one changed, or it would be served until it expires.
+func (s *fileServer) dropRelayCache(name string) {
+	_ = os.Remove(s.relayCachePath(name))
+}
+
+// cleanRelayCache removes the copies that have expired and returns how
+// many it removed.
+func (s *fileServer) cleanRelayCache() (int, error) {
+	removed := 0
+	err := filepath.WalkDir(filepath.Join(s.storageDir, ".relay", "cache"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || time.Since(info.ModTime()) < s.relayCacheTTL {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+func (s *fileServer) relayUpload(stream proto.FileService_UploadServer, first *proto.UploadRequest, name, upstream string) error {
+	err := s.proxyUpload(stream.Context(), stream, first, upstream)
+	s.dropRelayCache(name)
+	return err
+}
+
+// relayDownload passes the upstream stream through and, with caching
+// enabled, keeps a local copy that serves the next downloads.
+func (s *fileServer) relayDownload(req *proto.DownloadRequest, stream proto.FileService_DownloadServer, name, upstream string) error {
+	if s.relayCacheTTL <= 0 || req.GetOffset() > 0 || req.GetLength() > 0 || s.disk.full() {
+		// only whole files go into the cache, and only while there is room
+		return s.proxyDownload(stream.Context(), req, stream, upstream, nil)
+	}
+	tmpDir := filepath.Join(s.storageDir, ".relay")
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		return fmt.Errorf("mkdir error: %w", err)
+	}
+	tmp, err := os.CreateTemp(tmpDir, "*")
+	if err != nil {
+		return fmt.Errorf("relay cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := s.proxyDownload(stream.Context(), req, stream, upstream, tmp); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	// the client already has the data; a failed cache write only costs a
+	// refetch next time
+	if tmp.Close() == nil {
+		path := s.relayCachePath(name)
+		if os.MkdirAll(filepath.Dir(path), 0o755) == nil {
+			_ = os.Rename(tmp.Name(), path)
+		}
+	}
+	return nil
+}
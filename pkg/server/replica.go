@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// writeMethods change stored files and are refused by read-only replicas.
+var writeMethods = map[string]bool{
+	fullMethod("Upload"):             true,
+	fullMethod("BeginUpload"):        true,
+	fullMethod("Delete"):             true,
+	fullMethod("RenameFile"):         true,
+	fullMethod("CopyFile"):           true,
+	fullMethod("MoveFile"):           true,
+	fullMethod("ImportFiles"):        true,
+	fullMethod("QuarantineFile"):     true,
+	fullMethod("ReleaseQuarantined"): true,
+	fullMethod("RestoreVersion"):     true,
+}
+
+// checkWritable lets writes through on a primary, and on a read-only
+// replica only while its primary has been unreachable for failoverAfter.
+// Writes taken over that way replicate back once the primary returns.
+func (s *fileServer) checkWritable() error {
+	if !s.readOnly {
+		return nil
+	}
+	down := s.replicas[s.primary].downFor()
+	if s.failoverAfter > 0 && down >= s.failoverAfter {
+		if !s.takenOver.Swap(true) {
+			slog.Warn("primary unreachable, accepting writes", "primary", s.primary, "down_for", down.Round(1e9))
+		}
+		return nil
+	}
+	if s.takenOver.Swap(false) {
+		slog.Info("primary is back, read-only again", "primary", s.primary)
+	}
+	return status.Errorf(codes.FailedPrecondition, "read-only replica of %s, write to the primary", s.primary)
+}
+
+func unaryWriteGuard(srv *fileServer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if writeMethods[info.FullMethod] {
+			if err := srv.checkWritable(); err != nil {
+				return nil, err
+			}
+		}
+		if err := srv.checkDraining(info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func streamWriteGuard(srv *fileServer) grpc.StreamServerInterceptor {
+	return func(srvInterface interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if writeMethods[info.FullMethod] {
+			if err := srv.checkWritable(); err != nil {
+				return err
+			}
+		}
+		if err := srv.checkDraining(info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srvInterface, ss)
+	}
+}
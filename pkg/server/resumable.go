@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// uploadExpiry is how long an unfinished resumable upload is kept after
+// its last data; the staging-cleanup job removes older ones.
+const uploadExpiry = 7 * 24 * time.Hour
+
+// uploadSession is a resumable upload in progress. It lives in
+// <storage>/.uploads as <id>, the data persisted so far, and <id>.json.
+type uploadSession struct {
+	Filename  string    `json:"filename"`
+	Original  string    `json:"original,omitempty"` // as BeginUpload was sent it
+	Size      int64     `json:"size,omitempty"`     // 0 when not announced
+	Signature []byte    `json:"signature,omitempty"`
+	SHA256    string    `json:"sha256,omitempty"` // announced by the client
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s *fileServer) uploadPath(id string) string {
+	return filepath.Join(s.storageDir, ".uploads", id)
+}
+
+func (s *fileServer) saveSession(id string, sess *uploadSession) error {
+	b, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.uploadPath(id)+".json", b)
+}
+
+// loadSession returns the session and how many bytes of it are on disk.
+func (s *fileServer) loadSession(id string) (*uploadSession, int64, error) {
+	if raw, err := hex.DecodeString(id); err != nil || len(raw) != 16 {
+		return nil, 0, status.Errorf(codes.InvalidArgument, "bad upload id %q", id)
+	}
+	b, err := os.ReadFile(s.uploadPath(id) + ".json")
+	if err == nil {
+		var sess uploadSession
+		if err = json.Unmarshal(b, &sess); err == nil {
+			info, serr := os.Stat(s.uploadPath(id))
+			if serr == nil {
+				return &sess, info.Size(), nil
+			}
+			err = serr
+		}
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, status.Errorf(codes.NotFound, "upload %s: unknown, finished or expired", id)
+	}
+	return nil, 0, err
+}
+
+func (s *fileServer) removeSession(id string) {
+	_ = os.Remove(s.uploadPath(id))
+	_ = os.Remove(s.uploadPath(id) + ".json")
+}
+
+func (s *fileServer) BeginUpload(ctx context.Context, req *proto.BeginUploadRequest) (*proto.UploadStatus, error) {
+	filename := s.requestName(ctx, req.GetFilename())
+	if filename == "" {
+		return nil, status.Error(codes.InvalidArgument, "filename is required")
+	}
+	if reservedName(filename) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s is a reserved name", req.GetFilename())
+	}
+	if addr, fctx := s.route(ctx, filename); addr != "" {
+		c, err := s.peers.client(addr)
+		if err != nil {
+			return nil, err
+		}
+		return c.BeginUpload(fctx, req)
+	}
+	if req.GetSizeBytes() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "negative size")
+	}
+	if err := s.checkSize(filename, req.GetSizeBytes()); err != nil {
+		return nil, err
+	}
+	if s.disk.full() {
+		return nil, status.Error(codes.ResourceExhausted, "storage is above the high watermark")
+	}
+	raw := make([]byte, 16)
+	_, _ = rand.Read(raw)
+	id := hex.EncodeToString(raw)
+	if err := os.MkdirAll(filepath.Dir(s.uploadPath(id)), 0o755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(s.uploadPath(id), nil, 0o644); err != nil {
+		return nil, err
+	}
+	sess := &uploadSession{Filename: filename, Original: req.GetFilename(), Size: req.GetSizeBytes(), CreatedAt: time.Now().UTC()}
+	if err := s.saveSession(id, sess); err != nil {
+		s.removeSession(id)
+		return nil, err
+	}
+	return &proto.UploadStatus{UploadId: id, Filename: filename, SizeBytes: sess.Size}, nil
+}
+
+func (s *fileServer) GetUploadStatus(ctx context.Context, req *proto.UploadStatusRequest) (*proto.UploadStatus, error) {
+	if req.GetFilename() != "" {
+		if addr, fctx := s.route(ctx, s.requestName(ctx, req.GetFilename())); addr != "" {
+			c, err := s.peers.client(addr)
+			if err != nil {
+				return nil, err
+			}
+			return c.GetUploadStatus(fctx, req)
+		}
+	}
+	sess, n, err := s.loadSession(req.GetUploadId())
+	if err != nil {
+		return nil, err
+	}
+	if !visible(ctx, sess.Filename) {
+		return nil, status.Errorf(codes.NotFound, "upload %s: unknown, finished or expired", req.GetUploadId())
+	}
+	return &proto.UploadStatus{UploadId: req.GetUploadId(), Filename: sess.Filename, PersistedBytes: n, SizeBytes: sess.Size}, nil
+}
+
+// resumeUpload appends the data of an Upload stream to a resumable upload
+// and publishes the file once it is complete. What was written stays on
+// disk when the stream breaks.
+func (s *fileServer) resumeUpload(stream proto.FileService_UploadServer, first *proto.UploadRequest, filename string) error {
+	id := first.GetUploadId()
+	unlock, err := s.locks.Lock(stream.Context(), ".upload-"+id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	sess, persisted, err := s.loadSession(id)
+	if err != nil {
+		return err
+	}
+	if sess.Filename != filename {
+		return status.Errorf(codes.InvalidArgument, "upload %s is for %s, not %s", id, sess.Filename, filename)
+	}
+	pos := first.GetOffset()
+	if pos < 0 || pos > persisted {
+		return status.Errorf(codes.OutOfRange, "offset %d: upload %s has %d bytes persisted", pos, id, persisted)
+	}
+
+	f, err := os.OpenFile(s.uploadPath(id), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(pos); err != nil {
+		return err
+	}
+	if _, err := f.Seek(pos, io.SeekStart); err != nil {
+		return err
+	}
+	// whatever happens to the stream, the bytes written are persisted
+	// before the client can ask how many there are
+	finished := false
+	defer func() {
+		if !finished {
+			_ = f.Sync()
+			_ = s.saveSession(id, sess)
+		}
+	}()
+	for req := first; ; {
+		if len(req.GetSignature()) > 0 {
+			sess.Signature = req.GetSignature()
+		}
+		if req.GetSha256() != "" {
+			if sess.SHA256, err = expectedSHA256(req.GetSha256()); err != nil {
+				return err
+			}
+		}
+		data := req.GetData()
+		if sess.Size > 0 && pos+int64(len(data)) > sess.Size {
+			return status.Errorf(codes.OutOfRange, "upload %s: data past the announced %d bytes", id, sess.Size)
+		}
+		err = s.checkChunk(filename, len(data))
+		if err == nil {
+			err = s.checkSize(filename, pos+int64(len(data)))
+		}
+		if err != nil {
+			// a client over the limits does not get to resume
+			finished = true
+			s.removeSession(id)
+			return err
+		}
+		if _, err := f.Write(data); err != nil {
+			return fmt.Errorf("upload %s: %w", id, err)
+		}
+		pos += int64(len(data))
+		if req, err = stream.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	if sess.Size > 0 && pos < sess.Size {
+		return stream.SendAndClose(&proto.UploadResponse{Ok: false, Message: fmt.Sprintf("%d of %d bytes persisted", pos, sess.Size), Filename: filename})
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := s.saveSession(id, sess); err != nil {
+		return err
+	}
+	finished = true
+	return s.finishUpload(stream, id, sess)
+}
+
+// finishUpload publishes a complete resumable upload like a staged one:
+// signature and moderation checks apply as for other uploads.
+func (s *fileServer) finishUpload(stream proto.FileService_UploadServer, id string, sess *uploadSession) error {
+	unlock, err := s.locks.Lock(stream.Context(), sess.Filename)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	src, err := os.Open(s.uploadPath(id))
+	if err != nil {
+		return err
+	}
+	sum := sha256.New()
+	pieces := newPieceHasher()
+	_, err = io.Copy(io.MultiWriter(sum, pieces), src)
+	src.Close()
+	if err != nil {
+		return err
+	}
+	if err := checkUpload(sess.Filename, sess.SHA256, hex.EncodeToString(sum.Sum(nil))); err != nil {
+		s.removeSession(id)
+		return err
+	}
+	if s.secret().uploadKeys != nil {
+		if err := s.verifyUpload(sess.Original, sum.Sum(nil), sess.Signature); err != nil {
+			return err
+		}
+	}
+	err = s.publishStaged(stream, sess.Filename, sess.Original, s.uploadPath(id), hex.EncodeToString(sum.Sum(nil)), pieces.sum())
+	if status.Code(err) != codes.Unavailable {
+		// a classifier outage is worth retrying; anything else is final
+		s.removeSession(id)
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+var (
+	retentionDeleted   = expvar.NewInt("retention_deleted_files")
+	retentionReclaimed = expvar.NewInt("retention_reclaimed_bytes")
+)
+
+// retentionPolicy says which files the retention job deletes: those not
+// modified for ttl, and the least recently modified ones while the store
+// holds more than maxBytes. Zero disables either rule. In a dry run the job
+// only logs what it would delete.
+type retentionPolicy struct {
+	ttl      time.Duration
+	maxBytes int64
+	dryRun   bool
+}
+
+func (p retentionPolicy) enabled() bool { return p.ttl > 0 || p.maxBytes > 0 }
+
+// retentionJob applies s.retention. Deleted files are not kept as
+// versions: the point is to free the space.
+func (s *fileServer) retentionJob(ctx context.Context) (string, error) {
+	names, err := s.store.List()
+	if err != nil {
+		return "", err
+	}
+	type stored struct {
+		name  string
+		size  int64
+		mtime time.Time
+	}
+	var files []stored
+	var total int64
+	for _, name := range names {
+		info, err := s.statStored(name)
+		if err != nil || info.IsDir() {
+			continue // removed since the listing
+		}
+		files = append(files, stored{name, info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mtime.Before(files[j].mtime) })
+
+	p := s.retention
+	cutoff := time.Now().Add(-p.ttl)
+	var deleted, reclaimed int64
+	var errs []error
+	for _, f := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		expired := p.ttl > 0 && f.mtime.Before(cutoff)
+		if !expired && !(p.maxBytes > 0 && total > p.maxBytes) {
+			break // the rest are newer and the store is below the limit
+		}
+		reason := "expired"
+		if !expired {
+			reason = "over the size limit"
+		}
+		if p.dryRun {
+			slog.Info("retention: would delete", "file", f.name, "reason", reason, "size", f.size, "modified", f.mtime.UTC().Format(time.RFC3339))
+		} else {
+			ok, err := s.expire(ctx, f.name, f.mtime)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", f.name, err))
+				continue
+			}
+			if !ok {
+				continue // written since the listing
+			}
+			slog.Info("retention: deleted", "file", f.name, "reason", reason, "size", f.size)
+			retentionDeleted.Add(1)
+			retentionReclaimed.Add(f.size)
+		}
+		total -= f.size
+		deleted++
+		reclaimed += f.size
+	}
+	result := fmt.Sprintf("deleted %d files, %d bytes", deleted, reclaimed)
+	if p.dryRun {
+		result = fmt.Sprintf("dry run: would delete %d files, %d bytes", deleted, reclaimed)
+	}
+	if len(errs) > 0 {
+		return result, errors.Join(errs...)
+	}
+	return result, ctx.Err()
+}
+
+// expire deletes name for the retention job and reports whether it did; a
+// file modified since it was listed is left alone.
+func (s *fileServer) expire(ctx context.Context, name string, mtime time.Time) (bool, error) {
+	unlock, err := s.locks.Lock(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	info, err := s.statStored(name)
+	if err != nil || !info.ModTime().Equal(mtime) {
+		return false, nil
+	}
+	if err := s.removeStored(name); err != nil {
+		return false, err
+	}
+	s.cold.forget(name)
+	s.changes.notify(name)
+	return true, nil
+}
@@ -0,0 +1,360 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Store keeps files as objects in an S3-compatible bucket (AWS S3,
+// MinIO, Ceph RGW) under an optional key prefix. Requests are signed with
+// AWS Signature V4 and use path-style URLs, which every implementation
+// accepts. Uploads are spooled to a local file and sent with one PUT on
+// Close, so a file may be at most 5 GiB.
+type s3Store struct {
+	endpoint string // scheme://host[:port]
+	bucket   string
+	prefix   string
+	region   string
+	creds    awsCredentials
+	spool    string // local dir for uploads in progress
+}
+
+// awsCredentials sign requests to AWS and S3-compatible services.
+type awsCredentials struct {
+	keyID  string
+	secret string
+	token  string // session token of temporary credentials
+}
+
+// awsCredentialsFromEnv reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN.
+func awsCredentialsFromEnv() awsCredentials {
+	return awsCredentials{
+		keyID:  os.Getenv("AWS_ACCESS_KEY_ID"),
+		secret: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:  os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// newS3Store parses s3://bucket[/prefix][?endpoint=URL&region=REGION].
+// Credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN.
+func newS3Store(target, spool string) (*s3Store, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("bad s3 backend %q, want s3://bucket[/prefix]", target)
+	}
+	q := u.Query()
+	st := &s3Store{
+		bucket:   u.Host,
+		prefix:   strings.Trim(u.Path, "/"),
+		region:   q.Get("region"),
+		endpoint: strings.TrimSuffix(q.Get("endpoint"), "/"),
+		creds:    awsCredentialsFromEnv(),
+		spool:    spool,
+	}
+	if st.prefix != "" {
+		st.prefix += "/"
+	}
+	if st.region == "" {
+		st.region = "us-east-1"
+	}
+	if st.endpoint == "" {
+		st.endpoint = "https://s3." + st.region + ".amazonaws.com"
+	}
+	if st.creds.keyID == "" || st.creds.secret == "" {
+		return nil, errors.New("s3 backend needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return st, nil
+}
+
+func (st *s3Store) Put(name string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(st.spool, 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(st.spool, "s3-*")
+	if err != nil {
+		return nil, err
+	}
+	return &s3Writer{st: st, name: name, f: f}, nil
+}
+
+type s3Writer struct {
+	st   *s3Store
+	name string
+	f    *os.File
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) { return w.f.Write(p) }
+
+func (w *s3Writer) Close() error {
+	defer os.Remove(w.f.Name())
+	defer w.f.Close()
+	size, err := w.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var body io.ReadCloser = http.NoBody // an empty body must not go chunked
+	if size > 0 {
+		body = io.NopCloser(w.f)
+	}
+	resp, err := w.st.do(context.Background(), http.MethodPut, w.name, nil, body, size, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (st *s3Store) Stat(name string) (fs.FileInfo, error) {
+	resp, err := st.do(context.Background(), http.MethodHead, name, nil, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	mod, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return objectInfo{name: name, size: resp.ContentLength, modTime: mod, tag: resp.Header.Get("ETag")}, nil
+}
+
+func (st *s3Store) Get(name string) (io.ReadSeekCloser, fs.FileInfo, error) {
+	info, err := st.Stat(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &s3Reader{st: st, info: info.(objectInfo)}, info, nil
+}
+
+// s3Reader reads an object with ranged GETs, starting a new one after each
+// seek. If-Match makes a read fail rather than mix two versions.
+type s3Reader struct {
+	st   *s3Store
+	info objectInfo
+	off  int64
+	body io.ReadCloser
+}
+
+func (r *s3Reader) Read(p []byte) (int, error) {
+	if r.off >= r.info.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		h := http.Header{}
+		h.Set("Range", fmt.Sprintf("bytes=%d-", r.off))
+		h.Set("If-Match", r.info.tag)
+		resp, err := r.st.do(context.Background(), http.MethodGet, r.info.name, nil, nil, 0, h)
+		if err != nil {
+			return 0, err
+		}
+		r.body = resp.Body
+	}
+	n, err := r.body.Read(p)
+	r.off += int64(n)
+	if err == io.EOF && r.off < r.info.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.info.size
+	}
+	if offset < 0 {
+		return 0, errors.New("s3: negative position")
+	}
+	if offset != r.off {
+		r.Close()
+		r.off = offset
+	}
+	return offset, nil
+}
+
+func (r *s3Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+func (st *s3Store) List() ([]string, error) {
+	var names []string
+	q := url.Values{"list-type": {"2"}, "prefix": {st.prefix}}
+	for {
+		resp, err := st.do(context.Background(), http.MethodGet, "", q, nil, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3 list: %w", err)
+		}
+		for _, c := range page.Contents {
+			// keys deeper than a user's namespace are not ours
+			if name := strings.TrimPrefix(c.Key, st.prefix); name != "" && validStoredName(name) {
+				names = append(names, name)
+			}
+		}
+		if !page.IsTruncated {
+			return names, nil
+		}
+		q.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+func (st *s3Store) Delete(name string) error {
+	resp, err := st.do(context.Background(), http.MethodDelete, name, nil, nil, 0, nil)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a signed request for the object name, or for the bucket when
+// name is empty. A 404 comes back as fs.ErrNotExist, other failures with
+// S3's error code.
+func (st *s3Store) do(ctx context.Context, method, name string, query url.Values, body io.ReadCloser, size int64, header http.Header) (*http.Response, error) {
+	path := "/" + st.bucket + "/"
+	if name != "" {
+		path += st.prefix + name
+	}
+	u := st.endpoint + s3Escape(path)
+	if len(query) > 0 {
+		u += "?" + s3Query(query)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	st.creds.sign(req, st.region, "s3", s3Escape(path), s3Query(query), "UNSIGNED-PAYLOAD")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("s3 %s: %w", name, fs.ErrNotExist)
+	}
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	_ = xml.NewDecoder(resp.Body).Decode(&e)
+	return nil, fmt.Errorf("s3 %s %s: %s %s %s", method, name, resp.Status, e.Code, e.Message)
+}
+
+// sign adds an AWS Signature V4 Authorization header for service in
+// region. payload is the hex SHA-256 of the body, or UNSIGNED-PAYLOAD so
+// S3 uploads can be streamed.
+func (c awsCredentials) sign(req *http.Request, region, service, path, query, payload string) {
+	now := time.Now().UTC()
+	date, stamp := now.Format("20060102"), now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if c.token != "" {
+		req.Header.Set("X-Amz-Security-Token", c.token)
+	}
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if c.token != "" {
+		signed = append(signed, "x-amz-security-token")
+	}
+	var canonical strings.Builder
+	fmt.Fprintf(&canonical, "%s\n%s\n%s\n", req.Method, path, query)
+	for _, h := range signed {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		fmt.Fprintf(&canonical, "%s:%s\n", h, strings.TrimSpace(v))
+	}
+	fmt.Fprintf(&canonical, "\n%s\n%s", strings.Join(signed, ";"), payload)
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	sum := sha256.Sum256([]byte(canonical.String()))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := []byte("AWS4" + c.secret)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.keyID, scope, strings.Join(signed, ";"), hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// s3Escape encodes a path the way SigV4 expects: everything but unreserved
+// characters and the slashes.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Query is the canonical query string: sorted, fully escaped pairs.
+func s3Query(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range q[k] {
+			parts = append(parts, strings.ReplaceAll(s3Escape(k), "/", "%2F")+"="+strings.ReplaceAll(s3Escape(v), "/", "%2F"))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/metadata"
+)
+
+// forwardedKey marks calls one peer makes to another, so the receiving node
+// serves them locally even if its view of the ring differs.
+const forwardedKey = "x-fs-forwarded-by"
+
+// shardRing maps filenames onto the nodes of a sharded deployment with
+// consistent hashing: adding or removing a node only moves ~1/n of the names.
+type shardRing struct {
+	self   string
+	nodes  []string
+	hashes []uint32
+	owners map[uint32]string
+}
+
+func newShardRing(self string, nodes []string, vnodes int) (*shardRing, error) {
+	r := &shardRing{self: self, owners: make(map[uint32]string)}
+	found := false
+	for _, n := range nodes {
+		if n == "" {
+			continue
+		}
+		found = found || n == self
+		r.nodes = append(r.nodes, n)
+		for i := 0; i < vnodes; i++ {
+			h := crc32.ChecksumIEEE([]byte(n + "#" + strconv.Itoa(i)))
+			if _, dup := r.owners[h]; dup {
+				continue
+			}
+			r.owners[h] = n
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("self address %q is not in the peer list", self)
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r, nil
+}
+
+func (r *shardRing) owner(name string) string {
+	h := crc32.ChecksumIEEE([]byte(name))
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+func isForwarded(ctx context.Context) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return len(md.Get(forwardedKey)) > 0
+}
+
+func (s *fileServer) forwardContext(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, forwardedKey, s.ring.self)
+}
+
+// remoteOwner returns the peer that owns name, or "" when this node should
+// serve the request itself.
+func (s *fileServer) remoteOwner(ctx context.Context, name string) string {
+	if s.ring == nil || isForwarded(ctx) {
+		return ""
+	}
+	if owner := s.ring.owner(name); owner != s.ring.self {
+		return owner
+	}
+	return ""
+}
+
+// listPeers collects the local listings of every other shard.
+func (s *fileServer) listPeers(ctx context.Context, req *proto.ListRequest) ([]*proto.FileInfo, error) {
+	var files []*proto.FileInfo
+	for _, n := range s.ring.nodes {
+		if n == s.ring.self {
+			continue
+		}
+		c, err := s.peers.client(n)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.ListFiles(s.forwardContext(ctx), req)
+		if err != nil {
+			return nil, fmt.Errorf("list peer %s: %w", n, err)
+		}
+		files = append(files, resp.GetFiles()...)
+	}
+	return files, nil
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/daniil1412412/grpc-file-service/pkg/uploadsig"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// parsePublicKeys reads ed25519 public keys, one base64 key per line with an
+// optional comment after it, like authorized_keys. src names where b came
+// from in errors.
+func parsePublicKeys(src string, b []byte) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%s:%d: not a base64 ed25519 public key", src, line)
+		}
+		keys = append(keys, ed25519.PublicKey(b))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", src)
+	}
+	return keys, nil
+}
+
+// verifyUpload checks the detached signature of an upload against the
+// configured keys. name is the file name as the client sent it and sum the
+// raw SHA-256 of the content.
+func (s *fileServer) verifyUpload(name string, sum, sig []byte) error {
+	if len(sig) == 0 {
+		return status.Errorf(codes.Unauthenticated, "%s: upload signature required", name)
+	}
+	if !uploadsig.Verify(s.secret().uploadKeys, name, sum, sig) {
+		return status.Errorf(codes.PermissionDenied, "%s: signature does not match any trusted key", name)
+	}
+	return nil
+}
@@ -0,0 +1,552 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fileStore holds the stored files by name. diskStore keeps them in the
+// storage dir with its layouts (chunks, packs, cold files); the object
+// stores keep plain objects and leave out the features built on those
+// layouts. Server state such as checksums, staging and quarantine stays in
+// the storage dir whatever the store.
+type fileStore interface {
+	// Put opens name for writing. The file is stored once the writer is
+	// closed without error.
+	Put(name string) (io.WriteCloser, error)
+	Get(name string) (io.ReadSeekCloser, fs.FileInfo, error)
+	Stat(name string) (fs.FileInfo, error)
+	// List returns the names of all stored files.
+	List() ([]string, error)
+	// Delete removes name; removing a missing file is not an error.
+	Delete(name string) error
+}
+
+// openFileStore returns the store named by -backend: disk, memory or
+// s3://bucket[/prefix][?endpoint=URL&region=REGION].
+func openFileStore(spec string, s *fileServer) (fileStore, error) {
+	switch {
+	case spec == "disk":
+		return diskStore{s}, nil
+	case spec == "memory":
+		return newMemStore(), nil
+	case strings.HasPrefix(spec, "s3://"):
+		return newS3Store(spec, filepath.Join(s.storageDir, ".staging"))
+	}
+	return nil, fmt.Errorf("unknown backend %q, want disk, memory or s3://bucket", spec)
+}
+
+func (s *fileServer) statStored(name string) (fs.FileInfo, error) {
+	info, err := s.store.Stat(name)
+	return info, fileError(name, err)
+}
+
+// openStored opens name for reading. On disk reading counts as an access
+// for the cold job, and a compressed file is decompressed first.
+func (s *fileServer) openStored(name string) (io.ReadSeekCloser, fs.FileInfo, error) {
+	f, info, err := s.store.Get(name)
+	return f, info, fileError(name, err)
+}
+
+// createStored opens name for writing.
+func (s *fileServer) createStored(name string) (io.WriteCloser, error) {
+	return s.store.Put(name)
+}
+
+// removeStored deletes name along with its cached checksum.
+func (s *fileServer) removeStored(name string) error {
+	if err := s.store.Delete(name); err != nil {
+		return err
+	}
+	_ = os.Remove(s.sumPath(name))
+	_ = os.Remove(s.piecesPath(name))
+	s.cache.remove(name)
+	s.recount(name)
+	return nil
+}
+
+// renameStored moves from to to, replacing to. On disk a file outside the
+// packs is renamed in place and keeps its cached checksum and piece hashes;
+// anything else is copied. Its metadata index record moves along.
+func (s *fileServer) renameStored(from, to string) (err error) {
+	defer s.recount(from)
+	defer s.recount(to)
+	rec := s.meta.get(from)
+	defer func() {
+		if err == nil {
+			s.meta.put(to, rec)
+		}
+	}()
+	_ = os.Remove(s.sumPath(to))
+	_ = os.Remove(s.piecesPath(to))
+	s.cache.remove(to)
+	if s.onDisk() {
+		if _, err := os.Lstat(filepath.Join(s.storageDir, from)); err == nil {
+			return s.renameOnDisk(from, to)
+		}
+	}
+	src, err := s.readStored(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := s.createStored(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return s.removeStored(from)
+}
+
+func (s *fileServer) renameOnDisk(from, to string) error {
+	path := filepath.Join(s.storageDir, from)
+	rec, chunked, err := storedRecipe(s.storageDir, path, true)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(s.storageDir, to)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := setLayout(s.storageDir, dst, plainLayout); err != nil {
+		return err
+	}
+	if err := os.Rename(path, dst); err != nil {
+		return err
+	}
+	if err := moveLayout(s.storageDir, path, dst); err != nil {
+		return err
+	}
+	s.chunks.unlink(from)
+	if chunked {
+		s.chunks.link(to, rec.Chunks)
+	} else {
+		s.chunks.unlink(to)
+	}
+	for _, sidecar := range []func(string) string{s.sumPath, s.piecesPath} {
+		_ = os.Rename(sidecar(from), sidecar(to))
+	}
+	s.cache.remove(from)
+	// the plain file now shadows a packed entry of the new name
+	return s.packs.remove(to)
+}
+
+// copyStored stores the data of from again as to. The caller holds both
+// file locks. On disk the copy shares what it can with the original: a
+// recipe is hard-linked and references the same chunks, and so is a plain
+// file, which like a kept version stays intact because writes replace a
+// file rather than write into it. Packed entries and other backends are
+// copied.
+func (s *fileServer) copyStored(from, to string) (err error) {
+	defer s.recount(to)
+	rec := s.meta.get(from)
+	defer func() {
+		if err == nil && rec != nil {
+			rec.Created = time.Now().UTC()
+			s.meta.put(to, rec)
+		}
+	}()
+	_ = os.Remove(s.sumPath(to))
+	_ = os.Remove(s.piecesPath(to))
+	s.cache.remove(to)
+	if s.onDisk() {
+		if info, err := os.Lstat(filepath.Join(s.storageDir, from)); err == nil && info.Mode().IsRegular() {
+			if err := s.linkOnDisk(from, to); err == nil {
+				return nil
+			} else if !errors.Is(err, errNoLink) {
+				return err
+			}
+		}
+	}
+	src, err := s.readStored(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := s.createStored(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// errNoLink is returned by linkOnDisk when the storage dir cannot hard-link
+// and the copy has to be made the long way.
+var errNoLink = errors.New("hard links are not supported")
+
+func (s *fileServer) linkOnDisk(from, to string) error {
+	path := filepath.Join(s.storageDir, from)
+	rec, chunked, err := storedRecipe(s.storageDir, path, true)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(s.storageDir, to)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := setLayout(s.storageDir, dst, plainLayout); err != nil {
+		return err
+	}
+	// linked beside the target and renamed over it, so a file stored as
+	// to is replaced at once
+	staged, err := s.stage()
+	if err != nil {
+		return err
+	}
+	staged.Close()
+	os.Remove(staged.Name())
+	if err := os.Link(path, staged.Name()); err != nil {
+		return errNoLink
+	}
+	if err := os.Rename(staged.Name(), dst); err != nil {
+		os.Remove(staged.Name())
+		return err
+	}
+	if err := copyLayout(s.storageDir, path, dst); err != nil {
+		return err
+	}
+	if chunked {
+		s.chunks.link(to, rec.Chunks)
+	} else {
+		s.chunks.unlink(to)
+	}
+	// the sidecars are rewritten in place, so they are copied, not linked
+	for _, sidecar := range []func(string) string{s.sumPath, s.piecesPath} {
+		if b, err := os.ReadFile(sidecar(from)); err == nil && os.MkdirAll(filepath.Dir(sidecar(to)), 0o755) == nil {
+			_ = os.WriteFile(sidecar(to), b, 0o644)
+		}
+	}
+	return s.packs.remove(to)
+}
+
+func (s *fileServer) onDisk() bool {
+	_, ok := s.store.(diskStore)
+	return ok
+}
+
+// diskStore is the storage dir. Its methods are the only places that know
+// how a file is laid out there: as a plain file, as a chunk recipe,
+// compressed or inside a pack. A plain file or recipe shadows a packed
+// entry of the same name.
+type diskStore struct{ s *fileServer }
+
+// sizedInfo reports the logical size of a chunked file instead of the size
+// of its recipe.
+type sizedInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i sizedInfo) Size() int64 { return i.size }
+
+func (d diskStore) Stat(name string) (fs.FileInfo, error) {
+	s := d.s
+	path := filepath.Join(s.storageDir, name)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		if e, ok := s.packs.get(name); ok {
+			return packInfo{e}, nil
+		}
+	}
+	if err != nil {
+		return nil, fileError(name, err)
+	}
+	if rec, ok, _ := storedRecipe(s.storageDir, path, false); ok {
+		return sizedInfo{info, rec.Size}, nil
+	}
+	if size, ok := storedColdSize(s.storageDir, path); ok {
+		return sizedInfo{info, size}, nil
+	}
+	if size, ok := storedSealedSize(s.storageDir, path); ok {
+		return sizedInfo{info, size}, nil
+	}
+	return info, nil
+}
+
+func (d diskStore) Get(name string) (io.ReadSeekCloser, fs.FileInfo, error) {
+	s := d.s
+	if err := s.warm(name); err != nil {
+		return nil, nil, fileError(name, err)
+	}
+	return s.openWarm(name)
+}
+
+// warm records an access to name and decompresses it if it went cold,
+// unless -compress-stored keeps files compressed for good. It takes the
+// file lock, so callers holding it use warm before locking and
+// openWarm after.
+func (s *fileServer) warm(name string) error {
+	s.cold.touch(name)
+	if _, ok := storedColdSize(s.storageDir, filepath.Join(s.storageDir, name)); ok && !s.cold.atRest {
+		return s.thaw(name)
+	}
+	return nil
+}
+
+// readStored reads name for a background scan: unlike openStored it does
+// not count as an access and reads compressed files without thawing them.
+func (s *fileServer) readStored(name string) (io.ReadCloser, error) {
+	if !s.onDisk() {
+		f, _, err := s.openStored(name)
+		return f, err
+	}
+	path := filepath.Join(s.storageDir, name)
+	if _, ok := storedColdSize(s.storageDir, path); ok {
+		r, err := openCold(path)
+		if err != nil {
+			return nil, fileError(name, err)
+		}
+		return r, nil
+	}
+	f, _, err := s.openWarm(name)
+	return f, err
+}
+
+// openWarm opens name without thawing it, for callers holding its lock. A
+// compressed file is decompressed and a sealed one decrypted as it is
+// read.
+func (s *fileServer) openWarm(name string) (io.ReadSeekCloser, fs.FileInfo, error) {
+	if !s.onDisk() {
+		return s.openStored(name)
+	}
+	path := filepath.Join(s.storageDir, name)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		if e, ok := s.packs.get(name); ok {
+			f, err := s.packs.open(e)
+			if err != nil {
+				return nil, nil, fileError(name, err)
+			}
+			return f, packInfo{e}, nil
+		}
+	}
+	if err != nil {
+		return nil, nil, fileError(name, err)
+	}
+	rec, ok, err := storedRecipe(s.storageDir, path, true)
+	if err != nil {
+		return nil, nil, fileError(name, err)
+	}
+	if ok {
+		return newChunkReader(s.storageDir, rec), sizedInfo{info, rec.Size}, nil
+	}
+	if size, ok := storedColdSize(s.storageDir, path); ok {
+		f, err := openColdFile(path, size)
+		if err != nil {
+			return nil, nil, fileError(name, err)
+		}
+		return f, sizedInfo{info, size}, nil
+	}
+	if size, ok := storedSealedSize(s.storageDir, path); ok {
+		f, err := s.openSealedFile(path)
+		if err != nil {
+			return nil, nil, fileError(name, err)
+		}
+		return f, sizedInfo{info, size}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fileError(name, err)
+	}
+	return f, info, nil
+}
+
+// sameStored reports whether two infos from statStored describe the same
+// stored version of a file.
+func sameStored(a, b fs.FileInfo) bool {
+	if sa, ok := a.(sizedInfo); ok {
+		a = sa.FileInfo
+	}
+	if sb, ok := b.(sizedInfo); ok {
+		b = sb.FileInfo
+	}
+	if pa, ok := a.(packInfo); ok {
+		// compaction moves a packed file but keeps its mtime
+		pb, ok := b.(packInfo)
+		return ok && pa.e.Name == pb.e.Name && pa.e.Size == pb.e.Size && pa.e.MTime == pb.e.MTime
+	}
+	if oa, ok := a.(objectInfo); ok {
+		ob, ok := b.(objectInfo)
+		return ok && oa == ob
+	}
+	return os.SameFile(a, b)
+}
+
+// Put opens name for writing. With packing enabled small files go into a
+// pack on Close.
+func (d diskStore) Put(name string) (io.WriteCloser, error) {
+	s := d.s
+	if s.packs.threshold > 0 {
+		return &packWriter{s: s, name: name}, nil
+	}
+	return s.createUnpacked(name)
+}
+
+// createUnpacked opens name for writing outside the packs. Plain files are
+// truncated and written in place, sealed with -encryption-key; with
+// chunking enabled the data goes to the chunk store, or the blob pool, and
+// the new version replaces the old one on Close.
+func (s *fileServer) createUnpacked(name string) (io.WriteCloser, error) {
+	path := filepath.Join(s.storageDir, name)
+	if s.blobPool {
+		return s.newBlobWriter(name)
+	}
+	if s.chunking {
+		return &cdcWriter{storageDir: s.storageDir, name: name, path: path, index: s.chunks}, nil
+	}
+	s.chunks.unlink(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := setLayout(s.storageDir, path, plainLayout); err != nil {
+		return nil, err
+	}
+	// a kept version or a copy may share the file by a hard link, so it is
+	// replaced rather than truncated
+	_ = os.Remove(path)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil || s.crypt == nil {
+		return f, err
+	}
+	keyID, kw, err := s.sealKey(name)
+	var w *sealWriter
+	if err == nil {
+		w, err = newSealWriter(f, keyID, kw)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("encrypt %s: %w", name, err)
+	}
+	return layoutWriter{w, s.storageDir, path, sealedLayout}, nil
+}
+
+// Delete removes name wherever it is stored.
+func (d diskStore) Delete(name string) error {
+	s := d.s
+	path := filepath.Join(s.storageDir, name)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := setLayout(s.storageDir, path, plainLayout); err != nil {
+		return err
+	}
+	s.chunks.unlink(name)
+	return s.packs.remove(name)
+}
+
+func (d diskStore) List() ([]string, error) {
+	s := d.s
+	names, err := storedPaths(s.storageDir)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		seen[name] = true
+	}
+	for _, p := range s.packs.list() {
+		if !seen[p.Name] {
+			names = append(names, p.Name)
+		}
+	}
+	return names, nil
+}
+
+// storedPaths returns the names of the files in the storage dir that are
+// not packed: those at its top and those in users' namespaces one level
+// down, as <user>/<name>.
+func storedPaths(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		switch {
+		case internalName(e.Name()):
+		case e.Type().IsRegular():
+			names = append(names, e.Name())
+		case e.IsDir() && validUser.MatchString(e.Name()):
+			sub, err := os.ReadDir(filepath.Join(dir, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range sub {
+				if f.Type().IsRegular() && !internalName(f.Name()) {
+					names = append(names, e.Name()+"/"+f.Name())
+				}
+			}
+		}
+	}
+	return names, nil
+}
+
+// importStored moves the finished file at tmp into the store as name.
+func (s *fileServer) importStored(name, tmp string) error {
+	defer s.recount(name)
+	if s.blobPool && s.packs.threshold == 0 {
+		return s.storeBlob(name, tmp)
+	}
+	if s.onDisk() && !s.chunking && s.packs.threshold == 0 {
+		path := filepath.Join(s.storageDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		s.chunks.unlink(name)
+		if err := setLayout(s.storageDir, path, plainLayout); err != nil {
+			return err
+		}
+		kind := plainLayout
+		if s.cold.atRest {
+			packed, err := s.storeCompressed(tmp)
+			if err != nil {
+				return err
+			}
+			if packed != tmp {
+				tmp, kind = packed, coldLayout
+			}
+		}
+		if s.crypt != nil {
+			var err error
+			if tmp, err = s.sealFile(name, tmp); err != nil {
+				return err
+			}
+			kind = sealedLayout
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return err
+		}
+		return setLayout(s.storageDir, path, kind)
+	}
+	defer os.Remove(tmp)
+	src, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := s.createStored(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,332 @@
+package server
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// With -tenant-keys the data keys of stored files are wrapped not by the
+// master key but by a key of the file's tenant: its namespace, or "" for
+// the files outside namespaces. Tenant keys are wrapped by the master key
+// or KMS plugin in turn and kept in .tenant-keys, so the KMS is asked once
+// per tenant key rather than once per file. A sealed file records the key
+// wrapping its data key as "<tenant>:<version>". Rotating a tenant's key
+// starts a new version for new files; the older versions are kept for the
+// files and kept versions still sealed with them.
+type tenantKeys struct {
+	path   string
+	master keyWrapper
+	seal   bool // new files are sealed with tenant keys
+
+	mu    sync.Mutex
+	keys  map[string][]tenantKey // by tenant, oldest first
+	plain map[string]cipher.AEAD // by key id, once unwrapped
+}
+
+type tenantKey struct {
+	Version int       `json:"version"`
+	Wrapped []byte    `json:"wrapped"` // by the master key
+	Created time.Time `json:"created"`
+}
+
+// openTenantKeys reads the tenant keys kept in storageDir. Their master
+// key is only needed once one is used.
+func openTenantKeys(storageDir string, master keyWrapper, seal bool) (*tenantKeys, error) {
+	t := &tenantKeys{
+		path: filepath.Join(storageDir, ".tenant-keys"), master: master, seal: seal,
+		keys:  make(map[string][]tenantKey),
+		plain: make(map[string]cipher.AEAD),
+	}
+	b, err := os.ReadFile(t.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &t.keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", t.path, err)
+	}
+	return t, nil
+}
+
+// tenantOf returns the tenant of a stored name.
+func tenantOf(name string) string {
+	if dir, _, ok := strings.Cut(name, "/"); ok {
+		return dir
+	}
+	return ""
+}
+
+func tenantKeyID(tenant string, version int) string {
+	return tenant + ":" + strconv.Itoa(version)
+}
+
+// current returns the id and wrapper of the tenant's newest key, making
+// the tenant's first key if it has none.
+func (t *tenantKeys) current(tenant string) (string, keyWrapper, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.keys[tenant]) == 0 {
+		if err := t.add(tenant); err != nil {
+			return "", nil, err
+		}
+	}
+	keys := t.keys[tenant]
+	id := tenantKeyID(tenant, keys[len(keys)-1].Version)
+	aead, err := t.unwrap(id, keys[len(keys)-1])
+	if err != nil {
+		return "", nil, err
+	}
+	return id, tenantWrapper{id: id, aead: aead}, nil
+}
+
+// wrapper returns the wrapper of the key id.
+func (t *tenantKeys) wrapper(id string) (keyWrapper, error) {
+	i := strings.LastIndex(id, ":")
+	version, err := strconv.Atoi(id[i+1:])
+	if i < 0 || err != nil {
+		return nil, fmt.Errorf("malformed tenant key id %q", id)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, k := range t.keys[id[:i]] {
+		if k.Version == version {
+			aead, err := t.unwrap(id, k)
+			if err != nil {
+				return nil, err
+			}
+			return tenantWrapper{id: id, aead: aead}, nil
+		}
+	}
+	return nil, fmt.Errorf("sealed with tenant key %s, which is not in %s", id, t.path)
+}
+
+// unwrap returns the key k, named id, unwrapping it with the master key
+// the first time. t.mu is held.
+func (t *tenantKeys) unwrap(id string, k tenantKey) (cipher.AEAD, error) {
+	if aead, ok := t.plain[id]; ok {
+		return aead, nil
+	}
+	key, err := t.master.unwrap(k.Wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap tenant key %s: %w", id, err)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	t.plain[id] = aead
+	return aead, nil
+}
+
+// add makes a new key for the tenant and saves it. t.mu is held.
+func (t *tenantKeys) add(tenant string) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	wrapped, err := t.master.wrap(key)
+	if err != nil {
+		return fmt.Errorf("wrap tenant key: %w", err)
+	}
+	keys := t.keys[tenant]
+	k := tenantKey{Version: 1, Wrapped: wrapped, Created: time.Now().UTC()}
+	if len(keys) > 0 {
+		k.Version = keys[len(keys)-1].Version + 1
+	}
+	t.keys[tenant] = append(keys[:len(keys):len(keys)], k)
+	if err := t.save(); err != nil {
+		t.keys[tenant] = keys
+		return err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	t.plain[tenantKeyID(tenant, k.Version)] = aead
+	return nil
+}
+
+// rotate wraps the tenant's keys again with the current master key and
+// starts a new one, which it returns. Files sealed with the older keys
+// stay readable.
+func (t *tenantKeys) rotate(tenant string) (string, tenantKey, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys := append([]tenantKey(nil), t.keys[tenant]...)
+	for i, k := range keys {
+		id := tenantKeyID(tenant, k.Version)
+		key, err := t.master.unwrap(k.Wrapped)
+		if err != nil {
+			return "", tenantKey{}, fmt.Errorf("unwrap tenant key %s: %w", id, err)
+		}
+		if keys[i].Wrapped, err = t.master.wrap(key); err != nil {
+			return "", tenantKey{}, fmt.Errorf("wrap tenant key %s: %w", id, err)
+		}
+	}
+	old := t.keys[tenant]
+	t.keys[tenant] = keys
+	if err := t.add(tenant); err != nil {
+		t.keys[tenant] = old
+		return "", tenantKey{}, err
+	}
+	k := t.keys[tenant][len(t.keys[tenant])-1]
+	return tenantKeyID(tenant, k.Version), k, nil
+}
+
+func (t *tenantKeys) save() error {
+	b, err := json.MarshalIndent(t.keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(t.path, b)
+}
+
+// tenantWrapper wraps data keys with a tenant key, bound to its id.
+type tenantWrapper struct {
+	id   string
+	aead cipher.AEAD
+}
+
+func (w tenantWrapper) wrap(dataKey []byte) ([]byte, error) {
+	nonce := make([]byte, w.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return w.aead.Seal(nonce, nonce, dataKey, []byte(w.id)), nil
+}
+
+func (w tenantWrapper) unwrap(wrapped []byte) ([]byte, error) {
+	n := w.aead.NonceSize()
+	if len(wrapped) < n {
+		return nil, errors.New("wrapped key too short")
+	}
+	return w.aead.Open(nil, wrapped[:n], wrapped[n:], []byte(w.id))
+}
+
+func (s *fileServer) RotateTenantKey(ctx context.Context, req *proto.RotateTenantKeyRequest) (*proto.TenantKey, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if s.tenantKeys == nil || !s.tenantKeys.seal {
+		return nil, status.Error(codes.FailedPrecondition, "tenant keys need -tenant-keys")
+	}
+	tenant := req.GetTenant()
+	if tenant != "" && !validUser.MatchString(tenant) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid tenant %q", tenant)
+	}
+	id, k, err := s.tenantKeys.rotate(tenant)
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "tenant key rotated", "tenant", tenant, "key_id", id)
+	resp := &proto.TenantKey{Tenant: tenant, KeyId: id, CreatedAt: k.Created.Format(time.RFC3339)}
+	if !req.GetRewrap() {
+		return resp, nil
+	}
+	names, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+	w, err := s.tenantKeys.wrapper(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if tenantOf(name) != tenant {
+			continue
+		}
+		moved, err := s.rewrapFile(ctx, name, id, w)
+		if err != nil {
+			return nil, status.Errorf(status.Code(err), "rewrap %s: %v (%d files rewrapped)", name, err, resp.Rewrapped)
+		}
+		if moved {
+			resp.Rewrapped++
+		}
+	}
+	slog.InfoContext(ctx, "tenant files rewrapped", "tenant", tenant, "key_id", id, "files", resp.Rewrapped)
+	return resp, nil
+}
+
+// rewrapFile wraps the data key of the stored name again with w, the key
+// id, if another key wraps it. Only the header is new: the content is
+// copied sealed as it is. A file stored plain is left alone.
+func (s *fileServer) rewrapFile(ctx context.Context, name, id string, w keyWrapper) (bool, error) {
+	unlock, err := s.locks.Lock(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	path := filepath.Join(s.storageDir, name)
+	if layoutOf(s.storageDir, path) != sealedLayout {
+		return false, nil
+	}
+	src, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+	keyID, wrapped, _, ok := sealHeader(src)
+	if !ok || keyID == id {
+		return false, nil
+	}
+	old, err := s.unsealKey(keyID)
+	if err != nil {
+		return false, err
+	}
+	key, err := old.unwrap(wrapped)
+	if err != nil {
+		return false, fmt.Errorf("unwrap data key: %w", err)
+	}
+	if wrapped, err = w.wrap(key); err != nil {
+		return false, err
+	}
+	info, err := src.Stat()
+	if err != nil {
+		return false, err
+	}
+	out, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(out.Name())
+	_, err = out.Write(sealHeaderBytes(id, wrapped))
+	if err == nil {
+		_, err = io.Copy(out, src)
+	}
+	if err == nil {
+		err = out.Chmod(0o644)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	// the content is unchanged, so the file keeps its time
+	if err == nil {
+		err = os.Chtimes(out.Name(), info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), path)
+	}
+	return err == nil, err
+}
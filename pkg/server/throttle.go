@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// tokenBucket paces a flow of bytes to rate per second, letting a second's
+// worth through at once after a pause. A message larger than that goes
+// through into debt, which the following ones wait off, so the average
+// stays at the rate whatever the chunk size. A zero rate does not limit.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) setRate(rate int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if rate != b.rate {
+		b.rate = rate
+		b.tokens = min(b.tokens, float64(rate))
+	}
+}
+
+// wait takes n bytes from the bucket, sleeping until they are paid for or
+// ctx is done.
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	if b.rate <= 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	if b.last.IsZero() {
+		b.tokens = float64(b.rate)
+	} else {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*float64(b.rate), float64(b.rate))
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	delay := time.Duration(-b.tokens / float64(b.rate) * float64(time.Second))
+	b.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// bandwidth holds the limits of transfer streams: each one at perStream
+// bytes a second, and all of them together at the rate of total. Both can
+// change while streams run; a stream rereads its limit for every message.
+type bandwidth struct {
+	perStream atomic.Int64
+	total     tokenBucket
+}
+
+func newBandwidth(perStream, total int64) *bandwidth {
+	b := &bandwidth{}
+	b.perStream.Store(perStream)
+	b.total.setRate(total)
+	return b
+}
+
+func (b *bandwidth) limits() *proto.Bandwidth {
+	b.total.mu.Lock()
+	defer b.total.mu.Unlock()
+	return &proto.Bandwidth{StreamBytesPerSecond: b.perStream.Load(), TotalBytesPerSecond: b.total.rate}
+}
+
+// throttledStream paces the messages of one transfer stream: received ones
+// once they are in, so the client's sends back up, and sent ones before
+// they go out.
+type throttledStream struct {
+	grpc.ServerStream
+	bw  *bandwidth
+	own tokenBucket
+}
+
+func (b *bandwidth) stream(ss grpc.ServerStream) grpc.ServerStream {
+	return &throttledStream{ServerStream: ss, bw: b}
+}
+
+func (s *throttledStream) pace(m any) error {
+	msg, ok := m.(protobuf.Message)
+	if !ok {
+		return nil
+	}
+	n := protobuf.Size(msg)
+	s.own.setRate(s.bw.perStream.Load())
+	if err := s.own.wait(s.Context(), n); err != nil {
+		return err
+	}
+	return s.bw.total.wait(s.Context(), n)
+}
+
+func (s *throttledStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.pace(m)
+}
+
+func (s *throttledStream) SendMsg(m any) error {
+	if err := s.pace(m); err != nil {
+		return err
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *fileServer) SetBandwidth(ctx context.Context, req *proto.SetBandwidthRequest) (*proto.Bandwidth, error) {
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if req.GetStreamBytesPerSecond() < 0 || req.GetTotalBytesPerSecond() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "bandwidth limits must not be negative")
+	}
+	if req.StreamBytesPerSecond != nil {
+		s.bandwidth.perStream.Store(req.GetStreamBytesPerSecond())
+	}
+	if req.TotalBytesPerSecond != nil {
+		s.bandwidth.total.setRate(req.GetTotalBytesPerSecond())
+	}
+	limits := s.bandwidth.limits()
+	if req.StreamBytesPerSecond == nil && req.TotalBytesPerSecond == nil {
+		return limits, nil
+	}
+	slog.InfoContext(ctx, "bandwidth limits changed", "stream_bytes_per_second", limits.StreamBytesPerSecond, "total_bytes_per_second", limits.TotalBytesPerSecond)
+	return limits, nil
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// transportSecurity is the TLS setup of a server: the config it serves with
+// and the credentials it dials with. The server's certificate doubles as its
+// client certificate towards peers and its own gateway, so under mTLS it
+// needs the clientAuth extended key usage as well as serverAuth.
+type transportSecurity struct {
+	server *tls.Config                      // nil serves plaintext
+	peers  credentials.TransportCredentials // for shard, relay and replication peers
+	self   credentials.TransportCredentials // for the gateway calling this server
+}
+
+// loadTransportSecurity reads the -tls-* files. Without a certificate
+// everything stays plaintext. caFile verifies peer servers and, with mtls,
+// the certificates clients must present.
+func loadTransportSecurity(certFile, keyFile, caFile string, mtls bool) (*transportSecurity, error) {
+	if certFile == "" {
+		if keyFile != "" || caFile != "" || mtls {
+			return nil, errors.New("-tls-key, -tls-ca and -mtls need -tls-cert")
+		}
+		return &transportSecurity{peers: insecure.NewCredentials(), self: insecure.NewCredentials()}, nil
+	}
+	if keyFile == "" {
+		keyFile = certFile
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", caFile)
+		}
+	}
+	if mtls && caFile == "" {
+		return nil, errors.New("-mtls needs -tls-ca to verify client certificates")
+	}
+
+	ts := &transportSecurity{server: &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}}
+	if mtls {
+		ts.server.ClientAuth = tls.RequireAndVerifyClientCert
+		ts.server.ClientCAs = roots
+	}
+	ts.peers = credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      roots,
+		MinVersion:   tls.VersionTLS12,
+	})
+	// the gateway dials localhost, which the certificate need not name, so
+	// it checks the certificate against the name it does carry
+	selfRoots := roots.Clone()
+	selfRoots.AddCert(leaf)
+	ts.self = credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      selfRoots,
+		ServerName:   certName(leaf),
+		MinVersion:   tls.VersionTLS12,
+	})
+	return ts, nil
+}
+
+// certName returns a name leaf is valid for.
+func certName(leaf *x509.Certificate) string {
+	switch {
+	case len(leaf.DNSNames) > 0:
+		// any name under a wildcard matches it
+		return strings.Replace(leaf.DNSNames[0], "*", "gateway", 1)
+	case len(leaf.IPAddresses) > 0:
+		return leaf.IPAddresses[0].String()
+	}
+	return leaf.Subject.CommonName
+}
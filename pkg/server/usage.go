@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// usage counts the bytes and files stored, in total and per namespace, so
+// quotas can be checked without walking the store. It is filled by a scan
+// at startup and kept current by recount, which the store helpers call for
+// every name they change.
+type usage struct {
+	quota     int64 // of the whole server, 0 for none
+	userQuota int64 // of each namespace, 0 for none
+
+	mu    sync.Mutex
+	sizes map[string]int64 // of every stored name
+	total nsUsage
+	ns    map[string]*nsUsage
+}
+
+type nsUsage struct{ bytes, files int64 }
+
+func newUsage(quota, userQuota int64) *usage {
+	return &usage{quota: quota, userQuota: userQuota, sizes: make(map[string]int64), ns: make(map[string]*nsUsage)}
+}
+
+// namespaceOf is the user whose namespace name is in, or "" for a file at
+// the top of the store.
+func namespaceOf(name string) string {
+	if dir, _, ok := strings.Cut(name, "/"); ok {
+		return dir
+	}
+	return ""
+}
+
+// set records that name is stored with size bytes, or is gone when size
+// is negative, and reports whether it was stored before.
+func (u *usage) set(name string, size int64) (existed bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	n := u.ns[namespaceOf(name)]
+	if n == nil {
+		n = &nsUsage{}
+		u.ns[namespaceOf(name)] = n
+	}
+	old, existed := u.sizes[name]
+	if existed {
+		u.total.bytes, n.bytes = u.total.bytes-old, n.bytes-old
+		u.total.files, n.files = u.total.files-1, n.files-1
+		delete(u.sizes, name)
+	}
+	if size >= 0 {
+		u.sizes[name] = size
+		u.total.bytes, n.bytes = u.total.bytes+size, n.bytes+size
+		u.total.files, n.files = u.total.files+1, n.files+1
+	}
+	if n.files == 0 {
+		delete(u.ns, namespaceOf(name))
+	}
+	return existed
+}
+
+// scanUsage counts what the store holds.
+func (s *fileServer) scanUsage() error {
+	start := time.Now()
+	names, err := s.store.List()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if info, err := s.statStored(name); err == nil && !info.IsDir() {
+			s.usage.set(name, info.Size())
+		}
+	}
+	s.usage.mu.Lock()
+	total := s.usage.total
+	s.usage.mu.Unlock()
+	slog.Info("usage counted", "files", total.files, "bytes", total.bytes, "took", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// recount updates the usage and the metadata index of name from the
+// store, and tells the change feed what became of it. The watcher reports
+// namespace dirs too; they are not files.
+func (s *fileServer) recount(name string) {
+	info, err := s.statStored(name)
+	size := int64(-1)
+	if err != nil || info.IsDir() {
+		info = nil
+	} else {
+		size = info.Size()
+	}
+	s.changes.observe(name, s.usage.set(name, size), info)
+	s.meta.sync(name, info)
+}
+
+// checkQuota refuses an upload of size bytes to name that would take the
+// server or the name's namespace past its quota. The stored version of the
+// name does not count, since the upload replaces it.
+func (u *usage) checkQuota(name string, size int64) error {
+	if u.quota <= 0 && u.userQuota <= 0 {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	grow := size - u.sizes[name]
+	if u.quota > 0 && u.total.bytes+grow > u.quota {
+		return status.Errorf(codes.ResourceExhausted, "%s: the server quota of %d bytes would be exceeded, %d are used", name, u.quota, u.total.bytes)
+	}
+	ns := namespaceOf(name)
+	if used := u.nsBytes(ns); ns != "" && u.userQuota > 0 && used+grow > u.userQuota {
+		return status.Errorf(codes.ResourceExhausted, "%s: the quota of %d bytes of %s would be exceeded, %d are used", name, u.userQuota, ns, used)
+	}
+	return nil
+}
+
+func (u *usage) nsBytes(ns string) int64 {
+	if n := u.ns[ns]; n != nil {
+		return n.bytes
+	}
+	return 0
+}
+
+func (s *fileServer) GetUsage(ctx context.Context, _ *proto.UsageRequest) (*proto.Usage, error) {
+	u := s.usage
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if user := callerUser(ctx); user != "" {
+		var n nsUsage
+		if cur := u.ns[user]; cur != nil {
+			n = *cur
+		}
+		return &proto.Usage{Bytes: n.bytes, Files: n.files, QuotaBytes: u.userQuota}, nil
+	}
+	resp := &proto.Usage{Bytes: u.total.bytes, Files: u.total.files, QuotaBytes: u.quota}
+	for name, n := range u.ns {
+		q := u.userQuota
+		if name == "" {
+			q = 0 // the user quota is for namespaces
+		}
+		resp.Namespaces = append(resp.Namespaces, &proto.NamespaceUsage{Name: name, Bytes: n.bytes, Files: n.files, QuotaBytes: q})
+	}
+	sort.Slice(resp.Namespaces, func(i, j int) bool { return resp.Namespaces[i].Name < resp.Namespaces[j].Name })
+	return resp, nil
+}
+
+// publish serves the counts under /debug/vars.
+func (u *usage) publish() {
+	expvar.Publish("storage_used_bytes", expvar.Func(func() any {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		return u.total.bytes
+	}))
+	expvar.Publish("storage_files", expvar.Func(func() any {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		return u.total.files
+	}))
+	expvar.Publish("namespace_used_bytes", expvar.Func(func() any {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		m := make(map[string]int64, len(u.ns))
+		for name, n := range u.ns {
+			m[name] = n.bytes
+		}
+		return m
+	}))
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// vaultPrefix marks a secret flag value read from Vault instead of a file:
+// vault:<path>#<field>, e.g. vault:secret/data/file-service#admin_token.
+const vaultPrefix = "vault:"
+
+const (
+	vaultTimeout = 10 * time.Second
+	vaultRetry   = 30 * time.Second
+)
+
+// serverSecrets are the values -admin-token, -upload-keys, -signing-key,
+// -api-keys and -jwt-secret name. Secrets from Vault are replaced as a whole when they rotate, so
+// handlers read them through secret once per call and never modify them.
+type serverSecrets struct {
+	adminToken string
+	uploadKeys []ed25519.PublicKey
+	signingKey ed25519.PrivateKey
+	apiKeys    map[[32]byte]string // SHA-256 of the key -> user
+	jwtSecret  []byte
+	linkSecret []byte   // signs download links
+	masterKeys [][]byte // the first encrypts stored files
+}
+
+func (s *fileServer) secret() *serverSecrets {
+	if p := s.secrets.Load(); p != nil {
+		return p
+	}
+	return &serverSecrets{}
+}
+
+// secretSource is one secret flag: a vault: reference, an env:NAME
+// variable, or else a file, or the value itself when literal.
+type secretSource struct {
+	flag    string
+	ref     string
+	literal bool
+	set     func(sec *serverSecrets, src string, b []byte) error
+	last    []byte
+}
+
+func isVaultRef(ref string) bool { return strings.HasPrefix(ref, vaultPrefix) }
+
+func (src *secretSource) read(ctx context.Context, v *vaultClient) ([]byte, error) {
+	switch {
+	case isVaultRef(src.ref):
+		return v.read(ctx, src.ref)
+	case strings.HasPrefix(src.ref, "env:"):
+		b, ok := os.LookupEnv(strings.TrimPrefix(src.ref, "env:"))
+		if !ok {
+			return nil, fmt.Errorf("%s is not set", strings.TrimPrefix(src.ref, "env:"))
+		}
+		return []byte(b), nil
+	case src.literal:
+		return []byte(src.ref), nil
+	}
+	return os.ReadFile(src.ref)
+}
+
+// applySecret parses b into a copy of the current secrets and publishes
+// the copy. Only main and the refresh loop call it, never concurrently.
+func (s *fileServer) applySecret(src *secretSource, b []byte) error {
+	next := *s.secret()
+	if err := src.set(&next, src.ref, b); err != nil {
+		return err
+	}
+	s.secrets.Store(&next)
+	src.last = b
+	return nil
+}
+
+// refreshSecrets re-reads the Vault secrets and applies the ones that
+// changed. A secret that fails to read or parse keeps its old value.
+func (s *fileServer) refreshSecrets(v *vaultClient, sources []*secretSource) {
+	for _, src := range sources {
+		if !isVaultRef(src.ref) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+		b, err := src.read(ctx, v)
+		cancel()
+		if err == nil && bytes.Equal(b, src.last) {
+			continue
+		}
+		if err == nil {
+			err = s.applySecret(src, b)
+		}
+		if err != nil {
+			slog.Warn("vault: refresh failed", "flag", src.flag, "error", err)
+			continue
+		}
+		slog.Info("vault: secret rotated", "flag", src.flag)
+	}
+}
+
+// watchSecrets refreshes the Vault secrets every interval and renews the
+// token at half its TTL so it does not expire under a long-running server.
+func (s *fileServer) watchSecrets(v *vaultClient, sources []*secretSource, every time.Duration) {
+	refresh := time.NewTicker(every)
+	defer refresh.Stop()
+	var renew <-chan time.Time
+	if v.renewable && v.ttl > 0 {
+		renew = time.After(v.ttl / 2)
+	}
+	for {
+		select {
+		case <-refresh.C:
+			s.refreshSecrets(v, sources)
+		case <-renew:
+			if err := v.renew(); err != nil {
+				slog.Warn("vault: renew token", "error", err)
+				renew = time.After(min(vaultRetry, v.ttl/2))
+				continue
+			}
+			renew = nil
+			if v.renewable && v.ttl > 0 {
+				renew = time.After(v.ttl / 2)
+			}
+		}
+	}
+}
+
+// vaultClient reads secrets over Vault's HTTP API with a token from
+// VAULT_TOKEN or a file, e.g. one kept fresh by Vault Agent.
+type vaultClient struct {
+	addr      string
+	token     string
+	namespace string
+	renewable bool
+	ttl       time.Duration // 0 for tokens that do not expire
+}
+
+func newVaultClient(addr, tokenFile string) (*vaultClient, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("vault: secrets reference Vault, set -vault-addr or VAULT_ADDR")
+	}
+	v := &vaultClient{addr: strings.TrimSuffix(addr, "/"), token: os.Getenv("VAULT_TOKEN"), namespace: os.Getenv("VAULT_NAMESPACE")}
+	if tokenFile != "" {
+		b, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("vault token: %w", err)
+		}
+		v.token = strings.TrimSpace(string(b))
+	}
+	if v.token == "" {
+		return nil, fmt.Errorf("vault: no token, set VAULT_TOKEN or -vault-token-file")
+	}
+	var out struct {
+		Data struct {
+			TTL       int64 `json:"ttl"`
+			Renewable bool  `json:"renewable"`
+		} `json:"data"`
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+	defer cancel()
+	if err := v.call(ctx, http.MethodGet, "auth/token/lookup-self", &out); err != nil {
+		return nil, err
+	}
+	v.renewable, v.ttl = out.Data.Renewable, time.Duration(out.Data.TTL)*time.Second
+	return v, nil
+}
+
+// read returns the string field of the secret a vault: reference names.
+// KV version 1 and 2 mounts both work; version 2 paths include data/.
+func (v *vaultClient) read(ctx context.Context, ref string) ([]byte, error) {
+	path, field, ok := strings.Cut(strings.TrimPrefix(ref, vaultPrefix), "#")
+	if !ok || path == "" || field == "" {
+		return nil, fmt.Errorf("%s: want vault:<path>#<field>", ref)
+	}
+	var out struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := v.call(ctx, http.MethodGet, path, &out); err != nil {
+		return nil, err
+	}
+	data := out.Data
+	// KV version 2 nests the secret under data, next to its metadata
+	if inner, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = inner
+	}
+	val, ok := data[field].(string)
+	if !ok {
+		return nil, fmt.Errorf("vault %s: no string field %q", path, field)
+	}
+	return []byte(val), nil
+}
+
+func (v *vaultClient) renew() error {
+	var out struct {
+		Auth struct {
+			LeaseDuration int64 `json:"lease_duration"`
+			Renewable     bool  `json:"renewable"`
+		} `json:"auth"`
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+	defer cancel()
+	if err := v.call(ctx, http.MethodPost, "auth/token/renew-self", &out); err != nil {
+		return err
+	}
+	v.renewable, v.ttl = out.Auth.Renewable, time.Duration(out.Auth.LeaseDuration)*time.Second
+	return nil
+}
+
+func (v *vaultClient) call(ctx context.Context, method, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, v.addr+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	req.Header.Set("X-Vault-Request", "true")
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("vault %s %s: %s", method, path, strings.TrimSpace(resp.Status+" "+strings.Join(e.Errors, "; ")))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("vault %s %s: %w", method, path, err)
+	}
+	return nil
+}
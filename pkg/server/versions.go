@@ -0,0 +1,311 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Replaced versions of a file are kept in .versions/<escaped name>/ as <n>
+// with its description in <n>.json, the newest s.keepVersions of them.
+// Plain files on disk are hard-linked there, since uploads replace a file
+// by renaming over it and never write into it; anything else is copied.
+// Sealed files stay sealed there.
+
+func (s *fileServer) versionsDir(name string) string {
+	return filepath.Join(s.storageDir, ".versions", url.PathEscape(name))
+}
+
+// keepVersion saves the stored version of name before it is replaced or
+// deleted. The caller holds the file lock. A missing file has nothing to
+// keep.
+func (s *fileServer) keepVersion(name string) error {
+	if s.keepVersions <= 0 {
+		return nil
+	}
+	info, err := s.statStored(name)
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	dir := s.versionsDir(name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	versions, err := s.versions(name)
+	if err != nil {
+		return err
+	}
+	v := &proto.FileVersion{
+		Filename:   name,
+		Version:    1,
+		SizeBytes:  info.Size(),
+		ModifiedAt: info.ModTime().UTC().Format(time.RFC3339),
+		ReplacedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if len(versions) > 0 {
+		v.Version = versions[0].Version + 1
+	}
+	v.Sha256, _ = s.cachedChecksum(name, info)
+	data := filepath.Join(dir, strconv.FormatInt(v.Version, 10))
+	if err := s.saveVersionData(name, data); err != nil {
+		s.dropVersion(name, v.Version)
+		return fmt.Errorf("keep version of %s: %w", name, err)
+	}
+	b, _ := json.Marshal(v)
+	if err := os.WriteFile(data+".json", b, 0o644); err != nil {
+		s.dropVersion(name, v.Version)
+		return fmt.Errorf("keep version of %s: %w", name, err)
+	}
+	versions = append([]*proto.FileVersion{v}, versions...)
+	for _, old := range versions[min(len(versions), s.keepVersions):] {
+		s.dropVersion(name, old.Version)
+	}
+	return nil
+}
+
+func (s *fileServer) saveVersionData(name, dst string) error {
+	if s.onDisk() {
+		path := filepath.Join(s.storageDir, name)
+		_, chunked, _ := storedRecipe(s.storageDir, path, false)
+		_, cold := storedColdSize(s.storageDir, path)
+		if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() && !chunked && !cold {
+			if os.Link(path, dst) == nil {
+				return copyLayout(s.storageDir, path, dst)
+			}
+			if s.crypt != nil {
+				// copied as it is, so the copy is no less protected
+				src, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				defer src.Close()
+				if err := copyToFile(dst, src); err != nil {
+					return err
+				}
+				return copyLayout(s.storageDir, path, dst)
+			}
+		}
+	}
+	src, err := s.readStored(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return copyToFile(dst, src)
+}
+
+func (s *fileServer) dropVersion(name string, version int64) {
+	data := filepath.Join(s.versionsDir(name), strconv.FormatInt(version, 10))
+	os.Remove(data)
+	os.Remove(data + ".json")
+	_ = setLayout(s.storageDir, data, plainLayout)
+}
+
+// versions returns the kept versions of name, newest first.
+func (s *fileServer) versions(name string) ([]*proto.FileVersion, error) {
+	entries, err := os.ReadDir(s.versionsDir(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var versions []*proto.FileVersion
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(s.versionsDir(name), e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var v proto.FileVersion
+		if err := json.Unmarshal(b, &v); err != nil {
+			slog.Warn("unreadable version", "file", name, "version", e.Name(), "error", err)
+			continue
+		}
+		versions = append(versions, &v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version > versions[j].Version })
+	return versions, nil
+}
+
+// version returns the description and data of one kept version.
+func (s *fileServer) version(name string, version int64) (*proto.FileVersion, io.ReadCloser, error) {
+	data := filepath.Join(s.versionsDir(name), strconv.FormatInt(version, 10))
+	b, err := os.ReadFile(data + ".json")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, status.Errorf(codes.NotFound, "%s: no version %d", name, version)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var v proto.FileVersion
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, nil, fmt.Errorf("version %d of %s: %w", version, name, err)
+	}
+	var f io.ReadCloser
+	if _, sealed := storedSealedSize(s.storageDir, data); sealed {
+		f, err = s.openSealedFile(data)
+	} else {
+		f, err = os.Open(data)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("version %d of %s: %w", version, name, err)
+	}
+	return &v, f, nil
+}
+
+func (s *fileServer) ListVersions(ctx context.Context, req *proto.ListVersionsRequest) (*proto.ListVersionsResponse, error) {
+	filename := s.requestName(ctx, req.GetFilename())
+	if filename == "" {
+		return nil, status.Error(codes.InvalidArgument, "filename is required")
+	}
+	if addr, fctx := s.route(ctx, filename); addr != "" {
+		c, err := s.peers.client(addr)
+		if err != nil {
+			return nil, err
+		}
+		return c.ListVersions(fctx, req)
+	}
+	versions, err := s.versions(filename)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		v.Filename = shownName(ctx, v.Filename)
+	}
+	return &proto.ListVersionsResponse{Versions: versions}, nil
+}
+
+func (s *fileServer) DownloadVersion(req *proto.DownloadVersionRequest, stream proto.FileService_DownloadVersionServer) error {
+	ctx := stream.Context()
+	filename := s.requestName(ctx, req.GetFilename())
+	if filename == "" {
+		return status.Error(codes.InvalidArgument, "filename is required")
+	}
+	if addr, fctx := s.route(ctx, filename); addr != "" {
+		c, err := s.peers.client(addr)
+		if err != nil {
+			return err
+		}
+		from, err := c.DownloadVersion(fctx, req)
+		if err != nil {
+			return err
+		}
+		for {
+			chunk, err := from.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := stream.Send(chunk); err != nil {
+				return err
+			}
+		}
+	}
+	v, f, err := s.version(filename, req.GetVersion())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// like Download, the first message carries the checksum
+	recorded := v.Sha256
+	buf := make([]byte, s.chunkSize)
+	for {
+		n, rerr := f.Read(buf)
+		if n > 0 || (rerr == io.EOF && recorded != "") {
+			if err := stream.Send(&proto.DownloadResponse{Data: buf[:n], Sha256: recorded}); err != nil {
+				return err
+			}
+			recorded = ""
+		}
+		if rerr == io.EOF {
+			return nil
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+}
+
+// RestoreVersion makes a kept version current. The version it replaces is
+// kept in turn, so a restore can be undone.
+func (s *fileServer) RestoreVersion(ctx context.Context, req *proto.RestoreVersionRequest) (*proto.FileVersion, error) {
+	filename := s.requestName(ctx, req.GetFilename())
+	if filename == "" {
+		return nil, status.Error(codes.InvalidArgument, "filename is required")
+	}
+	if addr, fctx := s.route(ctx, filename); addr != "" {
+		c, err := s.peers.client(addr)
+		if err != nil {
+			return nil, err
+		}
+		return c.RestoreVersion(fctx, req)
+	}
+	unlock, err := s.locks.Lock(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	v, f, err := s.version(filename, req.GetVersion())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := s.checkSize(filename, v.SizeBytes); err != nil {
+		return nil, err
+	}
+	staged, err := s.stage()
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(staged.Name())
+	sum := sha256.New()
+	pieces := newPieceHasher()
+	_, err = io.Copy(io.MultiWriter(staged, sum, pieces), f)
+	if cerr := staged.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("restore %s: %w", filename, err)
+	}
+	got := hex.EncodeToString(sum.Sum(nil))
+	if v.Sha256 != "" && got != v.Sha256 {
+		return nil, status.Errorf(codes.DataLoss, "version %d of %s: checksum %s, recorded %s", v.Version, filename, got, v.Sha256)
+	}
+	if err := s.keepVersion(filename); err != nil {
+		return nil, err
+	}
+	if err := s.importStored(filename, staged.Name()); err != nil {
+		return nil, fmt.Errorf("restore %s: %w", filename, err)
+	}
+	s.storeChecksum(filename, got)
+	s.storePieces(filename, pieces.sum())
+	s.changes.notify(filename)
+	slog.InfoContext(ctx, "restored version", "file", filename, "version", v.Version)
+	v.Filename = shownName(ctx, v.Filename)
+	return v, nil
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/daniil1412412/grpc-file-service/proto"
+	"github.com/fsnotify/fsnotify"
+)
+
+// changeLogSize is how many changes the feed remembers for replication.
+// A site that falls further behind gets a snapshot instead.
+const changeLogSize = 4096
+
+// changeFeed tells interested streams that a stored file changed, and
+// keeps a log of recent changes. The log lives in memory; its epoch
+// changes on restart so readers know their position is gone.
+type changeFeed struct {
+	mu      sync.Mutex
+	subs    map[string]map[chan struct{}]struct{}
+	epoch   string
+	seq     uint64
+	log     []changeRecord
+	wake    chan struct{}               // closed on the next change
+	stamps  map[string]fileStamp        // of the files changed since startup
+	pending map[string]*proto.FileEvent // observed and not yet logged
+}
+
+type changeRecord struct {
+	seq  uint64
+	name string
+	at   int64 // unix nanos
+	// what became of the file, for WatchFiles; not every change is an
+	// event
+	event *proto.FileEvent
+}
+
+func (f *changeFeed) init() {
+	if f.epoch == "" {
+		b := make([]byte, 8)
+		_, _ = rand.Read(b)
+		f.epoch = hex.EncodeToString(b)
+		f.wake = make(chan struct{})
+	}
+}
+
+// subscribe returns a channel that receives after every change to name.
+// Changes that arrive while one is pending are folded into it.
+func (f *changeFeed) subscribe(name string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs == nil {
+		f.subs = make(map[string]map[chan struct{}]struct{})
+	}
+	if f.subs[name] == nil {
+		f.subs[name] = make(map[chan struct{}]struct{})
+	}
+	f.subs[name][ch] = struct{}{}
+	return ch, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.subs[name], ch)
+		if len(f.subs[name]) == 0 {
+			delete(f.subs, name)
+		}
+	}
+}
+
+func (f *changeFeed) notify(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.init()
+	f.seq++
+	rec := changeRecord{seq: f.seq, name: name, at: time.Now().UnixNano()}
+	if p := f.pending[name]; p != nil {
+		rec.event = p
+		delete(f.pending, name)
+	}
+	f.log = append(f.log, rec)
+	if len(f.log) > changeLogSize {
+		f.log = append(f.log[:0:0], f.log[len(f.log)-changeLogSize:]...)
+	}
+	close(f.wake)
+	f.wake = make(chan struct{})
+	for ch := range f.subs[name] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// since returns the changes logged after position after of epoch, the
+// current epoch and head, and a channel closed on the next change. ok is
+// false when the position is not in the log any more.
+func (f *changeFeed) since(epoch string, after uint64) (recs []changeRecord, cur string, head uint64, ok bool, wake <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.init()
+	oldest := f.seq - uint64(len(f.log)) // position just before the first entry
+	if epoch != f.epoch || after < oldest || after > f.seq {
+		return nil, f.epoch, f.seq, false, f.wake
+	}
+	recs = append(recs, f.log[len(f.log)-int(f.seq-after):]...)
+	return recs, f.epoch, f.seq, true, f.wake
+}
+
+// internalName reports whether an entry of the storage dir belongs to the
+// server rather than being a stored file.
+func internalName(name string) bool {
+	switch name {
+	case ".chunks", ".packs", ".sums", ".pieces", ".cold", ".relay", ".locks", ".quarantine", ".staging", ".uploads", ".replication", ".versions", ".meta", ".mirror", ".tenant-keys", ".layout":
+		return true
+	}
+	return strings.HasPrefix(name, ".tmp-")
+}
+
+// reservedName reports whether a stored name, in a namespace or not,
+// would take the place of one of the server's entries.
+func reservedName(name string) bool {
+	return internalName(filepath.Base(name))
+}
+
+// watchStorage follows the storage dir so files added or removed on the
+// host, bypassing the API, are reflected in the pack index, the checksum
+// and access caches and the change feed. Without it everything still
+// works, only slower to notice.
+func (s *fileServer) watchStorage() {
+	w, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = w.Add(s.storageDir)
+	}
+	if err != nil {
+		slog.Warn("watch failed, external changes are picked up by polling only", "dir", s.storageDir, "error", err)
+		return
+	}
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if name := filepath.Base(ev.Name); !internalName(name) {
+				s.externalChange(name, ev.Op)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			// an overflow only means some events were lost; the caches
+			// validate themselves against the files anyway
+			slog.Warn("watch", "dir", s.storageDir, "error", err)
+		}
+	}
+}
+
+func (s *fileServer) externalChange(name string, op fsnotify.Op) {
+	path := filepath.Join(s.storageDir, name)
+	switch {
+	case op.Has(fsnotify.Create):
+		// a plain file shadows a packed one; drop the packed copy so it
+		// does not come back if the plain file is removed later. Events
+		// can be late, so only trust what is on disk now.
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			if err := s.packs.remove(name); err != nil {
+				slog.Warn("watch: drop packed copy", "file", name, "error", err)
+			}
+		}
+	case op.Has(fsnotify.Remove) || op.Has(fsnotify.Rename):
+		if _, err := s.statStored(name); err != nil {
+			_ = os.Remove(s.sumPath(name))
+			_ = os.Remove(s.piecesPath(name))
+			_ = setLayout(s.storageDir, path, plainLayout)
+			s.cold.forget(name)
+		}
+	}
+	s.recount(name)
+	s.changes.notify(name)
+}
@@ -0,0 +1,37 @@
+// Package uploadsig defines the detached upload signatures servers started
+// with -upload-keys check: ed25519 over Message, which ties the SHA-256 of
+// the content to the name the file is uploaded as, so a signed file cannot
+// be stored under another name.
+package uploadsig
+
+import "crypto/ed25519"
+
+const prefix = "file-service upload\n"
+
+// Message returns what an upload is signed over: a fixed prefix, name as
+// sent in the first UploadRequest, a newline and the raw 32-byte sum. The
+// sum has a fixed length, so no name can be confused with another.
+func Message(name string, sum []byte) []byte {
+	b := make([]byte, 0, len(prefix)+len(name)+1+len(sum))
+	b = append(b, prefix...)
+	b = append(b, name...)
+	b = append(b, '\n')
+	return append(b, sum...)
+}
+
+// Sign signs an upload of name whose content has SHA-256 sum.
+func Sign(key ed25519.PrivateKey, name string, sum []byte) []byte {
+	return ed25519.Sign(key, Message(name, sum))
+}
+
+// Verify reports whether sig signs an upload of name with content sum by
+// one of keys.
+func Verify(keys []ed25519.PublicKey, name string, sum, sig []byte) bool {
+	msg := Message(name, sum)
+	for _, k := range keys {
+		if ed25519.Verify(k, msg, sig) {
+			return true
+		}
+	}
+	return false
+}
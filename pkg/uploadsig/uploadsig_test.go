@@ -0,0 +1,37 @@
+package uploadsig
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(nil)
+	other, _, _ := ed25519.GenerateKey(nil)
+	sum := sha256.Sum256([]byte("content"))
+	sig := Sign(key, "report.pdf", sum[:])
+	otherSum := sha256.Sum256([]byte("other content"))
+	tests := []struct {
+		name string
+		keys []ed25519.PublicKey
+		file string
+		sum  []byte
+		sig  []byte
+		want bool
+	}{
+		{"valid", []ed25519.PublicKey{pub}, "report.pdf", sum[:], sig, true},
+		{"one of several keys", []ed25519.PublicKey{other, pub}, "report.pdf", sum[:], sig, true},
+		{"untrusted key", []ed25519.PublicKey{other}, "report.pdf", sum[:], sig, false},
+		{"no keys", nil, "report.pdf", sum[:], sig, false},
+		{"renamed", []ed25519.PublicKey{pub}, "invoice.pdf", sum[:], sig, false},
+		{"other content", []ed25519.PublicKey{pub}, "report.pdf", otherSum[:], sig, false},
+		{"content hash only", []ed25519.PublicKey{pub}, "report.pdf", sum[:], ed25519.Sign(key, sum[:]), false},
+		{"no signature", []ed25519.PublicKey{pub}, "report.pdf", sum[:], nil, false},
+	}
+	for _, tt := range tests {
+		if got := Verify(tt.keys, tt.file, tt.sum, tt.sig); got != tt.want {
+			t.Errorf("%s: Verify = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -24,8 +24,40 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type FileServiceClient interface {
 	Upload(ctx context.Context, opts ...grpc.CallOption) (FileService_UploadClient, error)
+	BeginUpload(ctx context.Context, in *BeginUploadRequest, opts ...grpc.CallOption) (*UploadStatus, error)
+	GetUploadStatus(ctx context.Context, in *UploadStatusRequest, opts ...grpc.CallOption) (*UploadStatus, error)
 	Download(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (FileService_DownloadClient, error)
+	DownloadArchive(ctx context.Context, in *ArchiveRequest, opts ...grpc.CallOption) (FileService_DownloadArchiveClient, error)
+	CreateDownloadLink(ctx context.Context, in *DownloadLinkRequest, opts ...grpc.CallOption) (*DownloadLink, error)
+	RedeemDownload(ctx context.Context, in *RedeemRequest, opts ...grpc.CallOption) (FileService_RedeemDownloadClient, error)
 	ListFiles(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	HashFile(ctx context.Context, in *HashRequest, opts ...grpc.CallOption) (*HashResponse, error)
+	Follow(ctx context.Context, in *FollowRequest, opts ...grpc.CallOption) (FileService_FollowClient, error)
+	Head(ctx context.Context, in *HeadRequest, opts ...grpc.CallOption) (*HeadResponse, error)
+	StatFile(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*FileInfo, error)
+	GetUsage(ctx context.Context, in *UsageRequest, opts ...grpc.CallOption) (*Usage, error)
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	RenameFile(ctx context.Context, in *RenameRequest, opts ...grpc.CallOption) (*RenameResponse, error)
+	CopyFile(ctx context.Context, in *CopyRequest, opts ...grpc.CallOption) (*CopyResponse, error)
+	MoveFile(ctx context.Context, in *MoveRequest, opts ...grpc.CallOption) (*MoveResponse, error)
+	ListVersions(ctx context.Context, in *ListVersionsRequest, opts ...grpc.CallOption) (*ListVersionsResponse, error)
+	DownloadVersion(ctx context.Context, in *DownloadVersionRequest, opts ...grpc.CallOption) (FileService_DownloadVersionClient, error)
+	RestoreVersion(ctx context.Context, in *RestoreVersionRequest, opts ...grpc.CallOption) (*FileVersion, error)
+	GetSignedManifest(ctx context.Context, in *ManifestRequest, opts ...grpc.CallOption) (*SignedManifest, error)
+	ExportManifest(ctx context.Context, in *ExportManifestRequest, opts ...grpc.CallOption) (FileService_ExportManifestClient, error)
+	GetPieceHashes(ctx context.Context, in *PieceHashesRequest, opts ...grpc.CallOption) (*PieceHashes, error)
+	Changes(ctx context.Context, in *ChangesRequest, opts ...grpc.CallOption) (FileService_ChangesClient, error)
+	WatchFiles(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (FileService_WatchFilesClient, error)
+	QuarantineFile(ctx context.Context, in *QuarantineRequest, opts ...grpc.CallOption) (*QuarantineEntry, error)
+	ListQuarantine(ctx context.Context, in *ListQuarantineRequest, opts ...grpc.CallOption) (*ListQuarantineResponse, error)
+	ReleaseQuarantined(ctx context.Context, in *QuarantineIDRequest, opts ...grpc.CallOption) (*QuarantineEntry, error)
+	PurgeQuarantined(ctx context.Context, in *QuarantineIDRequest, opts ...grpc.CallOption) (*QuarantineEntry, error)
+	ListJobs(ctx context.Context, in *ListJobsRequest, opts ...grpc.CallOption) (*ListJobsResponse, error)
+	RunJob(ctx context.Context, in *RunJobRequest, opts ...grpc.CallOption) (*JobStatus, error)
+	SetBandwidth(ctx context.Context, in *SetBandwidthRequest, opts ...grpc.CallOption) (*Bandwidth, error)
+	ImportFiles(ctx context.Context, in *ImportRequest, opts ...grpc.CallOption) (FileService_ImportFilesClient, error)
+	GetReplicationStatus(ctx context.Context, in *ReplicationStatusRequest, opts ...grpc.CallOption) (*ReplicationStatus, error)
+	RotateTenantKey(ctx context.Context, in *RotateTenantKeyRequest, opts ...grpc.CallOption) (*TenantKey, error)
 }
 
 type fileServiceClient struct {
@@ -70,6 +102,24 @@ func (x *fileServiceUploadClient) CloseAndRecv() (*UploadResponse, error) {
 	return m, nil
 }
 
+func (c *fileServiceClient) BeginUpload(ctx context.Context, in *BeginUploadRequest, opts ...grpc.CallOption) (*UploadStatus, error) {
+	out := new(UploadStatus)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/BeginUpload", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) GetUploadStatus(ctx context.Context, in *UploadStatusRequest, opts ...grpc.CallOption) (*UploadStatus, error) {
+	out := new(UploadStatus)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/GetUploadStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *fileServiceClient) Download(ctx context.Context, in *DownloadRequest, opts ...grpc.CallOption) (FileService_DownloadClient, error) {
 	stream, err := c.cc.NewStream(ctx, &FileService_ServiceDesc.Streams[1], "/fileservice.FileService/Download", opts...)
 	if err != nil {
@@ -85,129 +135,1311 @@ func (c *fileServiceClient) Download(ctx context.Context, in *DownloadRequest, o
 	return x, nil
 }
 
-type FileService_DownloadClient interface {
-	Recv() (*DownloadResponse, error)
-	grpc.ClientStream
+type FileService_DownloadClient interface {
+	Recv() (*DownloadResponse, error)
+	grpc.ClientStream
+}
+
+type fileServiceDownloadClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileServiceDownloadClient) Recv() (*DownloadResponse, error) {
+	m := new(DownloadResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileServiceClient) DownloadArchive(ctx context.Context, in *ArchiveRequest, opts ...grpc.CallOption) (FileService_DownloadArchiveClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileService_ServiceDesc.Streams[2], "/fileservice.FileService/DownloadArchive", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileServiceDownloadArchiveClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileService_DownloadArchiveClient interface {
+	Recv() (*DownloadResponse, error)
+	grpc.ClientStream
+}
+
+type fileServiceDownloadArchiveClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileServiceDownloadArchiveClient) Recv() (*DownloadResponse, error) {
+	m := new(DownloadResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileServiceClient) CreateDownloadLink(ctx context.Context, in *DownloadLinkRequest, opts ...grpc.CallOption) (*DownloadLink, error) {
+	out := new(DownloadLink)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/CreateDownloadLink", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) RedeemDownload(ctx context.Context, in *RedeemRequest, opts ...grpc.CallOption) (FileService_RedeemDownloadClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileService_ServiceDesc.Streams[3], "/fileservice.FileService/RedeemDownload", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileServiceRedeemDownloadClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileService_RedeemDownloadClient interface {
+	Recv() (*DownloadResponse, error)
+	grpc.ClientStream
+}
+
+type fileServiceRedeemDownloadClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileServiceRedeemDownloadClient) Recv() (*DownloadResponse, error) {
+	m := new(DownloadResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileServiceClient) ListFiles(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/ListFiles", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) HashFile(ctx context.Context, in *HashRequest, opts ...grpc.CallOption) (*HashResponse, error) {
+	out := new(HashResponse)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/HashFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) Follow(ctx context.Context, in *FollowRequest, opts ...grpc.CallOption) (FileService_FollowClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileService_ServiceDesc.Streams[4], "/fileservice.FileService/Follow", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileServiceFollowClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileService_FollowClient interface {
+	Recv() (*FollowResponse, error)
+	grpc.ClientStream
+}
+
+type fileServiceFollowClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileServiceFollowClient) Recv() (*FollowResponse, error) {
+	m := new(FollowResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileServiceClient) Head(ctx context.Context, in *HeadRequest, opts ...grpc.CallOption) (*HeadResponse, error) {
+	out := new(HeadResponse)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/Head", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) StatFile(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*FileInfo, error) {
+	out := new(FileInfo)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/StatFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) GetUsage(ctx context.Context, in *UsageRequest, opts ...grpc.CallOption) (*Usage, error) {
+	out := new(Usage)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/GetUsage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) RenameFile(ctx context.Context, in *RenameRequest, opts ...grpc.CallOption) (*RenameResponse, error) {
+	out := new(RenameResponse)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/RenameFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) CopyFile(ctx context.Context, in *CopyRequest, opts ...grpc.CallOption) (*CopyResponse, error) {
+	out := new(CopyResponse)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/CopyFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) MoveFile(ctx context.Context, in *MoveRequest, opts ...grpc.CallOption) (*MoveResponse, error) {
+	out := new(MoveResponse)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/MoveFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) ListVersions(ctx context.Context, in *ListVersionsRequest, opts ...grpc.CallOption) (*ListVersionsResponse, error) {
+	out := new(ListVersionsResponse)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/ListVersions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) DownloadVersion(ctx context.Context, in *DownloadVersionRequest, opts ...grpc.CallOption) (FileService_DownloadVersionClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileService_ServiceDesc.Streams[5], "/fileservice.FileService/DownloadVersion", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileServiceDownloadVersionClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileService_DownloadVersionClient interface {
+	Recv() (*DownloadResponse, error)
+	grpc.ClientStream
+}
+
+type fileServiceDownloadVersionClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileServiceDownloadVersionClient) Recv() (*DownloadResponse, error) {
+	m := new(DownloadResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileServiceClient) RestoreVersion(ctx context.Context, in *RestoreVersionRequest, opts ...grpc.CallOption) (*FileVersion, error) {
+	out := new(FileVersion)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/RestoreVersion", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) GetSignedManifest(ctx context.Context, in *ManifestRequest, opts ...grpc.CallOption) (*SignedManifest, error) {
+	out := new(SignedManifest)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/GetSignedManifest", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) ExportManifest(ctx context.Context, in *ExportManifestRequest, opts ...grpc.CallOption) (FileService_ExportManifestClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileService_ServiceDesc.Streams[6], "/fileservice.FileService/ExportManifest", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileServiceExportManifestClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileService_ExportManifestClient interface {
+	Recv() (*ManifestEntry, error)
+	grpc.ClientStream
+}
+
+type fileServiceExportManifestClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileServiceExportManifestClient) Recv() (*ManifestEntry, error) {
+	m := new(ManifestEntry)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileServiceClient) GetPieceHashes(ctx context.Context, in *PieceHashesRequest, opts ...grpc.CallOption) (*PieceHashes, error) {
+	out := new(PieceHashes)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/GetPieceHashes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) Changes(ctx context.Context, in *ChangesRequest, opts ...grpc.CallOption) (FileService_ChangesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileService_ServiceDesc.Streams[7], "/fileservice.FileService/Changes", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileServiceChangesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileService_ChangesClient interface {
+	Recv() (*Change, error)
+	grpc.ClientStream
+}
+
+type fileServiceChangesClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileServiceChangesClient) Recv() (*Change, error) {
+	m := new(Change)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileServiceClient) WatchFiles(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (FileService_WatchFilesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileService_ServiceDesc.Streams[8], "/fileservice.FileService/WatchFiles", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileServiceWatchFilesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileService_WatchFilesClient interface {
+	Recv() (*FileEvent, error)
+	grpc.ClientStream
+}
+
+type fileServiceWatchFilesClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileServiceWatchFilesClient) Recv() (*FileEvent, error) {
+	m := new(FileEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileServiceClient) QuarantineFile(ctx context.Context, in *QuarantineRequest, opts ...grpc.CallOption) (*QuarantineEntry, error) {
+	out := new(QuarantineEntry)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/QuarantineFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) ListQuarantine(ctx context.Context, in *ListQuarantineRequest, opts ...grpc.CallOption) (*ListQuarantineResponse, error) {
+	out := new(ListQuarantineResponse)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/ListQuarantine", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) ReleaseQuarantined(ctx context.Context, in *QuarantineIDRequest, opts ...grpc.CallOption) (*QuarantineEntry, error) {
+	out := new(QuarantineEntry)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/ReleaseQuarantined", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) PurgeQuarantined(ctx context.Context, in *QuarantineIDRequest, opts ...grpc.CallOption) (*QuarantineEntry, error) {
+	out := new(QuarantineEntry)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/PurgeQuarantined", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) ListJobs(ctx context.Context, in *ListJobsRequest, opts ...grpc.CallOption) (*ListJobsResponse, error) {
+	out := new(ListJobsResponse)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/ListJobs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) RunJob(ctx context.Context, in *RunJobRequest, opts ...grpc.CallOption) (*JobStatus, error) {
+	out := new(JobStatus)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/RunJob", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) SetBandwidth(ctx context.Context, in *SetBandwidthRequest, opts ...grpc.CallOption) (*Bandwidth, error) {
+	out := new(Bandwidth)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/SetBandwidth", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) ImportFiles(ctx context.Context, in *ImportRequest, opts ...grpc.CallOption) (FileService_ImportFilesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FileService_ServiceDesc.Streams[9], "/fileservice.FileService/ImportFiles", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileServiceImportFilesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileService_ImportFilesClient interface {
+	Recv() (*ImportResult, error)
+	grpc.ClientStream
+}
+
+type fileServiceImportFilesClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileServiceImportFilesClient) Recv() (*ImportResult, error) {
+	m := new(ImportResult)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileServiceClient) GetReplicationStatus(ctx context.Context, in *ReplicationStatusRequest, opts ...grpc.CallOption) (*ReplicationStatus, error) {
+	out := new(ReplicationStatus)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/GetReplicationStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileServiceClient) RotateTenantKey(ctx context.Context, in *RotateTenantKeyRequest, opts ...grpc.CallOption) (*TenantKey, error) {
+	out := new(TenantKey)
+	err := c.cc.Invoke(ctx, "/fileservice.FileService/RotateTenantKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FileServiceServer is the server API for FileService service.
+// All implementations must embed UnimplementedFileServiceServer
+// for forward compatibility
+type FileServiceServer interface {
+	Upload(FileService_UploadServer) error
+	BeginUpload(context.Context, *BeginUploadRequest) (*UploadStatus, error)
+	GetUploadStatus(context.Context, *UploadStatusRequest) (*UploadStatus, error)
+	Download(*DownloadRequest, FileService_DownloadServer) error
+	DownloadArchive(*ArchiveRequest, FileService_DownloadArchiveServer) error
+	CreateDownloadLink(context.Context, *DownloadLinkRequest) (*DownloadLink, error)
+	RedeemDownload(*RedeemRequest, FileService_RedeemDownloadServer) error
+	ListFiles(context.Context, *ListRequest) (*ListResponse, error)
+	HashFile(context.Context, *HashRequest) (*HashResponse, error)
+	Follow(*FollowRequest, FileService_FollowServer) error
+	Head(context.Context, *HeadRequest) (*HeadResponse, error)
+	StatFile(context.Context, *StatRequest) (*FileInfo, error)
+	GetUsage(context.Context, *UsageRequest) (*Usage, error)
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	RenameFile(context.Context, *RenameRequest) (*RenameResponse, error)
+	CopyFile(context.Context, *CopyRequest) (*CopyResponse, error)
+	MoveFile(context.Context, *MoveRequest) (*MoveResponse, error)
+	ListVersions(context.Context, *ListVersionsRequest) (*ListVersionsResponse, error)
+	DownloadVersion(*DownloadVersionRequest, FileService_DownloadVersionServer) error
+	RestoreVersion(context.Context, *RestoreVersionRequest) (*FileVersion, error)
+	GetSignedManifest(context.Context, *ManifestRequest) (*SignedManifest, error)
+	ExportManifest(*ExportManifestRequest, FileService_ExportManifestServer) error
+	GetPieceHashes(context.Context, *PieceHashesRequest) (*PieceHashes, error)
+	Changes(*ChangesRequest, FileService_ChangesServer) error
+	WatchFiles(*WatchRequest, FileService_WatchFilesServer) error
+	QuarantineFile(context.Context, *QuarantineRequest) (*QuarantineEntry, error)
+	ListQuarantine(context.Context, *ListQuarantineRequest) (*ListQuarantineResponse, error)
+	ReleaseQuarantined(context.Context, *QuarantineIDRequest) (*QuarantineEntry, error)
+	PurgeQuarantined(context.Context, *QuarantineIDRequest) (*QuarantineEntry, error)
+	ListJobs(context.Context, *ListJobsRequest) (*ListJobsResponse, error)
+	RunJob(context.Context, *RunJobRequest) (*JobStatus, error)
+	SetBandwidth(context.Context, *SetBandwidthRequest) (*Bandwidth, error)
+	ImportFiles(*ImportRequest, FileService_ImportFilesServer) error
+	GetReplicationStatus(context.Context, *ReplicationStatusRequest) (*ReplicationStatus, error)
+	RotateTenantKey(context.Context, *RotateTenantKeyRequest) (*TenantKey, error)
+	mustEmbedUnimplementedFileServiceServer()
+}
+
+// UnimplementedFileServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedFileServiceServer struct {
+}
+
+func (UnimplementedFileServiceServer) Upload(FileService_UploadServer) error {
+	return status.Errorf(codes.Unimplemented, "method Upload not implemented")
+}
+func (UnimplementedFileServiceServer) BeginUpload(context.Context, *BeginUploadRequest) (*UploadStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BeginUpload not implemented")
+}
+func (UnimplementedFileServiceServer) GetUploadStatus(context.Context, *UploadStatusRequest) (*UploadStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUploadStatus not implemented")
+}
+func (UnimplementedFileServiceServer) Download(*DownloadRequest, FileService_DownloadServer) error {
+	return status.Errorf(codes.Unimplemented, "method Download not implemented")
+}
+func (UnimplementedFileServiceServer) DownloadArchive(*ArchiveRequest, FileService_DownloadArchiveServer) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadArchive not implemented")
+}
+func (UnimplementedFileServiceServer) CreateDownloadLink(context.Context, *DownloadLinkRequest) (*DownloadLink, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateDownloadLink not implemented")
+}
+func (UnimplementedFileServiceServer) RedeemDownload(*RedeemRequest, FileService_RedeemDownloadServer) error {
+	return status.Errorf(codes.Unimplemented, "method RedeemDownload not implemented")
+}
+func (UnimplementedFileServiceServer) ListFiles(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListFiles not implemented")
+}
+func (UnimplementedFileServiceServer) HashFile(context.Context, *HashRequest) (*HashResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HashFile not implemented")
+}
+func (UnimplementedFileServiceServer) Follow(*FollowRequest, FileService_FollowServer) error {
+	return status.Errorf(codes.Unimplemented, "method Follow not implemented")
+}
+func (UnimplementedFileServiceServer) Head(context.Context, *HeadRequest) (*HeadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Head not implemented")
+}
+func (UnimplementedFileServiceServer) StatFile(context.Context, *StatRequest) (*FileInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StatFile not implemented")
+}
+func (UnimplementedFileServiceServer) GetUsage(context.Context, *UsageRequest) (*Usage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUsage not implemented")
+}
+func (UnimplementedFileServiceServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedFileServiceServer) RenameFile(context.Context, *RenameRequest) (*RenameResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenameFile not implemented")
+}
+func (UnimplementedFileServiceServer) CopyFile(context.Context, *CopyRequest) (*CopyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CopyFile not implemented")
+}
+func (UnimplementedFileServiceServer) MoveFile(context.Context, *MoveRequest) (*MoveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MoveFile not implemented")
+}
+func (UnimplementedFileServiceServer) ListVersions(context.Context, *ListVersionsRequest) (*ListVersionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVersions not implemented")
+}
+func (UnimplementedFileServiceServer) DownloadVersion(*DownloadVersionRequest, FileService_DownloadVersionServer) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadVersion not implemented")
+}
+func (UnimplementedFileServiceServer) RestoreVersion(context.Context, *RestoreVersionRequest) (*FileVersion, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreVersion not implemented")
+}
+func (UnimplementedFileServiceServer) GetSignedManifest(context.Context, *ManifestRequest) (*SignedManifest, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSignedManifest not implemented")
+}
+func (UnimplementedFileServiceServer) ExportManifest(*ExportManifestRequest, FileService_ExportManifestServer) error {
+	return status.Errorf(codes.Unimplemented, "method ExportManifest not implemented")
+}
+func (UnimplementedFileServiceServer) GetPieceHashes(context.Context, *PieceHashesRequest) (*PieceHashes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPieceHashes not implemented")
+}
+func (UnimplementedFileServiceServer) Changes(*ChangesRequest, FileService_ChangesServer) error {
+	return status.Errorf(codes.Unimplemented, "method Changes not implemented")
+}
+func (UnimplementedFileServiceServer) WatchFiles(*WatchRequest, FileService_WatchFilesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchFiles not implemented")
+}
+func (UnimplementedFileServiceServer) QuarantineFile(context.Context, *QuarantineRequest) (*QuarantineEntry, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QuarantineFile not implemented")
+}
+func (UnimplementedFileServiceServer) ListQuarantine(context.Context, *ListQuarantineRequest) (*ListQuarantineResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListQuarantine not implemented")
+}
+func (UnimplementedFileServiceServer) ReleaseQuarantined(context.Context, *QuarantineIDRequest) (*QuarantineEntry, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReleaseQuarantined not implemented")
+}
+func (UnimplementedFileServiceServer) PurgeQuarantined(context.Context, *QuarantineIDRequest) (*QuarantineEntry, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PurgeQuarantined not implemented")
+}
+func (UnimplementedFileServiceServer) ListJobs(context.Context, *ListJobsRequest) (*ListJobsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListJobs not implemented")
+}
+func (UnimplementedFileServiceServer) RunJob(context.Context, *RunJobRequest) (*JobStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RunJob not implemented")
+}
+func (UnimplementedFileServiceServer) SetBandwidth(context.Context, *SetBandwidthRequest) (*Bandwidth, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetBandwidth not implemented")
+}
+func (UnimplementedFileServiceServer) ImportFiles(*ImportRequest, FileService_ImportFilesServer) error {
+	return status.Errorf(codes.Unimplemented, "method ImportFiles not implemented")
+}
+func (UnimplementedFileServiceServer) GetReplicationStatus(context.Context, *ReplicationStatusRequest) (*ReplicationStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetReplicationStatus not implemented")
+}
+func (UnimplementedFileServiceServer) RotateTenantKey(context.Context, *RotateTenantKeyRequest) (*TenantKey, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RotateTenantKey not implemented")
+}
+func (UnimplementedFileServiceServer) mustEmbedUnimplementedFileServiceServer() {}
+
+// UnsafeFileServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FileServiceServer will
+// result in compilation errors.
+type UnsafeFileServiceServer interface {
+	mustEmbedUnimplementedFileServiceServer()
+}
+
+func RegisterFileServiceServer(s grpc.ServiceRegistrar, srv FileServiceServer) {
+	s.RegisterService(&FileService_ServiceDesc, srv)
+}
+
+func _FileService_Upload_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FileServiceServer).Upload(&fileServiceUploadServer{stream})
+}
+
+type FileService_UploadServer interface {
+	SendAndClose(*UploadResponse) error
+	Recv() (*UploadRequest, error)
+	grpc.ServerStream
+}
+
+type fileServiceUploadServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileServiceUploadServer) SendAndClose(m *UploadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *fileServiceUploadServer) Recv() (*UploadRequest, error) {
+	m := new(UploadRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _FileService_BeginUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BeginUploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).BeginUpload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/BeginUpload",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).BeginUpload(ctx, req.(*BeginUploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_GetUploadStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UploadStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).GetUploadStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/GetUploadStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).GetUploadStatus(ctx, req.(*UploadStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_Download_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileServiceServer).Download(m, &fileServiceDownloadServer{stream})
+}
+
+type FileService_DownloadServer interface {
+	Send(*DownloadResponse) error
+	grpc.ServerStream
+}
+
+type fileServiceDownloadServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileServiceDownloadServer) Send(m *DownloadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileService_DownloadArchive_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ArchiveRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileServiceServer).DownloadArchive(m, &fileServiceDownloadArchiveServer{stream})
+}
+
+type FileService_DownloadArchiveServer interface {
+	Send(*DownloadResponse) error
+	grpc.ServerStream
+}
+
+type fileServiceDownloadArchiveServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileServiceDownloadArchiveServer) Send(m *DownloadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileService_CreateDownloadLink_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DownloadLinkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).CreateDownloadLink(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/CreateDownloadLink",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).CreateDownloadLink(ctx, req.(*DownloadLinkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_RedeemDownload_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RedeemRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileServiceServer).RedeemDownload(m, &fileServiceRedeemDownloadServer{stream})
+}
+
+type FileService_RedeemDownloadServer interface {
+	Send(*DownloadResponse) error
+	grpc.ServerStream
+}
+
+type fileServiceRedeemDownloadServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileServiceRedeemDownloadServer) Send(m *DownloadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileService_ListFiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).ListFiles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/ListFiles",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).ListFiles(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_HashFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).HashFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/HashFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).HashFile(ctx, req.(*HashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_Follow_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FollowRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileServiceServer).Follow(m, &fileServiceFollowServer{stream})
+}
+
+type FileService_FollowServer interface {
+	Send(*FollowResponse) error
+	grpc.ServerStream
+}
+
+type fileServiceFollowServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileServiceFollowServer) Send(m *FollowResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileService_Head_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).Head(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/Head",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).Head(ctx, req.(*HeadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_StatFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).StatFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/StatFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).StatFile(ctx, req.(*StatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_GetUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UsageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).GetUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/GetUsage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).GetUsage(ctx, req.(*UsageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_RenameFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenameRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).RenameFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/RenameFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).RenameFile(ctx, req.(*RenameRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_CopyFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CopyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).CopyFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/CopyFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).CopyFile(ctx, req.(*CopyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_MoveFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MoveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).MoveFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/MoveFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).MoveFile(ctx, req.(*MoveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_ListVersions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListVersionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).ListVersions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/ListVersions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).ListVersions(ctx, req.(*ListVersionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_DownloadVersion_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadVersionRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileServiceServer).DownloadVersion(m, &fileServiceDownloadVersionServer{stream})
+}
+
+type FileService_DownloadVersionServer interface {
+	Send(*DownloadResponse) error
+	grpc.ServerStream
+}
+
+type fileServiceDownloadVersionServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileServiceDownloadVersionServer) Send(m *DownloadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileService_RestoreVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreVersionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).RestoreVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/RestoreVersion",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).RestoreVersion(ctx, req.(*RestoreVersionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_GetSignedManifest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ManifestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).GetSignedManifest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/GetSignedManifest",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).GetSignedManifest(ctx, req.(*ManifestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_ExportManifest_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportManifestRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileServiceServer).ExportManifest(m, &fileServiceExportManifestServer{stream})
+}
+
+type FileService_ExportManifestServer interface {
+	Send(*ManifestEntry) error
+	grpc.ServerStream
 }
 
-type fileServiceDownloadClient struct {
-	grpc.ClientStream
+type fileServiceExportManifestServer struct {
+	grpc.ServerStream
 }
 
-func (x *fileServiceDownloadClient) Recv() (*DownloadResponse, error) {
-	m := new(DownloadResponse)
-	if err := x.ClientStream.RecvMsg(m); err != nil {
+func (x *fileServiceExportManifestServer) Send(m *ManifestEntry) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileService_GetPieceHashes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PieceHashesRequest)
+	if err := dec(in); err != nil {
 		return nil, err
 	}
-	return m, nil
+	if interceptor == nil {
+		return srv.(FileServiceServer).GetPieceHashes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/GetPieceHashes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).GetPieceHashes(ctx, req.(*PieceHashesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-func (c *fileServiceClient) ListFiles(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
-	out := new(ListResponse)
-	err := c.cc.Invoke(ctx, "/fileservice.FileService/ListFiles", in, out, opts...)
-	if err != nil {
-		return nil, err
+func _FileService_Changes_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ChangesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
 	}
-	return out, nil
+	return srv.(FileServiceServer).Changes(m, &fileServiceChangesServer{stream})
 }
 
-// FileServiceServer is the server API for FileService service.
-// All implementations must embed UnimplementedFileServiceServer
-// for forward compatibility
-type FileServiceServer interface {
-	Upload(FileService_UploadServer) error
-	Download(*DownloadRequest, FileService_DownloadServer) error
-	ListFiles(context.Context, *ListRequest) (*ListResponse, error)
-	mustEmbedUnimplementedFileServiceServer()
+type FileService_ChangesServer interface {
+	Send(*Change) error
+	grpc.ServerStream
 }
 
-// UnimplementedFileServiceServer must be embedded to have forward compatible implementations.
-type UnimplementedFileServiceServer struct {
+type fileServiceChangesServer struct {
+	grpc.ServerStream
 }
 
-func (UnimplementedFileServiceServer) Upload(FileService_UploadServer) error {
-	return status.Errorf(codes.Unimplemented, "method Upload not implemented")
+func (x *fileServiceChangesServer) Send(m *Change) error {
+	return x.ServerStream.SendMsg(m)
 }
-func (UnimplementedFileServiceServer) Download(*DownloadRequest, FileService_DownloadServer) error {
-	return status.Errorf(codes.Unimplemented, "method Download not implemented")
+
+func _FileService_WatchFiles_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileServiceServer).WatchFiles(m, &fileServiceWatchFilesServer{stream})
 }
-func (UnimplementedFileServiceServer) ListFiles(context.Context, *ListRequest) (*ListResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method ListFiles not implemented")
+
+type FileService_WatchFilesServer interface {
+	Send(*FileEvent) error
+	grpc.ServerStream
 }
-func (UnimplementedFileServiceServer) mustEmbedUnimplementedFileServiceServer() {}
 
-// UnsafeFileServiceServer may be embedded to opt out of forward compatibility for this service.
-// Use of this interface is not recommended, as added methods to FileServiceServer will
-// result in compilation errors.
-type UnsafeFileServiceServer interface {
-	mustEmbedUnimplementedFileServiceServer()
+type fileServiceWatchFilesServer struct {
+	grpc.ServerStream
 }
 
-func RegisterFileServiceServer(s grpc.ServiceRegistrar, srv FileServiceServer) {
-	s.RegisterService(&FileService_ServiceDesc, srv)
+func (x *fileServiceWatchFilesServer) Send(m *FileEvent) error {
+	return x.ServerStream.SendMsg(m)
 }
 
-func _FileService_Upload_Handler(srv interface{}, stream grpc.ServerStream) error {
-	return srv.(FileServiceServer).Upload(&fileServiceUploadServer{stream})
+func _FileService_QuarantineFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QuarantineRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).QuarantineFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/QuarantineFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).QuarantineFile(ctx, req.(*QuarantineRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-type FileService_UploadServer interface {
-	SendAndClose(*UploadResponse) error
-	Recv() (*UploadRequest, error)
-	grpc.ServerStream
+func _FileService_ListQuarantine_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListQuarantineRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).ListQuarantine(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/ListQuarantine",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).ListQuarantine(ctx, req.(*ListQuarantineRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-type fileServiceUploadServer struct {
-	grpc.ServerStream
+func _FileService_ReleaseQuarantined_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QuarantineIDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).ReleaseQuarantined(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/ReleaseQuarantined",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).ReleaseQuarantined(ctx, req.(*QuarantineIDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-func (x *fileServiceUploadServer) SendAndClose(m *UploadResponse) error {
-	return x.ServerStream.SendMsg(m)
+func _FileService_PurgeQuarantined_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QuarantineIDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).PurgeQuarantined(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/PurgeQuarantined",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).PurgeQuarantined(ctx, req.(*QuarantineIDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-func (x *fileServiceUploadServer) Recv() (*UploadRequest, error) {
-	m := new(UploadRequest)
-	if err := x.ServerStream.RecvMsg(m); err != nil {
+func _FileService_ListJobs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListJobsRequest)
+	if err := dec(in); err != nil {
 		return nil, err
 	}
-	return m, nil
+	if interceptor == nil {
+		return srv.(FileServiceServer).ListJobs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/ListJobs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).ListJobs(ctx, req.(*ListJobsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-func _FileService_Download_Handler(srv interface{}, stream grpc.ServerStream) error {
-	m := new(DownloadRequest)
+func _FileService_RunJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RunJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).RunJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/RunJob",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).RunJob(ctx, req.(*RunJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_SetBandwidth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetBandwidthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).SetBandwidth(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/SetBandwidth",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).SetBandwidth(ctx, req.(*SetBandwidthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_ImportFiles_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ImportRequest)
 	if err := stream.RecvMsg(m); err != nil {
 		return err
 	}
-	return srv.(FileServiceServer).Download(m, &fileServiceDownloadServer{stream})
+	return srv.(FileServiceServer).ImportFiles(m, &fileServiceImportFilesServer{stream})
 }
 
-type FileService_DownloadServer interface {
-	Send(*DownloadResponse) error
+type FileService_ImportFilesServer interface {
+	Send(*ImportResult) error
 	grpc.ServerStream
 }
 
-type fileServiceDownloadServer struct {
+type fileServiceImportFilesServer struct {
 	grpc.ServerStream
 }
 
-func (x *fileServiceDownloadServer) Send(m *DownloadResponse) error {
+func (x *fileServiceImportFilesServer) Send(m *ImportResult) error {
 	return x.ServerStream.SendMsg(m)
 }
 
-func _FileService_ListFiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(ListRequest)
+func _FileService_GetReplicationStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReplicationStatusRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(FileServiceServer).ListFiles(ctx, in)
+		return srv.(FileServiceServer).GetReplicationStatus(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/fileservice.FileService/ListFiles",
+		FullMethod: "/fileservice.FileService/GetReplicationStatus",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(FileServiceServer).ListFiles(ctx, req.(*ListRequest))
+		return srv.(FileServiceServer).GetReplicationStatus(ctx, req.(*ReplicationStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileService_RotateTenantKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RotateTenantKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileServiceServer).RotateTenantKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/fileservice.FileService/RotateTenantKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileServiceServer).RotateTenantKey(ctx, req.(*RotateTenantKeyRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -219,10 +1451,106 @@ var FileService_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "fileservice.FileService",
 	HandlerType: (*FileServiceServer)(nil),
 	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "BeginUpload",
+			Handler:    _FileService_BeginUpload_Handler,
+		},
+		{
+			MethodName: "GetUploadStatus",
+			Handler:    _FileService_GetUploadStatus_Handler,
+		},
+		{
+			MethodName: "CreateDownloadLink",
+			Handler:    _FileService_CreateDownloadLink_Handler,
+		},
 		{
 			MethodName: "ListFiles",
 			Handler:    _FileService_ListFiles_Handler,
 		},
+		{
+			MethodName: "HashFile",
+			Handler:    _FileService_HashFile_Handler,
+		},
+		{
+			MethodName: "Head",
+			Handler:    _FileService_Head_Handler,
+		},
+		{
+			MethodName: "StatFile",
+			Handler:    _FileService_StatFile_Handler,
+		},
+		{
+			MethodName: "GetUsage",
+			Handler:    _FileService_GetUsage_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _FileService_Delete_Handler,
+		},
+		{
+			MethodName: "RenameFile",
+			Handler:    _FileService_RenameFile_Handler,
+		},
+		{
+			MethodName: "CopyFile",
+			Handler:    _FileService_CopyFile_Handler,
+		},
+		{
+			MethodName: "MoveFile",
+			Handler:    _FileService_MoveFile_Handler,
+		},
+		{
+			MethodName: "ListVersions",
+			Handler:    _FileService_ListVersions_Handler,
+		},
+		{
+			MethodName: "RestoreVersion",
+			Handler:    _FileService_RestoreVersion_Handler,
+		},
+		{
+			MethodName: "GetSignedManifest",
+			Handler:    _FileService_GetSignedManifest_Handler,
+		},
+		{
+			MethodName: "GetPieceHashes",
+			Handler:    _FileService_GetPieceHashes_Handler,
+		},
+		{
+			MethodName: "QuarantineFile",
+			Handler:    _FileService_QuarantineFile_Handler,
+		},
+		{
+			MethodName: "ListQuarantine",
+			Handler:    _FileService_ListQuarantine_Handler,
+		},
+		{
+			MethodName: "ReleaseQuarantined",
+			Handler:    _FileService_ReleaseQuarantined_Handler,
+		},
+		{
+			MethodName: "PurgeQuarantined",
+			Handler:    _FileService_PurgeQuarantined_Handler,
+		},
+		{
+			MethodName: "ListJobs",
+			Handler:    _FileService_ListJobs_Handler,
+		},
+		{
+			MethodName: "RunJob",
+			Handler:    _FileService_RunJob_Handler,
+		},
+		{
+			MethodName: "SetBandwidth",
+			Handler:    _FileService_SetBandwidth_Handler,
+		},
+		{
+			MethodName: "GetReplicationStatus",
+			Handler:    _FileService_GetReplicationStatus_Handler,
+		},
+		{
+			MethodName: "RotateTenantKey",
+			Handler:    _FileService_RotateTenantKey_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -235,6 +1563,46 @@ var FileService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _FileService_Download_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "DownloadArchive",
+			Handler:       _FileService_DownloadArchive_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "RedeemDownload",
+			Handler:       _FileService_RedeemDownload_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Follow",
+			Handler:       _FileService_Follow_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "DownloadVersion",
+			Handler:       _FileService_DownloadVersion_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ExportManifest",
+			Handler:       _FileService_ExportManifest_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Changes",
+			Handler:       _FileService_Changes_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchFiles",
+			Handler:       _FileService_WatchFiles_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ImportFiles",
+			Handler:       _FileService_ImportFiles_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "proto/file_service.proto",
 }
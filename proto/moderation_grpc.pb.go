@@ -0,0 +1,140 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v6.32.1
+// source: proto/moderation.proto
+
+package proto
+
+import (
+	context "context"
+
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// ModeratorClient is the client API for Moderator service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ModeratorClient interface {
+	Classify(ctx context.Context, opts ...grpc.CallOption) (Moderator_ClassifyClient, error)
+}
+
+type moderatorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewModeratorClient(cc grpc.ClientConnInterface) ModeratorClient {
+	return &moderatorClient{cc}
+}
+
+func (c *moderatorClient) Classify(ctx context.Context, opts ...grpc.CallOption) (Moderator_ClassifyClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Moderator_ServiceDesc.Streams[0], "/fileservice.Moderator/Classify", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &moderatorClassifyClient{stream}
+	return x, nil
+}
+
+type Moderator_ClassifyClient interface {
+	Send(*ClassifyRequest) error
+	CloseAndRecv() (*ClassifyResponse, error)
+	grpc.ClientStream
+}
+
+type moderatorClassifyClient struct {
+	grpc.ClientStream
+}
+
+func (x *moderatorClassifyClient) Send(m *ClassifyRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *moderatorClassifyClient) CloseAndRecv() (*ClassifyResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ClassifyResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ModeratorServer is the server API for Moderator service.
+// All implementations must embed UnimplementedModeratorServer
+// for forward compatibility
+type ModeratorServer interface {
+	Classify(Moderator_ClassifyServer) error
+	mustEmbedUnimplementedModeratorServer()
+}
+
+// UnimplementedModeratorServer must be embedded to have forward compatible implementations.
+type UnimplementedModeratorServer struct {
+}
+
+func (UnimplementedModeratorServer) Classify(Moderator_ClassifyServer) error {
+	return status.Errorf(codes.Unimplemented, "method Classify not implemented")
+}
+func (UnimplementedModeratorServer) mustEmbedUnimplementedModeratorServer() {}
+
+// UnsafeModeratorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ModeratorServer will
+// result in compilation errors.
+type UnsafeModeratorServer interface {
+	mustEmbedUnimplementedModeratorServer()
+}
+
+func RegisterModeratorServer(s grpc.ServiceRegistrar, srv ModeratorServer) {
+	s.RegisterService(&Moderator_ServiceDesc, srv)
+}
+
+func _Moderator_Classify_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ModeratorServer).Classify(&moderatorClassifyServer{stream})
+}
+
+type Moderator_ClassifyServer interface {
+	SendAndClose(*ClassifyResponse) error
+	Recv() (*ClassifyRequest, error)
+	grpc.ServerStream
+}
+
+type moderatorClassifyServer struct {
+	grpc.ServerStream
+}
+
+func (x *moderatorClassifyServer) SendAndClose(m *ClassifyResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *moderatorClassifyServer) Recv() (*ClassifyRequest, error) {
+	m := new(ClassifyRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Moderator_ServiceDesc is the grpc.ServiceDesc for Moderator service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Moderator_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fileservice.Moderator",
+	HandlerType: (*ModeratorServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Classify",
+			Handler:       _Moderator_Classify_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "proto/moderation.proto",
+}